@@ -0,0 +1,202 @@
+// Package canvas implements a drivers.Displayer made of several smaller
+// displays, so that applications can draw on a single large logical canvas
+// spanning tiled or chained panels.
+//
+// Each panel is mapped to a rectangle of the canvas and can be mounted with
+// any rotation. Panels that are chained and driven by a single device (like
+// hub75 matrices or cascaded max72xx modules) are described with an offset
+// inside that device.
+package canvas // import "tinygo.org/x/drivers/canvas"
+
+import (
+	"image/color"
+
+	"tinygo.org/x/drivers"
+)
+
+// Panel is a display, or part of a display, placed on the canvas.
+type Panel struct {
+	// Display is the device the panel is drawn on.
+	Display drivers.Displayer
+
+	// X and Y are the position of the top-left corner of the panel on the
+	// canvas.
+	X, Y int16
+
+	// Width and Height are the size of the panel as seen by Display (before
+	// rotation). When zero, the size of Display is used.
+	Width, Height int16
+
+	// OffsetX and OffsetY are the position of the panel inside Display, for
+	// chained panels that are driven as one long display.
+	OffsetX, OffsetY int16
+
+	// Rotation is how the panel is mounted on the canvas, clock-wise.
+	Rotation drivers.Rotation
+}
+
+// GridConfig describes a grid of panels of the same size.
+type GridConfig struct {
+	Columns, Rows int
+
+	// PanelWidth and PanelHeight are the size of every panel (before
+	// rotation). When zero, the size of the first display divided by the
+	// number of panels it drives is used.
+	PanelWidth, PanelHeight int16
+
+	// Serpentine is set when the chain of panels snakes through the grid:
+	// odd rows are chained from right to left and mounted upside down.
+	Serpentine bool
+
+	// Rotation is how every panel is mounted, clock-wise.
+	Rotation drivers.Rotation
+}
+
+// Canvas is a drivers.Displayer over a set of panels.
+type Canvas struct {
+	panels   []Panel
+	displays []drivers.Displayer
+	width    int16
+	height   int16
+}
+
+var _ drivers.Displayer = &Canvas{}
+
+// New returns a canvas made of the given panels. The size of the canvas is
+// the bounding box of all panels. Panels should not overlap, if they do the
+// pixel is set on all of them.
+func New(panels ...Panel) *Canvas {
+	c := &Canvas{}
+	for _, p := range panels {
+		if p.Width == 0 || p.Height == 0 {
+			p.Width, p.Height = p.Display.Size()
+		}
+		c.panels = append(c.panels, p)
+
+		w, h := p.size()
+		if p.X+w > c.width {
+			c.width = p.X + w
+		}
+		if p.Y+h > c.height {
+			c.height = p.Y + h
+		}
+
+		shared := false
+		for _, d := range c.displays {
+			if d == p.Display {
+				shared = true
+				break
+			}
+		}
+		if !shared {
+			c.displays = append(c.displays, p.Display)
+		}
+	}
+	return c
+}
+
+// NewGrid returns a canvas made of cfg.Columns × cfg.Rows panels, given in
+// chain order. When there are less displays than panels, the panels are
+// chained horizontally on the displays: each display drives the same number
+// of consecutive panels.
+func NewGrid(cfg GridConfig, displays ...drivers.Displayer) *Canvas {
+	count := cfg.Columns * cfg.Rows
+	if count <= 0 || len(displays) == 0 {
+		return New()
+	}
+	perDisplay := (count + len(displays) - 1) / len(displays)
+
+	pw, ph := cfg.PanelWidth, cfg.PanelHeight
+	if pw == 0 || ph == 0 {
+		pw, ph = displays[0].Size()
+		pw /= int16(perDisplay)
+	}
+
+	// size of a panel on the canvas
+	cw, ch := pw, ph
+	if cfg.Rotation&1 != 0 {
+		cw, ch = ph, pw
+	}
+
+	panels := make([]Panel, count)
+	for i := range panels {
+		row, col := i/cfg.Columns, i%cfg.Columns
+		rotation := cfg.Rotation
+		if cfg.Serpentine && row%2 == 1 {
+			col = cfg.Columns - col - 1
+			rotation = rotation&^3 | (rotation+2)&3
+		}
+		panels[i] = Panel{
+			Display:  displays[i/perDisplay],
+			X:        int16(col) * cw,
+			Y:        int16(row) * ch,
+			Width:    pw,
+			Height:   ph,
+			OffsetX:  int16(i%perDisplay) * pw,
+			Rotation: rotation,
+		}
+	}
+	return New(panels...)
+}
+
+// Size returns the size of the canvas.
+func (c *Canvas) Size() (x, y int16) {
+	return c.width, c.height
+}
+
+// SetPixel sets a pixel of the canvas on the panel that holds it.
+func (c *Canvas) SetPixel(x, y int16, col color.RGBA) {
+	for i := range c.panels {
+		p := &c.panels[i]
+		w, h := p.size()
+		px, py := x-p.X, y-p.Y
+		if px < 0 || py < 0 || px >= w || py >= h {
+			continue
+		}
+		px, py = p.xy(px, py)
+		p.Display.SetPixel(p.OffsetX+px, p.OffsetY+py, col)
+	}
+}
+
+// Display sends the buffers of all displays to their screens. It returns the
+// first error, but every display is updated.
+func (c *Canvas) Display() error {
+	var err error
+	for _, d := range c.displays {
+		if e := d.Display(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Panels returns the panels of the canvas.
+func (c *Canvas) Panels() []Panel {
+	return c.panels
+}
+
+// size returns the size of the panel on the canvas.
+func (p *Panel) size() (w, h int16) {
+	if p.Rotation&1 != 0 {
+		return p.Height, p.Width
+	}
+	return p.Width, p.Height
+}
+
+// xy changes canvas coordinates, relative to the panel, to coordinates of the
+// panel according to its rotation.
+func (p *Panel) xy(x, y int16) (int16, int16) {
+	if p.Rotation >= drivers.Rotation0Mirror {
+		w, _ := p.size()
+		x = w - x - 1
+	}
+	switch p.Rotation & 3 {
+	case drivers.Rotation90:
+		return p.Width - y - 1, x
+	case drivers.Rotation180:
+		return p.Width - x - 1, p.Height - y - 1
+	case drivers.Rotation270:
+		return y, p.Height - x - 1
+	}
+	return x, y
+}
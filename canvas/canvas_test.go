@@ -0,0 +1,122 @@
+package canvas
+
+import (
+	"errors"
+	"image/color"
+	"testing"
+
+	"tinygo.org/x/drivers"
+)
+
+type fakeDisplay struct {
+	width, height int16
+	pix           []bool
+	displayed     int
+	err           error
+}
+
+func newFake(w, h int16) *fakeDisplay {
+	return &fakeDisplay{width: w, height: h, pix: make([]bool, int(w)*int(h))}
+}
+
+func (d *fakeDisplay) Size() (int16, int16) { return d.width, d.height }
+
+func (d *fakeDisplay) SetPixel(x, y int16, c color.RGBA) {
+	if x < 0 || y < 0 || x >= d.width || y >= d.height {
+		panic("pixel out of range")
+	}
+	d.pix[int(y)*int(d.width)+int(x)] = c.R != 0
+}
+
+func (d *fakeDisplay) Display() error {
+	d.displayed++
+	return d.err
+}
+
+func (d *fakeDisplay) get(x, y int16) bool {
+	return d.pix[int(y)*int(d.width)+int(x)]
+}
+
+var white = color.RGBA{255, 255, 255, 255}
+
+func TestRotation(t *testing.T) {
+	tests := []struct {
+		rotation drivers.Rotation
+		x, y     int16 // pixel set on the 4x2 panel for the canvas pixel (1, 0)
+	}{
+		{drivers.Rotation0, 1, 0},
+		{drivers.Rotation90, 3, 1},
+		{drivers.Rotation180, 2, 1},
+		{drivers.Rotation270, 0, 0},
+		{drivers.Rotation0Mirror, 2, 0},
+	}
+	for _, tc := range tests {
+		d := newFake(4, 2)
+		c := New(Panel{Display: d, Rotation: tc.rotation})
+		w, h := c.Size()
+		if tc.rotation&1 != 0 {
+			w, h = h, w
+		}
+		if w != 4 || h != 2 {
+			t.Errorf("rotation %d: wrong size %dx%d", tc.rotation, w, h)
+		}
+		c.SetPixel(1, 0, white)
+		if !d.get(tc.x, tc.y) {
+			t.Errorf("rotation %d: pixel (%d, %d) not set", tc.rotation, tc.x, tc.y)
+		}
+	}
+}
+
+func TestGrid(t *testing.T) {
+	displays := []*fakeDisplay{newFake(4, 2), newFake(4, 2), newFake(4, 2), newFake(4, 2)}
+	c := NewGrid(GridConfig{Columns: 2, Rows: 2, Serpentine: true},
+		displays[0], displays[1], displays[2], displays[3])
+	if w, h := c.Size(); w != 8 || h != 4 {
+		t.Fatalf("wrong size %dx%d", w, h)
+	}
+
+	c.SetPixel(5, 0, white) // second panel
+	if !displays[1].get(1, 0) {
+		t.Error("pixel not set on second panel")
+	}
+	c.SetPixel(5, 2, white) // bottom right, third in the chain and upside down
+	if !displays[2].get(2, 1) {
+		t.Error("pixel not set on third panel")
+	}
+	c.SetPixel(0, 3, white) // bottom left, last in the chain and upside down
+	if !displays[3].get(3, 0) {
+		t.Error("pixel not set on fourth panel")
+	}
+}
+
+func TestChain(t *testing.T) {
+	// a chain of 3 panels of 4x2 driven as a single 12x2 display, stacked
+	// vertically on the canvas
+	d := newFake(12, 2)
+	c := NewGrid(GridConfig{Columns: 1, Rows: 3}, d)
+	if w, h := c.Size(); w != 4 || h != 6 {
+		t.Fatalf("wrong size %dx%d", w, h)
+	}
+	c.SetPixel(3, 5, white)
+	if !d.get(11, 1) {
+		t.Error("pixel not set on the last panel of the chain")
+	}
+
+	c.Display()
+	if d.displayed != 1 {
+		t.Errorf("display updated %d times, expected once", d.displayed)
+	}
+}
+
+func TestDisplayError(t *testing.T) {
+	errFailed := errors.New("failed")
+	a, b := newFake(2, 2), newFake(2, 2)
+	a.err = errFailed
+	c := New(Panel{Display: a}, Panel{Display: b, X: 2})
+	if err := c.Display(); err != errFailed {
+		t.Errorf("expected error, got %v", err)
+	}
+	if b.displayed != 1 {
+		t.Error("second display not updated")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+
+	"tinygo.org/x/drivers/image/dither"
+	"tinygo.org/x/drivers/image/rle"
+	"tinygo.org/x/drivers/pixel"
+)
+
+// pixelFormat describes an output format of the asset compiler.
+type pixelFormat struct {
+	name    string // name of the pixel type, or "" for the epd format
+	convert func(img image.Image, method dither.Method) []byte
+}
+
+var formats = map[string]pixelFormat{
+	"rgb565be": {"RGB565BE", convertTo[pixel.RGB565BE]},
+	"rgb444be": {"RGB444BE", convertTo[pixel.RGB444BE]},
+	"gray2":    {"Grayscale2bit", convertTo[pixel.Grayscale2bit]},
+	"mono":     {"Monochrome", convertTo[pixel.Monochrome]},
+	"epd":      {"", nil},
+}
+
+type options struct {
+	format   pixelFormat
+	dither   dither.Method
+	palette  dither.Palette
+	compress bool
+}
+
+func parseFormat(s string) (pixelFormat, error) {
+	f, ok := formats[s]
+	if !ok {
+		return f, fmt.Errorf("unknown format %q", s)
+	}
+	return f, nil
+}
+
+func parseDither(s string) (dither.Method, error) {
+	switch s {
+	case "none":
+		return dither.None, nil
+	case "bayer":
+		return dither.Bayer, nil
+	case "fs":
+		return dither.FloydSteinberg, nil
+	}
+	return 0, fmt.Errorf("unknown dithering method %q", s)
+}
+
+func parseAccent(s string) (dither.Palette, error) {
+	switch s {
+	case "red":
+		return dither.BlackWhiteRed, nil
+	case "yellow":
+		return dither.BlackWhiteYellow, nil
+	}
+	return nil, fmt.Errorf("unknown accent color %q", s)
+}
+
+// asset is a single image to be converted.
+type asset struct {
+	name          string
+	path          string
+	width, height int // requested size, 0 to keep the original
+}
+
+func writeImports(w io.Writer, opts options) {
+	if opts.format.name == "" {
+		// The epd format only declares constants.
+		return
+	}
+	fmt.Fprintf(w, "import (\n")
+	if !opts.compress {
+		fmt.Fprintf(w, "\t\"unsafe\"\n\n")
+	} else {
+		fmt.Fprintf(w, "\t\"tinygo.org/x/drivers/image/rle\"\n")
+	}
+	fmt.Fprintf(w, "\t\"tinygo.org/x/drivers/pixel\"\n")
+	fmt.Fprintf(w, ")\n\n")
+}
+
+// convert reads the image of a, converts it according to opts and writes the
+// resulting Go declarations to w.
+func (a asset) convert(w io.Writer, opts options) error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return err
+	}
+	img = resize(img, a.width, a.height)
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	if opts.format.name == "" {
+		black, colored := convertEPD(img, opts.dither, opts.palette)
+		fmt.Fprintf(w, "// %s is a %dx%d image for three color e-paper displays, converted from %s.\n", a.name, width, height, filepath.Base(a.path))
+		fmt.Fprintf(w, "// Each plane has 1 bit per pixel, most significant bit first. In the\n")
+		fmt.Fprintf(w, "// black plane a 0 bit is black, in the color plane a 1 bit is colored.\n")
+		fmt.Fprintf(w, "const (\n\t%sWidth = %d\n\t%sHeight = %d\n)\n\n", a.name, width, a.name, height)
+		if opts.compress {
+			fmt.Fprintf(w, "// The planes are compressed, use rle.Decode to decompress them.\n")
+			black, colored = rle.Encode(nil, black), rle.Encode(nil, colored)
+		}
+		writeString(w, "const "+a.name+"Black = ", black)
+		fmt.Fprintf(w, "\n")
+		writeString(w, "const "+a.name+"Color = ", colored)
+		fmt.Fprintf(w, "\n")
+		return nil
+	}
+
+	data := opts.format.convert(img, opts.dither)
+	typ := "pixel." + opts.format.name
+	fmt.Fprintf(w, "// %s returns the %dx%d image converted from %s.\n", a.name, width, height, filepath.Base(a.path))
+	if opts.compress {
+		fmt.Fprintf(w, "// The image is decompressed into a newly allocated buffer.\n")
+		fmt.Fprintf(w, "func %s() pixel.Image[%s] {\n", a.name, typ)
+		fmt.Fprintf(w, "\tbuf := make([]byte, %d)\n", len(data))
+		fmt.Fprintf(w, "\tif _, err := rle.Decode(buf, %sData); err != nil {\n\t\tpanic(err)\n\t}\n", a.name)
+		fmt.Fprintf(w, "\treturn pixel.NewImageFromBytes[%s](%d, %d, buf)\n}\n\n", typ, width, height)
+		data = rle.Encode(nil, data)
+	} else {
+		fmt.Fprintf(w, "// The image data is stored in flash and must not be modified.\n")
+		fmt.Fprintf(w, "func %s() pixel.Image[%s] {\n", a.name, typ)
+		fmt.Fprintf(w, "\tbuf := unsafe.Slice(unsafe.StringData(%sData), len(%sData))\n", a.name, a.name)
+		fmt.Fprintf(w, "\treturn pixel.NewImageFromBytes[%s](%d, %d, buf)\n}\n\n", typ, width, height)
+	}
+	writeString(w, "const "+a.name+"Data = ", data)
+	fmt.Fprintf(w, "\n")
+	return nil
+}
+
+// convertTo converts img to the pixel format T and returns the raw image data.
+func convertTo[T pixel.Color](img image.Image, method dither.Method) []byte {
+	b := img.Bounds()
+	out := pixel.NewImage[T](b.Dx(), b.Dy())
+	d := dither.New[T](b.Dx(), method)
+	forEachRow(img, func(data []uint16, y int) {
+		d.Draw(data, 0, int16(y), int16(len(data)), 1, func(x, y int16, c T) {
+			out.Set(int(x), int(y), c)
+		})
+	})
+	return out.RawBuffer()
+}
+
+// convertEPD converts img to the black and color planes used by three color
+// e-paper displays.
+func convertEPD(img image.Image, method dither.Method, p dither.Palette) (black, colored []byte) {
+	b := img.Bounds()
+	stride := (b.Dx() + 7) / 8
+	black = make([]byte, stride*b.Dy())
+	colored = make([]byte, stride*b.Dy())
+	d := dither.NewPalette(b.Dx(), method, p)
+	forEachRow(img, func(data []uint16, y int) {
+		d.Draw(data, 0, int16(y), int16(len(data)), 1, func(x, y int16, c uint8) {
+			i, bit := int(y)*stride+int(x)/8, byte(0x80)>>(x%8)
+			switch c {
+			case 1: // white
+				black[i] |= bit
+			case 2: // colored
+				black[i] |= bit
+				colored[i] |= bit
+			}
+		})
+	})
+	return black, colored
+}
+
+// forEachRow calls fn with each row of img in RGB565, the format of the
+// image/png and image/jpeg callbacks.
+func forEachRow(img image.Image, fn func(data []uint16, y int)) {
+	bounds := img.Bounds()
+	row := make([]uint16, bounds.Dx())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			row[x-bounds.Min.X] = uint16(r>>11)<<11 | uint16(g>>10)<<5 | uint16(b>>11)
+		}
+		fn(row, y-bounds.Min.Y)
+	}
+}
+
+// resize scales img to width x height by averaging the source pixels covered
+// by each destination pixel. If one of the dimensions is 0 it is computed from
+// the aspect ratio, and if both are 0 img is returned unchanged.
+func resize(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	switch {
+	case width == 0 && height == 0:
+		return img
+	case width == 0:
+		width = (bounds.Dx()*height + bounds.Dy()/2) / bounds.Dy()
+	case height == 0:
+		height = (bounds.Dy()*width + bounds.Dx()/2) / bounds.Dx()
+	}
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/width, x0+1)
+			var sum [4]uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, a := img.At(sx, sy).RGBA()
+					sum[0] += r
+					sum[1] += g
+					sum[2] += b
+					sum[3] += a
+				}
+			}
+			n := uint32((x1 - x0) * (y1 - y0))
+			i := out.PixOffset(x, y)
+			for c := range sum {
+				out.Pix[i+c] = uint8(sum[c] / n >> 8)
+			}
+		}
+	}
+	return out
+}
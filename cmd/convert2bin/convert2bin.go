@@ -1,49 +1,189 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"go/format"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 // See ../../image/README.md for the usage.
 
 func main() {
-	err := run(os.Args)
+	err := run(os.Args, os.Stdout)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func run(args []string) error {
-	if len(args) < 2 {
-		return fmt.Errorf("usage: %s FILE")
+const usage = `usage: %s [flags] [NAME=]FILE[@WxH] ...
+
+Without -format, the contents of each FILE is written as a Go string constant,
+to be decoded at runtime with the image/png or image/jpeg packages.
+
+With -format, each PNG or JPEG FILE is converted to the given pixel format and
+written as a function returning a pixel.Image. The image can be resized by
+appending @WxH to the file name or with -size. If W or H is 0, it is computed
+from the aspect ratio.
+
+flags:
+`
+
+func run(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), usage, args[0])
+		flags.PrintDefaults()
+	}
+	var (
+		formatName = flags.String("format", "", "pixel format: rgb565be, rgb444be, gray2, mono or epd")
+		size       = flags.String("size", "", "resize all images to `WxH`")
+		ditherName = flags.String("dither", "none", "dithering method: none, bayer or fs")
+		accent     = flags.String("accent", "red", "third color of the epd format: red or yellow")
+		compress   = flags.Bool("rle", false, "compress the image data with run-length encoding")
+		pkg        = flags.String("pkg", "main", "package name of the generated file")
+		output     = flags.String("o", "", "write to `FILE` instead of stdout")
+	)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no input files")
 	}
 
-	b, err := ioutil.ReadFile(args[1])
-	if err != nil {
+	if *formatName == "" {
+		// The other flags only apply to the conversion, don't ignore them.
+		var err error
+		flags.Visit(func(f *flag.Flag) {
+			if err == nil && f.Name != "o" {
+				err = fmt.Errorf("-%s requires -format", f.Name)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var assets []asset
+	for _, arg := range flags.Args() {
+		a, err := parseAsset(arg, *size)
+		if err != nil {
+			return err
+		}
+		if *formatName == "" && (a.width != 0 || a.height != 0) {
+			return fmt.Errorf("%s: resizing requires -format", arg)
+		}
+		assets = append(assets, a)
+	}
+
+	var buf bytes.Buffer
+	if *formatName == "" {
+		// Plain dump of the file, as used by the image/png and image/jpeg
+		// examples.
+		for _, a := range assets {
+			b, err := os.ReadFile(a.path)
+			if err != nil {
+				return err
+			}
+			writeString(&buf, "const "+a.name+" = ", b)
+		}
+		return writeOutput(*output, stdout, buf.Bytes())
+	}
+
+	opts := options{compress: *compress}
+	var err error
+	if opts.format, err = parseFormat(*formatName); err != nil {
+		return err
+	}
+	if opts.dither, err = parseDither(*ditherName); err != nil {
+		return err
+	}
+	if opts.palette, err = parseAccent(*accent); err != nil {
 		return err
 	}
 
-	fmt.Printf("const %s = \"\" +\n", strings.Replace(args[1], ".", "_", -1))
+	fmt.Fprintf(&buf, "// Code generated by convert2bin; DO NOT EDIT.\n\npackage %s\n\n", *pkg)
+	writeImports(&buf, opts)
+	for _, a := range assets {
+		if err := a.convert(&buf, opts); err != nil {
+			return fmt.Errorf("%s: %w", a.path, err)
+		}
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting generated code: %w", err)
+	}
+	return writeOutput(*output, stdout, src)
+}
 
-	i := 0
-	max := 32
-	for i = 0; i < len(b); i++ {
-		bb := b[i]
-		if (i % max) == 0 {
-			fmt.Printf("	\"")
+// parseAsset parses an input argument of the form [NAME=]FILE[@WxH]. The
+// default size is used if the argument does not specify one.
+func parseAsset(arg, defaultSize string) (asset, error) {
+	a := asset{path: arg}
+	if name, path, ok := strings.Cut(arg, "="); ok {
+		a.name, a.path = name, path
+	}
+	size := defaultSize
+	if path, s, ok := strings.Cut(a.path, "@"); ok {
+		a.path, size = path, s
+	}
+	if size != "" {
+		if _, err := fmt.Sscanf(size, "%dx%d", &a.width, &a.height); err != nil || a.width < 0 || a.height < 0 {
+			return a, fmt.Errorf("invalid size %q, expected WxH", size)
 		}
-		fmt.Printf("\\x%02X", bb)
-		if (i%max) == max-1 && i != len(b)-1 {
-			fmt.Printf("\" + \n")
+	}
+	if a.name == "" {
+		a.name = strings.Replace(filepath.Base(a.path), ".", "_", -1)
+	}
+	if !isIdentifier(a.name) {
+		return a, fmt.Errorf("%q is not a valid Go identifier", a.name)
+	}
+	return a, nil
+}
+
+func isIdentifier(s string) bool {
+	for i, c := range s {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
 		}
 	}
-	if (i % max) < max-1 {
-		fmt.Printf("\"\n")
+	return s != ""
+}
+
+func writeOutput(path string, stdout io.Writer, b []byte) error {
+	if path == "" {
+		_, err := stdout.Write(b)
+		return err
 	}
+	return os.WriteFile(path, b, 0o644)
+}
 
-	return nil
+// writeString writes b as a Go string literal split over multiple lines,
+// preceded by prefix.
+func writeString(w io.Writer, prefix string, b []byte) {
+	const lineLen = 32
+	if len(b) == 0 {
+		fmt.Fprintf(w, "%s\"\"\n", prefix)
+		return
+	}
+	fmt.Fprintf(w, "%s\"\" +\n", prefix)
+	for i := 0; i < len(b); i += lineLen {
+		line := b[i:min(i+lineLen, len(b))]
+		fmt.Fprintf(w, "\t\"")
+		for _, bb := range line {
+			fmt.Fprintf(w, "\\x%02X", bb)
+		}
+		if i+lineLen < len(b) {
+			fmt.Fprintf(w, "\" +\n")
+		} else {
+			fmt.Fprintf(w, "\"\n")
+		}
+	}
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tinygo.org/x/drivers/image/dither"
+	"tinygo.org/x/drivers/image/rle"
+	"tinygo.org/x/drivers/pixel"
+)
+
+func writeTestPNG(t *testing.T) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			if x < 20 {
+				img.Set(x, y, color.RGBA{0xff, 0xff, 0xff, 0xff})
+			} else {
+				img.Set(x, y, color.RGBA{0xff, 0x00, 0x00, 0xff})
+			}
+		}
+	}
+	path := filepath.Join(t.TempDir(), "test.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRaw(t *testing.T) {
+	path := writeTestPNG(t)
+	var out bytes.Buffer
+	if err := run([]string{"convert2bin", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "const test_png = \"\" +\n\t\"\\x89\\x50\\x4E\\x47") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+
+	// The conversion flags are rejected without a format
+	for _, args := range [][]string{
+		{"-rle", path},
+		{"-dither", "fs", path},
+		{"-accent", "yellow", path},
+		{"-size", "8x4", path},
+		{"-pkg", "assets", path},
+		{path + "@8x4"},
+	} {
+		args = append([]string{"convert2bin"}, args...)
+		if err := run(args, &out); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	path := writeTestPNG(t)
+	for _, args := range [][]string{
+		{"-format", "rgb565be"},
+		{"-format", "rgb444be", "-dither", "bayer"},
+		{"-format", "gray2", "-dither", "fs", "-rle"},
+		{"-format", "mono", "-size", "20x0"},
+		{"-format", "epd", "-rle"},
+	} {
+		var out bytes.Buffer
+		args = append(append([]string{"convert2bin"}, args...), "first="+path, "second="+path+"@8x4")
+		if err := run(args, &out); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		for _, s := range []string{"package main", "firstData", "secondData", "8x4"} {
+			if args[2] == "epd" {
+				s = strings.Replace(s, "Data", "Black", 1)
+			}
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("%v: output does not contain %q", args, s)
+			}
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	path := writeTestPNG(t)
+	a, err := parseAsset("img="+path+"@10x5", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.name != "img" || a.path != path || a.width != 10 || a.height != 5 {
+		t.Fatalf("unexpected asset: %+v", a)
+	}
+
+	f, _ := os.Open(path)
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img = resize(img, 10, 5)
+
+	// The left half is white and the right half red.
+	data := formats["rgb565be"].convert(img, 0)
+	m := pixel.NewImageFromBytes[pixel.RGB565BE](10, 5, data)
+	if c := m.Get(2, 2).RGBA(); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("expected white, got %v", c)
+	}
+	if c := m.Get(7, 2).RGBA(); c != (color.RGBA{0xff, 0x00, 0x00, 0xff}) {
+		t.Errorf("expected red, got %v", c)
+	}
+
+	black, colored := convertEPD(img, 0, dither.BlackWhiteRed)
+	packed := rle.Encode(nil, colored)
+	unpacked := make([]byte, len(colored))
+	if _, err := rle.Decode(unpacked, packed); err != nil || !bytes.Equal(unpacked, colored) {
+		t.Fatalf("rle roundtrip failed: %v", err)
+	}
+	// Rows are 2 bytes wide: 5 white pixels, 5 red pixels and padding.
+	for y := 0; y < 5; y++ {
+		if black[2*y] != 0xff || black[2*y+1] != 0xc0 {
+			t.Errorf("row %d: unexpected black plane %08b %08b", y, black[2*y], black[2*y+1])
+		}
+		if colored[2*y] != 0x07 || colored[2*y+1] != 0xc0 {
+			t.Errorf("row %d: unexpected color plane %08b %08b", y, colored[2*y], colored[2*y+1])
+		}
+	}
+}
+
+func TestParseAsset(t *testing.T) {
+	for _, arg := range []string{"x=a.png@10", "x=a.png@-1x2", "1x=a.png"} {
+		if _, err := parseAsset(arg, ""); err == nil {
+			t.Errorf("%q: expected an error", arg)
+		}
+	}
+	a, err := parseAsset("dir/logo.png", "16x0")
+	if err != nil || a.name != "logo_png" || a.width != 16 || a.height != 0 {
+		t.Errorf("unexpected result: %+v, %v", a, err)
+	}
+}
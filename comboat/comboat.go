@@ -3,8 +3,9 @@
 // is a RTL8720d variant.  The driver interface is via AT command set over UART
 // (see reference docs below).
 //
-// NOTE: the driver doesn't support UDP/TCP server connections in STA mode,
-// currently.  UDP/TCP/TLS client connections are supported in STA mode.
+// UDP/TCP/TLS client connections and UDP/TCP server sockets are supported.
+// NOTE: the reference examples only show servers in AP mode, and some firmware
+// versions fail to bind server sockets in STA mode ("Socket bind error").
 //
 // https://aithinker-combo-guide.readthedocs.io/en/latest/docs/instruction/index.html
 // https://aithinker-combo-guide.readthedocs.io/en/latest/docs/command-set/index.html
@@ -17,10 +18,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"machine"
 	"net"
 	"net/netip"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,11 +30,11 @@ import (
 	"tinygo.org/x/drivers/netlink"
 )
 
-type Config struct {
-	BaudRate uint32
-	Uart     *machine.UART
-	Tx       machine.Pin
-	Rx       machine.Pin
+// serial is the UART connected to the WiFi device.
+type serial interface {
+	io.Writer
+	io.ByteReader
+	Buffered() int
 }
 
 type socket struct {
@@ -42,11 +43,12 @@ type socket struct {
 	rx        chan []byte
 	remainder []byte
 	laddr     netip.AddrPort // Set in Bind()
+	backlog   chan *socket   // Set in Listen() for TCP servers
 }
 
 type device struct {
-	cfg     *Config
-	uart    *machine.UART
+	setup   func() serial
+	uart    serial
 	uartMu  sync.Mutex
 	mac     net.HardwareAddr
 	ip      netip.Addr
@@ -56,19 +58,32 @@ type device struct {
 	last    []byte
 	ok      chan bool
 	txReady chan bool
-	accept  chan string
 	err     chan error
 	sockets [8]*socket
+	// connections accepted by a server, waiting for Accept()
+	pending []*socket
+	// access points found by AT+WSCAN
+	scanning bool
+	aps      []netlink.AccessPoint
+
+	params          *netlink.ConnectParams
+	notifyCb        func(netlink.Event)
+	killWatchdog    chan bool
+	watchdogRunning bool
+
+	// bytes sent and received on sockets
+	txBytes uint64
+	rxBytes uint64
 	sync.Mutex
 }
 
-func NewDevice(cfg *Config) *device {
+func newDevice() *device {
 	return &device{
-		cfg:     cfg,
 		ok:      make(chan bool),
 		txReady: make(chan bool),
-		accept:  make(chan string),
 		err:     make(chan error),
+
+		killWatchdog: make(chan bool),
 	}
 }
 
@@ -189,6 +204,11 @@ func (d *device) send(cmd string, timeout int) (err error) {
 
 func (d *device) findSocket(id string) (*socket, error) {
 	for _, s := range d.sockets {
+		if s != nil && s.id == id {
+			return s, nil
+		}
+	}
+	for _, s := range d.pending {
 		if s.id == id {
 			return s, nil
 		}
@@ -196,6 +216,27 @@ func (d *device) findSocket(id string) (*socket, error) {
 	return nil, errors.New("Socket not found with id: " + id)
 }
 
+// seed queues a connection accepted by a TCP server until Accept() returns it.
+func (d *device) seed(id, serverID string) {
+	server, err := d.findSocket(serverID)
+	if err != nil || server.backlog == nil {
+		logError("Connection " + id + " to unknown server " + serverID)
+		return
+	}
+	s := &socket{
+		protocol: netdev.IPPROTO_TCP,
+		id:       id,
+		rx:       make(chan []byte, 10),
+	}
+	select {
+	case server.backlog <- s:
+		// Keep the socket around to receive the data sent before Accept()
+		d.pending = append(d.pending, s)
+	default:
+		logError("Backlog full, dropping connection " + id)
+	}
+}
+
 func (d *device) getSocket(sockfd int) (*socket, error) {
 	if sockfd < 0 || sockfd+1 > len(d.sockets) {
 		return nil, netdev.ErrInvalidSocketFd
@@ -219,8 +260,10 @@ func (d *device) handle(event []byte) {
 		}
 
 	// SocketSeed,<id>,<server id>
-	case bytes.HasPrefix(event, []byte("SocketSeed,2,1")):
-		//d.uart.Write([]byte("AT+SOCKET?" + "\r\n"))
+	case bytes.HasPrefix(event, []byte("SocketSeed")):
+		id := split(event, 1, ",", "SocketSeed")
+		serverID := split(event, 2, ",", "SocketSeed")
+		d.seed(id, serverID)
 	}
 }
 
@@ -292,6 +335,12 @@ func (d *device) processUART() {
 		event := sofar[len("+EVENT:"):]
 		d.handle(event)
 
+	case d.scanning && len(sofar) > 0:
+		d.pos = 0
+		if ap, ok := parseAP(sofar); ok {
+			d.aps = append(d.aps, ap)
+		}
+
 	default:
 		// Catch everything else and store in d.last
 		d.pos = 0
@@ -325,19 +374,21 @@ func (d *device) serviceUART() {
 	}
 }
 
+// start sets up the UART, once.
+func (d *device) start() {
+	if d.uart == nil {
+		d.uart = d.setup()
+		go d.serviceUART()
+	}
+}
+
 func (d *device) NetConnect(params *netlink.ConnectParams) error {
 
 	d.Lock()
 	defer d.Unlock()
 
-	d.uart = d.cfg.Uart
-	d.uart.Configure(machine.UARTConfig{
-		BaudRate: d.cfg.BaudRate,
-		TX:       d.cfg.Tx,
-		RX:       d.cfg.Rx,
-	})
-
-	go d.serviceUART()
+	d.params = params
+	d.start()
 
 	fmt.Printf("\r\n")
 	fmt.Printf("TinyGo Combo-AT WiFi network device driver\r\n")
@@ -424,18 +475,220 @@ func (d *device) NetConnect(params *netlink.ConnectParams) error {
 		return err
 	}
 
+	if d.notifyCb != nil {
+		d.notifyCb(netlink.EventNetUp)
+	}
+
+	// The watchdog only checks the signal strength
+	if d.watching() && !d.watchdogRunning {
+		d.watchdogRunning = true
+		go d.watchdog(d.params.WatchdogTimeout, d.params.LowSignal)
+	}
+
 	return nil
 }
 
 func (d *device) NetDisconnect() {
+	// The watchdog is only running after a successful NetConnect
+	d.Lock()
+	running := d.watchdogRunning
+	d.watchdogRunning = false
+	d.Unlock()
+	if running {
+		d.killWatchdog <- true
+	}
+
 	d.Lock()
 	defer d.Unlock()
 	// Disconnect from WiFi AP
 	d.execute("AT+WDISCONNECT", 1000)
+	d.ip = netip.Addr{}
+
+	if d.notifyCb != nil {
+		d.notifyCb(netlink.EventNetDown)
+	}
 }
 
 func (d *device) NetNotify(cb func(netlink.Event)) {
-	fmt.Printf("\r\n%s\r\n", netlink.ErrNotSupported)
+	d.Lock()
+	d.notifyCb = cb
+	d.Unlock()
+}
+
+func (d *device) watching() bool {
+	return d.params != nil && d.params.WatchdogTimeout != 0 && d.params.LowSignal != 0
+}
+
+func (d *device) watchdog(timeout time.Duration, lowSignal int) {
+	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.killWatchdog:
+			return
+		case <-ticker.C:
+			d.Lock()
+			info := d.linkInfo()
+			cb := d.notifyCb
+			d.Unlock()
+			// Not all firmware versions report the RSSI
+			if info.Up && info.Rssi != 0 && info.Rssi < lowSignal && cb != nil {
+				cb(netlink.EventNetLowSignal)
+			}
+		}
+	}
+}
+
+func (d *device) NetInfo() (netlink.LinkInfo, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.uart == nil || d.params == nil {
+		return netlink.LinkInfo{}, nil
+	}
+	return d.linkInfo(), nil
+}
+
+// linkInfo queries the connection to the AP.  The fields of the reply differ
+// between firmware versions, so the BSSID and RSSI are told apart by their
+// format, as in parseAP.  The channel is not reported.
+func (d *device) linkInfo() netlink.LinkInfo {
+	info := netlink.LinkInfo{
+		TxBytes: d.txBytes,
+		RxBytes: d.rxBytes,
+	}
+
+	if err := d.execute("AT+WJAP?", 1000); err != nil {
+		return info
+	}
+	d.saveIP()
+	if !d.ip.IsValid() || d.ip.IsUnspecified() {
+		return info
+	}
+
+	info.Up = true
+	info.Ssid = d.params.Ssid
+	for _, field := range strings.Split(string(d.last), ",") {
+		if mac, err := net.ParseMAC(field); err == nil && info.Bssid == nil &&
+			!bytes.Equal(mac, d.mac) {
+			info.Bssid = mac
+		} else if n, err := strconv.Atoi(field); err == nil && n < 0 {
+			info.Rssi = n
+		}
+	}
+	return info
+}
+
+func (d *device) NetScan() ([]netlink.AccessPoint, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.start()
+
+	d.uartMu.Lock()
+	d.scanning = true
+	d.aps = nil
+	d.uartMu.Unlock()
+
+	err := d.execute("AT+WSCAN", 10000)
+
+	d.uartMu.Lock()
+	defer d.uartMu.Unlock()
+	d.scanning = false
+	if err != nil {
+		return nil, netlink.ErrScanFailed
+	}
+	return d.aps, nil
+}
+
+// parseAP parses an access point found by AT+WSCAN. The order of the fields
+// differs between firmware versions, so they are told apart by their format:
+// the BSSID is a MAC address, the RSSI is negative, the channel comes before
+// the security, and what is left is the SSID.
+func parseAP(line []byte) (ap netlink.AccessPoint, ok bool) {
+	var ssid []string
+	channel := false
+	ap.AuthType = netlink.AuthTypeUnknown
+	line = bytes.TrimPrefix(line, []byte("+WSCAN:"))
+	for _, field := range strings.Split(string(line), ",") {
+		field = strings.TrimSpace(field)
+		if mac, err := net.ParseMAC(field); err == nil && ap.Bssid == nil {
+			ap.Bssid = mac
+			continue
+		}
+		if n, err := strconv.Atoi(field); err == nil {
+			switch {
+			case n < 0:
+				ap.Rssi = n
+			case !channel:
+				ap.Channel = n
+				channel = true
+			default:
+				ap.AuthType = authType(n)
+			}
+			continue
+		}
+		if auth, ok := authKeyword(field); ok {
+			ap.AuthType = auth
+			continue
+		}
+		ssid = append(ssid, field)
+	}
+	ap.Ssid = strings.Trim(strings.Join(ssid, ","), `"`)
+	// the header line only names the fields
+	if ap.Bssid == nil || ap.Ssid == "SSID" {
+		return ap, false
+	}
+	return ap, true
+}
+
+// authType converts a security mode number, numbered as by the ESP-AT
+// firmware.
+func authType(n int) netlink.AuthType {
+	switch n {
+	case 0:
+		return netlink.AuthTypeOpen
+	case 1:
+		return netlink.AuthTypeWEP
+	case 2:
+		return netlink.AuthTypeWPA
+	case 3:
+		return netlink.AuthTypeWPA2
+	case 4:
+		return netlink.AuthTypeWPA2Mixed
+	case 5:
+		return netlink.AuthTypeEAP
+	case 6:
+		return netlink.AuthTypeWPA3
+	case 7:
+		return netlink.AuthTypeWPA3Mixed
+	default:
+		return netlink.AuthTypeUnknown
+	}
+}
+
+// authKeyword converts a security mode name, such as "WPA2_AES_PSK".
+func authKeyword(s string) (netlink.AuthType, bool) {
+	s = strings.ToUpper(s)
+	switch {
+	case strings.HasPrefix(s, "OPEN"):
+		return netlink.AuthTypeOpen, true
+	case strings.HasPrefix(s, "WEP"):
+		return netlink.AuthTypeWEP, true
+	case strings.HasPrefix(s, "WPA2_WPA3"), strings.HasPrefix(s, "WPA3_WPA2"):
+		return netlink.AuthTypeWPA3Mixed, true
+	case strings.HasPrefix(s, "WPA3"):
+		return netlink.AuthTypeWPA3, true
+	case strings.HasPrefix(s, "WPA_WPA2"), strings.HasPrefix(s, "WPA2_MIXED"):
+		return netlink.AuthTypeWPA2Mixed, true
+	case strings.Contains(s, "ENTERPRISE"):
+		return netlink.AuthTypeEAP, true
+	case strings.HasPrefix(s, "WPA2"):
+		return netlink.AuthTypeWPA2, true
+	case strings.HasPrefix(s, "WPA"):
+		return netlink.AuthTypeWPA, true
+	}
+	return 0, false
 }
 
 func (d *device) GetHardwareAddr() (net.HardwareAddr, error) {
@@ -565,46 +818,93 @@ func (d *device) Connect(sockfd int, host string, ip netip.AddrPort) error {
 
 func (d *device) Listen(sockfd, backlog int) error {
 
-	// TODO Creating a TCP server socket isn't working when in STA mode,
-	// TODO returning error "Socket bind error".
-	// TODO The reference example shows a TCP server example in AP mode.
+	var cmd string
 
-	/*
-		var cmd string
+	d.Lock()
+	defer d.Unlock()
 
-		d.Lock()
-		defer d.Unlock()
+	s, err := d.getSocket(sockfd)
+	if err != nil {
+		return err
+	}
 
-		s, err := d.getSocket(sockfd)
-		if err != nil {
-			return err
-		}
+	port := strconv.Itoa(int(s.laddr.Port()))
 
-		port := strconv.Itoa(int(s.laddr.Port()))
+	switch s.protocol {
+	case netdev.IPPROTO_UDP:
+		cmd = "AT+SOCKET=1," + port
+	case netdev.IPPROTO_TCP:
+		cmd = "AT+SOCKET=3," + port
+		s.backlog = make(chan *socket, max(backlog, 1))
+	}
 
-		switch s.protocol {
-		case netdev.IPPROTO_UDP:
-			cmd = "AT+SOCKET=1," + port
-		case netdev.IPPROTO_TCP:
-			cmd = "AT+SOCKET=3," + port
-		}
+	if cmd == "" {
+		return netdev.ErrProtocolNotSupported
+	}
 
-		if cmd == "" {
-			return netdev.ErrProtocolNotSupported
-		}
+	if err := d.execute(cmd, 20000); err != nil {
+		s.backlog = nil
+		return err
+	}
 
-		if err := d.execute(cmd, 20000); err != nil {
-			return err
+	s.id = split(d.last, 1, "=", "connection ID")
+
+	return nil
+}
+
+// Accept waits for a connection to the TCP server.  The device doesn't report
+// the address of the remote end, so it is left empty.
+func (d *device) Accept(sockfd int) (int, netip.AddrPort, error) {
+
+	d.Lock()
+	s, err := d.getSocket(sockfd)
+	d.Unlock()
+
+	if err != nil {
+		return -1, netip.AddrPort{}, err
+	}
+	if s.backlog == nil {
+		return -1, netip.AddrPort{}, errors.New("Socket is not listening")
+	}
+
+	// Wait for a connection without holding the lock
+	c, ok := <-s.backlog
+	if !ok {
+		return -1, netip.AddrPort{}, net.ErrClosed
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	// Move the connection from pending to sockets at once, so no data
+	// gets lost in between
+	d.uartMu.Lock()
+	d.unpend(c)
+	fd := -1
+	for i, s := range d.sockets {
+		if s == nil {
+			d.sockets[i] = c
+			fd = i
+			break
 		}
+	}
+	d.uartMu.Unlock()
 
-		s.id = split(d.last, 1, "=", "connection ID")
-	*/
+	if fd < 0 {
+		d.execute("AT+SOCKETDEL="+c.id, 1000)
+		return -1, netip.AddrPort{}, netdev.ErrNoMoreSockets
+	}
 
-	return netdev.ErrNotSupported
+	return fd, netip.AddrPort{}, nil
 }
 
-func (d *device) Accept(sockfd int) (int, netip.AddrPort, error) {
-	return 0, netip.AddrPort{}, netdev.ErrNotSupported
+func (d *device) unpend(c *socket) {
+	for i, s := range d.pending {
+		if s == c {
+			d.pending = append(d.pending[:i], d.pending[i+1:]...)
+			return
+		}
+	}
 }
 
 func (d *device) Send(sockfd int, buf []byte, flags int, deadline time.Time) (int, error) {
@@ -644,6 +944,7 @@ func (d *device) Send(sockfd int, buf []byte, flags int, deadline time.Time) (in
 	case <-t.C:
 		return 0, errors.New("Timed out")
 	case <-d.ok:
+		d.txBytes += uint64(n)
 		return n, nil
 	case err = <-d.err:
 		return 0, err
@@ -664,6 +965,7 @@ func (d *device) Recv(sockfd int, buf []byte, flags int, deadline time.Time) (in
 	if len(s.remainder) > 0 {
 		n := copy(buf, s.remainder)
 		s.remainder = s.remainder[n:]
+		d.rxBytes += uint64(n)
 		return n, nil
 	}
 
@@ -680,6 +982,7 @@ func (d *device) Recv(sockfd int, buf []byte, flags int, deadline time.Time) (in
 		s.remainder = data[n:]
 	}
 
+	d.rxBytes += uint64(n)
 	return n, nil
 }
 
@@ -701,7 +1004,22 @@ func (d *device) Close(sockfd int) error {
 		}
 	}
 
+	d.uartMu.Lock()
 	d.sockets[sockfd] = nil
+	if s.backlog != nil {
+		close(s.backlog)
+	}
+	d.uartMu.Unlock()
+
+	// Drop the connections not accepted yet
+	if s.backlog != nil {
+		for c := range s.backlog {
+			d.execute("AT+SOCKETDEL="+c.id, 1000)
+			d.uartMu.Lock()
+			d.unpend(c)
+			d.uartMu.Unlock()
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,229 @@
+package comboat
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+	"tinygo.org/x/drivers/netlink"
+)
+
+// step is an exchange with the scripted peer: the command or data it expects
+// from the driver, and its reply.
+type step struct {
+	expect string
+	reply  string
+}
+
+// peer is a Combo-AT device replaying a script over the UART.
+type peer struct {
+	t      *testing.T
+	mu     sync.Mutex
+	script []step
+	rx     []byte // written by the driver, not matched yet
+	tx     []byte // to be read by the driver
+}
+
+func (p *peer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rx = append(p.rx, b...)
+	for len(p.script) > 0 && len(p.rx) >= len(p.script[0].expect) {
+		step := p.script[0]
+		if string(p.rx[:len(step.expect)]) != step.expect {
+			p.t.Errorf("expected %q, got %q", step.expect, p.rx)
+		}
+		p.rx = p.rx[len(step.expect):]
+		p.tx = append(p.tx, step.reply...)
+		p.script = p.script[1:]
+	}
+	if len(p.script) == 0 && len(p.rx) > 0 {
+		p.t.Errorf("unexpected %q", p.rx)
+		p.rx = nil
+	}
+	return len(b), nil
+}
+
+func (p *peer) Buffered() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.tx)
+}
+
+func (p *peer) ReadByte() (byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.tx) == 0 {
+		return 0, io.EOF
+	}
+	b := p.tx[0]
+	p.tx = p.tx[1:]
+	return b, nil
+}
+
+func (p *peer) expect(cmd, reply string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.script = append(p.script, step{cmd, reply})
+}
+
+// send sends an unsolicited message.
+func (p *peer) send(msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tx = append(p.tx, msg...)
+}
+
+func newTestDevice(t *testing.T) (*device, *peer) {
+	p := &peer{t: t}
+	d := newDevice()
+	d.uart = p
+	go d.serviceUART()
+	return d, p
+}
+
+func TestServer(t *testing.T) {
+	d, p := newTestDevice(t)
+	buf := make([]byte, 64)
+
+	fd, err := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Bind(fd, netip.AddrPortFrom(netip.IPv4Unspecified(), 80))
+	p.expect("AT+SOCKET=3,80\r\n", "connect success ConID=1\r\nOK\r\n")
+	if err := d.Listen(fd, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// a client connects and sends a request before it is accepted
+	p.send("+EVENT:SocketSeed,2,1\r\n+EVENT:SocketDown,2,5,hello\r\n")
+	c, _, err := d.Accept(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == fd {
+		t.Fatal("connection on the listening socket")
+	}
+	if n, err := d.Recv(c, buf, 0, time.Time{}); err != nil || string(buf[:n]) != "hello" {
+		t.Errorf("unexpected data %q: %v", buf[:n], err)
+	}
+
+	p.expect("AT+SOCKETSEND=2,5\r\n", ">")
+	p.expect("world", "OK\r\n")
+	if n, err := d.Send(c, []byte("world"), 0, time.Time{}); n != 5 || err != nil {
+		t.Errorf("send failed: %d, %v", n, err)
+	}
+
+	// the client leaves
+	p.send("+EVENT:SocketDisconnect,2\r\n")
+	if _, err := d.Recv(c, buf, 0, time.Time{}); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	p.expect("AT+SOCKETDEL=2\r\n", "OK\r\n")
+	if err := d.Close(c); err != nil {
+		t.Error(err)
+	}
+
+	// a connection not accepted yet is dropped with the server
+	p.send("+EVENT:SocketSeed,3,1\r\n")
+	for p.Buffered() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond) // let the event be handled
+	p.expect("AT+SOCKETDEL=1\r\n", "OK\r\n")
+	p.expect("AT+SOCKETDEL=3\r\n", "OK\r\n")
+	if err := d.Close(fd); err != nil {
+		t.Error(err)
+	}
+	d.uartMu.Lock()
+	defer d.uartMu.Unlock()
+	if len(d.pending) != 0 {
+		t.Error("pending connection not dropped")
+	}
+}
+
+func TestScan(t *testing.T) {
+	d, p := newTestDevice(t)
+
+	p.expect("AT+WSCAN\r\n", "+WSCAN:SSID,CH,SECURITY,RSSI,BSSID\r\n"+
+		"home,6,WPA2_AES_PSK,-52,a0:b1:c2:d3:e4:f5\r\n"+
+		"cafe,11,OPEN,-80,00:11:22:33:44:55\r\nOK\r\n")
+	aps, err := d.NetScan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aps) != 2 {
+		t.Fatalf("expected 2 access points, got %d", len(aps))
+	}
+	ap := aps[0]
+	if ap.Ssid != "home" || ap.Bssid.String() != "a0:b1:c2:d3:e4:f5" || ap.Rssi != -52 ||
+		ap.Channel != 6 || ap.AuthType != netlink.AuthTypeWPA2 {
+		t.Errorf("unexpected access point %+v", ap)
+	}
+	if aps[1].Ssid != "cafe" || aps[1].Channel != 11 || aps[1].AuthType != netlink.AuthTypeOpen {
+		t.Errorf("unexpected access point %+v", aps[1])
+	}
+
+	// other firmwares prefix each access point
+	if ap, ok := parseAP([]byte(`+WSCAN:"my,net",a0:b1:c2:d3:e4:f5,-61,1,3`)); !ok ||
+		ap.Ssid != "my,net" || ap.Channel != 1 || ap.AuthType != netlink.AuthTypeWPA2 {
+		t.Errorf("unexpected access point %+v", ap)
+	}
+}
+
+func TestLinkInfo(t *testing.T) {
+	d, p := newTestDevice(t)
+	d.params = &netlink.ConnectParams{Ssid: "home"}
+	d.mac, _ = net.ParseMAC("02:00:00:00:00:01")
+
+	p.expect("AT+WJAP?\r\n", "+WJAP:3,home,secret,a0:b1:c2:d3:e4:f5,4,-67,02:00:00:00:00:01,10.0.0.5,10.0.0.1\r\nOK\r\n")
+	info, err := d.NetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Up || info.Ssid != "home" || info.Bssid.String() != "a0:b1:c2:d3:e4:f5" || info.Rssi != -67 {
+		t.Errorf("unexpected link info %+v", info)
+	}
+
+	p.expect("AT+WJAP?\r\n", "+WJAP:0\r\nOK\r\n")
+	if info, err := d.NetInfo(); err != nil || info.Up {
+		t.Errorf("unexpected link info %+v: %v", info, err)
+	}
+}
+
+func TestNetDisconnect(t *testing.T) {
+	d, p := newTestDevice(t)
+	params := &netlink.ConnectParams{
+		Ssid:            "home",
+		Passphrase:      "secret",
+		WatchdogTimeout: time.Hour,
+		LowSignal:       -80,
+	}
+
+	// The watchdog isn't started when the connection fails
+	p.expect("AT\r\n", "ERROR\r\n")
+	if err := d.NetConnect(params); err == nil {
+		t.Fatal("connected")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2; i++ {
+			p.expect("AT+WDISCONNECT\r\n", "OK\r\n")
+			d.NetDisconnect()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("NetDisconnect blocked")
+	}
+}
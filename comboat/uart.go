@@ -0,0 +1,28 @@
+//go:build baremetal
+
+package comboat
+
+import "machine"
+
+type Config struct {
+	BaudRate uint32
+	Uart     *machine.UART
+	Tx       machine.Pin
+	Rx       machine.Pin
+}
+
+func NewDevice(cfg *Config) *device {
+	d := newDevice()
+	d.setup = cfg.configure
+	return d
+}
+
+// configure sets up the UART connected to the WiFi device.
+func (cfg *Config) configure() serial {
+	cfg.Uart.Configure(machine.UARTConfig{
+		BaudRate: cfg.BaudRate,
+		TX:       cfg.Tx,
+		RX:       cfg.Rx,
+	})
+	return cfg.Uart
+}
@@ -12,6 +12,8 @@ import (
 	"tinygo.org/x/drivers/internal/legacy"
 )
 
+var _ drivers.RTC = &Device{}
+
 // Device wraps an I2C connection to a DS1307 device.
 type Device struct {
 	bus         drivers.I2C
@@ -13,6 +13,8 @@ import (
 	"tinygo.org/x/drivers/internal/regmap"
 )
 
+var _ drivers.RTC = &Device{}
+
 type Mode uint8
 
 // Device wraps an I2C connection to a DS3231 device.
@@ -0,0 +1,40 @@
+package drivers
+
+// RefreshMode is the way an e-paper display updates its contents.
+type RefreshMode uint8
+
+const (
+	// RefreshFull redraws every pixel of the panel. The screen flashes while
+	// updating but any ghosting left by previous images is cleared.
+	RefreshFull RefreshMode = iota
+
+	// RefreshPartial only drives the pixels that changed. It is faster and
+	// does not flash, but ghosting builds up over time so a full refresh
+	// should be done every now and then.
+	RefreshPartial
+)
+
+// EPaper is a Displayer backed by an e-paper panel that supports partial
+// (windowed) updates.
+type EPaper interface {
+	Displayer
+
+	// DisplayRect sends only an area of the buffer to the screen.
+	DisplayRect(x, y, width, height int16) error
+
+	// SetRefreshMode changes how following calls to Display and DisplayRect
+	// update the panel.
+	SetRefreshMode(mode RefreshMode) error
+
+	// ClearBuffer sets the buffer to white.
+	ClearBuffer()
+
+	// ClearDisplay erases the panel using a full refresh.
+	ClearDisplay()
+
+	// IsBusy returns whether the panel is still refreshing.
+	IsBusy() bool
+
+	// WaitUntilIdle blocks until the panel finished refreshing.
+	WaitUntilIdle()
+}
@@ -21,9 +21,10 @@
 package espat // import "tinygo.org/x/drivers/espat"
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"machine"
+	"io"
 	"net"
 	"net/netip"
 	"strconv"
@@ -35,35 +36,63 @@ import (
 	"tinygo.org/x/drivers/netlink"
 )
 
-type Config struct {
-	// UART config
-	Uart *machine.UART
-	Tx   machine.Pin
-	Rx   machine.Pin
+// serial is the UART connected to the ESP8266/ESP32.
+type serial interface {
+	io.ReadWriter
+	Buffered() int
 }
 
+// maxLinks is the number of connections the ESP8266/ESP32 supports in
+// multiple connection mode, identified by a link ID.
+const maxLinks = 5
+
+// maxSockets is the number of sockets: one per connection and a listening
+// socket for the server.
+const maxSockets = maxLinks + 1
+
 type socket struct {
 	inUse    bool
 	protocol int
 	laddr    netip.AddrPort
+	link     int // link ID of the connection, -1 if not connected
+	listener bool
+}
+
+// link is the state of a connection of the ESP8266/ESP32.
+type link struct {
+	inUse    bool // used by a socket
+	incoming bool // accepted by the server, waiting for Accept
+	closed   bool
+	// data received from the connection forwarded by the ESP8266/ESP32
+	data []byte
 }
 
 type Device struct {
-	cfg  *Config
-	uart *machine.UART
+	setup func() serial
+	uart  serial
 	// command responses that come back from the ESP8266/ESP32
 	response []byte
-	// data received from a TCP/UDP connection forwarded by the ESP8266/ESP32
-	data   []byte
-	socket socket
-	mu     sync.Mutex
+	end      int // bytes received in response
+	consumed int // bytes of response already returned
+	sockets  [maxSockets]socket
+	links    [maxLinks]link
+	server   bool
+	mu       sync.Mutex
+
+	params          *netlink.ConnectParams
+	notifyCb        func(netlink.Event)
+	killWatchdog    chan bool
+	watchdogRunning bool
+
+	// bytes sent and received on sockets
+	txBytes uint64
+	rxBytes uint64
 }
 
-func NewDevice(cfg *Config) *Device {
+func newDevice() *Device {
 	return &Device{
-		cfg:      cfg,
-		response: make([]byte, 1500),
-		data:     make([]byte, 0, 1500),
+		response:     make([]byte, 2048),
+		killWatchdog: make(chan bool),
 	}
 }
 
@@ -73,8 +102,10 @@ func (d *Device) NetConnect(params *netlink.ConnectParams) error {
 		return netlink.ErrMissingSSID
 	}
 
-	d.uart = d.cfg.Uart
-	d.uart.Configure(machine.UARTConfig{TX: d.cfg.Tx, RX: d.cfg.Rx})
+	d.mu.Lock()
+	d.params = params
+	d.mu.Unlock()
+	d.uart = d.setup()
 
 	// Connect to ESP8266/ESP32
 	fmt.Printf("Connecting to device...")
@@ -106,6 +137,11 @@ func (d *Device) NetConnect(params *netlink.ConnectParams) error {
 
 	fmt.Printf("CONNECTED\r\n")
 
+	// Multiple connections are needed to run a server
+	if err := d.SetMux(TCPMuxMultiple); err != nil {
+		return err
+	}
+
 	ip, err := d.Addr()
 	if err != nil {
 		return err
@@ -113,16 +149,103 @@ func (d *Device) NetConnect(params *netlink.ConnectParams) error {
 	fmt.Printf("DHCP-assigned IP: %s\r\n", ip)
 	fmt.Printf("\r\n")
 
+	d.notify(netlink.EventNetUp)
+
+	// The watchdog only checks the signal strength
+	d.mu.Lock()
+	if d.watching() && !d.watchdogRunning {
+		d.watchdogRunning = true
+		go d.watchdog(d.params.WatchdogTimeout, d.params.LowSignal)
+	}
+	d.mu.Unlock()
+
 	return nil
 }
 
 func (d *Device) NetDisconnect() {
+	// The watchdog is only running after a successful NetConnect
+	d.mu.Lock()
+	running := d.watchdogRunning
+	d.watchdogRunning = false
+	d.mu.Unlock()
+	if running {
+		d.killWatchdog <- true
+	}
+
 	d.DisconnectFromAP()
 	fmt.Printf("\r\nDisconnected from Wifi\r\n\r\n")
+
+	d.notify(netlink.EventNetDown)
 }
 
 func (d *Device) NetNotify(cb func(netlink.Event)) {
-	fmt.Printf("\r\n%s\r\n", netlink.ErrNotSupported)
+	d.mu.Lock()
+	d.notifyCb = cb
+	d.mu.Unlock()
+}
+
+// notify calls the notification callback, if any, without holding the lock.
+func (d *Device) notify(e netlink.Event) {
+	d.mu.Lock()
+	cb := d.notifyCb
+	d.mu.Unlock()
+	if cb != nil {
+		cb(e)
+	}
+}
+
+func (d *Device) watching() bool {
+	return d.params != nil && d.params.WatchdogTimeout != 0 && d.params.LowSignal != 0
+}
+
+func (d *Device) watchdog(timeout time.Duration, lowSignal int) {
+	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.killWatchdog:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			info, err := d.linkInfo()
+			d.mu.Unlock()
+			if err == nil && info.Up && info.Rssi < lowSignal {
+				d.notify(netlink.EventNetLowSignal)
+			}
+		}
+	}
+}
+
+func (d *Device) NetInfo() (netlink.LinkInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.uart == nil {
+		return netlink.LinkInfo{}, nil
+	}
+	return d.linkInfo()
+}
+
+func (d *Device) linkInfo() (netlink.LinkInfo, error) {
+	info, err := d.ConnectedAP()
+	info.TxBytes = d.txBytes
+	info.RxBytes = d.rxBytes
+	return info, err
+}
+
+func (d *Device) NetScan() ([]netlink.AccessPoint, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Scanning doesn't need a connection, only the station mode
+	if d.uart == nil {
+		d.uart = d.setup()
+		if err := d.SetWifiMode(WifiModeClient); err != nil {
+			return nil, err
+		}
+	}
+
+	return d.ListAccessPoints()
 }
 
 func (d *Device) GetHostByName(name string) (netip.Addr, error) {
@@ -168,37 +291,79 @@ func (d *Device) Socket(domain int, stype int, protocol int) (int, error) {
 		return -1, netdev.ErrProtocolNotSupported
 	}
 
-	// Only supporting single connection mode, so only one socket at a time
-	if d.socket.inUse {
-		return -1, netdev.ErrNoMoreSockets
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for fd := range d.sockets {
+		if !d.sockets[fd].inUse {
+			d.sockets[fd] = socket{inUse: true, protocol: protocol, link: -1}
+			return fd, nil
+		}
+	}
+	return -1, netdev.ErrNoMoreSockets
+}
+
+func (d *Device) socket(sockfd int) (*socket, error) {
+	if sockfd < 0 || sockfd >= len(d.sockets) || !d.sockets[sockfd].inUse {
+		return nil, netdev.ErrInvalidSocketFd
 	}
-	d.socket.inUse = true
-	d.socket.protocol = protocol
+	return &d.sockets[sockfd], nil
+}
 
-	return 0, nil
+// freeLink returns a link ID that is not used by a connection, or -1.
+func (d *Device) freeLink() int {
+	for id := range d.links {
+		if !d.links[id].inUse && !d.links[id].incoming {
+			return id
+		}
+	}
+	return -1
 }
 
 func (d *Device) Bind(sockfd int, ip netip.AddrPort) error {
-	d.socket.laddr = ip
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, err := d.socket(sockfd)
+	if err != nil {
+		return err
+	}
+	s.laddr = ip
 	return nil
 }
 
 func (d *Device) Connect(sockfd int, host string, ip netip.AddrPort) error {
-	var err error
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, err := d.socket(sockfd)
+	if err != nil {
+		return err
+	}
+	id := d.freeLink()
+	if id < 0 {
+		return netdev.ErrNoMoreSockets
+	}
+
 	var addr = ip.Addr().String()
 	var rport = strconv.Itoa(int(ip.Port()))
-	var lport = strconv.Itoa(int(d.socket.laddr.Port()))
+	var lport = strconv.Itoa(int(s.laddr.Port()))
+
+	// Claim the link first, so its CONNECT message isn't taken for a
+	// connection to the server.
+	d.links[id] = link{inUse: true}
 
-	switch d.socket.protocol {
+	switch s.protocol {
 	case netdev.IPPROTO_TCP:
-		err = d.ConnectTCPSocket(addr, rport)
+		err = d.ConnectTCPLink(id, addr, rport)
 	case netdev.IPPROTO_UDP:
-		err = d.ConnectUDPSocket(addr, rport, lport)
+		err = d.ConnectUDPLink(id, addr, rport, lport)
 	case netdev.IPPROTO_TLS:
-		err = d.ConnectSSLSocket(host, rport)
+		err = d.ConnectSSLLink(id, host, rport)
 	}
 
 	if err != nil {
+		d.links[id] = link{}
 		if host == "" {
 			return fmt.Errorf("Connect to %s timed out", ip)
 		} else {
@@ -206,30 +371,128 @@ func (d *Device) Connect(sockfd int, host string, ip netip.AddrPort) error {
 		}
 	}
 
+	s.link = id
 	return nil
 }
 
+// Listen starts the TCP server of the ESP8266/ESP32 on the port the socket is
+// bound to. There can only be one server, the backlog is ignored.
 func (d *Device) Listen(sockfd int, backlog int) error {
-	switch d.socket.protocol {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, err := d.socket(sockfd)
+	if err != nil {
+		return err
+	}
+
+	switch s.protocol {
 	case netdev.IPPROTO_UDP:
+		return nil
+	case netdev.IPPROTO_TCP:
 	default:
 		return netdev.ErrProtocolNotSupported
 	}
+
+	if d.server {
+		return errors.New("server already running")
+	}
+	if err := d.StartServer(int(s.laddr.Port())); err != nil {
+		return err
+	}
+	d.server = true
+	s.listener = true
 	return nil
 }
 
+// Accept waits for a connection to the server. The connections are accepted
+// by the ESP8266/ESP32 as they come, and handed out in the order of their
+// link IDs.
 func (d *Device) Accept(sockfd int) (int, netip.AddrPort, error) {
-	return -1, netip.AddrPort{}, netdev.ErrNotSupported
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for {
+		s, err := d.socket(sockfd)
+		if err != nil {
+			return -1, netip.AddrPort{}, err
+		}
+		if !s.listener {
+			return -1, netip.AddrPort{}, errors.New("socket is not listening")
+		}
+
+		d.poll()
+		for id := range d.links {
+			if d.links[id].incoming {
+				return d.accept(id)
+			}
+		}
+
+		d.mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		d.mu.Lock()
+	}
+}
+
+func (d *Device) accept(id int) (int, netip.AddrPort, error) {
+	d.links[id].incoming = false
+
+	for fd := range d.sockets {
+		if d.sockets[fd].inUse {
+			continue
+		}
+		d.links[id].inUse = true
+		d.sockets[fd] = socket{inUse: true, protocol: netdev.IPPROTO_TCP, link: id}
+		// The connection is usable without its remote address
+		raddr, _ := d.remoteAddr(id)
+		return fd, raddr, nil
+	}
+
+	// No socket left for the connection
+	d.links[id] = link{}
+	d.DisconnectLink(id)
+	return -1, netip.AddrPort{}, netdev.ErrNoMoreSockets
+}
+
+// remoteAddr returns the address of the remote end of a connection.
+func (d *Device) remoteAddr(id int) (netip.AddrPort, error) {
+	d.Execute(TCPStatus)
+	resp, err := d.Response(1000)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	// +CIPSTATUS:<link ID>,<"type">,<"remote IP">,<remote port>,<local port>,<tetype>
+	prefix := TCPStatus + ":" + strconv.Itoa(id) + ","
+	for _, line := range strings.Split(string(resp), "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		fields := strings.Split(strings.TrimSpace(line[len(prefix):]), ",")
+		if len(fields) < 3 {
+			break
+		}
+		ip, err := netip.ParseAddr(strings.Trim(fields[1], `"`))
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		port, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		return netip.AddrPortFrom(ip, uint16(port)), nil
+	}
+	return netip.AddrPort{}, errors.New("no status for link " + strconv.Itoa(id))
 }
 
-func (d *Device) sendChunk(sockfd int, buf []byte, deadline time.Time) (int, error) {
+func (d *Device) sendChunk(id int, buf []byte, deadline time.Time) (int, error) {
 	// Check if we've timed out
 	if !deadline.IsZero() {
 		if time.Now().After(deadline) {
 			return -1, netdev.ErrTimeout
 		}
 	}
-	err := d.StartSocketSend(len(buf))
+	err := d.StartLinkSend(id, len(buf))
 	if err != nil {
 		return -1, err
 	}
@@ -249,6 +512,14 @@ func (d *Device) Send(sockfd int, buf []byte, flags int, deadline time.Time) (in
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	s, err := d.socket(sockfd)
+	if err != nil {
+		return -1, err
+	}
+	if s.link < 0 || d.links[s.link].closed {
+		return -1, net.ErrClosed
+	}
+
 	// Break large bufs into chunks so we don't overrun the hw queue
 
 	chunkSize := 1436
@@ -257,10 +528,11 @@ func (d *Device) Send(sockfd int, buf []byte, flags int, deadline time.Time) (in
 		if end > len(buf) {
 			end = len(buf)
 		}
-		_, err := d.sendChunk(sockfd, buf[i:end], deadline)
+		n, err := d.sendChunk(s.link, buf[i:end], deadline)
 		if err != nil {
 			return -1, err
 		}
+		d.txBytes += uint64(n)
 	}
 
 	return len(buf), nil
@@ -271,6 +543,15 @@ func (d *Device) Recv(sockfd int, buf []byte, flags int, deadline time.Time) (in
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	s, err := d.socket(sockfd)
+	if err != nil {
+		return -1, err
+	}
+	id := s.link
+	if id < 0 {
+		return -1, net.ErrClosed
+	}
+
 	var length = len(buf)
 
 	// Limit length read size to chunk large read requests
@@ -286,17 +567,24 @@ func (d *Device) Recv(sockfd int, buf []byte, flags int, deadline time.Time) (in
 			}
 		}
 
-		n, err := d.ReadSocket(buf[:length])
+		n, err := d.ReadLink(id, buf[:length])
 		if err != nil {
 			return -1, err
 		}
 		if n == 0 {
+			if d.links[id].closed {
+				return 0, io.EOF
+			}
 			d.mu.Unlock()
 			time.Sleep(100 * time.Millisecond)
 			d.mu.Lock()
+			if !d.sockets[sockfd].inUse || d.sockets[sockfd].link != id {
+				return -1, net.ErrClosed
+			}
 			continue
 		}
 
+		d.rxBytes += uint64(n)
 		return n, nil
 	}
 }
@@ -305,8 +593,25 @@ func (d *Device) Close(sockfd int) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	d.socket.inUse = false
-	return d.DisconnectSocket()
+	s, err := d.socket(sockfd)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case s.listener:
+		// The connections already accepted stay open
+		err = d.StopServer()
+		d.server = false
+	case s.link >= 0:
+		if !d.links[s.link].closed {
+			err = d.DisconnectLink(s.link)
+		}
+		d.links[s.link] = link{}
+	}
+
+	*s = socket{}
+	return err
 }
 
 func (d *Device) SetSockOpt(sockfd int, level int, opt int, value interface{}) error {
@@ -339,27 +644,27 @@ func (d *Device) Read(b []byte) (n int, err error) {
 const pause = 300
 
 // Execute sends an AT command to the ESP8266/ESP32.
-func (d Device) Execute(cmd string) error {
+func (d *Device) Execute(cmd string) error {
 	_, err := d.Write([]byte("AT" + cmd + "\r\n"))
 	return err
 }
 
 // Query sends an AT command to the ESP8266/ESP32 that returns the
 // current value for some configuration parameter.
-func (d Device) Query(cmd string) (string, error) {
+func (d *Device) Query(cmd string) (string, error) {
 	_, err := d.Write([]byte("AT" + cmd + "?\r\n"))
 	return "", err
 }
 
 // Set sends an AT command with params to the ESP8266/ESP32 for a
 // configuration value to be set.
-func (d Device) Set(cmd, params string) error {
+func (d *Device) Set(cmd, params string) error {
 	_, err := d.Write([]byte("AT" + cmd + "=" + params + "\r\n"))
 	return err
 }
 
 // Version returns the ESP8266/ESP32 firmware version info.
-func (d Device) Version() []byte {
+func (d *Device) Version() []byte {
 	d.Execute(Version)
 	r, err := d.Response(2000)
 	if err != nil {
@@ -370,7 +675,7 @@ func (d Device) Version() []byte {
 }
 
 // Echo sets the ESP8266/ESP32 echo setting.
-func (d Device) Echo(set bool) {
+func (d *Device) Echo(set bool) {
 	if set {
 		d.Execute(EchoConfigOn)
 	} else {
@@ -383,101 +688,200 @@ func (d Device) Echo(set bool) {
 // Reset restarts the ESP8266/ESP32 firmware. Due to how the baud rate changes,
 // this messes up communication with the ESP8266/ESP32 module. So make sure you know
 // what you are doing when you call this.
-func (d Device) Reset() {
+func (d *Device) Reset() {
 	d.Execute(Restart)
 	d.Response(100)
 }
 
-// ReadSocket returns the data that has already been read in from the responses.
+// ReadSocket returns the data that has already been received on link ID 0.
 func (d *Device) ReadSocket(b []byte) (n int, err error) {
-	// make sure no data in buffer
-	d.Response(300)
-
-	count := len(b)
-	if len(b) >= len(d.data) {
-		// copy it all, then clear socket data
-		count = len(d.data)
-		copy(b, d.data[:count])
-		d.data = d.data[:0]
-	} else {
-		// copy all we can, then keep the remaining socket data around
-		copy(b, d.data[:count])
-		copy(d.data, d.data[count:])
-		d.data = d.data[:len(d.data)-count]
+	return d.ReadLink(0, b)
+}
+
+// ReadLink returns the data that has already been received on the given link
+// ID.
+func (d *Device) ReadLink(link int, b []byte) (n int, err error) {
+	if link < 0 || link >= maxLinks {
+		return 0, errors.New("invalid link ID: " + strconv.Itoa(link))
 	}
 
-	return count, nil
+	// make sure no data in buffer
+	d.poll()
+
+	l := &d.links[link]
+	n = copy(b, l.data)
+	l.data = l.data[:copy(l.data, l.data[n:])]
+	return n, nil
 }
 
 // Response gets the next response bytes from the ESP8266/ESP32.
 // The call will retry for up to timeout milliseconds before returning nothing.
+// Socket data and connection messages received meanwhile are not part of the
+// response.
 func (d *Device) Response(timeout int) ([]byte, error) {
-	// read data
-	var size int
-	var start, end int
-	pause := 100 // pause to wait for 100 ms
-	retries := timeout / pause
+	return d.waitResponse(timeout, false)
+}
+
+// waitResponse waits for a response ending with OK or an error, or with the
+// ">" prompt before sending data if prompt is set.
+func (d *Device) waitResponse(timeout int, prompt bool) ([]byte, error) {
+	deadline := time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	d.discard()
 
 	for {
-		size = d.uart.Buffered()
+		received := d.fill()
+		n, lines, err := d.scan(prompt)
+		if n > 0 {
+			d.consumed = n
+			return d.response[:n], err
+		}
 
-		if size > 0 {
-			end += size
-			d.uart.Read(d.response[start:end])
+		if time.Now().After(deadline) {
+			d.consumed = lines
+			return nil, errors.New("response timeout error:" + string(d.response[:lines]))
+		}
 
-			// if "+IPD" then read socket data
-			if strings.Contains(string(d.response[:end]), "+IPD") {
-				// handle socket data
-				return nil, d.parseIPD(end)
-			}
+		if !received {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
 
-			// if "OK" then the command worked
-			if strings.Contains(string(d.response[:end]), "OK") {
-				return d.response[start:end], nil
-			}
+// poll handles the bytes received so far, without waiting for a response.
+func (d *Device) poll() {
+	d.discard()
+	for d.fill() {
+	}
+	n, lines, _ := d.scan(false)
+	d.consumed = max(n, lines)
+}
+
+// fill reads the bytes buffered by the UART.
+func (d *Device) fill() bool {
+	size := d.uart.Buffered()
+	if size == 0 || d.end == len(d.response) {
+		return false
+	}
+	n, _ := d.uart.Read(d.response[d.end:min(d.end+size, len(d.response))])
+	d.end += n
+	return n > 0
+}
 
-			// if "Error" then the command failed
-			if strings.Contains(string(d.response[:end]), "ERROR") {
-				return d.response[start:end], errors.New("response error:" + string(d.response[start:end]))
+// discard drops the response returned last.
+func (d *Device) discard() {
+	d.end = copy(d.response, d.response[d.consumed:d.end])
+	d.consumed = 0
+}
+
+// cut removes n bytes from the response at offset i.
+func (d *Device) cut(i, n int) {
+	d.end = i + copy(d.response[i:], d.response[i+n:d.end])
+}
+
+// scan handles the socket data and the connection messages received so far,
+// and removes them from the response. It returns the length of the response if
+// it is complete, and the length of its complete lines.
+func (d *Device) scan(prompt bool) (n, lines int, err error) {
+	for i := 0; i < d.end; {
+		rest := d.response[i:d.end]
+		if bytes.HasPrefix(rest, []byte("+IPD,")) {
+			size, ok := d.parseIPD(rest)
+			if !ok {
+				return 0, i, nil
 			}
+			d.cut(i, size)
+			continue
+		}
 
-			// if anything else, then keep reading data in?
-			start = end
+		if prompt && rest[0] == '>' {
+			return i + 1, i + 1, nil
 		}
 
-		// wait longer?
-		retries--
-		if retries == 0 {
-			return nil, errors.New("response timeout error:" + string(d.response[start:end]))
+		eol := bytes.IndexByte(rest, '\n')
+		if eol < 0 {
+			return 0, i, nil
 		}
+		line := bytes.TrimRight(rest[:eol], "\r")
+		end := i + eol + 1
 
-		time.Sleep(time.Duration(pause) * time.Millisecond)
+		switch {
+		case d.linkMessage(line):
+			d.cut(i, eol+1)
+			continue
+		case !prompt && (string(line) == "OK" || string(line) == "SEND OK"):
+			return end, end, nil
+		case bytes.Contains(line, []byte("ERROR")) || string(line) == "SEND FAIL":
+			return end, end, errors.New("response error:" + string(d.response[:end]))
+		}
+		i = end
 	}
+	return 0, d.end, nil
 }
 
-func (d *Device) parseIPD(end int) error {
-	// find the "+IPD," to get length
-	s := strings.Index(string(d.response[:end]), "+IPD,")
+// parseIPD hands the data of a "+IPD,<link ID>,<length>:<data>" message to its
+// link. It returns the length of the message, or false if it isn't complete.
+func (d *Device) parseIPD(msg []byte) (int, bool) {
+	const header = len("+IPD,")
 
-	// find the ":"
-	e := strings.Index(string(d.response[:end]), ":")
+	e := bytes.IndexByte(msg, ':')
+	if e < 0 {
+		// Wait for the rest of the header, unless it's garbage
+		return header, len(msg) > header+12
+	}
 
-	// find the data length
-	val := string(d.response[s+5 : e])
+	var id, length int
+	var err error
+	fields := strings.Split(string(msg[header:e]), ",")
+	if len(fields) == 2 {
+		if id, err = strconv.Atoi(fields[0]); err == nil {
+			length, err = strconv.Atoi(fields[1])
+		}
+	}
+	if len(fields) != 2 || err != nil || length < 0 || e+1+length > len(d.response) {
+		// not expected data here, skip the header
+		return e + 1, true
+	}
 
-	// TODO: verify count
-	v, err := strconv.Atoi(val)
-	if err != nil {
-		// not expected data here. what to do?
-		return err
+	if e+1+length > len(msg) {
+		return 0, false
 	}
+	if id >= 0 && id < maxLinks {
+		d.links[id].data = append(d.links[id].data, msg[e+1:e+1+length]...)
+	}
+	return e + 1 + length, true
+}
 
-	// load up the socket data
-	d.data = append(d.data, d.response[e+1:e+1+v]...)
-	return nil
+// linkMessage handles the "<link ID>,CONNECT" and "<link ID>,CLOSED" messages.
+func (d *Device) linkMessage(line []byte) bool {
+	if len(line) < 3 || line[0] < '0' || line[0] >= '0'+maxLinks || line[1] != ',' {
+		return false
+	}
+
+	l := &d.links[line[0]-'0']
+	switch string(line[2:]) {
+	case "CONNECT":
+		// Connections made by a socket are known already
+		if !l.inUse {
+			*l = link{incoming: true}
+		}
+	case "CLOSED", "CONNECT FAIL":
+		if l.inUse {
+			l.closed = true
+		} else {
+			*l = link{}
+		}
+	default:
+		return false
+	}
+	return true
 }
 
 // IsSocketDataAvailable returns of there is socket data available
 func (d *Device) IsSocketDataAvailable() bool {
-	return len(d.data) > 0 || d.uart.Buffered() > 0
+	for i := range d.links {
+		if len(d.links[i].data) > 0 {
+			return true
+		}
+	}
+	return d.uart.Buffered() > 0
 }
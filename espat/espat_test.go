@@ -0,0 +1,262 @@
+package espat
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+	"tinygo.org/x/drivers/netlink"
+)
+
+// step is an exchange with the scripted peer: the command or data it expects
+// from the driver, and its reply.
+type step struct {
+	expect string
+	reply  string
+}
+
+// peer is an ESP8266/ESP32 replaying a script over the UART.
+type peer struct {
+	t      *testing.T
+	script []step
+	rx     []byte // written by the driver, not matched yet
+	tx     []byte // to be read by the driver
+}
+
+func (p *peer) Write(b []byte) (int, error) {
+	p.rx = append(p.rx, b...)
+	for len(p.script) > 0 && len(p.rx) >= len(p.script[0].expect) {
+		step := p.script[0]
+		if string(p.rx[:len(step.expect)]) != step.expect {
+			p.t.Fatalf("expected %q, got %q", step.expect, p.rx)
+		}
+		p.rx = p.rx[len(step.expect):]
+		p.tx = append(p.tx, step.reply...)
+		p.script = p.script[1:]
+	}
+	if len(p.script) == 0 && len(p.rx) > 0 {
+		p.t.Fatalf("unexpected %q", p.rx)
+	}
+	return len(b), nil
+}
+
+// Buffered returns a few bytes at a time, so messages are split over reads.
+func (p *peer) Buffered() int {
+	return min(len(p.tx), 7)
+}
+
+func (p *peer) Read(b []byte) (int, error) {
+	n := copy(b, p.tx)
+	p.tx = p.tx[n:]
+	return n, nil
+}
+
+func (p *peer) expect(cmd, reply string) {
+	p.script = append(p.script, step{cmd, reply})
+}
+
+// send sends an unsolicited message.
+func (p *peer) send(msg string) {
+	p.tx = append(p.tx, msg...)
+}
+
+func (p *peer) done() {
+	if len(p.script) > 0 {
+		p.t.Errorf("script not completed, expecting %q", p.script[0].expect)
+	}
+}
+
+func newTestDevice(t *testing.T) (*Device, *peer) {
+	p := &peer{t: t}
+	d := newDevice()
+	d.uart = p
+	return d, p
+}
+
+func TestServer(t *testing.T) {
+	d, p := newTestDevice(t)
+	buf := make([]byte, 64)
+
+	fd, err := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Bind(fd, netip.AddrPortFrom(netip.IPv4Unspecified(), 80))
+	p.expect("AT+CIPSERVER=1,80\r\n", "\r\nOK\r\n")
+	if err := d.Listen(fd, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// two clients connect, the first one sends data looking like a response
+	const request = "GET /\r\nOK\r\n"
+	p.send("0,CONNECT\r\n1,CONNECT\r\n\r\n+IPD,0,11:" + request)
+	status := "STATUS:3\r\n" +
+		"+CIPSTATUS:0,\"TCP\",\"10.0.0.7\",50001,80,1\r\n" +
+		"+CIPSTATUS:1,\"TCP\",\"10.0.0.8\",50002,80,1\r\n\r\nOK\r\n"
+
+	p.expect("AT+CIPSTATUS\r\n", status)
+	c0, raddr, err := d.Accept(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raddr != netip.MustParseAddrPort("10.0.0.7:50001") {
+		t.Errorf("unexpected remote address %s", raddr)
+	}
+	if n, err := d.Recv(c0, buf, 0, time.Time{}); err != nil || string(buf[:n]) != request {
+		t.Errorf("unexpected data %q: %v", buf[:n], err)
+	}
+
+	p.expect("AT+CIPSEND=0,5\r\n", "\r\nOK\r\n> ")
+	p.expect("hello", "\r\nRecv 5 bytes\r\n\r\nSEND OK\r\n")
+	if n, err := d.Send(c0, []byte("hello"), 0, time.Time{}); n != 5 || err != nil {
+		t.Errorf("send failed: %d, %v", n, err)
+	}
+
+	p.expect("AT+CIPSTATUS\r\n", status)
+	c1, raddr, err := d.Accept(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 == c0 || raddr != netip.MustParseAddrPort("10.0.0.8:50002") {
+		t.Errorf("unexpected connection %d from %s", c1, raddr)
+	}
+
+	// the second client leaves
+	p.send("1,CLOSED\r\n")
+	if _, err := d.Recv(c1, buf, 0, time.Time{}); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	if err := d.Close(c1); err != nil {
+		t.Error(err)
+	}
+
+	p.expect("AT+CIPCLOSE=0\r\n", "0,CLOSED\r\n\r\nOK\r\n")
+	if err := d.Close(c0); err != nil {
+		t.Error(err)
+	}
+	p.expect("AT+CIPSERVER=0\r\n", "\r\nOK\r\n")
+	if err := d.Close(fd); err != nil {
+		t.Error(err)
+	}
+	p.done()
+}
+
+func TestClient(t *testing.T) {
+	d, p := newTestDevice(t)
+	buf := make([]byte, 64)
+
+	fd, _ := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	p.expect(`AT+CIPSTART=0,"TCP","10.0.0.2",8080,120`+"\r\n", "0,CONNECT\r\n\r\nOK\r\n")
+	if err := d.Connect(fd, "", netip.MustParseAddrPort("10.0.0.2:8080")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the answer comes before the end of the send, then the server leaves
+	p.expect("AT+CIPSEND=0,4\r\n", "\r\nOK\r\n> ")
+	p.expect("ping", "\r\nRecv 4 bytes\r\n\r\n+IPD,0,4:pong\r\nSEND OK\r\n0,CLOSED\r\n")
+	if _, err := d.Send(fd, []byte("ping"), 0, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Recv(fd, buf, 0, time.Time{}); err != nil || string(buf[:n]) != "pong" {
+		t.Errorf("unexpected data %q: %v", buf[:n], err)
+	}
+	if _, err := d.Recv(fd, buf, 0, time.Time{}); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	if _, err := d.Send(fd, []byte("ping"), 0, time.Time{}); err != net.ErrClosed {
+		t.Errorf("expected closed connection, got %v", err)
+	}
+	if d.txBytes != 4 || d.rxBytes != 4 {
+		t.Errorf("unexpected counters: %d bytes sent, %d received", d.txBytes, d.rxBytes)
+	}
+	if err := d.Close(fd); err != nil {
+		t.Error(err)
+	}
+	p.done()
+}
+
+func TestScan(t *testing.T) {
+	d, p := newTestDevice(t)
+
+	p.expect("AT+CWLAP\r\n", `+CWLAP:(3,"home",-52,"a0:b1:c2:d3:e4:f5",6,-1,-1,4,4,7,0)`+"\r\n"+
+		`+CWLAP:(0,"cafe, \"free\"",-80,"00:11:22:33:44:55",11,-1,-1,0,0,7,0)`+"\r\n\r\nOK\r\n")
+	aps, err := d.NetScan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aps) != 2 {
+		t.Fatalf("expected 2 access points, got %d", len(aps))
+	}
+	ap := aps[0]
+	if ap.Ssid != "home" || ap.Bssid.String() != "a0:b1:c2:d3:e4:f5" || ap.Rssi != -52 ||
+		ap.Channel != 6 || ap.AuthType != netlink.AuthTypeWPA2 {
+		t.Errorf("unexpected access point %+v", ap)
+	}
+	if aps[1].Channel != 11 || aps[1].AuthType != netlink.AuthTypeOpen {
+		t.Errorf("unexpected access point %+v", aps[1])
+	}
+	p.done()
+}
+
+func TestLinkInfo(t *testing.T) {
+	d, p := newTestDevice(t)
+
+	p.expect("AT+CWJAP?\r\n", `+CWJAP:"home","a0:b1:c2:d3:e4:f5",6,-71,0,1,3,0,1`+"\r\n\r\nOK\r\n")
+	info, err := d.NetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Up || info.Ssid != "home" || info.Bssid.String() != "a0:b1:c2:d3:e4:f5" ||
+		info.Channel != 6 || info.Rssi != -71 {
+		t.Errorf("unexpected link info %+v", info)
+	}
+
+	p.expect("AT+CWJAP?\r\n", "No AP\r\n\r\nOK\r\n")
+	if info, err := d.NetInfo(); err != nil || info.Up {
+		t.Errorf("unexpected link info %+v: %v", info, err)
+	}
+	p.done()
+}
+
+func TestNetDisconnect(t *testing.T) {
+	d, p := newTestDevice(t)
+	d.setup = func() serial { return p }
+	params := &netlink.ConnectParams{
+		Ssid:            "home",
+		Passphrase:      "secret",
+		WatchdogTimeout: time.Hour,
+		LowSignal:       -80,
+	}
+	var events []netlink.Event
+	d.NetNotify(func(e netlink.Event) { events = append(events, e) })
+
+	// The watchdog isn't started when the connection fails
+	p.expect("AT\r\n", "OK\r\n")
+	p.expect("AT\r\n", "OK\r\n")
+	p.expect("AT+CWMODE=1\r\n", "OK\r\n")
+	p.expect(`AT+CWJAP="home","secret"`+"\r\n", "+CWJAP:1\r\n\r\nFAIL\r\nERROR\r\n")
+	if err := d.NetConnect(params); err == nil {
+		t.Fatal("connected")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2; i++ {
+			p.expect("AT+CWQAP\r\n", "OK\r\n")
+			d.NetDisconnect()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("NetDisconnect blocked")
+	}
+	if len(events) != 2 || events[0] != netlink.EventNetDown {
+		t.Errorf("unexpected events %v", events)
+	}
+	p.done()
+}
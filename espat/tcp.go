@@ -32,11 +32,17 @@ func (d *Device) GetDNS(domain string) (string, error) {
 	return strings.Trim(res[0], `"`), nil
 }
 
-// ConnectTCPSocket creates a new TCP socket connection for the ESP8266/ESP32.
-// Currently only supports single connection mode.
+// ConnectTCPSocket creates a new TCP socket connection for the ESP8266/ESP32
+// on link ID 0, in multiple connection mode.
 func (d *Device) ConnectTCPSocket(addr, port string) error {
+	return d.ConnectTCPLink(0, addr, port)
+}
+
+// ConnectTCPLink creates a new TCP socket connection for the ESP8266/ESP32
+// on the given link ID, in multiple connection mode.
+func (d *Device) ConnectTCPLink(link int, addr, port string) error {
 	protocol := "TCP"
-	val := "\"" + protocol + "\",\"" + addr + "\"," + port + ",120"
+	val := strconv.Itoa(link) + ",\"" + protocol + "\",\"" + addr + "\"," + port + ",120"
 	err := d.Set(TCPConnect, val)
 	if err != nil {
 		return err
@@ -48,10 +54,17 @@ func (d *Device) ConnectTCPSocket(addr, port string) error {
 	return nil
 }
 
-// ConnectUDPSocket creates a new UDP connection for the ESP8266/ESP32.
+// ConnectUDPSocket creates a new UDP connection for the ESP8266/ESP32 on link
+// ID 0, in multiple connection mode.
 func (d *Device) ConnectUDPSocket(addr, sendport, listenport string) error {
+	return d.ConnectUDPLink(0, addr, sendport, listenport)
+}
+
+// ConnectUDPLink creates a new UDP connection for the ESP8266/ESP32 on the
+// given link ID, in multiple connection mode.
+func (d *Device) ConnectUDPLink(link int, addr, sendport, listenport string) error {
 	protocol := "UDP"
-	val := "\"" + protocol + "\",\"" + addr + "\"," + sendport + "," + listenport + ",0"
+	val := strconv.Itoa(link) + ",\"" + protocol + "\",\"" + addr + "\"," + sendport + "," + listenport + ",0"
 	err := d.Set(TCPConnect, val)
 	if err != nil {
 		return err
@@ -63,11 +76,17 @@ func (d *Device) ConnectUDPSocket(addr, sendport, listenport string) error {
 	return nil
 }
 
-// ConnectSSLSocket creates a new SSL socket connection for the ESP8266/ESP32.
-// Currently only supports single connection mode.
+// ConnectSSLSocket creates a new SSL socket connection for the ESP8266/ESP32
+// on link ID 0, in multiple connection mode.
 func (d *Device) ConnectSSLSocket(addr, port string) error {
+	return d.ConnectSSLLink(0, addr, port)
+}
+
+// ConnectSSLLink creates a new SSL socket connection for the ESP8266/ESP32
+// on the given link ID, in multiple connection mode.
+func (d *Device) ConnectSSLLink(link int, addr, port string) error {
 	protocol := "SSL"
-	val := "\"" + protocol + "\",\"" + addr + "\"," + port + ",120"
+	val := strconv.Itoa(link) + ",\"" + protocol + "\",\"" + addr + "\"," + port + ",120"
 	d.Set(TCPConnect, val)
 	// this operation takes longer, so wait up to 6 seconds to complete.
 	_, err := d.Response(6000)
@@ -77,9 +96,16 @@ func (d *Device) ConnectSSLSocket(addr, port string) error {
 	return nil
 }
 
-// DisconnectSocket disconnects the ESP8266/ESP32 from the current TCP/UDP connection.
+// DisconnectSocket closes the TCP/UDP connection of the ESP8266/ESP32 with
+// link ID 0.
 func (d *Device) DisconnectSocket() error {
-	err := d.Execute(TCPClose)
+	return d.DisconnectLink(0)
+}
+
+// DisconnectLink closes the TCP/UDP connection of the ESP8266/ESP32 with the
+// given link ID.
+func (d *Device) DisconnectLink(link int) error {
+	err := d.Set(TCPClose, strconv.Itoa(link))
 	if err != nil {
 		return err
 	}
@@ -120,21 +146,39 @@ func (d *Device) GetTCPTransferMode() ([]byte, error) {
 	return d.Response(pause)
 }
 
-// StartSocketSend gets the ESP8266/ESP32 ready to receive TCP/UDP socket data.
+// StartSocketSend gets the ESP8266/ESP32 ready to receive TCP/UDP socket data
+// for the connection with link ID 0.
 func (d *Device) StartSocketSend(size int) error {
-	val := strconv.Itoa(size)
+	return d.StartLinkSend(0, size)
+}
+
+// StartLinkSend gets the ESP8266/ESP32 ready to receive TCP/UDP socket data
+// for the connection with the given link ID.
+func (d *Device) StartLinkSend(link, size int) error {
+	val := strconv.Itoa(link) + "," + strconv.Itoa(size)
 	d.Set(TCPSend, val)
 
 	// when ">" is received, it indicates
 	// ready to receive data
-	r, err := d.Response(2000)
-	if err != nil {
-		return err
-	}
-	if strings.Contains(string(r), ">") {
-		return nil
-	}
-	return errors.New("StartSocketSend error:" + string(r))
+	_, err := d.waitResponse(2000, true)
+	return err
+}
+
+// StartServer starts the TCP server of the ESP8266/ESP32 on the given port.
+// It needs the multiple connection mode, each connection to the server is
+// reported with a "<link ID>,CONNECT" message.
+func (d *Device) StartServer(port int) error {
+	d.Set(ServerConfig, "1,"+strconv.Itoa(port))
+	_, err := d.Response(pause)
+	return err
+}
+
+// StopServer stops the TCP server of the ESP8266/ESP32. The connections
+// already made to the server stay open.
+func (d *Device) StopServer() error {
+	d.Set(ServerConfig, "0")
+	_, err := d.Response(pause)
+	return err
 }
 
 // EndSocketSend tell the ESP8266/ESP32 the TCP/UDP socket data sending is complete,
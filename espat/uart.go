@@ -0,0 +1,24 @@
+//go:build baremetal
+
+package espat
+
+import "machine"
+
+type Config struct {
+	// UART config
+	Uart *machine.UART
+	Tx   machine.Pin
+	Rx   machine.Pin
+}
+
+func NewDevice(cfg *Config) *Device {
+	d := newDevice()
+	d.setup = cfg.configure
+	return d
+}
+
+// configure sets up the UART connected to the ESP8266/ESP32.
+func (cfg *Config) configure() serial {
+	cfg.Uart.Configure(machine.UARTConfig{TX: cfg.Tx, RX: cfg.Rx})
+	return cfg.Uart
+}
@@ -1,7 +1,11 @@
 package espat
 
 import (
+	"net"
 	"strconv"
+	"strings"
+
+	"tinygo.org/x/drivers/netlink"
 )
 
 const (
@@ -37,6 +41,40 @@ func (d *Device) GetConnectedAP() ([]byte, error) {
 	return d.Response(100)
 }
 
+// ConnectedAP returns the access point the ESP8266/ESP32 is connected to as
+// a client, with the signal strength. The link is down if it isn't connected.
+func (d *Device) ConnectedAP() (netlink.LinkInfo, error) {
+	d.Query(ConnectAP)
+	r, err := d.Response(1000)
+	if err != nil {
+		return netlink.LinkInfo{}, err
+	}
+
+	// +CWJAP:<"ssid">,<"bssid">,<channel>,<rssi>,...
+	prefix := ConnectAP + ":"
+	for _, line := range strings.Split(string(r), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		fields := splitFields(line[len(prefix):])
+		if len(fields) < 4 {
+			break
+		}
+		bssid, _ := net.ParseMAC(strings.Trim(fields[1], `"`))
+		channel, _ := strconv.Atoi(fields[2])
+		rssi, _ := strconv.Atoi(fields[3])
+		return netlink.LinkInfo{
+			Up:      true,
+			Ssid:    strings.Trim(fields[0], `"`),
+			Bssid:   bssid,
+			Rssi:    rssi,
+			Channel: channel,
+		}, nil
+	}
+	return netlink.LinkInfo{}, nil
+}
+
 // ConnectToAP connects the ESP8266/ESP32 to an access point.
 // ws is the number of seconds to wait for connection.
 func (d *Device) ConnectToAP(ssid, pwd string, ws int) error {
@@ -47,6 +85,85 @@ func (d *Device) ConnectToAP(ssid, pwd string, ws int) error {
 	return err
 }
 
+// ListAccessPoints scans for the access points in range.
+func (d *Device) ListAccessPoints() ([]netlink.AccessPoint, error) {
+	d.Execute(ListAP)
+	r, err := d.Response(10000)
+	if err != nil {
+		return nil, netlink.ErrScanFailed
+	}
+
+	// +CWLAP:(<ecn>,<"ssid">,<rssi>,<"mac">,<channel>,...)
+	prefix := ListAP + ":("
+	var aps []netlink.AccessPoint
+	for _, line := range strings.Split(string(r), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, prefix) || !strings.HasSuffix(line, ")") {
+			continue
+		}
+		fields := splitFields(line[len(prefix) : len(line)-1])
+		if len(fields) < 5 {
+			continue
+		}
+		ecn, _ := strconv.Atoi(fields[0])
+		rssi, _ := strconv.Atoi(fields[2])
+		bssid, _ := net.ParseMAC(strings.Trim(fields[3], `"`))
+		channel, _ := strconv.Atoi(fields[4])
+		aps = append(aps, netlink.AccessPoint{
+			Ssid:     strings.Trim(fields[1], `"`),
+			Bssid:    bssid,
+			Rssi:     rssi,
+			Channel:  channel,
+			AuthType: authType(ecn),
+		})
+	}
+	return aps, nil
+}
+
+// splitFields splits comma separated fields, ignoring the commas between
+// quotes.
+func splitFields(s string) []string {
+	var fields []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				fields = append(fields, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, s[start:])
+}
+
+// authType converts the encryption method of an access point.
+func authType(ecn int) netlink.AuthType {
+	switch ecn {
+	case 0:
+		return netlink.AuthTypeOpen
+	case 1:
+		return netlink.AuthTypeWEP
+	case 2:
+		return netlink.AuthTypeWPA
+	case 3:
+		return netlink.AuthTypeWPA2
+	case 4:
+		return netlink.AuthTypeWPA2Mixed
+	case 5:
+		return netlink.AuthTypeEAP
+	case 6:
+		return netlink.AuthTypeWPA3
+	case 7:
+		return netlink.AuthTypeWPA3Mixed
+	default:
+		return netlink.AuthTypeUnknown
+	}
+}
+
 // DisconnectFromAP disconnects the ESP8266/ESP32 from the current access point.
 func (d *Device) DisconnectFromAP() error {
 	d.Execute(Disconnect)
@@ -54,6 +54,22 @@ func main() {
 		lorawan.UseRegionSettings(region.EU868())
 	case "US915":
 		lorawan.UseRegionSettings(region.US915())
+	case "AS923-1":
+		lorawan.UseRegionSettings(region.AS923_1())
+	case "AS923-2":
+		lorawan.UseRegionSettings(region.AS923_2())
+	case "AS923-3":
+		lorawan.UseRegionSettings(region.AS923_3())
+	case "AS923-4":
+		lorawan.UseRegionSettings(region.AS923_4())
+	case "KR920":
+		lorawan.UseRegionSettings(region.KR920())
+	case "IN865":
+		lorawan.UseRegionSettings(region.IN865())
+	case "CN470":
+		lorawan.UseRegionSettings(region.CN470())
+	case "EU433":
+		lorawan.UseRegionSettings(region.EU433())
 	default:
 		lorawan.UseRegionSettings(region.EU868())
 	}
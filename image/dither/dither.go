@@ -0,0 +1,289 @@
+// Package dither converts images to displays with few colors, such as
+// monochrome OLEDs and three-color e-paper panels.
+//
+// Instead of thresholding each pixel, like pixel.NewColor does, the
+// quantization error is spread over neighboring pixels (error diffusion) or
+// offset by a position dependent pattern (ordered dithering). This keeps
+// gradients and photos recognizable even on 1-bit displays.
+//
+// A Ditherer accepts the RGB565 tiles that are passed to the callbacks of the
+// image/jpeg and image/png packages, so images can be converted while they are
+// being decoded:
+//
+//	d := dither.New[pixel.Monochrome](width, dither.FloydSteinberg)
+//	png.SetCallback(buf[:], func(data []uint16, x, y, w, h, width, height int16) {
+//		d.Draw(data, x, y, w, h, func(x, y int16, c pixel.Monochrome) {
+//			img.Set(int(x), int(y), c)
+//		})
+//	})
+package dither
+
+import (
+	"image/color"
+
+	"tinygo.org/x/drivers/pixel"
+)
+
+// Method is the dithering algorithm used by a Ditherer.
+type Method uint8
+
+const (
+	// None maps each pixel to the nearest color, without dithering.
+	None Method = iota
+
+	// Bayer is ordered dithering with an 8x8 Bayer matrix. It is fast, has no
+	// state and produces a regular pattern that does not change when only
+	// part of the image is redrawn.
+	Bayer
+
+	// FloydSteinberg is error diffusion dithering. It gives the best result
+	// for photos, but needs to keep the error of the next row in memory.
+	FloydSteinberg
+)
+
+// bayer8 is the 8x8 Bayer threshold matrix.
+var bayer8 = [8][8]int8{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// A Ditherer converts RGB565 pixel data to colors of type T. Use New for
+// pixel formats and NewPalette for a fixed set of colors.
+//
+// When using FloydSteinberg, the tiles of an image must be passed in the order
+// the image decoders produce them: rows of tiles from top to bottom, and the
+// tiles of a row from left to right. The error is carried over between tiles.
+type Ditherer[T any] struct {
+	width  int
+	method Method
+
+	// quantize returns the nearest color to r, g, b as well as the RGB values
+	// of that color, which are needed to compute the quantization error.
+	quantize func(r, g, b uint8) (T, color.RGBA)
+
+	// step is the distance between two adjacent levels of each channel in the
+	// output, which is the amplitude of the Bayer pattern.
+	step [3]int16
+
+	// Error diffusion state, with 3 entries (R, G, B) per pixel. below is the
+	// error for the first row of the current row of tiles, and nextBelow for
+	// the first row of the next one. rightIn and rightOut are the error that
+	// flows into the first column of the current and the next tile. cur and
+	// next are the rows within the tile.
+	stripY            int
+	below, nextBelow  []int16
+	rightIn, rightOut []int16
+	cur, next         []int16
+}
+
+// New returns a Ditherer for an image that is width pixels wide, which
+// converts to the pixel format T.
+func New[T pixel.Color](width int, method Method) *Ditherer[T] {
+	d := &Ditherer[T]{
+		width:  width,
+		method: method,
+		quantize: func(r, g, b uint8) (T, color.RGBA) {
+			c := pixel.NewColor[T](r, g, b)
+			return c, c.RGBA()
+		},
+	}
+	var zero T
+	switch any(zero).(type) {
+	case pixel.RGB888:
+		d.step = [3]int16{1, 1, 1}
+	case pixel.RGB565BE:
+		d.step = [3]int16{8, 4, 8}
+	case pixel.RGB555:
+		d.step = [3]int16{8, 8, 8}
+	case pixel.RGB444BE:
+		d.step = [3]int16{16, 16, 16}
+	case pixel.Grayscale2bit:
+		d.step = [3]int16{85, 85, 85}
+	case pixel.Monochrome:
+		d.step = [3]int16{255, 255, 255}
+	}
+	d.Reset()
+	return d
+}
+
+// NewPalette returns a Ditherer for an image that is width pixels wide, which
+// converts to the nearest color in p. The colors are returned as indices into
+// p.
+func NewPalette(width int, method Method, p Palette) *Ditherer[uint8] {
+	d := &Ditherer[uint8]{
+		width:  width,
+		method: method,
+		quantize: func(r, g, b uint8) (uint8, color.RGBA) {
+			i := p.Index(r, g, b)
+			return i, p[i]
+		},
+		step: p.step(),
+	}
+	d.Reset()
+	return d
+}
+
+// Reset clears the diffused error, so that a new image can be drawn.
+func (d *Ditherer[T]) Reset() {
+	d.stripY = -1
+	if d.method != FloydSteinberg {
+		return
+	}
+	if len(d.below) != 3*d.width {
+		d.below = make([]int16, 3*d.width)
+		d.nextBelow = make([]int16, 3*d.width)
+	}
+	zero(d.below)
+	zero(d.nextBelow)
+	zero(d.rightIn)
+	zero(d.rightOut)
+}
+
+// Draw converts the w x h RGB565 pixels in data, located at x, y in the image,
+// and calls set for each of them. The parameters match those of the image/jpeg
+// and image/png callbacks.
+func (d *Ditherer[T]) Draw(data []uint16, x, y, w, h int16, set func(x, y int16, c T)) {
+	if d.method == FloydSteinberg {
+		d.diffuse(data, int(x), int(y), int(w), int(h), set)
+		return
+	}
+	for j := int16(0); j < h; j++ {
+		for i := int16(0); i < w; i++ {
+			r, g, b := expand(data[int(j)*int(w)+int(i)])
+			if d.method == Bayer {
+				// Offset the color by -step/2 to +step/2 depending on the
+				// position in the pattern.
+				t := int16(bayer8[(y+j)&7][(x+i)&7])*2 - 63
+				r = clamp(r + t*d.step[0]/128)
+				g = clamp(g + t*d.step[1]/128)
+				b = clamp(b + t*d.step[2]/128)
+			}
+			c, _ := d.quantize(uint8(r), uint8(g), uint8(b))
+			set(x+i, y+j, c)
+		}
+	}
+}
+
+// diffuse implements Floyd-Steinberg dithering of a single tile. The error of
+// a pixel is distributed as 7/16 to the right, 3/16 below left, 5/16 below and
+// 1/16 below right. Error that flows out of the tile is stored in below and
+// rightOut for the tiles that follow. The tile to the left has already been
+// drawn, so error below left of the first column goes straight down instead.
+func (d *Ditherer[T]) diffuse(data []uint16, x, y, w, h int, set func(x, y int16, c T)) {
+	if y < d.stripY {
+		// A new image was started without calling Reset.
+		d.Reset()
+	}
+	if y != d.stripY {
+		// First tile of a new row of tiles.
+		d.below, d.nextBelow = d.nextBelow, d.below
+		zero(d.nextBelow)
+		d.rightIn = grow(d.rightIn, 3*h)
+		d.rightOut = grow(d.rightOut, 3*h)
+		zero(d.rightOut)
+		d.stripY = y
+	}
+	d.rightIn, d.rightOut = d.rightOut, d.rightIn
+	zero(d.rightOut)
+	d.cur = grow(d.cur, 3*w)
+	d.next = grow(d.next, 3*w)
+
+	// The first row of the tile receives the error from the tile above.
+	copy(d.cur, d.below[3*x:3*(x+w)])
+	for j := 0; j < h; j++ {
+		zero(d.next)
+		last := j == h-1
+		for i := 0; i < w; i++ {
+			var v [3]int16
+			v[0], v[1], v[2] = expand(data[j*w+i])
+			for ch := range v {
+				v[ch] = clamp(v[ch] + d.cur[3*i+ch] + d.in(i, j, ch))
+			}
+			c, q := d.quantize(uint8(v[0]), uint8(v[1]), uint8(v[2]))
+			set(int16(x+i), int16(y+j), c)
+
+			v[0] -= int16(q.R)
+			v[1] -= int16(q.G)
+			v[2] -= int16(q.B)
+			for ch, e := range v {
+				// Right.
+				if i+1 < w {
+					d.cur[3*(i+1)+ch] += e * 7 / 16
+				} else {
+					d.rightOut[3*j+ch] += e * 7 / 16
+				}
+
+				// Below left, below and below right.
+				left := i - 1
+				if left < 0 {
+					left = 0
+				}
+				if !last {
+					d.next[3*left+ch] += e * 3 / 16
+					d.next[3*i+ch] += e * 5 / 16
+					if i+1 < w {
+						d.next[3*(i+1)+ch] += e / 16
+					} else {
+						d.rightOut[3*(j+1)+ch] += e / 16
+					}
+					continue
+				}
+				d.nextBelow[3*(x+left)+ch] += e * 3 / 16
+				d.nextBelow[3*(x+i)+ch] += e * 5 / 16
+				if x+i+1 < d.width {
+					d.nextBelow[3*(x+i+1)+ch] += e / 16
+				}
+			}
+		}
+		d.cur, d.next = d.next, d.cur
+	}
+}
+
+// in returns the error that flows into pixel i, j of the current tile from the
+// tile to its left.
+func (d *Ditherer[T]) in(i, j, ch int) int16 {
+	if i != 0 {
+		return 0
+	}
+	return d.rightIn[3*j+ch]
+}
+
+// expand converts an RGB565 color to 8 bits per channel.
+func expand(c uint16) (r, g, b int16) {
+	r = int16(c>>11) << 3
+	g = int16(c>>5&0x3f) << 2
+	b = int16(c&0x1f) << 3
+	// Correct color rounding, so that 0xff roundtrips back to 0xff.
+	return r | r>>5, g | g>>6, b | b>>5
+}
+
+func clamp(v int16) int16 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+func zero(s []int16) {
+	for i := range s {
+		s[i] = 0
+	}
+}
+
+// grow returns s with a length of at least n, reusing the backing array when
+// possible.
+func grow(s []int16, n int) []int16 {
+	if cap(s) < n {
+		return make([]int16, n)
+	}
+	return s[:n]
+}
@@ -0,0 +1,121 @@
+package dither_test
+
+import (
+	"testing"
+
+	"tinygo.org/x/drivers/image/dither"
+	"tinygo.org/x/drivers/pixel"
+)
+
+// gray returns the RGB565 value of a gray level.
+func gray(v uint8) uint16 {
+	return uint16(v>>3)<<11 | uint16(v>>2)<<5 | uint16(v>>3)
+}
+
+// drawTiles passes a uniform width x height image to d in tiles of the given
+// size, in the order used by the jpeg decoder, and returns the result.
+func drawTiles[T any](d *dither.Ditherer[T], v uint8, width, height, tile int) []T {
+	out := make([]T, width*height)
+	data := make([]uint16, tile*tile)
+	for y := 0; y < height; y += tile {
+		for x := 0; x < width; x += tile {
+			w, h := min(tile, width-x), min(tile, height-y)
+			for i := range data[:w*h] {
+				data[i] = gray(v)
+			}
+			d.Draw(data[:w*h], int16(x), int16(y), int16(w), int16(h), func(x, y int16, c T) {
+				out[int(y)*width+int(x)] = c
+			})
+		}
+	}
+	return out
+}
+
+func whiteFraction(pix []pixel.Monochrome) float64 {
+	n := 0
+	for _, c := range pix {
+		if c {
+			n++
+		}
+	}
+	return float64(n) / float64(len(pix))
+}
+
+func TestMonochrome(t *testing.T) {
+	for _, tc := range []struct {
+		method dither.Method
+		tile   int
+	}{
+		{dither.Bayer, 16},
+		{dither.FloydSteinberg, 64}, // one row per tile, like the png decoder
+		{dither.FloydSteinberg, 16},
+		{dither.FloydSteinberg, 7},
+	} {
+		for _, v := range []uint8{0x00, 0x40, 0x80, 0xc0, 0xff} {
+			d := dither.New[pixel.Monochrome](64, tc.method)
+			got := whiteFraction(drawTiles(d, v, 64, 48, tc.tile))
+			want := float64(v) / 255
+			if got < want-0.04 || got > want+0.04 {
+				t.Errorf("method %d, tile %d, level %#02x: %.2f of the pixels are white, want about %.2f", tc.method, tc.tile, v, got, want)
+			}
+		}
+	}
+}
+
+func TestNone(t *testing.T) {
+	d := dither.New[pixel.Grayscale2bit](16, dither.None)
+	for _, v := range []uint8{0x00, 0x30, 0x70, 0xb0, 0xff} {
+		want := pixel.NewColor[pixel.Grayscale2bit](v, v, v)
+		for i, c := range drawTiles(d, v, 16, 16, 8) {
+			if c != want {
+				t.Fatalf("level %#02x: pixel %d is %d, want %d", v, i, c, want)
+			}
+		}
+	}
+}
+
+func TestGrayscale2bit(t *testing.T) {
+	// A level between two gray levels must result in a mix of just those two.
+	d := dither.New[pixel.Grayscale2bit](32, dither.FloydSteinberg)
+	var count [4]int
+	for _, c := range drawTiles(d, 0x80, 32, 32, 16) {
+		count[c]++
+	}
+	if count[0] != 0 || count[3] != 0 || count[1] == 0 || count[2] == 0 {
+		t.Errorf("unexpected distribution of gray levels: %v", count)
+	}
+}
+
+func TestPalette(t *testing.T) {
+	p := dither.BlackWhiteRed
+	for _, tc := range []struct {
+		r, g, b uint8
+		want    uint8
+	}{
+		{0, 0, 0, 0},
+		{0xff, 0xff, 0xff, 1},
+		{0xff, 0, 0, 2},
+		{0xc0, 0x20, 0x10, 2},
+		{0x20, 0x20, 0x30, 0},
+	} {
+		if got := p.Index(tc.r, tc.g, tc.b); got != tc.want {
+			t.Errorf("Index(%d, %d, %d) = %d, want %d", tc.r, tc.g, tc.b, got, tc.want)
+		}
+	}
+
+	// Dark red is a mix of black and red, without white.
+	d := dither.NewPalette(16, dither.FloydSteinberg, p)
+	var count [3]int
+	data := make([]uint16, 16)
+	for y := int16(0); y < 16; y++ {
+		for i := range data {
+			data[i] = 0x10 << 11 // red at half intensity
+		}
+		d.Draw(data, 0, y, 16, 1, func(x, y int16, c uint8) {
+			count[c]++
+		})
+	}
+	if count[1] != 0 || count[0] == 0 || count[2] == 0 {
+		t.Errorf("unexpected distribution of colors: %v", count)
+	}
+}
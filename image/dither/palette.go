@@ -0,0 +1,66 @@
+package dither
+
+import "image/color"
+
+// Palette is a fixed set of colors, for displays that do not use one of the
+// formats in the pixel package.
+type Palette []color.RGBA
+
+// Palettes of common e-paper displays. The colors match those expected by
+// SetPixel of the waveshare-epd drivers.
+var (
+	BlackWhite = Palette{
+		{0x00, 0x00, 0x00, 0xff},
+		{0xff, 0xff, 0xff, 0xff},
+	}
+	BlackWhiteRed = Palette{
+		{0x00, 0x00, 0x00, 0xff},
+		{0xff, 0xff, 0xff, 0xff},
+		{0xff, 0x00, 0x00, 0xff},
+	}
+	BlackWhiteYellow = Palette{
+		{0x00, 0x00, 0x00, 0xff},
+		{0xff, 0xff, 0xff, 0xff},
+		{0xff, 0xff, 0x00, 0xff},
+	}
+)
+
+// Index returns the index of the color in p that is closest to r, g, b in
+// Euclidean RGB distance.
+func (p Palette) Index(r, g, b uint8) uint8 {
+	best, bestDist := 0, int32(-1)
+	for i, c := range p {
+		dr := int32(r) - int32(c.R)
+		dg := int32(g) - int32(c.G)
+		db := int32(b) - int32(c.B)
+		dist := dr*dr + dg*dg + db*db
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return uint8(best)
+}
+
+// step returns the amplitude of the Bayer pattern for p, which is the range
+// of each channel over all colors in the palette.
+func (p Palette) step() [3]int16 {
+	lo := [3]int16{255, 255, 255}
+	var hi [3]int16
+	for _, c := range p {
+		for ch, v := range [3]int16{int16(c.R), int16(c.G), int16(c.B)} {
+			if v < lo[ch] {
+				lo[ch] = v
+			}
+			if v > hi[ch] {
+				hi[ch] = v
+			}
+		}
+	}
+	var step [3]int16
+	for ch := range step {
+		if hi[ch] > lo[ch] {
+			step[ch] = hi[ch] - lo[ch]
+		}
+	}
+	return step
+}
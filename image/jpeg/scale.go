@@ -0,0 +1,68 @@
+package jpeg
+
+// idctScaleBits is the number of fractional bits in the scaled IDCT tables.
+const idctScaleBits = 12
+
+// idctScaled2 and idctScaled4 hold c(u)*cos((2x+1)*u*pi/(2n)) for the 2 and 4
+// point inverse DCTs, with c(0) = 1 and c(u) = sqrt(2) otherwise, indexed by
+// x*n+u and scaled by 1<<idctScaleBits.
+var (
+	idctScaled2 = [2 * 2]int32{
+		4096, 4096,
+		4096, -4096,
+	}
+	idctScaled4 = [4 * 4]int32{
+		4096, 5352, 4096, 2217,
+		4096, 2217, -4096, -5352,
+		4096, -2217, -4096, 5352,
+		4096, -5352, 4096, -2217,
+	}
+)
+
+// idctScaled performs a reduced size inverse DCT, producing an n x n block
+// from the low frequency n x n coefficients of src. This is equivalent to
+// sampling the full 8 x 8 reconstruction at the centers of n x n areas with
+// the high frequencies removed, so no separate filtering is needed. The
+// result is written to the first n*n entries of src and, like idct, is not
+// level shifted. n must be 1, 2, 4 or 8.
+func idctScaled(src *block, n int) {
+	var t []int32
+	switch n {
+	case 8:
+		idct(src)
+		return
+	case 1:
+		// Only the DC component remains.
+		src[0] = (src[0] + 4) >> 3
+		return
+	case 2:
+		t = idctScaled2[:]
+	case 4:
+		t = idctScaled4[:]
+	}
+
+	// Horizontal pass, keeping only the low frequency rows and columns.
+	var tmp [4 * 4]int32
+	for v := 0; v < n; v++ {
+		for x := 0; x < n; x++ {
+			sum := int32(0)
+			for u := 0; u < n; u++ {
+				sum += src[v*8+u] * t[x*n+u]
+			}
+			tmp[v*n+x] = (sum + 1<<(idctScaleBits-1)) >> idctScaleBits
+		}
+	}
+
+	// Vertical pass. The 1/8 normalization is the same as for the full size
+	// transform.
+	const shift = idctScaleBits + 3
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			sum := int32(0)
+			for v := 0; v < n; v++ {
+				sum += tmp[v*n+x] * t[y*n+v]
+			}
+			src[y*n+x] = (sum + 1<<(shift-1)) >> shift
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package jpeg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// decodeToFrame decodes data with the given scale and crop, and returns the
+// RGB565 pixels passed to the callback together with the reported size.
+func decodeToFrame(t *testing.T, data []byte, denom int, r image.Rectangle) ([]uint16, int, int) {
+	t.Helper()
+	var (
+		frame         []uint16
+		width, height int
+		buf           [16 * 16]uint16
+	)
+	SetCallback(buf[:], func(data []uint16, x, y, w, h, fw, fh int16) {
+		if frame == nil {
+			width, height = int(fw), int(fh)
+			frame = make([]uint16, width*height)
+		}
+		for j := 0; j < int(h); j++ {
+			for i := 0; i < int(w); i++ {
+				px, py := int(x)+i, int(y)+j
+				if px < width && py < height {
+					frame[py*width+px] = data[j*int(w)+i]
+				}
+			}
+		}
+	})
+	if err := SetScale(denom); err != nil {
+		t.Fatal(err)
+	}
+	SetCrop(r)
+	defer func() {
+		SetScale(1)
+		SetCrop(image.Rectangle{})
+	}()
+	if _, err := Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return frame, width, height
+}
+
+func gradientJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.Set(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 0x80, 0xff})
+		}
+	}
+	var b bytes.Buffer
+	if err := Encode(&b, m, &Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// boxAverage returns the average of the n x n RGB565 pixels at (x, y).
+func boxAverage(frame []uint16, stride, x, y, n int) uint16 {
+	var r, g, b int
+	for j := y; j < y+n; j++ {
+		for i := x; i < x+n; i++ {
+			c := frame[j*stride+i]
+			r += int(c >> 11)
+			g += int(c>>5) & 0x3f
+			b += int(c) & 0x1f
+		}
+	}
+	n *= n
+	return uint16((r+n/2)/n)<<11 | uint16((g+n/2)/n)<<5 | uint16((b+n/2)/n)
+}
+
+func rgb565Delta(c0, c1 uint16) int {
+	d := 0
+	for _, s := range []struct{ shift, mask uint16 }{{11, 0x1f}, {5, 0x3f}, {0, 0x1f}} {
+		v := int((c0>>s.shift)&s.mask) - int((c1>>s.shift)&s.mask)
+		if v < 0 {
+			v = -v
+		}
+		if v > d {
+			d = v
+		}
+	}
+	return d
+}
+
+func TestDecodeScaled(t *testing.T) {
+	data := gradientJPEG(t, 128, 96)
+	full, fw, fh := decodeToFrame(t, data, 1, image.Rectangle{})
+	if fw != 128 || fh != 96 {
+		t.Fatalf("full size: got %dx%d, want 128x96", fw, fh)
+	}
+
+	for _, denom := range []int{2, 4, 8} {
+		scaled, w, h := decodeToFrame(t, data, denom, image.Rectangle{})
+		if w != fw/denom || h != fh/denom {
+			t.Errorf("1/%d: got %dx%d, want %dx%d", denom, w, h, fw/denom, fh/denom)
+			continue
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				want := boxAverage(full, fw, x*denom, y*denom, denom)
+				if d := rgb565Delta(scaled[y*w+x], want); d > 4 {
+					t.Fatalf("1/%d: pixel (%d, %d) = %#04x, want about %#04x", denom, x, y, scaled[y*w+x], want)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeCropped(t *testing.T) {
+	data := gradientJPEG(t, 64, 48)
+	scaled, w, _ := decodeToFrame(t, data, 2, image.Rectangle{})
+
+	r := image.Rect(5, 3, 21, 30)
+	var tiles []image.Rectangle
+	var buf [16 * 16]uint16
+	cropped := make([]uint16, 16*21)
+	SetCallback(buf[:], func(data []uint16, x, y, tw, th, width, height int16) {
+		if width != 16 || height != 21 {
+			t.Fatalf("got size %dx%d, want 16x21", width, height)
+		}
+		tile := image.Rect(int(x), int(y), int(x+tw), int(y+th))
+		for _, prev := range tiles {
+			if prev.Overlaps(tile) {
+				t.Fatalf("tile %v overlaps %v", tile, prev)
+			}
+		}
+		tiles = append(tiles, tile)
+		for j := 0; j < int(th); j++ {
+			copy(cropped[(int(y)+j)*16+int(x):], data[j*int(tw):(j+1)*int(tw)])
+		}
+	})
+	SetScale(2)
+	SetCrop(r)
+	defer func() {
+		SetScale(1)
+		SetCrop(image.Rectangle{})
+	}()
+	if _, err := Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	area := 0
+	for _, tile := range tiles {
+		area += tile.Dx() * tile.Dy()
+	}
+	if area != 16*21 {
+		t.Errorf("callback covered %d pixels, want %d", area, 16*21)
+	}
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 16; x++ {
+			if got, want := cropped[y*16+x], scaled[(y+r.Min.Y)*w+x+r.Min.X]; got != want {
+				t.Fatalf("pixel (%d, %d) = %#04x, want %#04x", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestSetScale(t *testing.T) {
+	defer SetScale(1)
+	for _, denom := range []int{0, 3, 16} {
+		if err := SetScale(denom); err == nil {
+			t.Errorf("SetScale(%d): expected error", denom)
+		}
+	}
+}
@@ -306,62 +306,38 @@ func (d *decoder) processSOS(n int) error {
 						// SOS markers are processed.
 						continue
 					}
+					if !d.mcuVisible(mx, my) {
+						continue
+					}
 					if dst, err := d.reconstructBlock(&b, bx, by, int(compIndex)); err != nil {
 						return err
 					} else {
-						// Currently, only the YCbCr422 format is supported.
+						// Currently, only the YCbCr420 format is supported.
+						// bs is the size of a block and ms is the size of an
+						// MCU after scaling.
+						bs := 8 / decodeScale
+						ms := 2 * bs
 						switch compIndex {
 						case 0: // Y
-							bx8 := bx * 8
-							by8 := by * 8
-							bx16 := bx8 % 16
-							by16 := by8 % 16
-							for cy := 0; cy < 8; cy++ {
-								for cx := 0; cx < 8; cx++ {
-									processSOSBuf[((cy+by16)*16+(cx+bx16))*3+0] = dst[cy*8+cx]
+							ox := (bx % 2) * bs
+							oy := (by % 2) * bs
+							for cy := 0; cy < bs; cy++ {
+								for cx := 0; cx < bs; cx++ {
+									processSOSBuf[((cy+oy)*ms+(cx+ox))*3+0] = dst[cy*bs+cx]
 								}
 							}
-						case 1: // Cb
-							bx8 := bx * 8 * 2
-							by8 := by * 8 * 2
-							bx16 := bx8 % 16
-							by16 := by8 % 16
-
-							for cy := 0; cy < 8; cy++ {
-								for cx := 0; cx < 8; cx++ {
-									processSOSBuf[((cy*2+0+by16)*16+(cx*2+0+bx16))*3+1] = dst[cy*8+cx]
-									processSOSBuf[((cy*2+0+by16)*16+(cx*2+1+bx16))*3+1] = dst[cy*8+cx]
-									processSOSBuf[((cy*2+1+by16)*16+(cx*2+0+bx16))*3+1] = dst[cy*8+cx]
-									processSOSBuf[((cy*2+1+by16)*16+(cx*2+1+bx16))*3+1] = dst[cy*8+cx]
+						case 1, 2: // Cb, Cr
+							for cy := 0; cy < bs; cy++ {
+								for cx := 0; cx < bs; cx++ {
+									processSOSBuf[((cy*2+0)*ms+(cx*2+0))*3+int(compIndex)] = dst[cy*bs+cx]
+									processSOSBuf[((cy*2+0)*ms+(cx*2+1))*3+int(compIndex)] = dst[cy*bs+cx]
+									processSOSBuf[((cy*2+1)*ms+(cx*2+0))*3+int(compIndex)] = dst[cy*bs+cx]
+									processSOSBuf[((cy*2+1)*ms+(cx*2+1))*3+int(compIndex)] = dst[cy*bs+cx]
 								}
 							}
-						case 2: // Cr
-							bx8 := bx * 8 * 2
-							by8 := by * 8 * 2
-							bx16 := bx8 % 16
-							by16 := by8 % 16
-
-							for cy := 0; cy < 8; cy++ {
-								for cx := 0; cx < 8; cx++ {
-									processSOSBuf[((cy*2+0+by16)*16+(cx*2+0+bx16))*3+2] = dst[cy*8+cx]
-									processSOSBuf[((cy*2+0+by16)*16+(cx*2+1+bx16))*3+2] = dst[cy*8+cx]
-									processSOSBuf[((cy*2+1+by16)*16+(cx*2+0+bx16))*3+2] = dst[cy*8+cx]
-									processSOSBuf[((cy*2+1+by16)*16+(cx*2+1+bx16))*3+2] = dst[cy*8+cx]
-								}
+							if compIndex == 2 {
+								d.emitMCU(mx, my, ms)
 							}
-
-							for cy := 0; cy < 16; cy++ {
-								for cx := 0; cx < 16; cx++ {
-									yy := processSOSBuf[(cy*16+cx)*3+0]
-									cb := processSOSBuf[(cy*16+cx)*3+1]
-									cr := processSOSBuf[(cy*16+cx)*3+2]
-									r, g, b := color.YCbCrToRGB(yy, cb, cr)
-									callbackBuf[cy*16+cx] = uint16(((uint16(r) << 8) & 0xF800) +
-										(((uint16(g) << 8) & 0xFC00) >> 5) +
-										(((uint16(b) << 8) & 0xF800) >> 11))
-								}
-							}
-							callback(callbackBuf[:8*8*4], int16(bx8-bx16), int16(by8-by16), 16, 16, int16(d.width), int16(d.height))
 						}
 					}
 				} // for j
@@ -556,13 +532,13 @@ func (d *decoder) reconstructBlock(b *block, bx, by, compIndex int) ([]byte, err
 	for zig := 0; zig < blockSize; zig++ {
 		b[unzig[zig]] *= qt[zig]
 	}
-	idct(b)
+	bs := 8 / decodeScale
+	idctScaled(b, bs)
 	// Level shift by +128, clip to [0, 255], and write to dst.
-	var buf = reconstructBlockBuf[:]
-	for y := 0; y < 8; y++ {
-		y8 := y * 8
-		for x := 0; x < 8; x++ {
-			c := b[y8+x]
+	var buf = reconstructBlockBuf[:bs*bs]
+	for y := 0; y < bs; y++ {
+		for x := 0; x < bs; x++ {
+			c := b[y*bs+x]
 			if c < -128 {
 				c = 0
 			} else if c > 127 {
@@ -570,8 +546,54 @@ func (d *decoder) reconstructBlock(b *block, bx, by, compIndex int) ([]byte, err
 			} else {
 				c += 128
 			}
-			buf[y*8+x] = uint8(c)
+			buf[y*bs+x] = uint8(c)
 		}
 	}
 	return buf, nil
 }
+
+// mcuVisible reports whether any part of the MCU at (mx, my) falls within the
+// crop region set by SetCrop. Blocks of invisible MCUs still have to be
+// Huffman decoded, but are not reconstructed.
+func (d *decoder) mcuVisible(mx, my int) bool {
+	if decodeCrop.Empty() {
+		return true
+	}
+	ms := 16 / decodeScale
+	return image.Rect(mx*ms, my*ms, mx*ms+ms, my*ms+ms).Overlaps(decodeCrop)
+}
+
+// emitMCU converts the ms x ms pixels of the MCU at (mx, my) in processSOSBuf
+// to RGB565 and passes them to the callback, clipped to the crop region.
+func (d *decoder) emitMCU(mx, my, ms int) {
+	width := (d.width + decodeScale - 1) / decodeScale
+	height := (d.height + decodeScale - 1) / decodeScale
+	rect := image.Rect(mx*ms, my*ms, mx*ms+ms, my*ms+ms)
+	var origin image.Point
+	if !decodeCrop.Empty() {
+		bounds := decodeCrop.Intersect(image.Rect(0, 0, width, height))
+		rect = rect.Intersect(bounds)
+		if rect.Empty() {
+			return
+		}
+		origin = bounds.Min
+		width, height = bounds.Dx(), bounds.Dy()
+	}
+
+	i := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		cy := y - my*ms
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			cx := x - mx*ms
+			yy := processSOSBuf[(cy*ms+cx)*3+0]
+			cb := processSOSBuf[(cy*ms+cx)*3+1]
+			cr := processSOSBuf[(cy*ms+cx)*3+2]
+			r, g, b := color.YCbCrToRGB(yy, cb, cr)
+			callbackBuf[i] = uint16(((uint16(r) << 8) & 0xF800) +
+				(((uint16(g) << 8) & 0xFC00) >> 5) +
+				(((uint16(b) << 8) & 0xF800) >> 11))
+			i++
+		}
+	}
+	callback(callbackBuf[:i], int16(rect.Min.X-origin.X), int16(rect.Min.Y-origin.Y), int16(rect.Dx()), int16(rect.Dy()), int16(width), int16(height))
+}
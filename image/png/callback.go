@@ -1,8 +1,16 @@
 package png
 
+import "image"
+
 var (
 	callback    Callback = func(data []uint16, x, y, w, h, width, height int16) {}
 	callbackBuf []uint16
+
+	// decodeScale and decodeCrop are set by SetScale and SetCrop, and are
+	// used by Decode to limit the resolution and region passed to the
+	// callback.
+	decodeScale = 1
+	decodeCrop  image.Rectangle
 )
 
 // A portion of the image data consisting of data, x, y, w, and h is passed to
@@ -15,3 +23,23 @@ func SetCallback(buf []uint16, fn Callback) {
 	callbackBuf = buf
 	callback = fn
 }
+
+// SetScale sets the factor by which Decode shrinks the image before passing
+// it to the callback. The valid values are 1, 2, 4 and 8. Each output pixel is
+// the average of the denom x denom source pixels it covers.
+func SetScale(denom int) error {
+	switch denom {
+	case 1, 2, 4, 8:
+		decodeScale = denom
+		return nil
+	}
+	return UnsupportedError("scale")
+}
+
+// SetCrop restricts the callback to the region r of the scaled image. The
+// coordinates passed to the callback are relative to r.Min, and width and
+// height are the size of r clipped to the image. Rows outside of r are still
+// decompressed, but not converted. An empty rectangle disables cropping.
+func SetCrop(r image.Rectangle) {
+	decodeCrop = r
+}
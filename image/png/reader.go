@@ -119,6 +119,10 @@ type decoder struct {
 	// transparency, as opposed to palette transparency.
 	useTransparent bool
 	transparent    [6]byte
+
+	// acc holds the per column sums of the rows being scaled down by
+	// emitRow.
+	acc []uint16
 }
 
 // A FormatError reports that the input is not a valid PNG.
@@ -672,13 +676,7 @@ func (d *decoder) readImagePass(r io.Reader, pass int, allocateOnly bool) (image
 				}
 				pixOffset += nrgba.Stride
 			} else {
-				for x := 0; x < width; x++ {
-					r := uint16(cdat[x*3+0]) << 8
-					g := uint16(cdat[x*3+1]) << 8
-					b := uint16(cdat[x*3+2]) << 8
-					callbackBuf[x] = uint16((r & 0xF800) + ((g & 0xFC00) >> 5) + ((b & 0xF800) >> 11))
-				}
-				callback(callbackBuf[:width*1], 0, int16(y), int16(width), 1, int16(width), int16(height))
+				d.emitRow(cdat, 3, y, width, height)
 				pixOffset += rgba.Stride
 			}
 		case cbP1:
@@ -729,13 +727,7 @@ func (d *decoder) readImagePass(r io.Reader, pass int, allocateOnly bool) (image
 			pixOffset += paletted.Stride
 		case cbTCA8:
 			copy(nrgba.Pix[:], cdat)
-			for x := 0; x < width; x++ {
-				r := uint16(cdat[x*3+0]) << 8
-				g := uint16(cdat[x*3+1]) << 8
-				b := uint16(cdat[x*3+2]) << 8
-				callbackBuf[x] = uint16((r & 0xF800) + ((g & 0xFC00) >> 5) + ((b & 0xF800) >> 11))
-			}
-			callback(callbackBuf[:width*1], 0, int16(y), int16(width), 1, int16(width), int16(height))
+			d.emitRow(cdat, 4, y, width, height)
 			pixOffset += nrgba.Stride
 		case cbG16:
 			if d.useTransparent {
@@ -0,0 +1,72 @@
+package png
+
+import "image"
+
+// emitRow passes row y of a width x height image to the callback, applying
+// the scale and crop set by SetScale and SetCrop. pix holds the 8-bit RGB
+// samples of the row, with bpp bytes per pixel.
+//
+// When scaling, the rows are summed up in d.acc until a full row of output
+// pixels is available. The sum of up to 8 x 8 samples fits in a uint16.
+func (d *decoder) emitRow(pix []uint8, bpp, y, width, height int) {
+	s := decodeScale
+	bounds := image.Rect(0, 0, (width+s-1)/s, (height+s-1)/s)
+	if !decodeCrop.Empty() {
+		bounds = decodeCrop.Intersect(bounds)
+	}
+	sy := y / s
+	if sy < bounds.Min.Y || sy >= bounds.Max.Y {
+		return
+	}
+	w := bounds.Dx()
+
+	if s == 1 {
+		for x := 0; x < w; x++ {
+			p := pix[(bounds.Min.X+x)*bpp:]
+			callbackBuf[x] = rgb565(p[0], p[1], p[2])
+		}
+		callback(callbackBuf[:w], 0, int16(sy-bounds.Min.Y), int16(w), 1, int16(w), int16(bounds.Dy()))
+		return
+	}
+
+	if len(d.acc) < 3*w {
+		d.acc = make([]uint16, 3*w)
+	}
+	acc := d.acc[:3*w]
+	if y%s == 0 {
+		for i := range acc {
+			acc[i] = 0
+		}
+	}
+	for x := bounds.Min.X * s; x < bounds.Max.X*s && x < width; x++ {
+		p := pix[x*bpp:]
+		i := 3 * (x/s - bounds.Min.X)
+		acc[i+0] += uint16(p[0])
+		acc[i+1] += uint16(p[1])
+		acc[i+2] += uint16(p[2])
+	}
+	if y%s != s-1 && y != height-1 {
+		return
+	}
+
+	// The last row and column of output pixels may cover fewer source
+	// pixels if the size of the image is not a multiple of the scale.
+	rows := y%s + 1
+	for x := 0; x < w; x++ {
+		cols := width - (bounds.Min.X+x)*s
+		if cols > s {
+			cols = s
+		}
+		n := uint16(rows * cols)
+		r := (acc[3*x+0] + n/2) / n
+		g := (acc[3*x+1] + n/2) / n
+		b := (acc[3*x+2] + n/2) / n
+		callbackBuf[x] = rgb565(uint8(r), uint8(g), uint8(b))
+	}
+	callback(callbackBuf[:w], 0, int16(sy-bounds.Min.Y), int16(w), 1, int16(w), int16(bounds.Dy()))
+}
+
+// rgb565 converts 8-bit RGB samples to the RGB565 format of the callback.
+func rgb565(r, g, b uint8) uint16 {
+	return uint16(r&0xF8)<<8 | uint16(g&0xFC)<<3 | uint16(b)>>3
+}
@@ -0,0 +1,101 @@
+package png
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func testImage(w, h int, alpha uint8) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 5), uint8(x ^ y), alpha})
+		}
+	}
+	return m
+}
+
+// expectedScaled box filters m by denom and crops the result to r, the same
+// way Decode is expected to.
+func expectedScaled(m *image.NRGBA, denom int, r image.Rectangle) []uint16 {
+	b := m.Bounds()
+	out := make([]uint16, 0, r.Dx()*r.Dy())
+	for sy := r.Min.Y; sy < r.Max.Y; sy++ {
+		for sx := r.Min.X; sx < r.Max.X; sx++ {
+			var sum [3]int
+			n := 0
+			for y := sy * denom; y < (sy+1)*denom && y < b.Max.Y; y++ {
+				for x := sx * denom; x < (sx+1)*denom && x < b.Max.X; x++ {
+					c := m.NRGBAAt(x, y)
+					sum[0] += int(c.R)
+					sum[1] += int(c.G)
+					sum[2] += int(c.B)
+					n++
+				}
+			}
+			out = append(out, rgb565(uint8((sum[0]+n/2)/n), uint8((sum[1]+n/2)/n), uint8((sum[2]+n/2)/n)))
+		}
+	}
+	return out
+}
+
+func TestDecodeScaled(t *testing.T) {
+	defer func() {
+		SetScale(1)
+		SetCrop(image.Rectangle{})
+	}()
+
+	tests := []struct {
+		alpha uint8
+		denom int
+		crop  image.Rectangle
+		want  image.Rectangle
+	}{
+		{0xff, 1, image.Rectangle{}, image.Rect(0, 0, 37, 29)},
+		{0xff, 2, image.Rectangle{}, image.Rect(0, 0, 19, 15)},
+		{0xff, 4, image.Rectangle{}, image.Rect(0, 0, 10, 8)},
+		{0xff, 8, image.Rectangle{}, image.Rect(0, 0, 5, 4)},
+		{0xff, 1, image.Rect(3, 4, 20, 10), image.Rect(3, 4, 20, 10)},
+		{0xff, 2, image.Rect(5, 6, 30, 30), image.Rect(5, 6, 19, 15)},
+		{0x80, 2, image.Rectangle{}, image.Rect(0, 0, 19, 15)},
+		{0x80, 4, image.Rect(1, 1, 3, 7), image.Rect(1, 1, 3, 7)},
+	}
+	for _, tc := range tests {
+		m := testImage(37, 29, tc.alpha)
+		var b bytes.Buffer
+		if err := Encode(&b, m); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf [64]uint16
+		got := make([]uint16, tc.want.Dx()*tc.want.Dy())
+		rows := 0
+		SetCallback(buf[:], func(data []uint16, x, y, w, h, width, height int16) {
+			if int(width) != tc.want.Dx() || int(height) != tc.want.Dy() {
+				t.Fatalf("1/%d %v: got size %dx%d, want %dx%d", tc.denom, tc.crop, width, height, tc.want.Dx(), tc.want.Dy())
+			}
+			copy(got[int(y)*int(width)+int(x):], data[:w*h])
+			rows++
+		})
+		if err := SetScale(tc.denom); err != nil {
+			t.Fatal(err)
+		}
+		SetCrop(tc.crop)
+		if _, err := Decode(&b); err != nil {
+			t.Fatal(err)
+		}
+
+		if rows != tc.want.Dy() {
+			t.Errorf("1/%d %v: got %d rows, want %d", tc.denom, tc.crop, rows, tc.want.Dy())
+		}
+		want := expectedScaled(m, tc.denom, tc.want)
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("1/%d %v: pixel %d = %#04x, want %#04x", tc.denom, tc.crop, i, got[i], want[i])
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Package rle implements the PackBits run-length encoding, which is used to
+// store images with large areas of the same color in less flash memory.
+//
+// The encoded data is a sequence of runs, each starting with a header byte n:
+//
+//	0 to 127:    the next n+1 bytes are copied literally
+//	129 to 255:  the next byte is repeated 257-n times
+//	128:         no operation
+package rle
+
+import "errors"
+
+var (
+	errShortBuffer = errors.New("rle: destination buffer too small")
+	errTruncated   = errors.New("rle: truncated input")
+)
+
+// Decode decompresses src into dst and returns the number of bytes written.
+// src can be a string, so that constant data can be decoded without copying it
+// to RAM first.
+func Decode[S ~string | ~[]byte](dst []byte, src S) (int, error) {
+	n := 0
+	for i := 0; i < len(src); {
+		h := int(src[i])
+		i++
+		switch {
+		case h < 128:
+			count := h + 1
+			if i+count > len(src) {
+				return n, errTruncated
+			}
+			if n+count > len(dst) {
+				return n, errShortBuffer
+			}
+			for j := 0; j < count; j++ {
+				dst[n+j] = src[i+j]
+			}
+			i += count
+			n += count
+		case h > 128:
+			count := 257 - h
+			if i >= len(src) {
+				return n, errTruncated
+			}
+			if n+count > len(dst) {
+				return n, errShortBuffer
+			}
+			b := src[i]
+			i++
+			for j := 0; j < count; j++ {
+				dst[n+j] = b
+			}
+			n += count
+		}
+	}
+	return n, nil
+}
+
+// Encode appends the compressed form of src to dst and returns the result.
+func Encode(dst, src []byte) []byte {
+	for i := 0; i < len(src); {
+		// Length of the run of equal bytes at i.
+		run := 1
+		for i+run < len(src) && run < 128 && src[i+run] == src[i] {
+			run++
+		}
+		if run >= 2 {
+			dst = append(dst, byte(257-run), src[i])
+			i += run
+			continue
+		}
+
+		// Collect literal bytes up to the next run of at least 3 bytes, as a
+		// run of 2 costs as much as including it in the literal.
+		start := i
+		for i < len(src) && i-start < 128 {
+			if i+2 < len(src) && src[i] == src[i+1] && src[i] == src[i+2] {
+				break
+			}
+			i++
+		}
+		dst = append(dst, byte(i-start-1))
+		dst = append(dst, src[start:i]...)
+	}
+	return dst
+}
@@ -0,0 +1,46 @@
+package rle
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestRoundtrip(t *testing.T) {
+	long := bytes.Repeat([]byte{0xaa}, 1000)
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(random)
+	mixed := append(append(append([]byte{1, 2, 3}, long[:300]...), random[:500]...), 4, 4)
+
+	for _, src := range [][]byte{nil, {0}, {1, 1}, {1, 2}, long, random, mixed} {
+		enc := Encode(nil, src)
+		dst := make([]byte, len(src))
+		n, err := Decode(dst, string(enc))
+		if err != nil {
+			t.Fatalf("len %d: %v", len(src), err)
+		}
+		if n != len(src) || !bytes.Equal(dst, src) {
+			t.Fatalf("len %d: roundtrip mismatch", len(src))
+		}
+	}
+
+	if n := len(Encode(nil, long)); n != 16 {
+		t.Errorf("expected 1000 equal bytes to encode in 16 bytes, got %d", n)
+	}
+	if n := len(Encode(nil, random)); n > len(random)+len(random)/128+1 {
+		t.Errorf("random data expanded too much: %d bytes", n)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	dst := make([]byte, 4)
+	if _, err := Decode(dst, []byte{0xfb, 1}); err != errShortBuffer {
+		t.Errorf("expected %v, got %v", errShortBuffer, err)
+	}
+	if _, err := Decode(dst, []byte{2, 1}); err != errTruncated {
+		t.Errorf("expected %v, got %v", errTruncated, err)
+	}
+	if _, err := Decode(dst, []byte{0xff}); err != errTruncated {
+		t.Errorf("expected %v, got %v", errTruncated, err)
+	}
+}
@@ -0,0 +1,67 @@
+package lora
+
+import "time"
+
+// Bandwidths in Hz, indexed by the Bandwidth constants
+var bandwidthHz = [...]uint32{
+	7800, 10400, 15600, 20800, 31250, 41700, 62500, 125000,
+	203125, 250000, 406250, 500000, 812500, 1625000,
+}
+
+// BandwidthHz returns the bandwidth in Hz, 0 if it is unknown.
+func BandwidthHz(bw uint8) uint32 {
+	if int(bw) >= len(bandwidthHz) {
+		return 0
+	}
+	return bandwidthHz[bw]
+}
+
+// LowDataRateOptimize returns if the low data rate optimization is
+// recommended for the modulation, with symbols of 16 ms or more.
+func LowDataRateOptimize(sf uint8, bw uint8) uint8 {
+	hz := BandwidthHz(bw)
+	if hz == 0 || (uint32(1)<<sf)*1000/hz < 16 {
+		return LowDataRateOptimizeOff
+	}
+	return LowDataRateOptimizeOn
+}
+
+// TimeOnAir returns the time on air of a packet of payloadLen bytes sent
+// with the configuration.
+func TimeOnAir(cnf Config, payloadLen int) time.Duration {
+	hz := BandwidthHz(cnf.Bw)
+	if hz == 0 || cnf.Sf < SpreadingFactor5 || cnf.Sf > SpreadingFactor12 {
+		return 0
+	}
+	sf := int(cnf.Sf)
+
+	// Preamble and sync word, in quarters of symbols: 4.25 symbols, or
+	// 6.25 for SF5 and SF6
+	quarters := 4*int(cnf.Preamble) + 17
+	if sf < SpreadingFactor7 {
+		quarters += 8
+	}
+
+	// Payload, the header and CRC included
+	bits := 8*payloadLen - 4*sf
+	if sf >= SpreadingFactor7 {
+		bits += 8
+	}
+	if cnf.Crc == CRCOn {
+		bits += 16
+	}
+	if cnf.HeaderType == HeaderExplicit {
+		bits += 20
+	}
+	bitsPerSymbol := 4 * sf
+	if cnf.Ldr == LowDataRateOptimizeOn {
+		bitsPerSymbol = 4 * (sf - 2)
+	}
+	symbols := 8
+	if bits > 0 {
+		symbols += (bits + bitsPerSymbol - 1) / bitsPerSymbol * (int(cnf.Cr) + 4)
+	}
+	quarters += 4 * symbols
+
+	return time.Duration(int64(quarters) * int64(time.Second) * int64(1<<sf) / int64(hz) / 4)
+}
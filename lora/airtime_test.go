@@ -0,0 +1,40 @@
+package lora
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeOnAir(t *testing.T) {
+	for _, tt := range []struct {
+		sf, bw, ldr uint8
+		payloadLen  int
+		want        time.Duration
+	}{
+		{SpreadingFactor7, Bandwidth_125_0, LowDataRateOptimizeOff, 10, 41216 * time.Microsecond},
+		{SpreadingFactor12, Bandwidth_125_0, LowDataRateOptimizeOn, 13, 1155072 * time.Microsecond},
+		{SpreadingFactor8, Bandwidth_500_0, LowDataRateOptimizeOff, 0, 12928 * time.Microsecond},
+		{SpreadingFactor5, Bandwidth_125_0, LowDataRateOptimizeOff, 16, 15936 * time.Microsecond},
+	} {
+		cnf := Config{
+			Sf:         tt.sf,
+			Bw:         tt.bw,
+			Cr:         CodingRate4_5,
+			Ldr:        tt.ldr,
+			Preamble:   8,
+			HeaderType: HeaderExplicit,
+			Crc:        CRCOn,
+		}
+		if got := TimeOnAir(cnf, tt.payloadLen); got != tt.want {
+			t.Errorf("SF%d BW%d %d bytes: time on air %s, expected %s", tt.sf, BandwidthHz(tt.bw), tt.payloadLen, got, tt.want)
+		}
+	}
+}
+
+func TestLowDataRateOptimize(t *testing.T) {
+	if LowDataRateOptimize(SpreadingFactor11, Bandwidth_125_0) != LowDataRateOptimizeOn ||
+		LowDataRateOptimize(SpreadingFactor10, Bandwidth_125_0) != LowDataRateOptimizeOff ||
+		LowDataRateOptimize(SpreadingFactor12, Bandwidth_500_0) != LowDataRateOptimizeOff {
+		t.Error("unexpected low data rate optimization")
+	}
+}
@@ -82,11 +82,40 @@ const (
 )
 
 const (
-	MHz_868_1 = 868100000
-	MHz_868_5 = 868500000
-	MHz_902_3 = 902300000
-	Mhz_903_0 = 903000000
-	MHZ_915_0 = 915000000
-	MHz_916_8 = 916800000
-	MHz_923_3 = 923300000
+	MHz_433_175  = 433175000
+	MHz_433_375  = 433375000
+	MHz_433_575  = 433575000
+	MHz_434_665  = 434665000
+	MHz_470_3    = 470300000
+	MHz_500_3    = 500300000
+	MHz_505_3    = 505300000
+	MHz_510_0    = 510000000
+	MHz_863_0    = 863000000
+	MHz_865_0    = 865000000
+	MHz_865_0625 = 865062500
+	MHz_865_4025 = 865402500
+	MHz_865_985  = 865985000
+	MHz_866_55   = 866550000
+	MHz_867_0    = 867000000
+	MHz_868_1    = 868100000
+	MHz_868_3    = 868300000
+	MHz_868_5    = 868500000
+	MHz_869_5    = 869525000
+	MHz_870_0    = 870000000
+	MHz_902_0    = 902000000
+	MHz_902_3    = 902300000
+	Mhz_903_0    = 903000000
+	MHZ_915_0    = 915000000
+	MHz_915_2    = 915200000
+	MHz_915_9    = 915900000
+	MHz_916_8    = 916800000
+	MHz_920_9    = 920900000
+	MHz_921_9    = 921900000
+	MHz_922_1    = 922100000
+	MHz_922_3    = 922300000
+	MHz_922_5    = 922500000
+	MHz_923_2    = 923200000
+	MHz_923_3    = 923300000
+	MHz_923_4    = 923400000
+	MHz_928_0    = 928000000
 )
@@ -0,0 +1,28 @@
+package lorawan
+
+// ActivateABP activates the session by personalization: the DevAddr and the
+// session keys are provisioned in the network server, and no join is needed.
+// The frame counters start from zero, a SessionStore keeps them across
+// reboots.
+func ActivateABP(session *Session, devAddr []uint8, nwkSKey []uint8, appSKey []uint8) error {
+	if err := session.SetDevAddr(devAddr); err != nil {
+		return err
+	}
+	if err := session.SetNwkSKey(nwkSKey); err != nil {
+		return err
+	}
+	if err := session.SetAppSKey(appSKey); err != nil {
+		return err
+	}
+
+	// Default receive windows
+	session.RXDelay = 0
+	session.DLSettings = 0
+	session.reset()
+	if regionSettings != nil {
+		_, session.DLSettings = regionSettings.RX2Default()
+		session.applyRX2()
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package lorawan
 
 import (
 	"errors"
+	"time"
 
 	"tinygo.org/x/drivers/lora"
 	"tinygo.org/x/drivers/lora/lorawan/region"
@@ -12,6 +13,7 @@ var (
 	ErrNoRadioAttached         = errors.New("no LoRa radio attached")
 	ErrInvalidEuiLength        = errors.New("invalid EUI length")
 	ErrInvalidAppKeyLength     = errors.New("invalid AppKey length")
+	ErrInvalidNwkKeyLength     = errors.New("invalid NwkKey length")
 	ErrInvalidPacketLength     = errors.New("invalid packet length")
 	ErrInvalidDevAddrLength    = errors.New("invalid DevAddr length")
 	ErrInvalidMic              = errors.New("invalid Mic")
@@ -20,22 +22,90 @@ var (
 	ErrInvalidNwkSKeyLength    = errors.New("invalid NwkSKey length")
 	ErrInvalidAppSKeyLength    = errors.New("invalid AppSKey length")
 	ErrUndefinedRegionSettings = errors.New("undefined Regionnal Settings ")
+	ErrNoDownlinkReceived      = errors.New("no downlink packet received")
+	ErrNoUplinkSent            = errors.New("no uplink sent before listening")
+	ErrUnexpectedMessageType   = errors.New("unexpected message type")
+	ErrInvalidDevAddr          = errors.New("invalid DevAddr")
+	ErrInvalidFOpts            = errors.New("invalid FOpts")
+	ErrInvalidMACCommand       = errors.New("invalid MAC command")
+	ErrNoAck                   = errors.New("no ACK received")
+	ErrNoSessionStored         = errors.New("no session stored")
+	ErrDutyCycle               = errors.New("duty cycle limit exceeded")
+	ErrDwellTime               = errors.New("dwell time limit exceeded")
+	ErrInvalidJoinNonce        = errors.New("invalid JoinNonce")
+	ErrInvalidRejoinType       = errors.New("invalid Rejoin type")
+	ErrLoRaWAN11Required       = errors.New("LoRaWAN 1.1 session required")
+	ErrInvalidDeviceClass      = errors.New("invalid device class")
+	ErrClassCNotSupported      = errors.New("radio does not support class C")
 )
 
 const (
 	LORA_TX_TIMEOUT = 2000
 	LORA_RX_TIMEOUT = 10000
+
+	// Receive windows timeout in ms, RX1 must be over when RX2 opens one
+	// second later.
+	LORA_RX_WINDOW_TIMEOUT = 900
+
+	// With ADR, the uplinks ask for a downlink after ADR_ACK_LIMIT uplinks
+	// without one, and step back to a more robust configuration every
+	// ADR_ACK_DELAY uplinks after that.
+	ADR_ACK_LIMIT = 64
+	ADR_ACK_DELAY = 32
 )
 
 var (
 	ActiveRadio    lora.Radio
 	Retries        = 15
 	regionSettings region.Settings
+	sessionStore   *SessionStore
+
+	// ConfirmedTransmissions is the number of transmissions of a confirmed
+	// uplink before giving up.
+	ConfirmedTransmissions = 8
+
+	// DutyCycleWait is the longest delay of a transmission waiting for the
+	// duty cycle limits, beyond which it is refused with ErrDutyCycle.
+	DutyCycleWait = time.Minute
+	dutyCycle     *region.DutyCycle
+
+	downlinkHandler func(dl *Downlink)
+
+	// end and channel of the last uplink, to open the receive windows
+	uplinkEnd     time.Time
+	uplinkChannel region.Channel
+
+	// clock of the receive windows, replaced in tests
+	now   = time.Now
+	sleep = time.Sleep
 )
 
+// Clock is the time source of the receive windows and of the duty cycle
+// limits.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// UseClock sets the clock of the stack, such as the virtual clock of a
+// simulation, nil restoring the system clock.
+func UseClock(c Clock) {
+	if c == nil {
+		now = time.Now
+		sleep = time.Sleep
+		return
+	}
+	now = c.Now
+	sleep = c.Sleep
+}
+
 // UseRegionSettings sets current Lorawan Regional parameters
 func UseRegionSettings(rs region.Settings) {
 	regionSettings = rs
+	dutyCycle = nil
+	if rs != nil {
+		dutyCycle = region.NewDutyCycle(rs.Bands())
+	}
 }
 
 // UseRadio attaches Lora radio driver to Lorawan
@@ -46,6 +116,12 @@ func UseRadio(r lora.Radio) {
 	ActiveRadio = r
 }
 
+// UseSessionStore sets the store of the session, written by Join and before
+// the uplinks when the reserved frame counters are used up.
+func UseSessionStore(ss *SessionStore) {
+	sessionStore = ss
+}
+
 // SetPublicNetwork defines Lora Sync Word according to network type (public/private)
 func SetPublicNetwork(enabled bool) {
 	ActiveRadio.SetPublicNetwork(enabled)
@@ -76,25 +152,40 @@ func Join(otaa *Otaa, session *Session) error {
 		return ErrUndefinedRegionSettings
 	}
 
+	// The class C reception restarts with the new session
+	stopClassC()
+
 	otaa.Init()
+	if sessionStore != nil {
+		sessionStore.useOtaa(otaa)
+	}
 
 	// Send join packet
 	payload, err := otaa.GenerateJoinRequest()
 	if err != nil {
 		return err
 	}
+	if sessionStore != nil {
+		if err := sessionStore.saveDevNonce(otaa); err != nil {
+			return err
+		}
+	}
 
 	for {
 		joinRequestChannel := regionSettings.JoinRequestChannel()
 		joinAcceptChannel := regionSettings.JoinAcceptChannel()
 
 		// Prepare radio for Join Tx
+		if err := waitDutyCycle(joinRequestChannel); err != nil {
+			return err
+		}
 		applyChannelConfig(joinRequestChannel)
 		ActiveRadio.SetIqMode(lora.IQStandard)
-		ActiveRadio.Tx(payload, LORA_TX_TIMEOUT)
+		err = ActiveRadio.Tx(payload, LORA_TX_TIMEOUT)
 		if err != nil {
 			return err
 		}
+		dutyCycle.Transmitted(joinRequestChannel.Frequency(), now(), airtime(joinRequestChannel, len(payload)))
 
 		// Wait for JoinAccept
 		if joinAcceptChannel.Frequency() != 0 {
@@ -115,30 +206,258 @@ func Join(otaa *Otaa, session *Session) error {
 		return err
 	}
 
-	return nil
+	// RX2 data rate of the network, on the default frequency
+	session.applyRX2()
+
+	if sessionStore != nil {
+		sessionStore.setOtaa(otaa)
+		if err := sessionStore.Save(session); err != nil {
+			return err
+		}
+	}
+	return startClassC(session)
+}
+
+// SetDownlinkHandler sets the function receiving the application downlinks,
+// the ones with a non-zero FPort.
+func SetDownlinkHandler(h func(dl *Downlink)) {
+	downlinkHandler = h
 }
 
-// SendUplink sends Lorawan Uplink message
+// SendUplink sends Lorawan Uplink message, and listens for a downlink in the
+// RX1 and RX2 receive windows following it. The message is transmitted up to
+// NbTrans times, as set by the network server, until a downlink is received.
 func SendUplink(data []uint8, session *Session) error {
 
 	if regionSettings == nil {
 		return ErrUndefinedRegionSettings
 	}
+	if len(data) > int(regionSettings.MaxPayload()) {
+		return ErrFrmPayloadTooLarge
+	}
+
+	// In class C, the reception stops during the transmission and its
+	// receive windows
+	stopClassC()
+	defer startClassC(session)
+
+	if sessionStore != nil {
+		if err := sessionStore.reserveFCnt(session); err != nil {
+			return err
+		}
+	}
 
+	adrBackoff(session)
+	// The repetitions are the same message, with the same FCnt
 	payload, err := session.GenMessage(0, []byte(data))
 	if err != nil {
 		return err
 	}
 
-	applyChannelConfig(regionSettings.UplinkChannel())
-	ActiveRadio.SetIqMode(lora.IQStandard)
-	ActiveRadio.Tx(payload, LORA_TX_TIMEOUT)
+	for i := max(session.NbTrans, 1); i > 0; i-- {
+		dl, err := transmit(payload, session)
+		if err != nil && err != ErrNoDownlinkReceived {
+			return err
+		}
+		if dl != nil {
+			// Received by the network
+			return nil
+		}
+	}
+	return nil
+}
+
+// SendConfirmedUplink sends a confirmed Lorawan Uplink message, and waits for
+// its acknowledgement in the receive windows. It is retransmitted up to
+// ConfirmedTransmissions times, lowering the data rate every two
+// transmissions while the payload fits, before giving up with ErrNoAck.
+// The data rate set before is restored after the exchange, unless ADR is on:
+// the lowered one is kept then, until the network adjusts it.
+func SendConfirmedUplink(data []uint8, session *Session) error {
+
+	if regionSettings == nil {
+		return ErrUndefinedRegionSettings
+	}
+	if len(data) > int(regionSettings.MaxPayload()) {
+		return ErrFrmPayloadTooLarge
+	}
+
+	// In class C, the reception stops during the transmission and its
+	// receive windows
+	stopClassC()
+	defer startClassC(session)
+
+	if sessionStore != nil {
+		if err := sessionStore.reserveFCnt(session); err != nil {
+			return err
+		}
+	}
+
+	adrBackoff(session)
+	// The retransmissions are the same message, with the same FCnt
+	payload, err := session.GenConfirmedMessage(data)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	if !session.ADR {
+		defer regionSettings.SetDataRate(regionSettings.DataRate())
+	}
+	for i := 0; i < ConfirmedTransmissions; i++ {
+		if i > 0 {
+			sleep(ackTimeout())
+			if i%2 == 0 {
+				lowerDataRate(len(data))
+			}
+		}
+
+		dl, err := transmit(payload, session)
+		if err != nil && err != ErrNoDownlinkReceived {
+			return err
+		}
+		if dl != nil && dl.ACK {
+			return nil
+		}
+	}
+	return ErrNoAck
 }
 
-func ListenDownlink() error {
-	return nil
+// lowerDataRate steps the uplink data rate down, unless the payload doesn't
+// fit at the lower one.
+func lowerDataRate(payloadLen int) {
+	dr := regionSettings.DataRate()
+	if dr == 0 || !regionSettings.SetDataRate(dr-1) {
+		return
+	}
+	if payloadLen > int(regionSettings.MaxPayload()) {
+		regionSettings.SetDataRate(dr)
+	}
+}
+
+// adrBackoff steps back to a more robust uplink configuration every
+// ADR_ACK_DELAY uplinks, once ADR_ACK_LIMIT uplinks have been sent without
+// a downlink.
+func adrBackoff(session *Session) {
+	if !session.ADR || session.adrAckCnt < ADR_ACK_LIMIT+ADR_ACK_DELAY {
+		return
+	}
+	if (session.adrAckCnt-ADR_ACK_LIMIT)%ADR_ACK_DELAY == 0 {
+		regionSettings.ADRBackoff()
+	}
+}
+
+// ackTimeout returns the random delay, from 1 to 3 seconds, before the
+// retransmission of an unacknowledged uplink.
+func ackTimeout() time.Duration {
+	rnd, _ := GetRand16()
+	ms := (uint32(rnd[0])<<8 | uint32(rnd[1])) % 2000
+	return time.Second + time.Duration(ms)*time.Millisecond
+}
+
+// transmit sends the uplink message on the next uplink channel, and listens
+// for a downlink in the receive windows.
+func transmit(payload []uint8, session *Session) (*Downlink, error) {
+	dutyCycle.SetAggregated(1 << session.MaxDutyCycle)
+	uplink, err := nextUplinkChannel()
+	if err != nil {
+		return nil, err
+	}
+	session.signUplink(payload, regionSettings.DataRate(), regionSettings.UplinkChannelIndex())
+	toa := airtime(uplink, len(payload))
+	if dwell := regionSettings.DwellTime(); dwell != 0 && toa > dwell {
+		return nil, ErrDwellTime
+	}
+
+	applyChannelConfig(uplink)
+	ActiveRadio.SetIqMode(lora.IQStandard)
+	err = ActiveRadio.Tx(payload, LORA_TX_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	uplinkEnd = now()
+	uplinkChannel = uplink
+	dutyCycle.Transmitted(uplink.Frequency(), uplinkEnd, toa)
+
+	return ListenDownlink(session)
+}
+
+// ListenDownlink opens the RX1 and RX2 receive windows following the last
+// uplink, and returns the downlink received for the session. The application
+// downlinks are also passed to the downlink handler.
+func ListenDownlink(session *Session) (*Downlink, error) {
+	if ActiveRadio == nil {
+		return nil, ErrNoRadioAttached
+	}
+
+	if regionSettings == nil {
+		return nil, ErrUndefinedRegionSettings
+	}
+
+	if uplinkChannel == nil {
+		return nil, ErrNoUplinkSent
+	}
+
+	// RX1 opens RXDelay seconds after the uplink, RX2 one second later
+	delay := time.Duration(session.RXDelay&0x0F) * time.Second
+	if delay == 0 {
+		delay = time.Second
+	}
+	windows := [2]struct {
+		open    time.Time
+		channel region.Channel
+	}{
+		{uplinkEnd.Add(delay), regionSettings.RX1Channel(uplinkChannel, session.rx1DROffset())},
+		{uplinkEnd.Add(delay + time.Second), regionSettings.RX2Channel()},
+	}
+	// Each uplink is answered once
+	uplinkChannel = nil
+
+	for _, w := range windows {
+		wait := w.open.Sub(now())
+		if wait < -LORA_RX_WINDOW_TIMEOUT*time.Millisecond {
+			// Too late for this window
+			continue
+		}
+		if wait > 0 {
+			sleep(wait)
+		}
+
+		applyChannelConfig(w.channel)
+		ActiveRadio.SetIqMode(lora.IQInverted)
+		resp, err := ActiveRadio.Rx(LORA_RX_WINDOW_TIMEOUT)
+		if err != nil || resp == nil {
+			continue
+		}
+
+		// Ignore the frames of other devices
+		dl, err := session.DecodeDownlink(resp)
+		if err != nil {
+			continue
+		}
+
+		handleDownlink(session, dl)
+		return dl, nil
+	}
+
+	return nil, ErrNoDownlinkReceived
+}
+
+// handleDownlink applies the MAC commands of the downlink, and passes the
+// application ones to the downlink handler.
+func handleDownlink(session *Session, dl *Downlink) {
+	handleDownlinkCommands(session, dl)
+	if dl.FPort != 0 && downlinkHandler != nil {
+		downlinkHandler(dl)
+	}
+}
+
+// handleDownlinkCommands applies the MAC commands of the downlink.
+func handleDownlinkCommands(session *Session, dl *Downlink) {
+	// The MAC commands are in the FRMPayload of port 0, or in the FOpts of
+	// the frames without one
+	if dl.FPort == 0 && len(dl.Payload) > 0 {
+		handleMACCommands(session, dl.Payload)
+	} else {
+		handleMACCommands(session, dl.FOpts)
+	}
 }
@@ -0,0 +1,138 @@
+package lorawan
+
+import (
+	"tinygo.org/x/drivers/lora"
+)
+
+// Device classes
+const (
+	ClassA = iota
+	ClassC
+)
+
+var (
+	deviceClass uint8 = ClassA
+
+	// channels stopping the goroutine receiving in class C, and telling it
+	// is over
+	classCStop chan struct{}
+	classCDone chan struct{}
+
+	// application downlinks received in class C, passed to the downlink
+	// handler by their own goroutine so that the handler can send uplinks
+	classCDownlinks chan *Downlink
+)
+
+// Application downlinks received in class C and waiting for the downlink
+// handler, the next ones being dropped.
+const classCQueueLen = 4
+
+// SetDeviceClass selects the class of the device, ClassA by default.
+//
+// In class C, the radio receives continuously on the RX2 channel between the
+// uplinks, for the session of the last Join or uplink, or the one given. The
+// downlinks received are handled as the ones of the receive windows, the
+// application ones being passed to the downlink handler from a background
+// goroutine, where it can send uplinks. The radio must be a
+// lora.ContinuousReceiver.
+func SetDeviceClass(class uint8, session *Session) error {
+	if class != ClassA && class != ClassC {
+		return ErrInvalidDeviceClass
+	}
+	if class == ClassC {
+		if _, ok := ActiveRadio.(lora.ContinuousReceiver); !ok {
+			return ErrClassCNotSupported
+		}
+		if regionSettings == nil {
+			return ErrUndefinedRegionSettings
+		}
+	}
+
+	stopClassC()
+	deviceClass = class
+	return startClassC(session)
+}
+
+// startClassC starts receiving continuously for the session in class C.
+func startClassC(session *Session) error {
+	if deviceClass != ClassC || session == nil || classCStop != nil {
+		return nil
+	}
+	radio := ActiveRadio.(lora.ContinuousReceiver)
+
+	applyChannelConfig(regionSettings.RX2Channel())
+	radio.SetIqMode(lora.IQInverted)
+	if err := radio.RxContinuous(); err != nil {
+		return err
+	}
+
+	if classCDownlinks == nil {
+		classCDownlinks = make(chan *Downlink, classCQueueLen)
+		go dispatchClassC(classCDownlinks)
+	}
+	classCStop = make(chan struct{})
+	classCDone = make(chan struct{})
+	go receiveClassC(radio, session, classCStop, classCDone)
+	return nil
+}
+
+// stopClassC stops the reception of class C, before a transmission or a
+// change of the session.
+func stopClassC() {
+	if classCStop == nil {
+		return
+	}
+	close(classCStop)
+	<-classCDone
+	classCStop = nil
+	classCDone = nil
+}
+
+// receiveClassC handles the packets received in class C until stop is
+// closed. The radio is then put in standby, and its pending events dropped so
+// that they are not taken for the ones of the next transmission.
+func receiveClassC(radio lora.ContinuousReceiver, session *Session, stop, done chan struct{}) {
+	defer close(done)
+	events := radio.GetRadioEventChan()
+	for {
+		select {
+		case <-stop:
+			radio.SetStandby()
+			for {
+				select {
+				case <-events:
+				default:
+					return
+				}
+			}
+		case ev := <-events:
+			if ev.EventType != lora.RadioEventRxDone {
+				continue
+			}
+			// Ignore the frames of other devices
+			dl, err := session.DecodeDownlink(radio.ReadRxPacket())
+			if err != nil {
+				continue
+			}
+			handleDownlinkCommands(session, dl)
+			if dl.FPort == 0 || downlinkHandler == nil {
+				continue
+			}
+			select {
+			case classCDownlinks <- dl:
+			default:
+			}
+		}
+	}
+}
+
+// dispatchClassC passes the application downlinks received in class C to
+// the downlink handler. The handler may then stop the reception to send an
+// uplink, which the goroutine receiving can't do while calling it.
+func dispatchClassC(downlinks chan *Downlink) {
+	for dl := range downlinks {
+		if h := downlinkHandler; h != nil {
+			h(dl)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package lorawan
+
+import (
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/lora"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+// continuousRadio is a radio receiving in the background the packets sent in
+// its event channel.
+type continuousRadio struct {
+	ackRadio
+	events      chan lora.RadioEvent
+	packet      []uint8
+	continuous  []uint32 // frequency of each continuous reception started
+	receiving   bool
+	standbyRuns int
+}
+
+func (r *continuousRadio) GetRadioEventChan() chan lora.RadioEvent { return r.events }
+func (r *continuousRadio) ReadRxPacket() []uint8                   { return r.packet }
+
+func (r *continuousRadio) RxContinuous() error {
+	r.continuous = append(r.continuous, r.freq)
+	r.receiving = true
+	return nil
+}
+
+func (r *continuousRadio) SetStandby() {
+	r.receiving = false
+	r.standbyRuns++
+}
+
+func (r *continuousRadio) Tx(pkt []uint8, timeoutMs uint32) error {
+	if r.receiving {
+		panic("transmitting while receiving")
+	}
+	return r.ackRadio.Tx(pkt, timeoutMs)
+}
+
+func TestClassC(t *testing.T) {
+	var clock time.Time
+	now = func() time.Time { return clock }
+	sleep = func(d time.Duration) { clock = clock.Add(d) }
+	defer func() {
+		now = time.Now
+		sleep = time.Sleep
+		ActiveRadio = nil
+		UseRegionSettings(nil)
+		SetDownlinkHandler(nil)
+		SetDeviceClass(ClassA, nil)
+	}()
+	UseRegionSettings(region.EU868())
+
+	s := testSession()
+	ActiveRadio = &windowRadio{}
+	if err := SetDeviceClass(ClassC, s); err != ErrClassCNotSupported {
+		t.Errorf("expected ErrClassCNotSupported, got %v", err)
+	}
+
+	radio := &continuousRadio{ackRadio: ackRadio{session: s, ackFrom: 100}, events: make(chan lora.RadioEvent, 1)}
+	ActiveRadio = radio
+	received := make(chan *Downlink, 1)
+	SetDownlinkHandler(func(dl *Downlink) { received <- dl })
+
+	if err := SetDeviceClass(ClassC, s); err != nil {
+		t.Fatal(err)
+	}
+	if len(radio.continuous) != 1 || radio.continuous[0] != lora.MHz_869_5 || radio.iq != lora.IQInverted {
+		t.Fatalf("unexpected RX2 reception %v, iq %d", radio.continuous, radio.iq)
+	}
+
+	// a downlink received between the uplinks
+	radio.packet = encodeDownlink(s, mTypeUnconfirmedDataDown, 0, 0, nil, 3, []uint8("open"))
+	radio.events <- lora.NewRadioEvent(lora.RadioEventRxDone, 0, nil)
+	select {
+	case dl := <-received:
+		if dl.FPort != 3 || string(dl.Payload) != "open" {
+			t.Errorf("unexpected downlink %+v", dl)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no downlink received in class C")
+	}
+
+	// the reception stops during the uplink, and starts again after it
+	if err := SendUplink([]uint8("x"), s); err != nil {
+		t.Fatal(err)
+	}
+	if len(radio.sent) != 1 || radio.standbyRuns != 1 || len(radio.continuous) != 2 || !radio.receiving {
+		t.Errorf("unexpected class C reception around the uplink: %d standby, %d receptions", radio.standbyRuns, len(radio.continuous))
+	}
+
+	if err := SetDeviceClass(ClassA, nil); err != nil || radio.receiving {
+		t.Errorf("class C reception not stopped: %v", err)
+	}
+}
+
+func TestClassCHandlerUplink(t *testing.T) {
+	var clock time.Time
+	now = func() time.Time { return clock }
+	sleep = func(d time.Duration) { clock = clock.Add(d) }
+	defer func() {
+		now = time.Now
+		sleep = time.Sleep
+		ActiveRadio = nil
+		UseRegionSettings(nil)
+		SetDownlinkHandler(nil)
+		SetDeviceClass(ClassA, nil)
+	}()
+	UseRegionSettings(region.EU868())
+
+	s := testSession()
+	radio := &continuousRadio{ackRadio: ackRadio{session: s, ackFrom: 100}, events: make(chan lora.RadioEvent, 1)}
+	ActiveRadio = radio
+
+	// the handler answers the command with an uplink
+	answered := make(chan error, 1)
+	SetDownlinkHandler(func(dl *Downlink) { answered <- SendUplink([]uint8("done"), s) })
+	if err := SetDeviceClass(ClassC, s); err != nil {
+		t.Fatal(err)
+	}
+
+	radio.packet = encodeDownlink(s, mTypeUnconfirmedDataDown, 0, 0, nil, 3, []uint8("open"))
+	radio.events <- lora.NewRadioEvent(lora.RadioEventRxDone, 0, nil)
+	select {
+	case err := <-answered:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("uplink of the downlink handler not sent")
+	}
+	if len(radio.sent) != 1 || radio.standbyRuns != 1 || len(radio.continuous) != 2 || !radio.receiving {
+		t.Errorf("unexpected class C reception around the uplink: %d standby, %d receptions", radio.standbyRuns, len(radio.continuous))
+	}
+}
@@ -0,0 +1,139 @@
+package lorawan
+
+import (
+	"bytes"
+)
+
+// MHDR message types
+const (
+	mTypeUnconfirmedDataUp   = 0b010 << 5
+	mTypeConfirmedDataUp     = 0b100 << 5
+	mTypeUnconfirmedDataDown = 0b011 << 5
+	mTypeConfirmedDataDown   = 0b101 << 5
+	mTypeRejoinRequest       = 0b110 << 5
+	mTypeMask                = 0b111 << 5
+)
+
+// FCtrl bits of the data frames
+const (
+	fCtrlADR       = 0x80
+	fCtrlADRACKReq = 0x40
+	fCtrlACK       = 0x20
+	fCtrlFPending  = 0x10
+	fCtrlFOptsLen  = 0x0F
+)
+
+// Downlink is a data message received from the network server.
+type Downlink struct {
+	// FPort of the message, 0 if it only carries MAC commands.
+	FPort uint8
+
+	// Payload is the decrypted FRMPayload, the MAC commands on port 0.
+	Payload []uint8
+
+	// FOpts holds the MAC commands piggybacked in the frame header.
+	FOpts []uint8
+
+	// FCnt is the downlink frame counter of the message.
+	FCnt uint32
+
+	// Confirmed is set if the message must be acknowledged, the next uplink
+	// does it.
+	Confirmed bool
+
+	// ACK is set if the message acknowledges a confirmed uplink.
+	ACK bool
+
+	// FPending is set if the network server has more data to send.
+	FPending bool
+}
+
+// DecodeDownlink verifies and decrypts a downlink data message for the
+// session, and updates the session downlink frame counter, AFCntDown for the
+// application downlinks of LoRaWAN 1.1.
+func (s *Session) DecodeDownlink(phyPload []uint8) (*Downlink, error) {
+	// MHDR | DevAddr | FCtrl | FCnt | FOpts | FPort | FRMPayload | MIC
+	if len(phyPload) < 12 {
+		return nil, ErrInvalidPacketLength
+	}
+	mType := phyPload[0] & mTypeMask
+	if mType != mTypeUnconfirmedDataDown && mType != mTypeConfirmedDataDown {
+		return nil, ErrUnexpectedMessageType
+	}
+	if !bytes.Equal(phyPload[1:5], s.DevAddr[:]) {
+		return nil, ErrInvalidDevAddr
+	}
+
+	fCtrl := phyPload[5]
+	fOptsLen := int(fCtrl & fCtrlFOptsLen)
+	msg := phyPload[:len(phyPload)-4]
+	if len(msg) < 8+fOptsLen {
+		return nil, ErrInvalidPacketLength
+	}
+
+	// LoRaWAN 1.1 counts the downlinks of the applications apart from the
+	// MAC commands of port 0
+	aFCnt := s.LoRaWAN11 && len(msg) > 8+fOptsLen && msg[8+fOptsLen] != 0
+	counter := &s.FCntDown
+	if aFCnt {
+		counter = &s.AFCntDown
+	}
+
+	// Rebuild the 32 bits counter from its 16 low bits, the counter can't
+	// go backwards.
+	fCnt := *counter&^0xFFFF | uint32(phyPload[6]) | uint32(phyPload[7])<<8
+	if fCnt < *counter {
+		fCnt += 0x10000
+	}
+
+	var mic [4]uint8
+	if s.LoRaWAN11 {
+		var confFCnt uint16
+		if fCtrl&fCtrlACK != 0 {
+			confFCnt = s.confFCntUp
+		}
+		mic = s.downlinkMIC11(msg, fCnt, confFCnt)
+	} else {
+		mic = calcMessageMIC(msg, s.NwkSKey, 1, s.DevAddr[:], fCnt, uint8(len(msg)))
+	}
+	if !bytes.Equal(mic[:], phyPload[len(msg):]) {
+		return nil, ErrInvalidMic
+	}
+
+	dl := &Downlink{
+		FOpts:     append([]uint8(nil), msg[8:8+fOptsLen]...),
+		FCnt:      fCnt,
+		Confirmed: mType == mTypeConfirmedDataDown,
+		ACK:       fCtrl&fCtrlACK != 0,
+		FPending:  fCtrl&fCtrlFPending != 0,
+	}
+	if s.LoRaWAN11 && fOptsLen > 0 {
+		dl.FOpts = s.encryptFOpts(dl.FOpts, 1, fCnt, aFCnt)
+	}
+
+	if frm := msg[8+fOptsLen:]; len(frm) > 0 {
+		dl.FPort = frm[0]
+		key := s.AppSKey
+		if dl.FPort == 0 {
+			if fOptsLen > 0 {
+				// MAC commands can't be in both places
+				return nil, ErrInvalidFOpts
+			}
+			key = s.nwkSEncKey()
+		}
+		payload, err := s.genFRMPayload(key, 1, fCnt, frm[1:], false)
+		if err != nil {
+			return nil, err
+		}
+		dl.Payload = payload
+	}
+
+	*counter = fCnt + 1
+	s.ackDownlink = dl.Confirmed
+	if dl.Confirmed {
+		s.confFCntDown = uint16(fCnt)
+	}
+	s.adrAckCnt = 0
+	s.stickyCommands = nil
+	return dl, nil
+}
@@ -0,0 +1,264 @@
+package lorawan
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/lora"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+func testSession() *Session {
+	s := &Session{}
+	s.SetDevAddr([]uint8{0x01, 0x02, 0x03, 0x04})
+	s.SetNwkSKey(bytes.Repeat([]uint8{0x11}, 16))
+	s.SetAppSKey(bytes.Repeat([]uint8{0x22}, 16))
+	return s
+}
+
+// encodeDownlink builds a downlink data message as the network server does.
+func encodeDownlink(s *Session, mType, fCtrl uint8, fCnt uint32, fOpts []uint8, fPort uint8, payload []uint8) []uint8 {
+	buf := []uint8{mType}
+	buf = append(buf, s.DevAddr[:]...)
+	buf = append(buf, fCtrl|uint8(len(fOpts)), uint8(fCnt), uint8(fCnt>>8))
+	buf = append(buf, fOpts...)
+	if payload != nil {
+		key := s.AppSKey
+		if fPort == 0 {
+			key = s.NwkSKey
+		}
+		frm, _ := s.genFRMPayload(key, 1, fCnt, payload, false)
+		buf = append(buf, fPort)
+		buf = append(buf, frm...)
+	}
+	mic := calcMessageMIC(buf, s.NwkSKey, 1, s.DevAddr[:], fCnt, uint8(len(buf)))
+	return append(buf, mic[:]...)
+}
+
+func TestDecodeDownlink(t *testing.T) {
+	s := testSession()
+
+	dl, err := s.DecodeDownlink(encodeDownlink(s, mTypeConfirmedDataDown, fCtrlACK, 0, []uint8{0x02}, 10, []uint8("open")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.FPort != 10 || string(dl.Payload) != "open" || !bytes.Equal(dl.FOpts, []uint8{0x02}) ||
+		!dl.Confirmed || !dl.ACK || dl.FPending {
+		t.Errorf("unexpected downlink %+v", dl)
+	}
+	if s.FCntDown != 1 {
+		t.Errorf("unexpected FCntDown %d", s.FCntDown)
+	}
+
+	// the confirmed downlink is acknowledged once
+	up, _ := s.GenMessage(0, []uint8("x"))
+	if up[5]&fCtrlACK == 0 {
+		t.Error("uplink does not acknowledge the downlink")
+	}
+	up, _ = s.GenMessage(0, []uint8("x"))
+	if up[5]&fCtrlACK != 0 {
+		t.Error("uplink acknowledges again")
+	}
+
+	// replayed
+	if _, err := s.DecodeDownlink(encodeDownlink(s, mTypeUnconfirmedDataDown, 0, 0, nil, 10, []uint8("open"))); err != ErrInvalidMic {
+		t.Errorf("expected invalid MIC, got %v", err)
+	}
+
+	// MAC commands on port 0 are encrypted with the NwkSKey
+	dl, err = s.DecodeDownlink(encodeDownlink(s, mTypeUnconfirmedDataDown, fCtrlFPending, 5, nil, 0, []uint8{0x02, 0x03}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.FPort != 0 || !bytes.Equal(dl.Payload, []uint8{0x02, 0x03}) || !dl.FPending || dl.Confirmed {
+		t.Errorf("unexpected downlink %+v", dl)
+	}
+	if _, err := s.DecodeDownlink(encodeDownlink(s, mTypeUnconfirmedDataDown, 0, 6, []uint8{0x02}, 0, []uint8{0x02})); err != ErrInvalidFOpts {
+		t.Errorf("expected invalid FOpts, got %v", err)
+	}
+
+	// the 16 bits counter rolls over
+	s.FCntDown = 0x1FFFF
+	if dl, err := s.DecodeDownlink(encodeDownlink(s, mTypeUnconfirmedDataDown, 0, 0x20001, nil, 1, []uint8{1})); err != nil || dl.FCnt != 0x20001 {
+		t.Errorf("unexpected counter: %v", err)
+	}
+
+	// frames of other devices
+	other := testSession()
+	other.SetDevAddr([]uint8{0x05, 0x06, 0x07, 0x08})
+	if _, err := s.DecodeDownlink(encodeDownlink(other, mTypeUnconfirmedDataDown, 0, 0x20002, nil, 1, []uint8{1})); err != ErrInvalidDevAddr {
+		t.Errorf("expected invalid DevAddr, got %v", err)
+	}
+	up, _ = other.GenMessage(0, []uint8("x"))
+	if _, err := s.DecodeDownlink(up); err != ErrUnexpectedMessageType {
+		t.Errorf("expected unexpected message type, got %v", err)
+	}
+}
+
+// windowRadio is a radio answering in the receive windows with the queued
+// packets.
+type windowRadio struct {
+	lora.Radio
+	freq    uint32
+	sf      uint8
+	iq      uint8
+	windows []uint32 // frequency of each window opened
+	packets map[uint32][]uint8
+}
+
+func (r *windowRadio) SetFrequency(freq uint32)    { r.freq = freq }
+func (r *windowRadio) SetBandwidth(bw uint8)       {}
+func (r *windowRadio) SetCodingRate(cr uint8)      {}
+func (r *windowRadio) SetSpreadingFactor(sf uint8) { r.sf = sf }
+func (r *windowRadio) SetPreambleLength(pl uint16) {}
+func (r *windowRadio) SetTxPower(txpow int8)       {}
+func (r *windowRadio) SetHeaderType(ht uint8)      {}
+func (r *windowRadio) SetCrc(enable bool)          {}
+func (r *windowRadio) SetIqMode(mode uint8)        { r.iq = mode }
+
+func (r *windowRadio) Rx(timeoutMs uint32) ([]uint8, error) {
+	r.windows = append(r.windows, r.freq)
+	return r.packets[r.freq], nil
+}
+
+func TestListenDownlink(t *testing.T) {
+	radio := &windowRadio{packets: make(map[uint32][]uint8)}
+	ActiveRadio = radio
+	UseRegionSettings(region.EU868())
+	defer func() {
+		ActiveRadio = nil
+		UseRegionSettings(nil)
+		SetDownlinkHandler(nil)
+	}()
+
+	var received *Downlink
+	SetDownlinkHandler(func(dl *Downlink) { received = dl })
+
+	s := testSession()
+	s.DLSettings = 2 << 4 // RX1 two data rates lower
+
+	if _, err := ListenDownlink(s); err != ErrNoUplinkSent {
+		t.Errorf("expected no uplink sent, got %v", err)
+	}
+
+	// nothing received
+	uplinkChannel = regionSettings.UplinkChannel()
+	uplinkEnd = time.Now().Add(-1500 * time.Millisecond)
+	if _, err := ListenDownlink(s); err != ErrNoDownlinkReceived {
+		t.Errorf("expected no downlink, got %v", err)
+	}
+	if len(radio.windows) != 2 || radio.windows[0] != lora.MHz_868_1 || radio.windows[1] != lora.MHz_869_5 {
+		t.Errorf("unexpected windows %v", radio.windows)
+	}
+	if radio.iq != lora.IQInverted || radio.sf != lora.SpreadingFactor12 {
+		t.Errorf("unexpected RX2 modulation iq %d, sf %d", radio.iq, radio.sf)
+	}
+
+	// received in RX2, a foreign frame in RX1 is ignored
+	other := testSession()
+	other.SetDevAddr([]uint8{0x05, 0x06, 0x07, 0x08})
+	radio.windows = nil
+	radio.packets[lora.MHz_868_1] = encodeDownlink(other, mTypeUnconfirmedDataDown, 0, 0, nil, 1, []uint8("not mine"))
+	radio.packets[lora.MHz_869_5] = encodeDownlink(s, mTypeUnconfirmedDataDown, 0, 0, nil, 1, []uint8("mine"))
+	uplinkChannel = regionSettings.UplinkChannel()
+	uplinkEnd = time.Now().Add(-1500 * time.Millisecond)
+	dl, err := ListenDownlink(s)
+	if err != nil || string(dl.Payload) != "mine" || len(radio.windows) != 2 {
+		t.Fatalf("unexpected downlink %+v: %v", dl, err)
+	}
+	if received != dl {
+		t.Error("downlink not passed to the handler")
+	}
+
+	// RX1 is over
+	uplinkChannel = regionSettings.UplinkChannel()
+	uplinkEnd = time.Now().Add(-2 * time.Second)
+	radio.windows = nil
+	ListenDownlink(s)
+	if len(radio.windows) != 1 || radio.windows[0] != lora.MHz_869_5 {
+		t.Errorf("unexpected windows %v", radio.windows)
+	}
+}
+
+func TestRX1Channel(t *testing.T) {
+	eu := region.EU868()
+	if ch := eu.RX1Channel(eu.UplinkChannel(), 2); ch.Frequency() != lora.MHz_868_1 ||
+		ch.SpreadingFactor() != lora.SpreadingFactor11 {
+		t.Errorf("unexpected EU868 RX1 channel %d SF%d", ch.Frequency(), ch.SpreadingFactor())
+	}
+
+	us := region.US915()
+	up := us.JoinRequestChannel() // 902.3 MHz, channel 0
+	up.Next()                     // 902.5 MHz, channel 1
+	if ch := us.RX1Channel(up, 0); ch.Frequency() != 923900000 || ch.Bandwidth() != lora.Bandwidth_500_0 ||
+		ch.SpreadingFactor() != lora.SpreadingFactor10 {
+		t.Errorf("unexpected US915 RX1 channel %d SF%d", ch.Frequency(), ch.SpreadingFactor())
+	}
+
+	au := region.AU915()
+	if ch := au.RX1Channel(au.UplinkChannel(), 0); ch.Frequency() != lora.MHz_923_3 {
+		t.Errorf("unexpected AU915 RX1 channel %d", ch.Frequency())
+	}
+
+	// DR2 uplink, raised by the offset 7 but at most DR5
+	as := region.AS923_2()
+	if ch := as.RX1Channel(as.UplinkChannel(), 7); ch.Frequency() != 921400000 ||
+		ch.SpreadingFactor() != lora.SpreadingFactor8 {
+		t.Errorf("unexpected AS923-2 RX1 channel %d SF%d", ch.Frequency(), ch.SpreadingFactor())
+	}
+	// the downlink dwell time limit keeps it at DR2 or more
+	if ch := as.RX1Channel(as.UplinkChannel(), 5); ch.SpreadingFactor() != lora.SpreadingFactor10 {
+		t.Errorf("unexpected AS923-2 RX1 channel SF%d", ch.SpreadingFactor())
+	}
+
+	cn := region.CN470()
+	up = cn.UplinkChannel()
+	for i := 0; i < 50; i++ {
+		up = cn.UplinkChannel()
+	}
+	if ch := cn.RX1Channel(up, 1); ch.Frequency() != 500700000 || ch.SpreadingFactor() != lora.SpreadingFactor10 {
+		t.Errorf("unexpected CN470 RX1 channel %d SF%d", ch.Frequency(), ch.SpreadingFactor())
+	}
+}
+
+func TestRegions(t *testing.T) {
+	for _, r := range []struct {
+		name     string
+		settings region.Settings
+		channels int
+		rx2      uint32
+		maxPower int8
+	}{
+		{"EU868", region.EU868(), 3, lora.MHz_869_5, 16},
+		{"US915", region.US915(), 72, lora.MHz_923_3, 30},
+		{"AU915", region.AU915(), 72, lora.MHz_923_3, 30},
+		{"AS923-1", region.AS923_1(), 2, lora.MHz_923_2, 16},
+		{"AS923-3", region.AS923_3(), 2, 916600000, 16},
+		{"AS923-4", region.AS923_4(), 2, 917300000, 16},
+		{"KR920", region.KR920(), 3, lora.MHz_921_9, 14},
+		{"IN865", region.IN865(), 3, lora.MHz_866_55, 30},
+		{"CN470", region.CN470(), 96, lora.MHz_505_3, 19},
+		{"EU433", region.EU433(), 3, lora.MHz_434_665, 12},
+	} {
+		join := 0
+		for _, ch := range r.settings.Channels() {
+			if ch.Join {
+				join++
+				if !ch.Enabled || ch.Frequency == 0 {
+					t.Errorf("%s: unexpected join channel %+v", r.name, ch)
+				}
+			}
+		}
+		freq, dr := r.settings.RX2Default()
+		rx2 := r.settings.RX2Channel()
+		if join != r.channels || freq != r.rx2 || rx2.Frequency() != freq || r.settings.TxPowers()[0] != r.maxPower {
+			t.Errorf("%s: %d join channels, RX2 %d DR%d", r.name, join, freq, dr)
+		}
+
+		// the uplinks fit in the dwell time at the default data rate
+		if r.settings.MaxPayload() == 0 {
+			t.Errorf("%s: no payload at DR%d", r.name, r.settings.DataRate())
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package lorawan
+
+import (
+	"time"
+
+	"tinygo.org/x/drivers/lora"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+// airtime returns the time on air of a packet sent on the channel.
+func airtime(ch region.Channel, payloadLen int) time.Duration {
+	return lora.TimeOnAir(lora.Config{
+		Cr:         ch.CodingRate(),
+		Sf:         ch.SpreadingFactor(),
+		Bw:         ch.Bandwidth(),
+		Ldr:        lora.LowDataRateOptimize(ch.SpreadingFactor(), ch.Bandwidth()),
+		Preamble:   ch.PreambleLength(),
+		HeaderType: lora.HeaderExplicit,
+		Crc:        lora.CRCOn,
+	}, payloadLen)
+}
+
+// waitDutyCycle waits until the duty cycle limits allow a transmission on the
+// channel, or returns ErrDutyCycle if it is longer than DutyCycleWait.
+func waitDutyCycle(ch region.Channel) error {
+	wait := dutyCycle.Wait(ch.Frequency(), now())
+	if wait > DutyCycleWait {
+		return ErrDutyCycle
+	}
+	if wait > 0 {
+		sleep(wait)
+	}
+	return nil
+}
+
+// nextUplinkChannel returns the next uplink channel allowed by the duty cycle
+// limits, skipping the ones of the sub-bands used up, and waiting for one up
+// to DutyCycleWait.
+func nextUplinkChannel() (region.Channel, error) {
+	enabled := 0
+	for _, ch := range regionSettings.Channels() {
+		if ch.Enabled {
+			enabled++
+		}
+	}
+	if enabled == 0 {
+		// A plan without channel list, always the same channel
+		enabled = 1
+	}
+
+	for {
+		var wait time.Duration
+		for i := 0; i < enabled; i++ {
+			ch := regionSettings.UplinkChannel()
+			w := dutyCycle.Wait(ch.Frequency(), now())
+			if w == 0 {
+				return ch, nil
+			}
+			if wait == 0 || w < wait {
+				wait = w
+			}
+		}
+		if wait > DutyCycleWait {
+			return nil, ErrDutyCycle
+		}
+		sleep(wait)
+	}
+}
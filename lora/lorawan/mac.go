@@ -0,0 +1,231 @@
+package lorawan
+
+import (
+	"encoding/binary"
+	"time"
+
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+// MAC command identifiers
+const (
+	cidLinkCheck     = 0x02
+	cidLinkADR       = 0x03
+	cidDutyCycle     = 0x04
+	cidRXParamSetup  = 0x05
+	cidDevStatus     = 0x06
+	cidNewChannel    = 0x07
+	cidRXTimingSetup = 0x08
+	cidTxParamSetup  = 0x09
+	cidRekey         = 0x0B
+	cidDeviceTime    = 0x0D
+)
+
+// Length of the MAC commands sent by the network server, without the CID
+var downlinkCommandLen = map[uint8]int{
+	cidLinkCheck:     2,
+	cidLinkADR:       4,
+	cidDutyCycle:     1,
+	cidRXParamSetup:  4,
+	cidDevStatus:     0,
+	cidNewChannel:    5,
+	cidRXTimingSetup: 1,
+	cidTxParamSetup:  1,
+	cidRekey:         1,
+	cidDeviceTime:    5,
+}
+
+// Length of the MAC commands sent by the device, without the CID
+var uplinkCommandLen = map[uint8]int{
+	cidLinkCheck:     0,
+	cidLinkADR:       1,
+	cidDutyCycle:     0,
+	cidRXParamSetup:  1,
+	cidDevStatus:     2,
+	cidNewChannel:    1,
+	cidRXTimingSetup: 0,
+	cidTxParamSetup:  0,
+	cidRekey:         1,
+	cidDeviceTime:    0,
+}
+
+const (
+	// Maximum length of the FOpts field
+	maxFOptsLen = 15
+
+	// DevStatusAns battery level when it can't be measured
+	BatteryUnknown = 255
+
+	// GPS time is ahead of UTC by the leap seconds since 1980
+	gpsLeapSeconds = 18
+)
+
+// MaxEIRP of the TxParamSetupReq MAC command, in dBm
+var maxEIRP = [16]int8{8, 10, 12, 13, 14, 16, 18, 20, 21, 24, 26, 27, 29, 30, 33, 36}
+
+// GPS epoch, the origin of DeviceTimeAns
+var gpsEpoch = time.Date(1980, time.January, 6, 0, 0, 0, 0, time.UTC)
+
+var batteryLevel func() uint8
+
+// SetBatteryLevelFunc sets the function returning the battery level reported
+// to the network server: 0 for an external power source, 1 to 254 for the
+// battery level, or BatteryUnknown.
+func SetBatteryLevelFunc(f func() uint8) {
+	batteryLevel = f
+}
+
+// RequestLinkCheck asks the network server to check the link with the next
+// uplink. The answer sets the session LinkMargin and GatewayCount.
+func RequestLinkCheck(s *Session) {
+	s.macCommands = append(s.macCommands, cidLinkCheck)
+}
+
+// RequestDeviceTime asks the network server for the time with the next
+// uplink. The answer sets the session NetworkTime.
+func RequestDeviceTime(s *Session) {
+	s.macCommands = append(s.macCommands, cidDeviceTime)
+}
+
+// handleMACCommands applies the MAC commands received from the network
+// server, and queues their answers for the next uplink. The commands after
+// an unknown or truncated one are ignored.
+func handleMACCommands(s *Session, cmds []uint8) error {
+	for len(cmds) > 0 {
+		cid := cmds[0]
+		n, ok := downlinkCommandLen[cid]
+		if !ok || len(cmds) < 1+n {
+			return ErrInvalidMACCommand
+		}
+		args := cmds[1 : 1+n]
+		cmds = cmds[1+n:]
+
+		switch cid {
+		case cidLinkCheck:
+			s.LinkMargin = args[0]
+			s.GatewayCount = args[1]
+
+		case cidLinkADR:
+			// The contiguous LinkADRReq are a block applied at once, with
+			// the data rate, TX power and NbTrans of the last one.
+			masks := []region.ChannelMask{{Cntl: (args[3] >> 4) & 0x07, Mask: binary.LittleEndian.Uint16(args[1:])}}
+			for len(cmds) >= 5 && cmds[0] == cidLinkADR {
+				args = cmds[1:5]
+				cmds = cmds[5:]
+				masks = append(masks, region.ChannelMask{Cntl: (args[3] >> 4) & 0x07, Mask: binary.LittleEndian.Uint16(args[1:])})
+			}
+			chMaskOK, drOK, powerOK := regionSettings.LinkADR(args[0]>>4, args[0]&0x0F, masks)
+			status := statusBits(chMaskOK, drOK, powerOK)
+			if status == 0x07 && args[3]&0x0F != 0 {
+				s.NbTrans = args[3] & 0x0F
+			}
+			for range masks {
+				s.macCommands = append(s.macCommands, cidLinkADR, status)
+			}
+
+		case cidDutyCycle:
+			s.MaxDutyCycle = args[0] & 0x0F
+			s.macCommands = append(s.macCommands, cidDutyCycle)
+
+		case cidRXParamSetup:
+			rx1DROffset := (args[0] >> 4) & 0x07
+			rx2DR := args[0] & 0x0F
+			frequency := (uint32(args[1]) | uint32(args[2])<<8 | uint32(args[3])<<16) * 100
+			var freqOK, drOK bool
+			rx1OK := rx1DROffset <= 5
+			if rx1OK {
+				freqOK, drOK = regionSettings.SetRX2(frequency, rx2DR)
+			}
+			status := statusBits(freqOK, drOK, rx1OK)
+			if status == 0x07 {
+				s.DLSettings = s.DLSettings&0x80 | rx1DROffset<<4 | rx2DR
+				if frequency != 0 {
+					s.RX2Frequency = frequency
+				}
+			}
+			// Answered until a downlink is received
+			s.stickyCommands = append(s.stickyCommands, cidRXParamSetup, status)
+
+		case cidDevStatus:
+			battery := uint8(BatteryUnknown)
+			if batteryLevel != nil {
+				battery = batteryLevel()
+			}
+			// The SNR margin is not known from the radio
+			s.macCommands = append(s.macCommands, cidDevStatus, battery, 0)
+
+		case cidNewChannel:
+			frequency := (uint32(args[1]) | uint32(args[2])<<8 | uint32(args[3])<<16) * 100
+			freqOK, drOK := regionSettings.SetChannel(args[0], frequency, args[4]&0x0F, args[4]>>4)
+			s.macCommands = append(s.macCommands, cidNewChannel, statusBits(freqOK, drOK, false))
+
+		case cidRXTimingSetup:
+			s.RXDelay = args[0] & 0x0F
+			s.stickyCommands = append(s.stickyCommands, cidRXTimingSetup)
+
+		case cidTxParamSetup:
+			// Only answered in the regions supporting it
+			downlinkDwell := args[0]&0x20 != 0
+			uplinkDwell := args[0]&0x10 != 0
+			if regionSettings.SetTxParams(uplinkDwell, downlinkDwell, maxEIRP[args[0]&0x0F]) {
+				s.macCommands = append(s.macCommands, cidTxParamSetup)
+			}
+
+		case cidRekey:
+			// RekeyConf, the LoRaWAN 1.1 session is confirmed
+			s.rekeyPending = false
+
+		case cidDeviceTime:
+			// The time is the one at the end of the uplink
+			gps := time.Duration(binary.LittleEndian.Uint32(args))*time.Second +
+				time.Duration(args[4])*time.Second/256
+			s.NetworkTime = gpsEpoch.Add(gps - gpsLeapSeconds*time.Second).Add(now().Sub(uplinkEnd))
+		}
+	}
+	return nil
+}
+
+// statusBits returns the status of a MAC command answer from its 3 ACK bits.
+func statusBits(bit0, bit1, bit2 bool) uint8 {
+	var status uint8
+	if bit0 {
+		status |= 0x01
+	}
+	if bit1 {
+		status |= 0x02
+	}
+	if bit2 {
+		status |= 0x04
+	}
+	return status
+}
+
+// fOpts returns the MAC commands sent in the FOpts of the next uplink, the
+// RekeyInd of a new LoRaWAN 1.1 session and the sticky answers first. The
+// commands that don't fit are kept for the following uplinks.
+func (s *Session) fOpts() []uint8 {
+	var fOpts []uint8
+	if s.rekeyPending {
+		// Sent until the network server answers with a RekeyConf
+		fOpts = append(fOpts, cidRekey, lorawanMinor11)
+	}
+	fOpts, _ = appendCommands(fOpts, s.stickyCommands)
+	fOpts, n := appendCommands(fOpts, s.macCommands)
+	s.macCommands = s.macCommands[n:]
+	return fOpts
+}
+
+// appendCommands appends the whole uplink MAC commands fitting in the FOpts,
+// and returns the length of the commands appended.
+func appendCommands(fOpts []uint8, cmds []uint8) ([]uint8, int) {
+	n := 0
+	for n < len(cmds) {
+		l := 1 + uplinkCommandLen[cmds[n]]
+		if len(fOpts)+l > maxFOptsLen {
+			break
+		}
+		fOpts = append(fOpts, cmds[n:n+l]...)
+		n += l
+	}
+	return fOpts, n
+}
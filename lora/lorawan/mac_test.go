@@ -0,0 +1,210 @@
+package lorawan
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/lora"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+func TestMACCommands(t *testing.T) {
+	UseRegionSettings(region.EU868())
+	defer UseRegionSettings(nil)
+	SetBatteryLevelFunc(func() uint8 { return 200 })
+	defer SetBatteryLevelFunc(nil)
+
+	s := testSession()
+	cmds := []uint8{
+		cidLinkCheck, 12, 3,
+		cidNewChannel, 3, 0x18, 0x4f, 0x84, 0x50, // 867.1 MHz, DR0 to DR5
+		cidLinkADR, 0x51, 0x0F, 0x00, 0x01, // DR5, 14 dBm, channels 0 to 3, NbTrans 1
+		cidDutyCycle, 7,
+		cidRXParamSetup, 0x23, 0x18, 0x4f, 0x84, // RX1 offset 2, RX2 DR3 at 867.1 MHz
+		cidDevStatus,
+		cidRXTimingSetup, 5,
+	}
+	if err := handleMACCommands(s, cmds); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.LinkMargin != 12 || s.GatewayCount != 3 || s.MaxDutyCycle != 7 || s.NbTrans != 1 ||
+		s.RXDelay != 5 || s.DLSettings != 0x23 {
+		t.Errorf("unexpected session %+v", s)
+	}
+
+	// the uplinks use the new channel and data rate
+	var freqs []uint32
+	for i := 0; i < 4; i++ {
+		ch := regionSettings.UplinkChannel()
+		freqs = append(freqs, ch.Frequency())
+		if ch.SpreadingFactor() != lora.SpreadingFactor7 || ch.TxPowerDBm() != 14 {
+			t.Errorf("unexpected modulation SF%d %d dBm", ch.SpreadingFactor(), ch.TxPowerDBm())
+		}
+	}
+	if freqs[0] != lora.MHz_868_1 || freqs[3] != 867100000 {
+		t.Errorf("unexpected uplink channels %v", freqs)
+	}
+	if rx2 := regionSettings.RX2Channel(); rx2.Frequency() != 867100000 || rx2.SpreadingFactor() != lora.SpreadingFactor9 {
+		t.Errorf("unexpected RX2 channel %d SF%d", rx2.Frequency(), rx2.SpreadingFactor())
+	}
+
+	// the answers go in the FOpts of the next uplink, the sticky ones until
+	// a downlink is received
+	want := []uint8{
+		cidRXParamSetup, 0x07, cidRXTimingSetup,
+		cidNewChannel, 0x03, cidLinkADR, 0x07, cidDutyCycle, cidDevStatus, 200, 0,
+	}
+	up, _ := s.GenMessage(0, []uint8("x"))
+	if up[5]&fCtrlFOptsLen != uint8(len(want)) || !bytes.Equal(up[8:8+len(want)], want) {
+		t.Errorf("unexpected FOpts % x", up[5:])
+	}
+	if fOpts := s.fOpts(); !bytes.Equal(fOpts, want[:3]) {
+		t.Errorf("unexpected sticky FOpts % x", fOpts)
+	}
+	s.DecodeDownlink(encodeDownlink(s, mTypeUnconfirmedDataDown, 0, 0, nil, 1, []uint8{1}))
+	if fOpts := s.fOpts(); len(fOpts) != 0 {
+		t.Errorf("unexpected FOpts % x", fOpts)
+	}
+
+	// rejected commands are not applied
+	cmds = []uint8{
+		cidLinkADR, 0x71, 0x0F, 0x00, 0x01, // DR7 is FSK
+		cidNewChannel, 1, 0x18, 0x4f, 0x84, 0x50, // default channel
+		cidRXParamSetup, 0x23, 0x00, 0x00, 0x01, // 6553.6 MHz
+	}
+	handleMACCommands(s, cmds)
+	want = []uint8{cidRXParamSetup, 0x06, cidLinkADR, 0x05, cidNewChannel, 0x00}
+	if fOpts := s.fOpts(); !bytes.Equal(fOpts, want) {
+		t.Errorf("unexpected FOpts % x", fOpts)
+	}
+	if regionSettings.DataRate() != 5 {
+		t.Errorf("unexpected data rate %d", regionSettings.DataRate())
+	}
+
+	// unknown commands stop the processing
+	if err := handleMACCommands(s, []uint8{0x80, cidDevStatus}); err != ErrInvalidMACCommand {
+		t.Errorf("expected invalid MAC command, got %v", err)
+	}
+	if err := handleMACCommands(s, []uint8{cidLinkCheck, 1}); err != ErrInvalidMACCommand {
+		t.Errorf("expected invalid MAC command, got %v", err)
+	}
+}
+
+func TestLinkADRBlock(t *testing.T) {
+	UseRegionSettings(region.US915())
+	defer UseRegionSettings(nil)
+
+	// only the second sub-band, at DR3
+	s := testSession()
+	cmds := []uint8{
+		cidLinkADR, 0x3F, 0x00, 0x00, 0x70, // all 125 kHz channels off
+		cidLinkADR, 0x3F, 0x00, 0xFF, 0x00, // channels 8 to 15
+	}
+	handleMACCommands(s, cmds)
+	if fOpts := s.fOpts(); !bytes.Equal(fOpts, []uint8{cidLinkADR, 0x07, cidLinkADR, 0x07}) {
+		t.Errorf("unexpected FOpts % x", fOpts)
+	}
+	for i := 0; i < 9; i++ {
+		ch := regionSettings.UplinkChannel()
+		if f := ch.Frequency(); f < 903900000 || f > 905300000 || ch.SpreadingFactor() != lora.SpreadingFactor7 {
+			t.Errorf("unexpected uplink channel %d SF%d", f, ch.SpreadingFactor())
+		}
+	}
+
+	// all channels off, no channel left for the data rate
+	handleMACCommands(s, []uint8{cidLinkADR, 0xFF, 0x00, 0x00, 0x70})
+	if fOpts := s.fOpts(); !bytes.Equal(fOpts, []uint8{cidLinkADR, 0x04}) {
+		t.Errorf("unexpected FOpts % x", fOpts)
+	}
+
+	// sub-bands 2 and 4, with their 500 kHz channels
+	handleMACCommands(s, []uint8{cidLinkADR, 0x3F, 0x0A, 0x00, 0x50})
+	if fOpts := s.fOpts(); !bytes.Equal(fOpts, []uint8{cidLinkADR, 0x07}) {
+		t.Errorf("unexpected FOpts % x", fOpts)
+	}
+	for i, ch := range regionSettings.Channels() {
+		subBand := i / 8
+		if i >= 64 {
+			subBand = i - 64
+		}
+		if want := subBand == 1 || subBand == 3; ch.Enabled != want {
+			t.Errorf("channel %d enabled %v, expected %v", i, ch.Enabled, want)
+		}
+	}
+}
+
+func TestDeviceTime(t *testing.T) {
+	s := testSession()
+	RequestDeviceTime(s)
+	RequestLinkCheck(s)
+	if fOpts := s.fOpts(); !bytes.Equal(fOpts, []uint8{cidDeviceTime, cidLinkCheck}) {
+		t.Errorf("unexpected FOpts % x", fOpts)
+	}
+
+	// 2024-01-01 00:00:00 UTC, and half a second
+	uplinkEnd = time.Now()
+	handleMACCommands(s, []uint8{cidDeviceTime, 0x12, 0xc3, 0xbc, 0x52, 0x80})
+	want := time.Date(2024, time.January, 1, 0, 0, 0, 5e8, time.UTC)
+	if d := s.NetworkTime.Sub(want); d < 0 || d > 100*time.Millisecond {
+		t.Errorf("unexpected network time %s", s.NetworkTime)
+	}
+}
+
+func TestListenDownlinkFOpts(t *testing.T) {
+	radio := &windowRadio{packets: make(map[uint32][]uint8)}
+	ActiveRadio = radio
+	UseRegionSettings(region.EU868())
+	SetBatteryLevelFunc(func() uint8 { return 200 })
+	defer func() {
+		ActiveRadio = nil
+		UseRegionSettings(nil)
+		SetBatteryLevelFunc(nil)
+	}()
+
+	// MAC commands in the FOpts of a frame without FRMPayload, so without
+	// FPort
+	s := testSession()
+	fOpts := []uint8{cidLinkADR, 0x51, 0x07, 0x00, 0x01, cidDevStatus} // DR5, channels 0 to 2
+	radio.packets[lora.MHz_868_1] = encodeDownlink(s, mTypeUnconfirmedDataDown, 0, 0, fOpts, 0, nil)
+	uplinkChannel = regionSettings.UplinkChannel()
+	uplinkEnd = time.Now().Add(-time.Second)
+	if _, err := ListenDownlink(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if regionSettings.DataRate() != 5 || s.NbTrans != 1 {
+		t.Errorf("LinkADRReq not applied: DR%d, NbTrans %d", regionSettings.DataRate(), s.NbTrans)
+	}
+	if want := []uint8{cidLinkADR, 0x07, cidDevStatus, 200, 0}; !bytes.Equal(s.fOpts(), want) {
+		t.Errorf("unexpected FOpts % x", s.fOpts())
+	}
+}
+
+func TestTxParamSetup(t *testing.T) {
+	as := region.AS923_1()
+	UseRegionSettings(as)
+	defer UseRegionSettings(nil)
+
+	s := testSession()
+	if as.DwellTime() != region.DWELL_TIME || as.MaxPayload() != 11 || as.SetDataRate(1) {
+		t.Errorf("unexpected default dwell time %s", as.DwellTime())
+	}
+
+	// no dwell time limit, 14 dBm
+	handleMACCommands(s, []uint8{cidTxParamSetup, 0x04})
+	if fOpts := s.fOpts(); !bytes.Equal(fOpts, []uint8{cidTxParamSetup}) {
+		t.Errorf("unexpected FOpts % x", fOpts)
+	}
+	if as.DwellTime() != 0 || as.MaxPayload() != 51 || as.TxPowers()[0] != 14 || !as.SetDataRate(0) {
+		t.Errorf("unexpected dwell time %s, max payload %d", as.DwellTime(), as.MaxPayload())
+	}
+
+	// not answered in the other regions
+	UseRegionSettings(region.EU868())
+	handleMACCommands(s, []uint8{cidTxParamSetup, 0x04})
+	if fOpts := s.fOpts(); len(fOpts) != 0 {
+		t.Errorf("unexpected FOpts % x", fOpts)
+	}
+}
@@ -3,18 +3,34 @@ package lorawan
 import (
 	"bytes"
 	"crypto/aes"
+	"encoding/binary"
 	"encoding/hex"
 )
 
 // Otaa is used to store Over The Air Activation data of a LoRaWAN session
+//
+// A LoRaWAN 1.1 device also has a NwkKey, the network session keys being
+// derived from it and the application one from the AppKey, and AppEUI is its
+// JoinEUI. A LoRaWAN 1.0 device only has the AppKey.
 type Otaa struct {
 	DevEUI   [8]uint8
 	AppEUI   [8]uint8
 	AppKey   [16]uint8
+	NwkKey   [16]uint8
 	devNonce [2]uint8
 	appNonce [3]uint8
 	NetID    [3]uint8
 	buf      []uint8
+
+	// LoRaWAN 1.1: last JoinNonce accepted, which must increase, counter of
+	// the Rejoin requests of type 1, and type and RJcount of the pending
+	// Rejoin request
+	joinNonce    uint32
+	hasJoinNonce bool
+	rjCount1     uint16
+	rejoin       bool
+	rejoinType   uint8
+	rjCount      uint16
 }
 
 // Initialize DevNonce
@@ -88,6 +104,35 @@ func (o *Otaa) GetAppKey() string {
 	return hex.EncodeToString(o.AppKey[:])
 }
 
+// SetNwkKey configures the Otaa NwkKey of a LoRaWAN 1.1 device
+func (o *Otaa) SetNwkKey(nwkKey []uint8) error {
+	if len(nwkKey) != 16 {
+		return ErrInvalidNwkKeyLength
+	}
+
+	copy(o.NwkKey[:], nwkKey)
+
+	return nil
+}
+
+func (o *Otaa) GetNwkKey() string {
+	return hex.EncodeToString(o.NwkKey[:])
+}
+
+// lorawan11 returns if the device supports LoRaWAN 1.1, having a NwkKey.
+func (o *Otaa) lorawan11() bool {
+	return o.NwkKey != [16]uint8{}
+}
+
+// nwkKey returns the root key of the network session keys, the AppKey of
+// LoRaWAN 1.0.
+func (o *Otaa) nwkKey() [16]uint8 {
+	if o.lorawan11() {
+		return o.NwkKey
+	}
+	return o.AppKey
+}
+
 func (o *Otaa) GetNetID() string {
 	return hex.EncodeToString(o.NetID[:])
 }
@@ -105,6 +150,7 @@ func (o *Otaa) SetNetID(netID []uint8) error {
 // GenerateJoinRequest Generates a LoraWAN Join request
 func (o *Otaa) GenerateJoinRequest() ([]uint8, error) {
 	o.incrementDevNonce()
+	o.rejoin = false
 
 	// TODO: Add checks
 	o.buf = o.buf[:0]
@@ -112,21 +158,78 @@ func (o *Otaa) GenerateJoinRequest() ([]uint8, error) {
 	o.buf = append(o.buf, reverseBytes(o.AppEUI[:])...)
 	o.buf = append(o.buf, reverseBytes(o.DevEUI[:])...)
 	o.buf = append(o.buf, o.devNonce[:]...)
-	mic := genPayloadMIC(o.buf, o.AppKey)
+	mic := genPayloadMIC(o.buf, o.nwkKey())
+	o.buf = append(o.buf, mic[:]...)
+
+	return o.buf, nil
+}
+
+// GenerateRejoinRequest generates a LoRaWAN 1.1 Rejoin request of type 0, 1
+// or 2 for the session, its Rejoin-accept being decoded by DecodeJoinAccept.
+func (o *Otaa) GenerateRejoinRequest(s *Session, rejoinType uint8) ([]uint8, error) {
+	if !s.LoRaWAN11 {
+		return nil, ErrLoRaWAN11Required
+	}
+
+	o.buf = o.buf[:0]
+	o.buf = append(o.buf, mTypeRejoinRequest, rejoinType)
+	var mic [4]uint8
+	switch rejoinType {
+	case 0, 2:
+		// MHDR | type | NetID | DevEUI | RJcount0
+		o.buf = append(o.buf, o.NetID[:]...)
+		o.buf = append(o.buf, reverseBytes(o.DevEUI[:])...)
+		o.buf = binary.LittleEndian.AppendUint16(o.buf, s.rjCount0)
+		o.rjCount = s.rjCount0
+		s.rjCount0++
+		mic = genPayloadMIC(o.buf, s.SNwkSIntKey)
+	case 1:
+		// MHDR | type | JoinEUI | DevEUI | RJcount1
+		o.buf = append(o.buf, reverseBytes(o.AppEUI[:])...)
+		o.buf = append(o.buf, reverseBytes(o.DevEUI[:])...)
+		o.buf = binary.LittleEndian.AppendUint16(o.buf, o.rjCount1)
+		o.rjCount = o.rjCount1
+		o.rjCount1++
+		mic = genPayloadMIC(o.buf, o.jsIntKey())
+	default:
+		return nil, ErrInvalidRejoinType
+	}
+	o.rejoin = true
+	o.rejoinType = rejoinType
 	o.buf = append(o.buf, mic[:]...)
 
 	return o.buf, nil
 }
 
-// DecodeJoinAccept Decodes a Lora Join Accept packet
+// jsIntKey returns the JSIntKey of the Join-accept MIC of LoRaWAN 1.1 and of
+// the Rejoin requests of type 1.
+func (o *Otaa) jsIntKey() [16]uint8 {
+	return deriveKey(o.NwkKey, prefixJSIntKey, reverseBytes(o.DevEUI[:]))
+}
+
+// jsEncKey returns the JSEncKey encrypting the Rejoin-accept of LoRaWAN 1.1.
+func (o *Otaa) jsEncKey() [16]uint8 {
+	return deriveKey(o.NwkKey, prefixJSEncKey, reverseBytes(o.DevEUI[:]))
+}
+
+// DecodeJoinAccept Decodes a Lora Join Accept packet, or the Rejoin-accept of
+// a LoRaWAN 1.1 Rejoin request.
+//
+// The session is a LoRaWAN 1.1 one if the device has a NwkKey and the join
+// server sets the OptNeg bit, the keys being derived as of LoRaWAN 1.0
+// otherwise.
 func (o *Otaa) DecodeJoinAccept(phyPload []uint8, s *Session) error {
-	if len(phyPload) < 12 {
+	if len(phyPload) != 17 && len(phyPload) != 33 {
 		return ErrInvalidPacketLength
 	}
 	data := phyPload[1:] // Remove trailing 0x20
 
 	// Prepare AES Cipher
-	block, err := aes.NewCipher(o.AppKey[:])
+	key := o.nwkKey()
+	if o.rejoin {
+		key = o.jsEncKey()
+	}
+	block, err := aes.NewCipher(key[:])
 	if err != nil {
 		return err
 	}
@@ -134,52 +237,84 @@ func (o *Otaa) DecodeJoinAccept(phyPload []uint8, s *Session) error {
 	for k := 0; k < len(data)/aes.BlockSize; k++ {
 		block.Encrypt(buf[k*aes.BlockSize:], data[k*aes.BlockSize:])
 	}
-
-	copy(o.appNonce[:], buf[0:3])
-	copy(o.NetID[:], buf[3:6])
-	copy(s.DevAddr[:], buf[6:10])
-	s.DLSettings = buf[10]
-	s.RXDelay = buf[11]
-
-	if len(buf) > 16 {
-		copy(s.CFList[:], buf[12:28])
-	}
+	fields := buf[:len(buf)-4]
 	rxMic := buf[len(buf)-4:]
 
-	dataMic := []byte{}
-	dataMic = append(dataMic, phyPload[0])
-	dataMic = append(dataMic, o.appNonce[:]...)
-	dataMic = append(dataMic, o.NetID[:]...)
-	dataMic = append(dataMic, s.DevAddr[:]...)
-	dataMic = append(dataMic, s.DLSettings)
-	dataMic = append(dataMic, s.RXDelay)
-	dataMic = append(dataMic, s.CFList[:]...)
-	computedMic := genPayloadMIC(dataMic[:], o.AppKey)
+	// JoinNonce | NetID | DevAddr | DLSettings | RxDelay | CFList
+	optNeg := o.lorawan11() && fields[10]&dlSettingsOptNeg != 0
+	var computedMic [4]uint8
+	if optNeg {
+		// JoinReqType | JoinEUI | DevNonce | MHDR | fields, DevNonce being
+		// the RJcount of a Rejoin request
+		dataMic := []byte{joinRequestType}
+		if o.rejoin {
+			dataMic[0] = o.rejoinType
+		}
+		dataMic = append(dataMic, reverseBytes(o.AppEUI[:])...)
+		dataMic = append(dataMic, o.joinDevNonce()...)
+		dataMic = append(dataMic, phyPload[0])
+		dataMic = append(dataMic, fields...)
+		computedMic = genPayloadMIC(dataMic, o.jsIntKey())
+	} else {
+		dataMic := []byte{phyPload[0]}
+		dataMic = append(dataMic, fields...)
+		computedMic = genPayloadMIC(dataMic, key)
+	}
 	if !bytes.Equal(computedMic[:], rxMic[:]) {
 		return ErrInvalidMic
 	}
-	// Generate NwkSKey
-	// NwkSKey = aes128_encrypt(AppKey, 0x01|AppNonce|NetID|DevNonce|pad16)
-	sKey := []byte{}
-	sKey = append(sKey, 0x01)
-	sKey = append(sKey, o.appNonce[:]...)
-	sKey = append(sKey, o.NetID[:]...)
-	sKey = append(sKey, o.devNonce[:]...)
-	for i := 0; i < 7; i++ {
-		sKey = append(sKey, 0x00) // PAD to 16
-	}
-	block.Encrypt(buf, sKey)
-	copy(s.NwkSKey[:], buf[0:16])
-
-	// Generate AppSKey
-	// AppSKey = aes128_encrypt(AppKey, 0x02|AppNonce|NetID|DevNonce|pad16)
-	sKey[0] = 0x02
-	block.Encrypt(buf, sKey)
-	copy(s.AppSKey[:], buf[0:16])
-
-	// Reset counters
-	s.FCntDown = 0
-	s.FCntUp = 0
+
+	joinNonce := uint32(fields[0]) | uint32(fields[1])<<8 | uint32(fields[2])<<16
+	if optNeg {
+		// Replayed Join-accepts are rejected
+		if o.hasJoinNonce && joinNonce <= o.joinNonce {
+			return ErrInvalidJoinNonce
+		}
+		o.joinNonce = joinNonce
+		o.hasJoinNonce = true
+	}
+
+	copy(o.appNonce[:], fields[0:3])
+	copy(o.NetID[:], fields[3:6])
+	copy(s.DevAddr[:], fields[6:10])
+	s.DLSettings = fields[10]
+	s.RXDelay = fields[11]
+	s.CFList = [16]uint8{}
+	if len(fields) > 12 {
+		copy(s.CFList[:], fields[12:28])
+	}
+
+	s.LoRaWAN11 = optNeg
+	if optNeg {
+		// Keys = aes128_encrypt(NwkKey or AppKey, prefix|JoinNonce|JoinEUI|DevNonce|pad16)
+		joinEUI := reverseBytes(o.AppEUI[:])
+		devNonce := o.joinDevNonce()
+		s.NwkSKey = deriveKey(o.NwkKey, prefixFNwkSIntKey, o.appNonce[:], joinEUI, devNonce)
+		s.SNwkSIntKey = deriveKey(o.NwkKey, prefixSNwkSIntKey, o.appNonce[:], joinEUI, devNonce)
+		s.NwkSEncKey = deriveKey(o.NwkKey, prefixNwkSEncKey, o.appNonce[:], joinEUI, devNonce)
+		s.AppSKey = deriveKey(o.AppKey, prefixAppSKey, o.appNonce[:], joinEUI, devNonce)
+	} else {
+		// NwkSKey = aes128_encrypt(AppKey, 0x01|AppNonce|NetID|DevNonce|pad16)
+		// AppSKey = aes128_encrypt(AppKey, 0x02|AppNonce|NetID|DevNonce|pad16)
+		s.NwkSKey = deriveKey(key, 0x01, o.appNonce[:], o.NetID[:], o.devNonce[:])
+		s.AppSKey = deriveKey(key, 0x02, o.appNonce[:], o.NetID[:], o.devNonce[:])
+		s.SNwkSIntKey = [16]uint8{}
+		s.NwkSEncKey = [16]uint8{}
+	}
+	o.rejoin = false
+
+	s.reset()
+	// A new LoRaWAN 1.1 session is indicated to the network server
+	s.rekeyPending = optNeg
 
 	return nil
 }
+
+// joinDevNonce returns the DevNonce of the pending Join request, or the
+// RJcount of the pending Rejoin request.
+func (o *Otaa) joinDevNonce() []uint8 {
+	if !o.rejoin {
+		return o.devNonce[:]
+	}
+	return binary.LittleEndian.AppendUint16(nil, o.rjCount)
+}
@@ -0,0 +1,157 @@
+package region
+
+import "tinygo.org/x/drivers/lora"
+
+const (
+	AS923_DEFAULT_PREAMBLE_LEN = 8
+	AS923_DEFAULT_TX_POWER_DBM = 16
+	AS923_MAX_EIRP_DBM         = 16
+	AS923_DEFAULT_DATA_RATE    = 2
+
+	// Frequency offsets of the AS923 groups from AS923-1
+	AS923_2_FREQUENCY_OFFSET = -1800000
+	AS923_3_FREQUENCY_OFFSET = -6600000
+	AS923_4_FREQUENCY_OFFSET = -5900000
+)
+
+var dataRatesAS923 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0, 115},
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0, 242},
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0, 242},
+	{lora.SpreadingFactor7, lora.Bandwidth_250_0, 242},
+	{}, // FSK
+}
+
+// Maximum payloads with the uplink dwell time limit, DR0 and DR1 being too
+// slow for it
+var dwellPayloadsAS923 = []uint8{0, 0, 11, 53, 125, 242, 242, 0}
+
+type ChannelAS struct {
+	channel
+}
+
+func (c *ChannelAS) Next() bool {
+	return false
+}
+
+type SettingsAS923 struct {
+	settings
+}
+
+// AS923_1 returns the settings of the AS923-1 group, from 923.2 MHz.
+func AS923_1() *SettingsAS923 {
+	return as923(0)
+}
+
+// AS923_2 returns the settings of the AS923-2 group, from 921.4 MHz.
+func AS923_2() *SettingsAS923 {
+	return as923(AS923_2_FREQUENCY_OFFSET)
+}
+
+// AS923_3 returns the settings of the AS923-3 group, from 916.6 MHz.
+func AS923_3() *SettingsAS923 {
+	return as923(AS923_3_FREQUENCY_OFFSET)
+}
+
+// AS923_4 returns the settings of the AS923-4 group, from 917.3 MHz.
+func AS923_4() *SettingsAS923 {
+	return as923(AS923_4_FREQUENCY_OFFSET)
+}
+
+// as923 returns the AS923 settings, with the frequencies of AS923-1 moved by
+// the offset of the group.
+func as923(offset int32) *SettingsAS923 {
+	freq1 := uint32(lora.MHz_923_2 + offset)
+	freq2 := uint32(lora.MHz_923_4 + offset)
+	return &SettingsAS923{settings: settings{
+		joinRequestChannel: &ChannelAS{channel: channel{freq1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor10,
+			lora.CodingRate4_5,
+			AS923_DEFAULT_PREAMBLE_LEN,
+			AS923_DEFAULT_TX_POWER_DBM}},
+		joinAcceptChannel: &ChannelAS{channel: channel{freq1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor10,
+			lora.CodingRate4_5,
+			AS923_DEFAULT_PREAMBLE_LEN,
+			AS923_DEFAULT_TX_POWER_DBM}},
+		uplinkChannel: &ChannelAS{channel: channel{freq1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor10,
+			lora.CodingRate4_5,
+			AS923_DEFAULT_PREAMBLE_LEN,
+			AS923_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelAS{channel: channel{freq1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor10,
+			lora.CodingRate4_5,
+			AS923_DEFAULT_PREAMBLE_LEN,
+			AS923_DEFAULT_TX_POWER_DBM}},
+		plan: plan{
+			dataRates: dataRatesAS923,
+			txPowers:  txPowers(AS923_MAX_EIRP_DBM, 8),
+			channels: []planChannel{
+				{freq1, 0, 5, true, true},
+				{freq2, 0, 5, true, true},
+				// up to 16 channels with NewChannelReq
+				{}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {},
+			},
+			minFrequency:    lora.MHZ_915_0,
+			maxFrequency:    lora.MHz_928_0,
+			defaultChannels: 2,
+			newChannels:     true,
+			applyMask:       applyMask16,
+			rx1DataRate:     rx1DataRateAS923,
+			rx2Frequency:    freq1,
+			rx2DataRate:     2,
+			uplinkDwell:     true,
+			downlinkDwell:   true,
+			txParamSetup:    true,
+			dwellPayloads:   dwellPayloadsAS923,
+			dataRate:        AS923_DEFAULT_DATA_RATE,
+		},
+	}}
+}
+
+// RX1Channel returns the uplink channel, with the data rate lowered by the
+// offset.
+func (r *SettingsAS923) RX1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	dr := r.rx1Modulation(uplink, rx1DROffset)
+	return &ChannelAS{channel: channel{uplink.Frequency(),
+		dr.Bandwidth,
+		dr.SpreadingFactor,
+		lora.CodingRate4_5,
+		AS923_DEFAULT_PREAMBLE_LEN,
+		AS923_DEFAULT_TX_POWER_DBM}}
+}
+
+// rx1DataRateAS923 lowers the uplink data rate by the offset, the offsets 6
+// and 7 raising it by 1 and 2, from DR2 with the downlink dwell time limit up
+// to DR5.
+func rx1DataRateAS923(p *plan, dr, offset uint8) uint8 {
+	min := 0
+	if p.downlinkDwell {
+		min = 2
+	}
+	return rx1DataRateRaised(dr, offset, min, 5)
+}
+
+// rx1DataRateRaised lowers the uplink data rate by the offsets 0 to 5, and
+// raises it by 1 and 2 for the offsets 6 and 7, within min and max.
+func rx1DataRateRaised(dr, offset uint8, min, max int) uint8 {
+	rx1 := int(dr) - int(offset)
+	if offset > 5 {
+		rx1 = int(dr) + int(offset) - 5
+	}
+	if rx1 < min {
+		rx1 = min
+	}
+	if rx1 > max {
+		rx1 = max
+	}
+	return uint8(rx1)
+}
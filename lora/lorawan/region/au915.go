@@ -5,8 +5,27 @@ import "tinygo.org/x/drivers/lora"
 const (
 	AU915_DEFAULT_PREAMBLE_LEN = 8
 	AU915_DEFAULT_TX_POWER_DBM = 20
+	AU915_MAX_EIRP_DBM         = 30
+	AU915_DEFAULT_DATA_RATE    = 3
 )
 
+var dataRatesAU915 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0, 115},
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0, 222},
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0, 222},
+	{lora.SpreadingFactor8, lora.Bandwidth_500_0, 222},
+	{}, // RFU
+	{lora.SpreadingFactor12, lora.Bandwidth_500_0, 53},
+	{lora.SpreadingFactor11, lora.Bandwidth_500_0, 129},
+	{lora.SpreadingFactor10, lora.Bandwidth_500_0, 222},
+	{lora.SpreadingFactor9, lora.Bandwidth_500_0, 222},
+	{lora.SpreadingFactor8, lora.Bandwidth_500_0, 222},
+	{lora.SpreadingFactor7, lora.Bandwidth_500_0, 222},
+}
+
 type ChannelAU struct {
 	channel
 }
@@ -39,9 +58,47 @@ func AU915() *SettingsAU915 {
 			lora.CodingRate4_5,
 			AU915_DEFAULT_PREAMBLE_LEN,
 			AU915_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelAU{channel: channel{lora.MHz_923_3,
+			lora.Bandwidth_500_0,
+			lora.SpreadingFactor12,
+			lora.CodingRate4_5,
+			AU915_DEFAULT_PREAMBLE_LEN,
+			AU915_DEFAULT_TX_POWER_DBM}},
+		plan: plan{
+			dataRates:       dataRatesAU915,
+			txPowers:        txPowers(AU915_MAX_EIRP_DBM, 15),
+			channels:        channels72(lora.MHz_915_2, lora.MHz_915_9, 5, 6),
+			minFrequency:    lora.MHZ_915_0,
+			maxFrequency:    lora.MHz_928_0,
+			defaultChannels: 72,
+			applyMask:       applyMask72,
+			rx1DataRate:     rx1DataRateAU915,
+			rx2Frequency:    lora.MHz_923_3,
+			rx2DataRate:     8,
+			dataRate:        AU915_DEFAULT_DATA_RATE,
+		},
 	}}
 }
 
+// RX1Channel returns the 500 kHz downlink channel of the uplink channel, its
+// number modulo 8, with the data rate lowered by the offset.
+func (r *SettingsAU915) RX1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	n := uplinkChannelNumber(uplink, lora.MHz_915_2, lora.MHz_915_9)
+	dr := r.rx1Modulation(uplink, rx1DROffset)
+	return &ChannelAU{channel: channel{lora.MHz_923_3 + uint32(n%8)*US915_DOWNLINK_INCREMENT,
+		dr.Bandwidth,
+		dr.SpreadingFactor,
+		lora.CodingRate4_5,
+		AU915_DEFAULT_PREAMBLE_LEN,
+		AU915_DEFAULT_TX_POWER_DBM}}
+}
+
+// rx1DataRateAU915 returns the RX1 data rate, from DR8 for DR0 to DR13,
+// lowered by the offset down to DR8.
+func rx1DataRateAU915(p *plan, dr, offset uint8) uint8 {
+	return rx1DataRate72(8+dr, offset)
+}
+
 func Next(c *ChannelAU) bool {
 	return false
 }
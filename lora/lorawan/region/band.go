@@ -0,0 +1,71 @@
+package region
+
+import "time"
+
+// Band is a sub-band of a region, from MinFrequency up to MaxFrequency
+// excluded, with its duty cycle limit.
+type Band struct {
+	MinFrequency uint32
+	MaxFrequency uint32
+	DutyCycle    uint16 // transmitting at most 1/DutyCycle of the time
+}
+
+// DutyCycle schedules the transmissions within the duty cycle limits of the
+// sub-bands: after a transmission, its sub-band is off for the time on air
+// multiplied by DutyCycle - 1. The frequencies out of the sub-bands are not
+// limited.
+type DutyCycle struct {
+	bands []Band
+	next  []time.Time // when each band can be used again
+
+	// aggregated limit of all the bands, set by the network server
+	aggregated uint16
+	aggNext    time.Time
+}
+
+// NewDutyCycle returns a duty cycle scheduler of the sub-bands.
+func NewDutyCycle(bands []Band) *DutyCycle {
+	return &DutyCycle{
+		bands: bands,
+		next:  make([]time.Time, len(bands)),
+	}
+}
+
+// SetAggregated sets the aggregated duty cycle limit of all the
+// transmissions, 1/n of the time, 0 or 1 meaning no limit.
+func (d *DutyCycle) SetAggregated(n uint16) {
+	d.aggregated = n
+}
+
+// Wait returns the time to wait before transmitting on the frequency, zero
+// if it is allowed now.
+func (d *DutyCycle) Wait(frequency uint32, now time.Time) time.Duration {
+	next := d.aggNext
+	if b := d.band(frequency); b >= 0 && d.next[b].After(next) {
+		next = d.next[b]
+	}
+	if !next.After(now) {
+		return 0
+	}
+	return next.Sub(now)
+}
+
+// Transmitted accounts a transmission on the frequency ending at end.
+func (d *DutyCycle) Transmitted(frequency uint32, end time.Time, airtime time.Duration) {
+	if b := d.band(frequency); b >= 0 && d.bands[b].DutyCycle > 1 {
+		d.next[b] = end.Add(airtime * time.Duration(d.bands[b].DutyCycle-1))
+	}
+	if d.aggregated > 1 {
+		d.aggNext = end.Add(airtime * time.Duration(d.aggregated-1))
+	}
+}
+
+// band returns the index of the band of the frequency, -1 if it is in none.
+func (d *DutyCycle) band(frequency uint32) int {
+	for i, b := range d.bands {
+		if frequency >= b.MinFrequency && frequency < b.MaxFrequency {
+			return i
+		}
+	}
+	return -1
+}
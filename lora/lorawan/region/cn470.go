@@ -0,0 +1,112 @@
+package region
+
+import "tinygo.org/x/drivers/lora"
+
+const (
+	CN470_DEFAULT_PREAMBLE_LEN = 8
+	CN470_DEFAULT_TX_POWER_DBM = 19
+	CN470_MAX_EIRP_DBM         = 19
+	CN470_DEFAULT_DATA_RATE    = 3
+	CN470_FREQUENCY_INCREMENT  = 200000 // between the uplink and downlink channels
+	CN470_UPLINK_CHANNELS      = 96
+	CN470_DOWNLINK_CHANNELS    = 48
+)
+
+var dataRatesCN470 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0, 115},
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0, 242},
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0, 242},
+}
+
+type ChannelCN struct {
+	channel
+}
+
+func (c *ChannelCN) Next() bool {
+	return false
+}
+
+type SettingsCN470 struct {
+	settings
+}
+
+func CN470() *SettingsCN470 {
+	channels := make([]planChannel, CN470_UPLINK_CHANNELS)
+	for i := range channels {
+		channels[i] = planChannel{lora.MHz_470_3 + uint32(i)*CN470_FREQUENCY_INCREMENT, 0, 5, true, true}
+	}
+
+	return &SettingsCN470{settings: settings{
+		joinRequestChannel: &ChannelCN{channel: channel{lora.MHz_470_3,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			CN470_DEFAULT_PREAMBLE_LEN,
+			CN470_DEFAULT_TX_POWER_DBM}},
+		joinAcceptChannel: &ChannelCN{channel: channel{lora.MHz_500_3,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			CN470_DEFAULT_PREAMBLE_LEN,
+			CN470_DEFAULT_TX_POWER_DBM}},
+		uplinkChannel: &ChannelCN{channel: channel{lora.MHz_470_3,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			CN470_DEFAULT_PREAMBLE_LEN,
+			CN470_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelCN{channel: channel{lora.MHz_505_3,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor12,
+			lora.CodingRate4_5,
+			CN470_DEFAULT_PREAMBLE_LEN,
+			CN470_DEFAULT_TX_POWER_DBM}},
+		plan: plan{
+			dataRates:       dataRatesCN470,
+			txPowers:        txPowers(CN470_MAX_EIRP_DBM, 8),
+			channels:        channels,
+			minFrequency:    lora.MHz_470_3,
+			maxFrequency:    lora.MHz_510_0,
+			defaultChannels: CN470_UPLINK_CHANNELS,
+			applyMask:       applyMask96,
+			rx1DataRate:     rx1DataRate16,
+			rx2Frequency:    lora.MHz_505_3,
+			rx2DataRate:     0,
+			dataRate:        CN470_DEFAULT_DATA_RATE,
+		},
+	}}
+}
+
+// RX1Channel returns the downlink channel of the uplink channel, its number
+// modulo 48, with the data rate lowered by the offset.
+func (r *SettingsCN470) RX1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	n := (uplink.Frequency() - lora.MHz_470_3) / CN470_FREQUENCY_INCREMENT
+	dr := r.rx1Modulation(uplink, rx1DROffset)
+	return &ChannelCN{channel: channel{lora.MHz_500_3 + n%CN470_DOWNLINK_CHANNELS*CN470_FREQUENCY_INCREMENT,
+		dr.Bandwidth,
+		dr.SpreadingFactor,
+		lora.CodingRate4_5,
+		CN470_DEFAULT_PREAMBLE_LEN,
+		CN470_DEFAULT_TX_POWER_DBM}}
+}
+
+// applyMask96 applies the masks of the 96 channels of CN470, ChMaskCntl 6
+// enabling all of them.
+func applyMask96(p *plan, enabled []bool, m ChannelMask) bool {
+	switch m.Cntl {
+	case 0, 1, 2, 3, 4, 5:
+		for i := 0; i < 16; i++ {
+			enabled[int(m.Cntl)*16+i] = m.Mask&(1<<i) != 0
+		}
+	case 6:
+		for i := range enabled {
+			enabled[i] = true
+		}
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package region
+
+import "tinygo.org/x/drivers/lora"
+
+const (
+	EU433_DEFAULT_PREAMBLE_LEN = 8
+	EU433_DEFAULT_TX_POWER_DBM = 12
+	EU433_MAX_EIRP_DBM         = 12
+	EU433_DEFAULT_DATA_RATE    = 3
+)
+
+// Sub-band of ETSI EN 300 220
+var bandsEU433 = []Band{
+	{433050000, 434790000, 10},
+}
+
+var dataRatesEU433 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0, 115},
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0, 222},
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0, 222},
+	{lora.SpreadingFactor7, lora.Bandwidth_250_0, 222},
+	{}, // FSK
+}
+
+type SettingsEU433 struct {
+	settings
+}
+
+func EU433() *SettingsEU433 {
+	return &SettingsEU433{settings: settings{
+		joinRequestChannel: &ChannelEU{channel: channel{lora.MHz_433_175,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			EU433_DEFAULT_PREAMBLE_LEN,
+			EU433_DEFAULT_TX_POWER_DBM}},
+		joinAcceptChannel: &ChannelEU{channel: channel{lora.MHz_433_175,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			EU433_DEFAULT_PREAMBLE_LEN,
+			EU433_DEFAULT_TX_POWER_DBM}},
+		uplinkChannel: &ChannelEU{channel: channel{lora.MHz_433_175,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			EU433_DEFAULT_PREAMBLE_LEN,
+			EU433_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelEU{channel: channel{lora.MHz_434_665,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor12,
+			lora.CodingRate4_5,
+			EU433_DEFAULT_PREAMBLE_LEN,
+			EU433_DEFAULT_TX_POWER_DBM}},
+		plan: plan{
+			dataRates: dataRatesEU433,
+			txPowers:  txPowers(EU433_MAX_EIRP_DBM, 6),
+			channels: []planChannel{
+				{lora.MHz_433_175, 0, 5, true, true},
+				{lora.MHz_433_375, 0, 5, true, true},
+				{lora.MHz_433_575, 0, 5, true, true},
+				// up to 16 channels with NewChannelReq
+				{}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {},
+			},
+			minFrequency:    lora.MHz_433_175,
+			maxFrequency:    lora.MHz_434_665,
+			defaultChannels: 3,
+			newChannels:     true,
+			applyMask:       applyMask16,
+			rx1DataRate:     rx1DataRate16,
+			rx2Frequency:    lora.MHz_434_665,
+			rx2DataRate:     0,
+			bands:           bandsEU433,
+			dataRate:        EU433_DEFAULT_DATA_RATE,
+		},
+	}}
+}
+
+// RX1Channel returns the uplink channel, with the data rate lowered by the
+// offset.
+func (r *SettingsEU433) RX1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	dr := r.rx1Modulation(uplink, rx1DROffset)
+	return &ChannelEU{channel: channel{uplink.Frequency(),
+		dr.Bandwidth,
+		dr.SpreadingFactor,
+		lora.CodingRate4_5,
+		EU433_DEFAULT_PREAMBLE_LEN,
+		EU433_DEFAULT_TX_POWER_DBM}}
+}
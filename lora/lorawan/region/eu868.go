@@ -5,8 +5,30 @@ import "tinygo.org/x/drivers/lora"
 const (
 	EU868_DEFAULT_PREAMBLE_LEN = 8
 	EU868_DEFAULT_TX_POWER_DBM = 20
+	EU868_MAX_EIRP_DBM         = 16
+	EU868_DEFAULT_DATA_RATE    = 3
 )
 
+// Sub-bands of ETSI EN 300 220
+var bandsEU868 = []Band{
+	{863000000, 868000000, 100},
+	{868000000, 868600000, 100},
+	{868700000, 869200000, 1000},
+	{869400000, 869650000, 10},
+	{869700000, 870000000, 100},
+}
+
+var dataRatesEU868 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0, 115},
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0, 222},
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0, 222},
+	{lora.SpreadingFactor7, lora.Bandwidth_250_0, 222},
+	{}, // FSK
+}
+
 type ChannelEU struct {
 	channel
 }
@@ -39,5 +61,44 @@ func EU868() *SettingsEU868 {
 			lora.CodingRate4_7,
 			EU868_DEFAULT_PREAMBLE_LEN,
 			EU868_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelEU{channel: channel{lora.MHz_869_5,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor12,
+			lora.CodingRate4_5,
+			EU868_DEFAULT_PREAMBLE_LEN,
+			EU868_DEFAULT_TX_POWER_DBM}},
+		plan: plan{
+			dataRates: dataRatesEU868,
+			txPowers:  txPowers(EU868_MAX_EIRP_DBM, 8),
+			channels: []planChannel{
+				{lora.MHz_868_1, 0, 5, true, true},
+				{lora.MHz_868_3, 0, 5, true, true},
+				{lora.MHz_868_5, 0, 5, true, true},
+				// up to 16 channels with NewChannelReq
+				{}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {},
+			},
+			minFrequency:    lora.MHz_863_0,
+			maxFrequency:    lora.MHz_870_0,
+			defaultChannels: 3,
+			newChannels:     true,
+			applyMask:       applyMask16,
+			rx1DataRate:     rx1DataRate16,
+			rx2Frequency:    lora.MHz_869_5,
+			rx2DataRate:     0,
+			bands:           bandsEU868,
+			dataRate:        EU868_DEFAULT_DATA_RATE,
+		},
 	}}
 }
+
+// RX1Channel returns the uplink channel, with the data rate lowered by the
+// offset.
+func (r *SettingsEU868) RX1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	dr := r.rx1Modulation(uplink, rx1DROffset)
+	return &ChannelEU{channel: channel{uplink.Frequency(),
+		dr.Bandwidth,
+		dr.SpreadingFactor,
+		lora.CodingRate4_5,
+		EU868_DEFAULT_PREAMBLE_LEN,
+		EU868_DEFAULT_TX_POWER_DBM}}
+}
@@ -0,0 +1,100 @@
+package region
+
+import "tinygo.org/x/drivers/lora"
+
+const (
+	IN865_DEFAULT_PREAMBLE_LEN = 8
+	IN865_DEFAULT_TX_POWER_DBM = 20
+	IN865_MAX_EIRP_DBM         = 30
+	IN865_DEFAULT_DATA_RATE    = 3
+)
+
+var dataRatesIN865 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0, 115},
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0, 242},
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0, 242},
+	{}, // RFU
+	{}, // FSK
+}
+
+type ChannelIN struct {
+	channel
+}
+
+func (c *ChannelIN) Next() bool {
+	return false
+}
+
+type SettingsIN865 struct {
+	settings
+}
+
+func IN865() *SettingsIN865 {
+	return &SettingsIN865{settings: settings{
+		joinRequestChannel: &ChannelIN{channel: channel{lora.MHz_865_0625,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			IN865_DEFAULT_PREAMBLE_LEN,
+			IN865_DEFAULT_TX_POWER_DBM}},
+		joinAcceptChannel: &ChannelIN{channel: channel{lora.MHz_865_0625,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			IN865_DEFAULT_PREAMBLE_LEN,
+			IN865_DEFAULT_TX_POWER_DBM}},
+		uplinkChannel: &ChannelIN{channel: channel{lora.MHz_865_0625,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			IN865_DEFAULT_PREAMBLE_LEN,
+			IN865_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelIN{channel: channel{lora.MHz_866_55,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor10,
+			lora.CodingRate4_5,
+			IN865_DEFAULT_PREAMBLE_LEN,
+			IN865_DEFAULT_TX_POWER_DBM}},
+		plan: plan{
+			dataRates: dataRatesIN865,
+			txPowers:  txPowers(IN865_MAX_EIRP_DBM, 11),
+			channels: []planChannel{
+				{lora.MHz_865_0625, 0, 5, true, true},
+				{lora.MHz_865_4025, 0, 5, true, true},
+				{lora.MHz_865_985, 0, 5, true, true},
+				// up to 16 channels with NewChannelReq
+				{}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {},
+			},
+			minFrequency:    lora.MHz_865_0,
+			maxFrequency:    lora.MHz_867_0,
+			defaultChannels: 3,
+			newChannels:     true,
+			applyMask:       applyMask16,
+			rx1DataRate:     rx1DataRateIN865,
+			rx2Frequency:    lora.MHz_866_55,
+			rx2DataRate:     2,
+			dataRate:        IN865_DEFAULT_DATA_RATE,
+		},
+	}}
+}
+
+// RX1Channel returns the uplink channel, with the data rate lowered by the
+// offset.
+func (r *SettingsIN865) RX1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	dr := r.rx1Modulation(uplink, rx1DROffset)
+	return &ChannelIN{channel: channel{uplink.Frequency(),
+		dr.Bandwidth,
+		dr.SpreadingFactor,
+		lora.CodingRate4_5,
+		IN865_DEFAULT_PREAMBLE_LEN,
+		IN865_DEFAULT_TX_POWER_DBM}}
+}
+
+// rx1DataRateIN865 lowers the uplink data rate by the offset, the offsets 6
+// and 7 raising it by 1 and 2, up to DR5.
+func rx1DataRateIN865(p *plan, dr, offset uint8) uint8 {
+	return rx1DataRateRaised(dr, offset, 0, 5)
+}
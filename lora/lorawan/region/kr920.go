@@ -0,0 +1,92 @@
+package region
+
+import "tinygo.org/x/drivers/lora"
+
+const (
+	KR920_DEFAULT_PREAMBLE_LEN = 8
+	KR920_DEFAULT_TX_POWER_DBM = 14
+	KR920_MAX_EIRP_DBM         = 14
+	KR920_DEFAULT_DATA_RATE    = 3
+)
+
+var dataRatesKR920 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0, 51},
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0, 115},
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0, 242},
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0, 242},
+}
+
+type ChannelKR struct {
+	channel
+}
+
+func (c *ChannelKR) Next() bool {
+	return false
+}
+
+type SettingsKR920 struct {
+	settings
+}
+
+func KR920() *SettingsKR920 {
+	return &SettingsKR920{settings: settings{
+		joinRequestChannel: &ChannelKR{channel: channel{lora.MHz_922_1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			KR920_DEFAULT_PREAMBLE_LEN,
+			KR920_DEFAULT_TX_POWER_DBM}},
+		joinAcceptChannel: &ChannelKR{channel: channel{lora.MHz_922_1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			KR920_DEFAULT_PREAMBLE_LEN,
+			KR920_DEFAULT_TX_POWER_DBM}},
+		uplinkChannel: &ChannelKR{channel: channel{lora.MHz_922_1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			KR920_DEFAULT_PREAMBLE_LEN,
+			KR920_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelKR{channel: channel{lora.MHz_921_9,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor12,
+			lora.CodingRate4_5,
+			KR920_DEFAULT_PREAMBLE_LEN,
+			KR920_DEFAULT_TX_POWER_DBM}},
+		plan: plan{
+			dataRates: dataRatesKR920,
+			txPowers:  txPowers(KR920_MAX_EIRP_DBM, 8),
+			channels: []planChannel{
+				{lora.MHz_922_1, 0, 5, true, true},
+				{lora.MHz_922_3, 0, 5, true, true},
+				{lora.MHz_922_5, 0, 5, true, true},
+				// up to 16 channels with NewChannelReq
+				{}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {},
+			},
+			minFrequency:    lora.MHz_920_9,
+			maxFrequency:    lora.MHz_923_3,
+			defaultChannels: 3,
+			newChannels:     true,
+			applyMask:       applyMask16,
+			rx1DataRate:     rx1DataRate16,
+			rx2Frequency:    lora.MHz_921_9,
+			rx2DataRate:     0,
+			dataRate:        KR920_DEFAULT_DATA_RATE,
+		},
+	}}
+}
+
+// RX1Channel returns the uplink channel, with the data rate lowered by the
+// offset.
+func (r *SettingsKR920) RX1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	dr := r.rx1Modulation(uplink, rx1DROffset)
+	return &ChannelKR{channel: channel{uplink.Frequency(),
+		dr.Bandwidth,
+		dr.SpreadingFactor,
+		lora.CodingRate4_5,
+		KR920_DEFAULT_PREAMBLE_LEN,
+		KR920_DEFAULT_TX_POWER_DBM}}
+}
@@ -0,0 +1,426 @@
+package region
+
+import "time"
+
+// Dwell time limit of the regions limiting the time on air
+const DWELL_TIME = 400 * time.Millisecond
+
+// DataRate is the LoRa modulation of a LoRaWAN data rate, and the maximum
+// length of the application payload sent with it.
+type DataRate struct {
+	SpreadingFactor uint8
+	Bandwidth       uint8
+	MaxPayload      uint8
+}
+
+// valid reports if the data rate is defined, the others being RFU or
+// not LoRa.
+func (dr DataRate) valid() bool {
+	return dr.SpreadingFactor != 0
+}
+
+// ChannelMask is the channel mask of a LinkADRReq MAC command, ChMaskCntl
+// selecting the channels ChMask applies to.
+type ChannelMask struct {
+	Cntl uint8
+	Mask uint16
+}
+
+// PlanChannel is an uplink channel of a channel plan.
+type PlanChannel struct {
+	Frequency uint32 // zero if not defined
+	MinDR     uint8
+	MaxDR     uint8
+	Enabled   bool
+	Join      bool // used by the join requests
+}
+
+// planChannel is an uplink channel of the channel plan.
+type planChannel struct {
+	frequency uint32 // zero if not defined
+	minDR     uint8
+	maxDR     uint8
+	enabled   bool
+	join      bool
+}
+
+// plan is the uplink channel plan of a region, modified by the network
+// server with the MAC commands.
+type plan struct {
+	dataRates []DataRate // indexed by data rate
+	txPowers  []int8     // dBm, indexed by LinkADRReq TXPower
+	channels  []planChannel
+
+	// frequency range of the channels, including RX2
+	minFrequency uint32
+	maxFrequency uint32
+
+	// number of default channels, which can't be modified, and if other
+	// channels can be added by NewChannelReq
+	defaultChannels int
+	newChannels     bool
+
+	// applyMask applies a LinkADRReq channel mask to the enabled flags,
+	// reporting false if ChMaskCntl is not supported.
+	applyMask func(p *plan, enabled []bool, m ChannelMask) bool
+
+	// rx1DataRate returns the data rate of the RX1 receive window from the
+	// uplink data rate and the RX1 data rate offset.
+	rx1DataRate func(p *plan, dr, offset uint8) uint8
+
+	// default RX2 receive window
+	rx2Frequency uint32
+	rx2DataRate  uint8
+
+	// dwell time limits of the uplinks and downlinks, which can be changed
+	// by TxParamSetupReq if txParamSetup is set, and the maximum payloads
+	// with the uplink limit
+	uplinkDwell   bool
+	downlinkDwell bool
+	txParamSetup  bool
+	dwellPayloads []uint8
+
+	// sub-bands with a duty cycle limit
+	bands []Band
+
+	dataRate uint8
+	txPower  uint8 // index in txPowers
+	next     int   // next channel, in round robin
+}
+
+// DataRate returns the data rate of the uplinks.
+func (r *settings) DataRate() uint8 {
+	return r.plan.dataRate
+}
+
+// MaxPayload returns the maximum length of the application payload at the
+// data rate of the uplinks.
+func (r *settings) MaxPayload() uint8 {
+	return r.plan.maxPayload(r.plan.dataRate)
+}
+
+// maxPayload returns the maximum length of the application payload at the
+// data rate, with the dwell time limit in force.
+func (p *plan) maxPayload(dr uint8) uint8 {
+	if int(dr) >= len(p.dataRates) {
+		return 0
+	}
+	if p.uplinkDwell && p.dwellPayloads != nil {
+		return p.dwellPayloads[dr]
+	}
+	return p.dataRates[dr].MaxPayload
+}
+
+// usable reports if the data rate can be used by the uplinks, a data rate
+// carrying no payload within the dwell time being unusable.
+func (p *plan) usable(dr uint8) bool {
+	return int(dr) < len(p.dataRates) && p.dataRates[dr].valid() && p.maxPayload(dr) != 0
+}
+
+// Channels returns the uplink channel plan.
+func (r *settings) Channels() []PlanChannel {
+	channels := make([]PlanChannel, len(r.plan.channels))
+	for i, ch := range r.plan.channels {
+		channels[i] = PlanChannel{ch.frequency, ch.minDR, ch.maxDR, ch.enabled, ch.join}
+	}
+	return channels
+}
+
+// RX2Default returns the default frequency and data rate of the RX2 receive
+// window.
+func (r *settings) RX2Default() (frequency uint32, dr uint8) {
+	return r.plan.rx2Frequency, r.plan.rx2DataRate
+}
+
+// TxPowers returns the TX power table, in dBm.
+func (r *settings) TxPowers() []int8 {
+	return r.plan.txPowers
+}
+
+// Bands returns the sub-bands with a duty cycle limit.
+func (r *settings) Bands() []Band {
+	return r.plan.bands
+}
+
+// DwellTime returns the maximum time on air of the uplinks, zero without
+// limit.
+func (r *settings) DwellTime() time.Duration {
+	if r.plan.uplinkDwell {
+		return DWELL_TIME
+	}
+	return 0
+}
+
+// SetTxParams sets the dwell time limits and the maximum EIRP of the
+// TxParamSetupReq MAC command, and reports false if the region does not
+// support it.
+func (r *settings) SetTxParams(uplinkDwell, downlinkDwell bool, maxEIRP int8) bool {
+	p := &r.plan
+	if !p.txParamSetup {
+		return false
+	}
+	p.uplinkDwell = uplinkDwell
+	p.downlinkDwell = downlinkDwell
+	p.txPowers = txPowers(maxEIRP, len(p.txPowers))
+	r.uplinkChannel.SetTxPowerDBm(p.txPowers[p.txPower])
+	return true
+}
+
+// SetDataRate sets the data rate of the uplinks, if an enabled channel
+// supports it.
+func (r *settings) SetDataRate(dr uint8) bool {
+	p := &r.plan
+	if !p.usable(dr) {
+		return false
+	}
+	for _, ch := range p.channels {
+		if ch.enabled && ch.frequency != 0 && dr >= ch.minDR && dr <= ch.maxDR {
+			p.dataRate = dr
+			return true
+		}
+	}
+	return false
+}
+
+// ADRBackoff steps back to a more robust uplink configuration: first the
+// maximum TX power, then a lower data rate, and finally all the default
+// channels enabled. It reports false when there is nothing left to change.
+func (r *settings) ADRBackoff() bool {
+	p := &r.plan
+	if p.txPower != 0 && len(p.txPowers) > 0 {
+		p.txPower = 0
+		r.uplinkChannel.SetTxPowerDBm(p.txPowers[0])
+		return true
+	}
+	for dr := int(p.dataRate) - 1; dr >= 0; dr-- {
+		if r.SetDataRate(uint8(dr)) {
+			return true
+		}
+	}
+	changed := false
+	for i := 0; i < p.defaultChannels && i < len(p.channels); i++ {
+		if !p.channels[i].enabled && p.channels[i].frequency != 0 {
+			p.channels[i].enabled = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// rx1Modulation returns the modulation of the RX1 receive window answering
+// an uplink sent on the channel.
+func (r *settings) rx1Modulation(uplink Channel, rx1DROffset uint8) DataRate {
+	p := &r.plan
+	dr := uint8(0)
+	for i, d := range p.dataRates {
+		// the lowest match, the uplink data rates coming first
+		if d.SpreadingFactor == uplink.SpreadingFactor() && d.Bandwidth == uplink.Bandwidth() {
+			dr = uint8(i)
+			break
+		}
+	}
+	return p.dataRates[p.rx1DataRate(p, dr, rx1DROffset)]
+}
+
+// nextChannel sets the uplink channel to the next enabled channel supporting
+// the data rate, and its modulation to the data rate.
+func (r *settings) nextChannel() {
+	p := &r.plan
+	if len(p.channels) == 0 {
+		return
+	}
+	for i := range p.channels {
+		n := (p.next + i) % len(p.channels)
+		ch := &p.channels[n]
+		if ch.enabled && ch.frequency != 0 && p.dataRate >= ch.minDR && p.dataRate <= ch.maxDR {
+			dr := p.dataRates[p.dataRate]
+			r.uplinkChannel.SetFrequency(ch.frequency)
+			r.uplinkChannel.SetSpreadingFactor(dr.SpreadingFactor)
+			r.uplinkChannel.SetBandwidth(dr.Bandwidth)
+			p.next = n + 1
+			return
+		}
+	}
+}
+
+// LinkADR applies the data rate, TX power index and channel masks of a block
+// of LinkADRReq MAC commands, 0xF keeping the data rate or TX power. Nothing
+// is applied unless all of them are accepted.
+func (r *settings) LinkADR(dr, txPower uint8, masks []ChannelMask) (chMaskOK, drOK, powerOK bool) {
+	p := &r.plan
+	if len(p.channels) == 0 {
+		return false, false, false
+	}
+
+	enabled := make([]bool, len(p.channels))
+	for i, ch := range p.channels {
+		enabled[i] = ch.enabled
+	}
+	chMaskOK = true
+	for _, m := range masks {
+		if !p.applyMask(p, enabled, m) {
+			chMaskOK = false
+		}
+	}
+	some := false
+	for i, on := range enabled {
+		if on && p.channels[i].frequency == 0 {
+			// Undefined channels can't be enabled
+			chMaskOK = false
+		}
+		some = some || on
+	}
+	chMaskOK = chMaskOK && some
+
+	if dr == 0xF {
+		dr = p.dataRate
+	}
+	drOK = false
+	if p.usable(dr) {
+		for i, ch := range p.channels {
+			if enabled[i] && dr >= ch.minDR && dr <= ch.maxDR {
+				drOK = true
+				break
+			}
+		}
+	}
+
+	powerOK = txPower == 0xF || int(txPower) < len(p.txPowers)
+
+	if !chMaskOK || !drOK || !powerOK {
+		return
+	}
+	for i := range p.channels {
+		p.channels[i].enabled = enabled[i]
+	}
+	p.dataRate = dr
+	if txPower != 0xF {
+		p.txPower = txPower
+		r.uplinkChannel.SetTxPowerDBm(p.txPowers[txPower])
+	}
+	return
+}
+
+// SetChannel creates, modifies or disables (zero frequency) an uplink channel
+// for the NewChannelReq MAC command.
+func (r *settings) SetChannel(index uint8, frequency uint32, minDR, maxDR uint8) (freqOK, drOK bool) {
+	p := &r.plan
+	if !p.newChannels || int(index) < p.defaultChannels || int(index) >= len(p.channels) {
+		return false, false
+	}
+
+	freqOK = frequency == 0 || frequency >= p.minFrequency && frequency <= p.maxFrequency
+	drOK = minDR <= maxDR && int(maxDR) < len(p.dataRates) &&
+		p.dataRates[minDR].valid() && p.dataRates[maxDR].valid()
+	if !freqOK || !drOK {
+		return
+	}
+
+	p.channels[index] = planChannel{
+		frequency: frequency,
+		minDR:     minDR,
+		maxDR:     maxDR,
+		enabled:   frequency != 0,
+	}
+	return
+}
+
+// SetRX2 sets the frequency, unchanged if zero, and the data rate of the RX2
+// receive window, for the RXParamSetupReq MAC command and the Join Accept.
+func (r *settings) SetRX2(frequency uint32, dr uint8) (freqOK, drOK bool) {
+	p := &r.plan
+	freqOK = frequency == 0 || frequency >= p.minFrequency && frequency <= p.maxFrequency
+	drOK = int(dr) < len(p.dataRates) && p.dataRates[dr].valid()
+	if !freqOK || !drOK {
+		return
+	}
+
+	if frequency != 0 {
+		r.rx2Channel.SetFrequency(frequency)
+	}
+	r.rx2Channel.SetSpreadingFactor(p.dataRates[dr].SpreadingFactor)
+	r.rx2Channel.SetBandwidth(p.dataRates[dr].Bandwidth)
+	return
+}
+
+// applyMask16 applies the masks of the regions with up to 16 channels,
+// ChMaskCntl 6 enabling all the defined channels.
+func applyMask16(p *plan, enabled []bool, m ChannelMask) bool {
+	switch m.Cntl {
+	case 0:
+		for i := range enabled {
+			enabled[i] = m.Mask&(1<<i) != 0
+		}
+	case 6:
+		for i := range enabled {
+			enabled[i] = p.channels[i].frequency != 0
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// applyMask72 applies the masks of the regions with 64 channels of 125 kHz and
+// 8 channels of 500 kHz.
+func applyMask72(p *plan, enabled []bool, m ChannelMask) bool {
+	switch m.Cntl {
+	case 0, 1, 2, 3, 4:
+		for i := 0; i < 16; i++ {
+			if n := int(m.Cntl)*16 + i; n < len(enabled) {
+				enabled[n] = m.Mask&(1<<i) != 0
+			}
+		}
+	case 5:
+		// Each bit enables a sub-band of 8 channels of 125 kHz, and the
+		// 500 kHz channel of the same sub-band
+		for i := 0; i < 64; i++ {
+			enabled[i] = m.Mask&(1<<(i/8)) != 0
+			enabled[64+i/8] = enabled[i]
+		}
+	case 6, 7:
+		// All the 125 kHz channels on or off, the mask applying to the
+		// 500 kHz channels
+		for i := 0; i < 64; i++ {
+			enabled[i] = m.Cntl == 6
+		}
+		for i := 0; i < 8; i++ {
+			enabled[64+i] = m.Mask&(1<<i) != 0
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// rx1DataRate16 lowers the uplink data rate by the offset, down to DR0.
+func rx1DataRate16(p *plan, dr, offset uint8) uint8 {
+	if offset >= dr {
+		return 0
+	}
+	return dr - offset
+}
+
+// txPowers returns the TX power table from the maximum power, in steps of
+// 2 dB.
+func txPowers(max int8, n int) []int8 {
+	powers := make([]int8, n)
+	for i := range powers {
+		powers[i] = max - 2*int8(i)
+	}
+	return powers
+}
+
+// channels72 returns the 64 channels of 125 kHz from base125 with the data
+// rates 0 to max125, and the 8 channels of 500 kHz from base500 with the data
+// rate dr500.
+func channels72(base125, base500 uint32, max125, dr500 uint8) []planChannel {
+	channels := make([]planChannel, 72)
+	for i := 0; i < 64; i++ {
+		channels[i] = planChannel{base125 + uint32(i)*US915_FREQUENCY_INCREMENT_DR_0, 0, max125, true, true}
+	}
+	for i := 0; i < 8; i++ {
+		channels[64+i] = planChannel{base500 + uint32(i)*US915_FREQUENCY_INCREMENT_DR_4, dr500, dr500, true, true}
+	}
+	return channels
+}
@@ -1,15 +1,83 @@
 package region
 
+import "time"
+
 type Settings interface {
 	JoinRequestChannel() Channel
 	JoinAcceptChannel() Channel
 	UplinkChannel() Channel
+
+	// UplinkChannelIndex returns the index in the channel plan of the last
+	// channel returned by UplinkChannel.
+	UplinkChannelIndex() uint8
+
+	// Channels returns the uplink channel plan, the join requests using the
+	// channels of the join channel mask.
+	Channels() []PlanChannel
+
+	// RX2Default returns the default frequency and data rate of the RX2
+	// receive window.
+	RX2Default() (frequency uint32, dr uint8)
+
+	// TxPowers returns the TX power table, in dBm, indexed by the TXPower of
+	// the LinkADRReq MAC command.
+	TxPowers() []int8
+
+	// Bands returns the sub-bands with a duty cycle limit, to be scheduled
+	// with a DutyCycle.
+	Bands() []Band
+
+	// DwellTime returns the maximum time on air of the uplinks, zero without
+	// limit.
+	DwellTime() time.Duration
+
+	// SetTxParams applies a TxParamSetupReq MAC command, and reports false
+	// if the region does not support it.
+	SetTxParams(uplinkDwell, downlinkDwell bool, maxEIRP int8) bool
+
+	// RX1Channel returns the channel of the first receive window opened
+	// after an uplink sent on the given channel, with the data rate lowered
+	// by rx1DROffset.
+	RX1Channel(uplink Channel, rx1DROffset uint8) Channel
+
+	// RX2Channel returns the channel of the second receive window.
+	RX2Channel() Channel
+
+	// DataRate returns the data rate of the uplinks.
+	DataRate() uint8
+
+	// SetDataRate sets the data rate of the uplinks, and reports if an
+	// enabled channel supports it.
+	SetDataRate(dr uint8) bool
+
+	// MaxPayload returns the maximum length of the application payload at
+	// the data rate of the uplinks.
+	MaxPayload() uint8
+
+	// ADRBackoff steps back to a more robust uplink configuration when the
+	// network server does not answer, and reports false when there is
+	// nothing left to change.
+	ADRBackoff() bool
+
+	// LinkADR applies a block of LinkADRReq MAC commands to the channel
+	// plan, and returns their status.
+	LinkADR(dr, txPower uint8, masks []ChannelMask) (chMaskOK, drOK, powerOK bool)
+
+	// SetChannel applies a NewChannelReq MAC command to the channel plan,
+	// and returns its status.
+	SetChannel(index uint8, frequency uint32, minDR, maxDR uint8) (freqOK, drOK bool)
+
+	// SetRX2 changes the RX2 channel, and returns the status of the change.
+	SetRX2(frequency uint32, dr uint8) (freqOK, drOK bool)
 }
 
 type settings struct {
 	joinRequestChannel Channel
 	joinAcceptChannel  Channel
 	uplinkChannel      Channel
+	rx2Channel         Channel
+
+	plan plan
 }
 
 func (r *settings) JoinRequestChannel() Channel {
@@ -20,6 +88,20 @@ func (r *settings) JoinAcceptChannel() Channel {
 	return r.joinAcceptChannel
 }
 
+// UplinkChannel returns the channel of the next uplink, the enabled channels
+// of the channel plan being used in turn.
 func (r *settings) UplinkChannel() Channel {
+	r.nextChannel()
 	return r.uplinkChannel
 }
+
+func (r *settings) UplinkChannelIndex() uint8 {
+	if r.plan.next == 0 {
+		return 0
+	}
+	return uint8(r.plan.next - 1)
+}
+
+func (r *settings) RX2Channel() Channel {
+	return r.rx2Channel
+}
@@ -7,8 +7,26 @@ const (
 	US915_DEFAULT_TX_POWER_DBM     = 20
 	US915_FREQUENCY_INCREMENT_DR_0 = 200000  // only for 125 kHz Bandwidth
 	US915_FREQUENCY_INCREMENT_DR_4 = 1600000 // only for 500 kHz Bandwidth
+	US915_DOWNLINK_INCREMENT       = 600000  // between the 8 downlink channels
+	US915_MAX_EIRP_DBM             = 30
+	US915_DEFAULT_DATA_RATE        = 4
 )
 
+var dataRatesUS915 = []DataRate{
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0, 11},
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0, 53},
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0, 125},
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0, 242},
+	{lora.SpreadingFactor8, lora.Bandwidth_500_0, 242},
+	{}, {}, {}, // RFU
+	{lora.SpreadingFactor12, lora.Bandwidth_500_0, 53},
+	{lora.SpreadingFactor11, lora.Bandwidth_500_0, 129},
+	{lora.SpreadingFactor10, lora.Bandwidth_500_0, 242},
+	{lora.SpreadingFactor9, lora.Bandwidth_500_0, 242},
+	{lora.SpreadingFactor8, lora.Bandwidth_500_0, 242},
+	{lora.SpreadingFactor7, lora.Bandwidth_500_0, 242},
+}
+
 type ChannelUS struct {
 	channel
 }
@@ -78,5 +96,66 @@ func US915() *SettingsUS915 {
 			lora.CodingRate4_5,
 			US915_DEFAULT_PREAMBLE_LEN,
 			US915_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelUS{channel: channel{lora.MHz_923_3,
+			lora.Bandwidth_500_0,
+			lora.SpreadingFactor12,
+			lora.CodingRate4_5,
+			US915_DEFAULT_PREAMBLE_LEN,
+			US915_DEFAULT_TX_POWER_DBM}},
+		plan: plan{
+			dataRates:       dataRatesUS915,
+			txPowers:        txPowers(US915_MAX_EIRP_DBM, 15),
+			channels:        channels72(lora.MHz_902_3, lora.Mhz_903_0, 3, 4),
+			minFrequency:    lora.MHz_902_0,
+			maxFrequency:    lora.MHz_928_0,
+			defaultChannels: 72,
+			applyMask:       applyMask72,
+			rx1DataRate:     rx1DataRateUS915,
+			rx2Frequency:    lora.MHz_923_3,
+			rx2DataRate:     8,
+			uplinkDwell:     true,
+			dataRate:        US915_DEFAULT_DATA_RATE,
+		},
 	}}
 }
+
+// RX1Channel returns the 500 kHz downlink channel of the uplink channel, its
+// number modulo 8, with the data rate lowered by the offset.
+func (r *SettingsUS915) RX1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	n := uplinkChannelNumber(uplink, lora.MHz_902_3, lora.Mhz_903_0)
+	dr := r.rx1Modulation(uplink, rx1DROffset)
+	return &ChannelUS{channel: channel{lora.MHz_923_3 + uint32(n%8)*US915_DOWNLINK_INCREMENT,
+		dr.Bandwidth,
+		dr.SpreadingFactor,
+		lora.CodingRate4_5,
+		US915_DEFAULT_PREAMBLE_LEN,
+		US915_DEFAULT_TX_POWER_DBM}}
+}
+
+// uplinkChannelNumber returns the number of an uplink channel in the US915
+// and AU915 plans: 64 channels of 125 kHz from base125, then 8 channels of
+// 500 kHz from base500.
+func uplinkChannelNumber(uplink Channel, base125, base500 uint32) uint32 {
+	if uplink.Bandwidth() == lora.Bandwidth_500_0 {
+		return 64 + (uplink.Frequency()-base500)/US915_FREQUENCY_INCREMENT_DR_4
+	}
+	return (uplink.Frequency() - base125) / US915_FREQUENCY_INCREMENT_DR_0
+}
+
+// rx1DataRateUS915 returns the RX1 data rate, from DR10 for DR0 to DR13,
+// lowered by the offset down to DR8.
+func rx1DataRateUS915(p *plan, dr, offset uint8) uint8 {
+	return rx1DataRate72(10+dr, offset)
+}
+
+// rx1DataRate72 lowers the downlink data rate, at most DR13, by the offset
+// down to DR8.
+func rx1DataRate72(dr, offset uint8) uint8 {
+	if dr > 13 {
+		dr = 13
+	}
+	if offset >= dr-8 {
+		return 8
+	}
+	return dr - offset
+}
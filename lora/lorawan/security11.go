@@ -0,0 +1,141 @@
+package lorawan
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+)
+
+const (
+	// Minor version of LoRaWAN 1.1, in the RekeyInd MAC command
+	lorawanMinor11 = 0x01
+
+	// OptNeg bit of the DLSettings, set by a LoRaWAN 1.1 join server
+	dlSettingsOptNeg = 0x80
+
+	// JoinReqType of the Join-accept MIC, the Rejoin requests using their
+	// type
+	joinRequestType = 0xFF
+)
+
+// Key derivation prefixes of LoRaWAN 1.1
+const (
+	prefixFNwkSIntKey = 0x01
+	prefixAppSKey     = 0x02
+	prefixSNwkSIntKey = 0x03
+	prefixNwkSEncKey  = 0x04
+	prefixJSEncKey    = 0x05
+	prefixJSIntKey    = 0x06
+)
+
+// deriveKey returns aes128_encrypt(key, prefix | fields | pad16), the key
+// derivation of LoRaWAN.
+func deriveKey(key [16]uint8, prefix uint8, fields ...[]uint8) [16]uint8 {
+	var block [16]uint8
+	block[0] = prefix
+	n := 1
+	for _, f := range fields {
+		n += copy(block[n:], f)
+	}
+	cipher, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	var derived [16]uint8
+	cipher.Encrypt(derived[:], block[:])
+	return derived
+}
+
+// cmac4 returns the 4 first bytes of the AES-CMAC of the blocks.
+func cmac4(key [16]uint8, blocks ...[]uint8) [4]uint8 {
+	hash, _ := NewCmac(key[:])
+	for _, b := range blocks {
+		hash.Write(b)
+	}
+	var mic [4]uint8
+	copy(mic[:], hash.Sum(nil))
+	return mic
+}
+
+// micBlock returns the B0 or B1 block of the MIC of a data message.
+func micBlock(b1to4 [4]uint8, dir uint8, devAddr [4]uint8, fCnt uint32, msgLen int) []uint8 {
+	b := make([]uint8, 16)
+	b[0] = 0x49
+	copy(b[1:5], b1to4[:])
+	b[5] = dir
+	copy(b[6:10], devAddr[:])
+	binary.LittleEndian.PutUint32(b[10:14], fCnt)
+	b[15] = uint8(msgLen)
+	return b
+}
+
+// uplinkMIC11 returns the LoRaWAN 1.1 MIC of an uplink: 2 bytes of the
+// SNwkSIntKey CMAC, with the acknowledged downlink counter and the data rate
+// and channel of the transmission, and 2 bytes of the FNwkSIntKey CMAC.
+func (s *Session) uplinkMIC11(msg []uint8, fCnt uint32, confFCnt uint16, txDR, txCh uint8) [4]uint8 {
+	b0 := micBlock([4]uint8{}, 0, s.DevAddr, fCnt, len(msg))
+	var b1to4 [4]uint8
+	binary.LittleEndian.PutUint16(b1to4[:], confFCnt)
+	b1to4[2] = txDR
+	b1to4[3] = txCh
+	b1 := micBlock(b1to4, 0, s.DevAddr, fCnt, len(msg))
+
+	cmacS := cmac4(s.SNwkSIntKey, b1, msg)
+	cmacF := cmac4(s.NwkSKey, b0, msg)
+	return [4]uint8{cmacS[0], cmacS[1], cmacF[0], cmacF[1]}
+}
+
+// downlinkMIC11 returns the LoRaWAN 1.1 MIC of a downlink, with the
+// acknowledged uplink counter.
+func (s *Session) downlinkMIC11(msg []uint8, fCnt uint32, confFCnt uint16) [4]uint8 {
+	var b1to4 [4]uint8
+	binary.LittleEndian.PutUint16(b1to4[:], confFCnt)
+	return cmac4(s.SNwkSIntKey, micBlock(b1to4, 1, s.DevAddr, fCnt, len(msg)), msg)
+}
+
+// encryptFOpts encrypts or decrypts the FOpts of LoRaWAN 1.1 with the
+// NwkSEncKey, aFCnt telling if the frame counter is AFCntDown.
+func (s *Session) encryptFOpts(fOpts []uint8, dir uint8, fCnt uint32, aFCnt bool) []uint8 {
+	var a [16]uint8
+	a[0] = 0x01
+	a[4] = 0x01
+	if aFCnt {
+		a[4] = 0x02
+	}
+	a[5] = dir
+	copy(a[6:10], s.DevAddr[:])
+	binary.LittleEndian.PutUint32(a[10:14], fCnt)
+	a[15] = 0x01
+
+	cipher, err := aes.NewCipher(s.NwkSEncKey[:])
+	if err != nil {
+		panic(err)
+	}
+	var ss [16]uint8
+	cipher.Encrypt(ss[:], a[:])
+	out := make([]uint8, len(fOpts))
+	for i := range fOpts {
+		out[i] = fOpts[i] ^ ss[i]
+	}
+	return out
+}
+
+// signUplink replaces the MIC of the last uplink generated for the data rate
+// and channel index of its transmission, which are part of the LoRaWAN 1.1
+// MIC.
+func (s *Session) signUplink(phyPload []uint8, txDR, txCh uint8) {
+	if !s.LoRaWAN11 || len(phyPload) < 4 {
+		return
+	}
+	msg := phyPload[:len(phyPload)-4]
+	mic := s.uplinkMIC11(msg, s.uplinkFCnt, s.uplinkConfFCnt, txDR, txCh)
+	copy(phyPload[len(msg):], mic[:])
+}
+
+// nwkSEncKey returns the key of the MAC commands of port 0, the NwkSKey of
+// LoRaWAN 1.0.
+func (s *Session) nwkSEncKey() [16]uint8 {
+	if s.LoRaWAN11 {
+		return s.NwkSEncKey
+	}
+	return s.NwkSKey
+}
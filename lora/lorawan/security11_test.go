@@ -0,0 +1,327 @@
+package lorawan
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// The blocks below are built field by field as of the LoRaWAN 1.1
+// specification, independently of the device code.
+
+var (
+	testNwkKey  = [16]uint8{0x2B, 0x7E, 0x15, 0x16, 0x28, 0xAE, 0xD2, 0xA6, 0xAB, 0xF7, 0x15, 0x88, 0x09, 0xCF, 0x4F, 0x3C}
+	testAppKey  = [16]uint8{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+	testDevEUI  = []uint8{0x00, 0x04, 0xA3, 0x0B, 0x00, 0x1C, 0x05, 0x30}
+	testJoinEUI = []uint8{0x70, 0xB3, 0xD5, 0x7E, 0xD0, 0x00, 0x00, 0x01}
+)
+
+func aesEncrypt(key [16]uint8, block []uint8) [16]uint8 {
+	c, _ := aes.NewCipher(key[:])
+	var out [16]uint8
+	c.Encrypt(out[:], block)
+	return out
+}
+
+func test11Otaa() *Otaa {
+	o := &Otaa{}
+	o.Init()
+	o.Set(testJoinEUI, testDevEUI, testAppKey[:])
+	o.SetNwkKey(testNwkKey[:])
+	return o
+}
+
+// encodeJoinAccept11 builds a Join-accept as a LoRaWAN 1.1 join server does,
+// for the Join request of o.
+func encodeJoinAccept11(o *Otaa, joinNonce uint32, dlSettings uint8) []uint8 {
+	devEUI := reverseBytes(testDevEUI)
+	jsIntKey := aesEncrypt(testNwkKey, append([]uint8{0x06}, append(devEUI, make([]uint8, 7)...)...))
+
+	fields := []uint8{uint8(joinNonce), uint8(joinNonce >> 8), uint8(joinNonce >> 16)}
+	fields = append(fields, 0x13, 0x00, 0x00)       // NetID
+	fields = append(fields, 0x04, 0x03, 0x02, 0x01) // DevAddr
+	fields = append(fields, dlSettings, 0x01)       // DLSettings, RxDelay
+	micData := []uint8{0xFF}                        // JoinReqType
+	micData = append(micData, reverseBytes(testJoinEUI)...)
+	micData = append(micData, o.devNonce[:]...)
+	micData = append(micData, 0x20)
+	micData = append(micData, fields...)
+	mic := genPayloadMIC(micData, jsIntKey)
+	plain := append(fields, mic[:]...)
+
+	// The join server encrypts with the AES decryption
+	c, _ := aes.NewCipher(testNwkKey[:])
+	msg := make([]uint8, 17)
+	msg[0] = 0x20
+	c.Decrypt(msg[1:], plain)
+	return msg
+}
+
+func TestJoinAccept11(t *testing.T) {
+	o := test11Otaa()
+	req, _ := o.GenerateJoinRequest()
+	if mic := genPayloadMIC(req[:19], testNwkKey); !bytes.Equal(req[19:], mic[:]) {
+		t.Errorf("Join request not signed with the NwkKey")
+	}
+
+	s := &Session{}
+	if err := o.DecodeJoinAccept(encodeJoinAccept11(o, 5, 0x80), s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.LoRaWAN11 || !s.rekeyPending {
+		t.Fatal("expected a LoRaWAN 1.1 session")
+	}
+
+	// Keys = aes128_encrypt(key, prefix | JoinNonce | JoinEUI | DevNonce | pad16)
+	block := []uint8{0, 5, 0, 0}
+	block = append(block, reverseBytes(testJoinEUI)...)
+	block = append(block, o.devNonce[:]...)
+	block = append(block, 0x00, 0x00)
+	for _, k := range []struct {
+		name   string
+		got    [16]uint8
+		root   [16]uint8
+		prefix uint8
+	}{
+		{"FNwkSIntKey", s.NwkSKey, testNwkKey, 0x01},
+		{"AppSKey", s.AppSKey, testAppKey, 0x02},
+		{"SNwkSIntKey", s.SNwkSIntKey, testNwkKey, 0x03},
+		{"NwkSEncKey", s.NwkSEncKey, testNwkKey, 0x04},
+	} {
+		block[0] = k.prefix
+		if want := aesEncrypt(k.root, block); k.got != want {
+			t.Errorf("%s %x, expected %x", k.name, k.got, want)
+		}
+	}
+
+	// A replayed Join-accept is rejected
+	if err := o.DecodeJoinAccept(encodeJoinAccept11(o, 5, 0x80), &Session{}); err != ErrInvalidJoinNonce {
+		t.Errorf("expected ErrInvalidJoinNonce, got %v", err)
+	}
+}
+
+func TestJoinAccept11Fallback(t *testing.T) {
+	// A LoRaWAN 1.0 network server: the Join-accept is signed and the keys
+	// derived with the NwkKey, as of LoRaWAN 1.0
+	o := test11Otaa()
+	o.GenerateJoinRequest()
+	plain := []uint8{0x01, 0x00, 0x00, 0x13, 0x00, 0x00, 0x04, 0x03, 0x02, 0x01, 0x00, 0x01}
+	mic := genPayloadMIC(append([]uint8{0x20}, plain...), testNwkKey)
+	plain = append(plain, mic[:]...)
+	c, _ := aes.NewCipher(testNwkKey[:])
+	msg := make([]uint8, 17)
+	msg[0] = 0x20
+	c.Decrypt(msg[1:], plain)
+
+	s := &Session{}
+	if err := o.DecodeJoinAccept(msg, s); err != nil {
+		t.Fatal(err)
+	}
+	block := append([]uint8{0x01}, plain[0:6]...)
+	block = append(block, o.devNonce[:]...)
+	block = append(block, make([]uint8, 7)...)
+	if s.LoRaWAN11 || s.NwkSKey != aesEncrypt(testNwkKey, block) {
+		t.Errorf("unexpected LoRaWAN 1.0 session %+v", s)
+	}
+}
+
+func test11Session() *Session {
+	s := testSession()
+	s.LoRaWAN11 = true
+	s.SNwkSIntKey = [16]uint8{0x33, 0x33, 0x33, 0x33, 0x33, 0x33, 0x33, 0x33, 0x33, 0x33, 0x33, 0x33, 0x33, 0x33, 0x33, 0x33}
+	s.NwkSEncKey = [16]uint8{0x44, 0x44, 0x44, 0x44, 0x44, 0x44, 0x44, 0x44, 0x44, 0x44, 0x44, 0x44, 0x44, 0x44, 0x44, 0x44}
+	return s
+}
+
+func TestUplink11(t *testing.T) {
+	s := test11Session()
+	s.FCntUp = 0x10002
+	s.ackDownlink = true
+	s.confFCntDown = 0x0107
+	s.rekeyPending = true
+
+	phy, err := s.GenMessage(0, []uint8("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.signUplink(phy, 3, 5)
+
+	// FOpts encrypted with A = 0x01 | 4*0x00 ... as of the errata: the
+	// fifth byte is 0x01 for the uplinks
+	a := []uint8{0x01, 0, 0, 0, 0x01, 0x00, 0x01, 0x02, 0x03, 0x04, 0x02, 0x00, 0x01, 0x00, 0x00, 0x01}
+	ss := aesEncrypt(s.NwkSEncKey, a)
+	fOpts := []uint8{phy[8] ^ ss[0], phy[9] ^ ss[1]}
+	if phy[5]&fCtrlFOptsLen != 2 || !bytes.Equal(fOpts, []uint8{cidRekey, 0x01}) {
+		t.Errorf("unexpected FOpts %x", fOpts)
+	}
+
+	// MIC = cmacS[0..1] | cmacF[0..1]
+	msg := phy[:len(phy)-4]
+	b0 := []uint8{0x49, 0, 0, 0, 0, 0x00, 0x01, 0x02, 0x03, 0x04, 0x02, 0x00, 0x01, 0x00, 0x00, uint8(len(msg))}
+	b1 := []uint8{0x49, 0x07, 0x01, 3, 5, 0x00, 0x01, 0x02, 0x03, 0x04, 0x02, 0x00, 0x01, 0x00, 0x00, uint8(len(msg))}
+	cmacF := genPayloadMIC(append(b0, msg...), s.NwkSKey)
+	cmacS := genPayloadMIC(append(b1, msg...), s.SNwkSIntKey)
+	if want := []uint8{cmacS[0], cmacS[1], cmacF[0], cmacF[1]}; !bytes.Equal(phy[len(msg):], want) {
+		t.Errorf("MIC %x, expected %x", phy[len(msg):], want)
+	}
+}
+
+// encodeDownlink11 builds a LoRaWAN 1.1 downlink as the network server does.
+func encodeDownlink11(s *Session, fCtrl uint8, fCnt uint32, confFCnt uint16, fOpts []uint8, fPort uint8, payload []uint8) []uint8 {
+	buf := []uint8{mTypeUnconfirmedDataDown}
+	buf = append(buf, s.DevAddr[:]...)
+	buf = append(buf, fCtrl|uint8(len(fOpts)), uint8(fCnt), uint8(fCnt>>8))
+	if len(fOpts) > 0 {
+		a := []uint8{0x01, 0, 0, 0, 0x01, 0x01, 0x01, 0x02, 0x03, 0x04, 0, 0, 0, 0, 0x00, 0x01}
+		if payload != nil && fPort != 0 {
+			a[4] = 0x02
+		}
+		binary.LittleEndian.PutUint32(a[10:], fCnt)
+		ss := aesEncrypt(s.NwkSEncKey, a)
+		for i, b := range fOpts {
+			buf = append(buf, b^ss[i])
+		}
+	}
+	if payload != nil {
+		frm, _ := s.genFRMPayload(s.AppSKey, 1, fCnt, payload, false)
+		buf = append(buf, fPort)
+		buf = append(buf, frm...)
+	}
+	b0 := []uint8{0x49, uint8(confFCnt), uint8(confFCnt >> 8), 0, 0, 0x01, 0x01, 0x02, 0x03, 0x04, 0, 0, 0, 0, 0x00, uint8(len(buf))}
+	binary.LittleEndian.PutUint32(b0[10:], fCnt)
+	mic := genPayloadMIC(append(b0, buf...), s.SNwkSIntKey)
+	return append(buf, mic[:]...)
+}
+
+func TestDownlink11(t *testing.T) {
+	s := test11Session()
+	s.rekeyPending = true
+	s.FCntDown = 3
+	s.confFCntUp = 0x0042
+
+	// an application downlink acknowledging the confirmed uplink, counted
+	// by AFCntDown, with a RekeyConf
+	dl, err := s.DecodeDownlink(encodeDownlink11(s, fCtrlACK, 0, 0x0042, []uint8{cidRekey, 0x01}, 2, []uint8("on")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dl.Payload) != "on" || !bytes.Equal(dl.FOpts, []uint8{cidRekey, 0x01}) {
+		t.Errorf("unexpected downlink %+v", dl)
+	}
+	if s.AFCntDown != 1 || s.FCntDown != 3 {
+		t.Errorf("AFCntDown %d, NFCntDown %d", s.AFCntDown, s.FCntDown)
+	}
+	handleMACCommands(s, dl.FOpts)
+	if s.rekeyPending {
+		t.Error("RekeyConf not applied")
+	}
+
+	// MAC commands only, counted by NFCntDown
+	if _, err := s.DecodeDownlink(encodeDownlink11(s, 0, 3, 0, []uint8{cidDutyCycle, 0x01}, 0, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if s.AFCntDown != 1 || s.FCntDown != 4 {
+		t.Errorf("AFCntDown %d, NFCntDown %d", s.AFCntDown, s.FCntDown)
+	}
+}
+
+func TestRejoinRequest(t *testing.T) {
+	o := test11Otaa()
+	if _, err := o.GenerateRejoinRequest(testSession(), 0); err != ErrLoRaWAN11Required {
+		t.Errorf("expected ErrLoRaWAN11Required, got %v", err)
+	}
+
+	s := test11Session()
+	s.rjCount0 = 7
+	o.SetNetID([]uint8{0x13, 0x00, 0x00})
+	req, err := o.GenerateRejoinRequest(s, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint8{0xC0, 0x02, 0x13, 0x00, 0x00}
+	want = append(want, reverseBytes(testDevEUI)...)
+	want = append(want, 0x07, 0x00)
+	mic := genPayloadMIC(want, s.SNwkSIntKey)
+	want = append(want, mic[:]...)
+	if !bytes.Equal(req, want) || s.rjCount0 != 8 {
+		t.Errorf("Rejoin request %x, expected %x", req, want)
+	}
+
+	if _, err := o.GenerateRejoinRequest(s, 3); err != ErrInvalidRejoinType {
+		t.Errorf("expected ErrInvalidRejoinType, got %v", err)
+	}
+}
+
+// Known answers of the LoRaWAN 1.1 key derivation, FOpts encryption and MICs,
+// computed with another AES and AES-CMAC implementation.
+func TestKnownAnswers11(t *testing.T) {
+	unhex := func(s string) []uint8 {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// JoinNonce 0x000005, DevNonce 0x1234
+	o := test11Otaa()
+	o.devNonce = [2]uint8{0x34, 0x12}
+	if k := o.jsIntKey(); !bytes.Equal(k[:], unhex("9a9a43545c823a2379e744ba856995d4")) {
+		t.Errorf("JSIntKey %x", k)
+	}
+	if k := o.jsEncKey(); !bytes.Equal(k[:], unhex("e1fc17c9a820d3cda27d2380b216b2df")) {
+		t.Errorf("JSEncKey %x", k)
+	}
+
+	// Join-accept with OptNeg, its MIC over JoinReqType | JoinEUI | DevNonce
+	// | MHDR | fields is 0e9cdc64
+	s := &Session{}
+	if err := o.DecodeJoinAccept(unhex("20c974b7eba992a60d3fc43cc1c5249d94"), s); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []struct {
+		name string
+		got  [16]uint8
+		want string
+	}{
+		{"FNwkSIntKey", s.NwkSKey, "26f3f33b977ddce18073e21b6ff72c77"},
+		{"SNwkSIntKey", s.SNwkSIntKey, "29dac463ebf3d7bbe82f11a5a509eef1"},
+		{"NwkSEncKey", s.NwkSEncKey, "b8b6fd650e6ac30ffd45cbc5ac3e7777"},
+		{"AppSKey", s.AppSKey, "9b15602225d54be147ea4d22554a1c1d"},
+	} {
+		if !bytes.Equal(k.got[:], unhex(k.want)) {
+			t.Errorf("%s %x, expected %s", k.name, k.got, k.want)
+		}
+	}
+	if !s.LoRaWAN11 || s.DevAddr != [4]uint8{0x04, 0x03, 0x02, 0x01} {
+		t.Errorf("unexpected session %+v", s)
+	}
+
+	// FOpts 0b01, the counter block with 0x01 for the uplinks and the
+	// NFCntDown downlinks, 0x02 for the AFCntDown ones
+	s = test11Session()
+	fOpts := []uint8{cidRekey, 0x01}
+	for _, tc := range []struct {
+		dir   uint8
+		fCnt  uint32
+		aFCnt bool
+		want  string
+	}{
+		{0, 0x10002, false, "bdb1"},
+		{1, 3, false, "ca4e"},
+		{1, 3, true, "9d5e"},
+	} {
+		if got := s.encryptFOpts(fOpts, tc.dir, tc.fCnt, tc.aFCnt); !bytes.Equal(got, unhex(tc.want)) {
+			t.Errorf("FOpts %x, dir %d, AFCntDown %v: expected %s", got, tc.dir, tc.aFCnt, tc.want)
+		}
+	}
+
+	// Uplink MIC: cmacS = dac96db5..., with ConfFCnt 0x0107, DR3 and channel
+	// 5, and cmacF = b36190d5...
+	msg := unhex("4001020304800200016869")
+	if mic := s.uplinkMIC11(msg, 0x10002, 0x0107, 3, 5); !bytes.Equal(mic[:], unhex("dac9b361")) {
+		t.Errorf("uplink MIC %x, expected dac9b361", mic)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"math"
+	"time"
 )
 
 // Session is used to store session data of a LoRaWAN session
@@ -17,6 +18,94 @@ type Session struct {
 	CFList     [16]uint8
 	RXDelay    uint8
 	DLSettings uint8
+
+	// RX2Frequency is the RX2 frequency set by the network server with the
+	// RXParamSetupReq MAC command, zero for the default one of the region.
+	RX2Frequency uint32
+
+	// LoRaWAN11 is set for the sessions of LoRaWAN 1.1, where NwkSKey is
+	// the FNwkSIntKey and FCntDown the NFCntDown of the MAC commands, the
+	// application downlinks being counted by AFCntDown.
+	LoRaWAN11   bool
+	SNwkSIntKey [16]uint8
+	NwkSEncKey  [16]uint8
+	AFCntDown   uint32
+
+	// Set by the network server with the MAC commands: the aggregated duty
+	// cycle limit 1/2^MaxDutyCycle, and the number of transmissions of the
+	// unconfirmed uplinks, 0 meaning 1.
+	MaxDutyCycle uint8
+	NbTrans      uint8
+
+	// Answers of the LinkCheckReq and DeviceTimeReq MAC commands
+	LinkMargin   uint8
+	GatewayCount uint8
+	NetworkTime  time.Time
+
+	// ADR lets the network server set the data rate and TX power of the
+	// uplinks with the LinkADRReq MAC command.
+	ADR bool
+
+	// set when a confirmed downlink must be acknowledged by the next uplink
+	ackDownlink bool
+
+	// uplinks sent since the last downlink, when ADR is enabled
+	adrAckCnt uint32
+
+	// FCntUp reserved in the session store, the session is stored again
+	// before reaching it
+	fCntUpLimit uint32
+
+	// LoRaWAN 1.1: counter of the Rejoin requests of type 0 and 2, the
+	// FCnt of the last uplink for its MIC, and the counters of the last
+	// confirmed frames, acknowledged in the MIC of the next frames
+	rjCount0       uint16
+	uplinkFCnt     uint32
+	uplinkConfFCnt uint16
+	confFCntDown   uint16
+	confFCntUp     uint16
+
+	// set until the network server confirms the RekeyInd of a LoRaWAN 1.1
+	// session
+	rekeyPending bool
+
+	// MAC commands for the next uplink, the sticky ones being repeated
+	// until a downlink is received
+	macCommands    []uint8
+	stickyCommands []uint8
+}
+
+// reset resets the counters of a new session, and forgets the MAC commands
+// of the previous one.
+func (s *Session) reset() {
+	s.FCntDown = 0
+	s.AFCntDown = 0
+	s.FCntUp = 0
+	s.adrAckCnt = 0
+	s.fCntUpLimit = 0
+	s.rjCount0 = 0
+	s.confFCntDown = 0
+	s.confFCntUp = 0
+	s.rekeyPending = false
+	s.RX2Frequency = 0
+
+	s.ackDownlink = false
+	s.macCommands = nil
+	s.stickyCommands = nil
+}
+
+// applyRX2 configures the RX2 channel of the region for the session.
+func (s *Session) applyRX2() {
+	frequency := s.RX2Frequency
+	if frequency == 0 {
+		frequency, _ = regionSettings.RX2Default()
+	}
+	regionSettings.SetRX2(frequency, s.DLSettings&0x0F)
+}
+
+// rx1DROffset returns the RX1 data rate offset of the DLSettings.
+func (s *Session) rx1DROffset() uint8 {
+	return (s.DLSettings >> 4) & 0x07
 }
 
 // SetDevAddr configures the Session DevAddr
@@ -69,16 +158,51 @@ func (s *Session) GetAppSKey() string {
 
 // GenMessage generates an uplink message.
 func (s *Session) GenMessage(dir uint8, payload []uint8) ([]uint8, error) {
+	return s.genMessage(mTypeUnconfirmedDataUp, dir, payload)
+}
+
+// GenConfirmedMessage generates a confirmed uplink message, to be
+// acknowledged by the network server.
+func (s *Session) GenConfirmedMessage(payload []uint8) ([]uint8, error) {
+	return s.genMessage(mTypeConfirmedDataUp, 0, payload)
+}
+
+func (s *Session) genMessage(mType uint8, dir uint8, payload []uint8) ([]uint8, error) {
 	var buf []uint8
-	buf = append(buf, 0b01000000) // FHDR Unconfirmed up
+	buf = append(buf, mType) // FHDR
 	buf = append(buf, s.DevAddr[:]...)
 
-	// FCtl : No ADR, No RFU, No ACK, No FPending, No FOpt
-	buf = append(buf, 0x00)
+	// FCtl : No RFU, No FPending
+	var fCtrl uint8
+	var fOpts []uint8
+	if dir == 0 {
+		if s.ADR {
+			fCtrl |= fCtrlADR
+			if s.adrAckCnt >= ADR_ACK_LIMIT {
+				fCtrl |= fCtrlADRACKReq
+			}
+			s.adrAckCnt++
+		}
+		s.uplinkConfFCnt = 0
+		if s.ackDownlink {
+			fCtrl |= fCtrlACK
+			s.ackDownlink = false
+			s.uplinkConfFCnt = s.confFCntDown
+		}
+		fOpts = s.fOpts()
+		fCtrl |= uint8(len(fOpts))
+		if s.LoRaWAN11 && len(fOpts) > 0 {
+			fOpts = s.encryptFOpts(fOpts, 0, s.FCntUp, false)
+		}
+	}
+	buf = append(buf, fCtrl)
 
 	// FCnt Up
 	buf = append(buf, uint8(s.FCntUp&0xFF), uint8((s.FCntUp>>8)&0xFF))
 
+	// FOpts : MAC commands
+	buf = append(buf, fOpts...)
+
 	// FPort=1
 	buf = append(buf, 0x01)
 
@@ -86,22 +210,35 @@ func (s *Session) GenMessage(dir uint8, payload []uint8) ([]uint8, error) {
 	if dir == 0 {
 		fCnt = s.FCntUp
 		s.FCntUp++
+		s.uplinkFCnt = fCnt
+		if mType == mTypeConfirmedDataUp {
+			s.confFCntUp = uint16(fCnt)
+		}
 	} else {
 		fCnt = s.FCntDown
 	}
-	data, err := s.genFRMPayload(dir, fCnt, payload, false)
+	data, err := s.genFRMPayload(s.AppSKey, dir, fCnt, payload, false)
 	if err != nil {
 		return nil, err
 	}
 	buf = append(buf, data[:]...)
 
-	mic := calcMessageMIC(buf, s.NwkSKey, dir, s.DevAddr[:], fCnt, uint8(len(buf)))
+	var mic [4]uint8
+	if s.LoRaWAN11 && dir == 0 {
+		// Signed for DR0 and channel 0, signUplink signs it again for the
+		// transmission
+		mic = s.uplinkMIC11(buf, fCnt, s.uplinkConfFCnt, 0, 0)
+	} else {
+		mic = calcMessageMIC(buf, s.NwkSKey, dir, s.DevAddr[:], fCnt, uint8(len(buf)))
+	}
 	buf = append(buf, mic[:]...)
 
 	return buf, nil
 }
 
-func (s *Session) genFRMPayload(dir uint8, fCnt uint32, payload []byte, isFOpts bool) ([]byte, error) {
+// genFRMPayload encrypts or decrypts the payload with the key, the AppSKey, or
+// the NwkSKey (NwkSEncKey of LoRaWAN 1.1) for the MAC commands of port 0.
+func (s *Session) genFRMPayload(key [16]uint8, dir uint8, fCnt uint32, payload []byte, isFOpts bool) ([]byte, error) {
 	k := len(payload) / aes.BlockSize
 	if len(payload)%aes.BlockSize != 0 {
 		k++
@@ -110,7 +247,7 @@ func (s *Session) genFRMPayload(dir uint8, fCnt uint32, payload []byte, isFOpts
 		return nil, ErrFrmPayloadTooLarge
 	}
 	encrypted := make([]byte, 0, k*16)
-	cipher, err := aes.NewCipher(s.AppSKey[:])
+	cipher, err := aes.NewCipher(key[:])
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,25 @@
+package simulator
+
+import "time"
+
+// Clock is a virtual clock, only moving forward when slept on.
+type Clock struct {
+	t time.Time
+}
+
+// NewClock returns a virtual clock starting at the given time.
+func NewClock(start time.Time) *Clock {
+	return &Clock{t: start}
+}
+
+// Now returns the current virtual time.
+func (c *Clock) Now() time.Time {
+	return c.t
+}
+
+// Sleep moves the virtual time forward, at once.
+func (c *Clock) Sleep(d time.Duration) {
+	if d > 0 {
+		c.t = c.t.Add(d)
+	}
+}
@@ -0,0 +1,139 @@
+// Package simulator provides an in-memory lora.Radio connected to a minimal
+// LoRaWAN network server, to test the lorawan stack on the host without any
+// radio or gateway.
+//
+// The time is simulated by a virtual Clock, shared by the radio, the network
+// server and the lorawan stack, so that the receive windows and the duty
+// cycle limits don't slow the tests down:
+//
+//	clock := simulator.NewClock(time.Now())
+//	ns := simulator.NewNetworkServer(region.EU868(), clock)
+//	dev := ns.AddDevice(devEUI, appEUI, appKey)
+//	lorawan.UseClock(clock)
+//	lorawan.UseRadio(simulator.NewRadio(ns))
+//	lorawan.UseRegionSettings(region.EU868())
+package simulator
+
+import (
+	"math/rand"
+	"time"
+
+	"tinygo.org/x/drivers/lora"
+)
+
+// Radio is a lora.Radio exchanging its packets with a NetworkServer. The
+// packets take their time on air, and are lost with the given probabilities.
+type Radio struct {
+	// UplinkLoss and DownlinkLoss are the probabilities, from 0 to 1, of
+	// losing each packet sent and received.
+	UplinkLoss   float64
+	DownlinkLoss float64
+
+	ns   *NetworkServer
+	cnf  lora.Config
+	rand *rand.Rand
+
+	// downlinks sent by the network server to the radio
+	pending []*downlink
+}
+
+// downlink is a packet sent by the network server at a given time.
+type downlink struct {
+	at      time.Time
+	freq    uint32
+	sf, bw  uint8
+	payload []uint8
+}
+
+// NewRadio returns a radio connected to the network server. The packet
+// losses are drawn from a fixed seed, the same in each run.
+func NewRadio(ns *NetworkServer) *Radio {
+	return &Radio{
+		ns:   ns,
+		rand: rand.New(rand.NewSource(1)),
+		cnf: lora.Config{
+			Preamble:   8,
+			HeaderType: lora.HeaderExplicit,
+			Crc:        lora.CRCOn,
+		},
+	}
+}
+
+// Tx sends the packet to the network server, the clock moving forward by its
+// time on air.
+func (r *Radio) Tx(pkt []uint8, timeoutMs uint32) error {
+	if r.cnf.Freq == 0 {
+		return lora.ErrUndefinedLoraConf
+	}
+	r.ns.clock.Sleep(r.timeOnAir(len(pkt)))
+	if r.rand.Float64() < r.UplinkLoss {
+		return nil
+	}
+	if dl := r.ns.receive(append([]uint8(nil), pkt...), r.cnf); dl != nil {
+		r.pending = append(r.pending, dl)
+	}
+	return nil
+}
+
+// Rx returns the first downlink sent to the radio with its modulation, with
+// the inverted IQ of the downlinks, during the timeout. The clock moves
+// forward to the end of the packet, or of the timeout.
+func (r *Radio) Rx(timeoutMs uint32) ([]uint8, error) {
+	if r.cnf.Freq == 0 {
+		return nil, lora.ErrUndefinedLoraConf
+	}
+	clock := r.ns.clock
+	start := clock.Now()
+	end := start.Add(time.Duration(timeoutMs) * time.Millisecond)
+
+	for i, dl := range r.pending {
+		if dl.at.Before(start) || !dl.at.Before(end) ||
+			dl.freq != r.cnf.Freq || dl.sf != r.cnf.Sf || dl.bw != r.cnf.Bw || r.cnf.Iq != lora.IQInverted {
+			continue
+		}
+		r.pending = append(r.pending[:i], r.pending[i+1:]...)
+		clock.Sleep(dl.at.Sub(start) + r.timeOnAir(len(dl.payload)))
+		if r.rand.Float64() < r.DownlinkLoss {
+			return nil, nil
+		}
+		return dl.payload, nil
+	}
+
+	// The downlinks missed are dropped
+	clock.Sleep(end.Sub(start))
+	n := 0
+	for _, dl := range r.pending {
+		if !dl.at.Before(end) {
+			r.pending[n] = dl
+			n++
+		}
+	}
+	r.pending = r.pending[:n]
+	return nil, nil
+}
+
+func (r *Radio) timeOnAir(payloadLen int) time.Duration {
+	cnf := r.cnf
+	cnf.Ldr = lora.LowDataRateOptimize(cnf.Sf, cnf.Bw)
+	return lora.TimeOnAir(cnf, payloadLen)
+}
+
+func (r *Radio) Reset()                         {}
+func (r *Radio) SetFrequency(freq uint32)       { r.cnf.Freq = freq }
+func (r *Radio) SetIqMode(mode uint8)           { r.cnf.Iq = mode }
+func (r *Radio) SetCodingRate(cr uint8)         { r.cnf.Cr = cr }
+func (r *Radio) SetBandwidth(bw uint8)          { r.cnf.Bw = bw }
+func (r *Radio) SetSpreadingFactor(sf uint8)    { r.cnf.Sf = sf }
+func (r *Radio) SetPreambleLength(plen uint16)  { r.cnf.Preamble = plen }
+func (r *Radio) SetTxPower(txpow int8)          { r.cnf.LoraTxPowerDBm = txpow }
+func (r *Radio) SetSyncWord(syncWord uint16)    { r.cnf.SyncWord = syncWord }
+func (r *Radio) SetPublicNetwork(enable bool)   {}
+func (r *Radio) SetHeaderType(headerType uint8) { r.cnf.HeaderType = headerType }
+func (r *Radio) LoraConfig(cnf lora.Config)     { r.cnf = cnf }
+
+func (r *Radio) SetCrc(enable bool) {
+	r.cnf.Crc = lora.CRCOff
+	if enable {
+		r.cnf.Crc = lora.CRCOn
+	}
+}
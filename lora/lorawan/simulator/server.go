@@ -0,0 +1,510 @@
+package simulator
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"tinygo.org/x/drivers/lora"
+	"tinygo.org/x/drivers/lora/lorawan"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+const (
+	// Delays of the receive windows opened after the join requests, and of
+	// RX1 after the data uplinks
+	JOIN_ACCEPT_DELAY1 = 5 * time.Second
+	RECEIVE_DELAY1     = 1 * time.Second
+
+	// Frame types
+	mTypeJoinRequest         = 0b000 << 5
+	mTypeJoinAccept          = 0b001 << 5
+	mTypeUnconfirmedDataUp   = 0b010 << 5
+	mTypeUnconfirmedDataDown = 0b011 << 5
+	mTypeConfirmedDataUp     = 0b100 << 5
+	mTypeConfirmedDataDown   = 0b101 << 5
+
+	// FCtrl bits
+	fCtrlADRACKReq = 0x40
+	fCtrlACK       = 0x20
+	fCtrlFOptsLen  = 0x0F
+
+	maxFOptsLen    = 15
+	gpsLeapSeconds = 18
+)
+
+// MAC commands answered by the network server
+const (
+	cidLinkCheck  = 0x02
+	cidDeviceTime = 0x0D
+)
+
+// Lengths of the MAC commands sent by the devices, the requests and answers
+var uplinkCommandLen = map[uint8]int{
+	0x01: 1, 0x02: 0, 0x03: 1, 0x04: 0, 0x05: 1, 0x06: 2, 0x07: 1,
+	0x08: 0, 0x09: 0, 0x0A: 1, 0x0B: 1, 0x0D: 0,
+}
+
+// Lengths of the MAC commands sent to the devices
+var downlinkCommandLen = map[uint8]int{
+	0x02: 2, 0x03: 4, 0x04: 1, 0x05: 4, 0x06: 0, 0x07: 5,
+	0x08: 1, 0x09: 1, 0x0A: 4, 0x0B: 1, 0x0D: 5,
+}
+
+var gpsEpoch = time.Date(1980, time.January, 6, 0, 0, 0, 0, time.UTC)
+
+var (
+	ErrInvalidMACCommand = errors.New("invalid MAC command")
+	ErrInvalidKeyLength  = errors.New("invalid key length")
+)
+
+// NetworkServer is a minimal LoRaWAN 1.0 network server, joined by its
+// devices through the simulated radios. It answers the join requests, the
+// LinkCheckReq and DeviceTimeReq MAC commands, acknowledges the confirmed
+// uplinks, and sends the downlinks queued for each device in RX1, or RX2.
+type NetworkServer struct {
+	// NetID of the network, and RXDelay and RX1 data rate offset given to
+	// the devices joining it
+	NetID       [3]uint8
+	RXDelay     uint8
+	RX1DROffset uint8
+
+	// UseRX2 sends the downlinks in the RX2 receive window, instead of RX1.
+	UseRX2 bool
+
+	// Margin is the demodulation margin, in dB, of the LinkCheckAns.
+	Margin uint8
+
+	settings  region.Settings
+	clock     *Clock
+	devices   []*Device
+	joinNonce uint32
+	nextAddr  uint32
+}
+
+// Device is a device known by the network server.
+type Device struct {
+	devEUI [8]uint8
+	appEUI [8]uint8
+	appKey [16]uint8
+
+	devAddr  [4]uint8
+	nwkSKey  [16]uint8
+	appSKey  [16]uint8
+	joined   bool
+	nonces   map[uint16]bool
+	fCntUp   uint32
+	hasFCnt  bool
+	fCntDown uint32
+
+	uplinks     []Uplink
+	macCommands []uint8
+	queue       []queuedDownlink
+
+	// set when the last uplink is confirmed, its retransmissions being
+	// acknowledged again
+	lastAck bool
+}
+
+type queuedDownlink struct {
+	fPort     uint8
+	payload   []uint8
+	confirmed bool
+}
+
+// Uplink is a data uplink received by the network server.
+type Uplink struct {
+	Time      time.Time
+	Frequency uint32
+	SF        uint8
+	Bandwidth uint8
+
+	FCnt        uint32
+	Confirmed   bool
+	ADRACKReq   bool
+	FPort       uint8
+	Payload     []uint8
+	MACCommands []uint8
+}
+
+// NewNetworkServer returns a network server of the region, on the virtual
+// clock. The settings are the network's own, apart from the devices' ones.
+func NewNetworkServer(settings region.Settings, clock *Clock) *NetworkServer {
+	return &NetworkServer{
+		NetID:    [3]uint8{0x13, 0x00, 0x00},
+		RXDelay:  1,
+		Margin:   20,
+		settings: settings,
+		clock:    clock,
+		nextAddr: 0x26000001,
+	}
+}
+
+// AddDevice provisions a device joining by OTAA, with the EUIs and AppKey of
+// its lorawan.Otaa.
+func (ns *NetworkServer) AddDevice(devEUI, appEUI, appKey []uint8) *Device {
+	d := &Device{nonces: make(map[uint16]bool)}
+	copy(d.devEUI[:], devEUI)
+	copy(d.appEUI[:], appEUI)
+	copy(d.appKey[:], appKey)
+	ns.devices = append(ns.devices, d)
+	return d
+}
+
+// AddABPDevice provisions a device activated by personalization.
+func (ns *NetworkServer) AddABPDevice(devAddr, nwkSKey, appSKey []uint8) (*Device, error) {
+	if len(nwkSKey) != 16 || len(appSKey) != 16 {
+		return nil, ErrInvalidKeyLength
+	}
+	d := &Device{joined: true}
+	copy(d.devAddr[:], devAddr)
+	copy(d.nwkSKey[:], nwkSKey)
+	copy(d.appSKey[:], appSKey)
+	ns.devices = append(ns.devices, d)
+	return d, nil
+}
+
+// Joined returns if the device has joined the network.
+func (d *Device) Joined() bool {
+	return d.joined
+}
+
+// DevAddr returns the address of the device, as sent in the frames.
+func (d *Device) DevAddr() [4]uint8 {
+	return d.devAddr
+}
+
+// Uplinks returns the data uplinks received from the device.
+func (d *Device) Uplinks() []Uplink {
+	return d.uplinks
+}
+
+// QueueDownlink queues an application downlink, sent after the next uplink
+// of the device.
+func (d *Device) QueueDownlink(fPort uint8, payload []uint8, confirmed bool) {
+	d.queue = append(d.queue, queuedDownlink{fPort, payload, confirmed})
+}
+
+// QueueMACCommand queues a MAC command, sent in the FOpts of the next
+// downlink.
+func (d *Device) QueueMACCommand(cmd ...uint8) error {
+	if len(cmd) == 0 {
+		return ErrInvalidMACCommand
+	}
+	if n, ok := downlinkCommandLen[cmd[0]]; !ok || len(cmd) != 1+n {
+		return ErrInvalidMACCommand
+	}
+	d.macCommands = append(d.macCommands, cmd...)
+	return nil
+}
+
+// receive handles a packet sent with the radio configuration, and returns
+// the answer of the network server, if any.
+func (ns *NetworkServer) receive(pkt []uint8, cnf lora.Config) *downlink {
+	if len(pkt) < 1 || cnf.Iq != lora.IQStandard {
+		return nil
+	}
+	uplink := &txChannel{cnf}
+	switch pkt[0] & 0xE0 {
+	case mTypeJoinRequest:
+		if accept := ns.join(pkt); accept != nil {
+			return ns.schedule(accept, uplink, JOIN_ACCEPT_DELAY1, false)
+		}
+	case mTypeUnconfirmedDataUp, mTypeConfirmedDataUp:
+		if answer := ns.uplink(pkt, cnf); answer != nil {
+			delay := time.Duration(ns.RXDelay) * time.Second
+			if delay == 0 {
+				delay = RECEIVE_DELAY1
+			}
+			return ns.schedule(answer, uplink, delay, ns.UseRX2)
+		}
+	}
+	return nil
+}
+
+// schedule returns the downlink sent in RX1, or RX2 one second later, after
+// the uplink.
+func (ns *NetworkServer) schedule(payload []uint8, uplink region.Channel, delay time.Duration, rx2 bool) *downlink {
+	ch := ns.settings.RX1Channel(uplink, ns.RX1DROffset)
+	if rx2 {
+		ch = ns.settings.RX2Channel()
+		delay += time.Second
+	}
+	return &downlink{
+		at:      ns.clock.Now().Add(delay),
+		freq:    ch.Frequency(),
+		sf:      ch.SpreadingFactor(),
+		bw:      ch.Bandwidth(),
+		payload: payload,
+	}
+}
+
+// join answers a join request with a join accept, nil if it is refused.
+func (ns *NetworkServer) join(pkt []uint8) []uint8 {
+	// MHDR | JoinEUI | DevEUI | DevNonce | MIC
+	if len(pkt) != 23 {
+		return nil
+	}
+	var d *Device
+	for _, dev := range ns.devices {
+		if bytes.Equal(reverse(pkt[1:9]), dev.appEUI[:]) && bytes.Equal(reverse(pkt[9:17]), dev.devEUI[:]) {
+			d = dev
+		}
+	}
+	if d == nil || !bytes.Equal(cmac(d.appKey, pkt[:19]), pkt[19:]) {
+		return nil
+	}
+	devNonce := binary.LittleEndian.Uint16(pkt[17:])
+	if d.nonces[devNonce] {
+		// replayed
+		return nil
+	}
+	d.nonces[devNonce] = true
+
+	ns.joinNonce++
+	appNonce := []uint8{uint8(ns.joinNonce), uint8(ns.joinNonce >> 8), uint8(ns.joinNonce >> 16)}
+	binary.LittleEndian.PutUint32(d.devAddr[:], ns.nextAddr)
+	ns.nextAddr++
+
+	// NwkSKey, AppSKey = aes128_encrypt(AppKey, 0x01 or 0x02 | AppNonce | NetID | DevNonce | pad16)
+	block := make([]uint8, 16)
+	copy(block[1:], appNonce)
+	copy(block[4:], ns.NetID[:])
+	copy(block[7:], pkt[17:19])
+	block[0] = 0x01
+	d.nwkSKey = encrypt(d.appKey, block)
+	block[0] = 0x02
+	d.appSKey = encrypt(d.appKey, block)
+	d.joined = true
+	d.hasFCnt = false
+	d.fCntDown = 0
+
+	_, rx2DR := ns.settings.RX2Default()
+	accept := []uint8{mTypeJoinAccept}
+	accept = append(accept, appNonce...)
+	accept = append(accept, ns.NetID[:]...)
+	accept = append(accept, d.devAddr[:]...)
+	accept = append(accept, ns.RX1DROffset<<4|rx2DR, ns.RXDelay)
+	accept = append(accept, cmac(d.appKey, accept)...)
+
+	// Encrypted with the AES decryption, for the device to only encrypt
+	c, _ := aes.NewCipher(d.appKey[:])
+	for i := 1; i < len(accept); i += aes.BlockSize {
+		c.Decrypt(accept[i:], accept[i:])
+	}
+	return accept
+}
+
+// uplink handles a data uplink, and returns the downlink answering it, if
+// any.
+func (ns *NetworkServer) uplink(pkt []uint8, cnf lora.Config) []uint8 {
+	// MHDR | DevAddr | FCtrl | FCnt | FOpts | FPort | FRMPayload | MIC
+	if len(pkt) < 12 {
+		return nil
+	}
+	var d *Device
+	for _, dev := range ns.devices {
+		if dev.joined && bytes.Equal(dev.devAddr[:], pkt[1:5]) {
+			d = dev
+		}
+	}
+	if d == nil {
+		return nil
+	}
+	fCtrl := pkt[5]
+	fOptsLen := int(fCtrl & fCtrlFOptsLen)
+	msg := pkt[:len(pkt)-4]
+	if len(msg) < 8+fOptsLen {
+		return nil
+	}
+
+	fCnt := d.fCntUp&^0xFFFF | uint32(pkt[6]) | uint32(pkt[7])<<8
+	if d.hasFCnt && fCnt < d.fCntUp {
+		fCnt += 0x10000
+	}
+	if !bytes.Equal(micB0(d.nwkSKey, 0, d.devAddr, fCnt, msg), pkt[len(msg):]) {
+		return nil
+	}
+	confirmed := pkt[0]&0xE0 == mTypeConfirmedDataUp
+	if d.hasFCnt && fCnt == d.fCntUp {
+		// A retransmission, acknowledged again
+		if confirmed && d.lastAck {
+			return ns.downlink(d, true)
+		}
+		return nil
+	}
+	if d.hasFCnt && fCnt < d.fCntUp {
+		return nil
+	}
+	d.fCntUp = fCnt
+	d.hasFCnt = true
+
+	up := Uplink{
+		Time:        ns.clock.Now(),
+		Frequency:   cnf.Freq,
+		SF:          cnf.Sf,
+		Bandwidth:   cnf.Bw,
+		FCnt:        fCnt,
+		Confirmed:   confirmed,
+		ADRACKReq:   fCtrl&fCtrlADRACKReq != 0,
+		MACCommands: append([]uint8(nil), msg[8:8+fOptsLen]...),
+	}
+	if frm := msg[8+fOptsLen:]; len(frm) > 0 {
+		up.FPort = frm[0]
+		key := d.appSKey
+		if up.FPort == 0 {
+			key = d.nwkSKey
+		}
+		up.Payload = cryptFRMPayload(key, 0, d.devAddr, fCnt, frm[1:])
+		if up.FPort == 0 {
+			up.MACCommands = up.Payload
+		}
+	}
+	d.uplinks = append(d.uplinks, up)
+	ns.answerMACCommands(d, up.MACCommands)
+
+	d.lastAck = confirmed
+	if !confirmed && !up.ADRACKReq && len(d.queue) == 0 && len(d.macCommands) == 0 {
+		return nil
+	}
+	return ns.downlink(d, confirmed)
+}
+
+// answerMACCommands queues the answers of the MAC commands requests of the
+// device.
+func (ns *NetworkServer) answerMACCommands(d *Device, cmds []uint8) {
+	for len(cmds) > 0 {
+		n, ok := uplinkCommandLen[cmds[0]]
+		if !ok || len(cmds) < 1+n {
+			return
+		}
+		switch cmds[0] {
+		case cidLinkCheck:
+			d.macCommands = append(d.macCommands, cidLinkCheck, ns.Margin, 1)
+		case cidDeviceTime:
+			// GPS time at the end of the uplink, in 1/256 s
+			gps := ns.clock.Now().Sub(gpsEpoch) + gpsLeapSeconds*time.Second
+			d.macCommands = append(d.macCommands, cidDeviceTime)
+			d.macCommands = binary.LittleEndian.AppendUint32(d.macCommands, uint32(gps/time.Second))
+			d.macCommands = append(d.macCommands, uint8(gps%time.Second*256/time.Second))
+		}
+		cmds = cmds[1+n:]
+	}
+}
+
+// downlink returns the next downlink of the device: the first queued
+// application downlink, with the MAC commands fitting in the FOpts.
+func (ns *NetworkServer) downlink(d *Device, ack bool) []uint8 {
+	mType := uint8(mTypeUnconfirmedDataDown)
+	var next *queuedDownlink
+	if len(d.queue) > 0 {
+		next = &d.queue[0]
+		d.queue = d.queue[1:]
+		if next.confirmed {
+			mType = mTypeConfirmedDataDown
+		}
+	}
+
+	// The whole MAC commands fitting in the FOpts, the others being kept
+	// for the next downlinks
+	n := 0
+	for n < len(d.macCommands) {
+		l := 1 + downlinkCommandLen[d.macCommands[n]]
+		if n+l > maxFOptsLen {
+			break
+		}
+		n += l
+	}
+	fOpts := d.macCommands[:n]
+	d.macCommands = d.macCommands[n:]
+
+	fCtrl := uint8(len(fOpts))
+	if ack {
+		fCtrl |= fCtrlACK
+	}
+	buf := []uint8{mType}
+	buf = append(buf, d.devAddr[:]...)
+	buf = append(buf, fCtrl, uint8(d.fCntDown), uint8(d.fCntDown>>8))
+	buf = append(buf, fOpts...)
+	if next != nil {
+		buf = append(buf, next.fPort)
+		buf = append(buf, cryptFRMPayload(d.appSKey, 1, d.devAddr, d.fCntDown, next.payload)...)
+	}
+	buf = append(buf, micB0(d.nwkSKey, 1, d.devAddr, d.fCntDown, buf)...)
+	d.fCntDown++
+	return buf
+}
+
+// txChannel is the channel of a packet sent, as seen by the network server.
+type txChannel struct {
+	cnf lora.Config
+}
+
+func (c *txChannel) Next() bool                 { return false }
+func (c *txChannel) Frequency() uint32          { return c.cnf.Freq }
+func (c *txChannel) Bandwidth() uint8           { return c.cnf.Bw }
+func (c *txChannel) SpreadingFactor() uint8     { return c.cnf.Sf }
+func (c *txChannel) CodingRate() uint8          { return c.cnf.Cr }
+func (c *txChannel) PreambleLength() uint16     { return c.cnf.Preamble }
+func (c *txChannel) TxPowerDBm() int8           { return c.cnf.LoraTxPowerDBm }
+func (c *txChannel) SetFrequency(v uint32)      { c.cnf.Freq = v }
+func (c *txChannel) SetBandwidth(v uint8)       { c.cnf.Bw = v }
+func (c *txChannel) SetSpreadingFactor(v uint8) { c.cnf.Sf = v }
+func (c *txChannel) SetCodingRate(v uint8)      { c.cnf.Cr = v }
+func (c *txChannel) SetPreambleLength(v uint16) { c.cnf.Preamble = v }
+func (c *txChannel) SetTxPowerDBm(v int8)       { c.cnf.LoraTxPowerDBm = v }
+
+// reverse returns the bytes in the reverse order, the EUIs being sent little
+// endian.
+func reverse(b []uint8) []uint8 {
+	r := make([]uint8, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func encrypt(key [16]uint8, block []uint8) [16]uint8 {
+	c, _ := aes.NewCipher(key[:])
+	var out [16]uint8
+	c.Encrypt(out[:], block)
+	return out
+}
+
+// cmac returns the 4 first bytes of the AES-CMAC of the data.
+func cmac(key [16]uint8, data []uint8) []uint8 {
+	hash, _ := lorawan.NewCmac(key[:])
+	hash.Write(data)
+	return hash.Sum(nil)[:4]
+}
+
+// micB0 returns the MIC of a data frame.
+func micB0(key [16]uint8, dir uint8, devAddr [4]uint8, fCnt uint32, msg []uint8) []uint8 {
+	b0 := make([]uint8, 16, 16+len(msg))
+	b0[0] = 0x49
+	b0[5] = dir
+	copy(b0[6:], devAddr[:])
+	binary.LittleEndian.PutUint32(b0[10:], fCnt)
+	b0[15] = uint8(len(msg))
+	return cmac(key, append(b0, msg...))
+}
+
+// cryptFRMPayload encrypts or decrypts a FRMPayload.
+func cryptFRMPayload(key [16]uint8, dir uint8, devAddr [4]uint8, fCnt uint32, payload []uint8) []uint8 {
+	a := make([]uint8, 16)
+	a[0] = 0x01
+	a[5] = dir
+	copy(a[6:], devAddr[:])
+	binary.LittleEndian.PutUint32(a[10:], fCnt)
+	out := make([]uint8, len(payload))
+	for i := 0; i < len(payload); i += 16 {
+		a[15] = uint8(i/16 + 1)
+		s := encrypt(key, a)
+		for j := i; j < len(payload) && j < i+16; j++ {
+			out[j] = payload[j] ^ s[j-i]
+		}
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+package simulator
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/lora/lorawan"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+var (
+	testDevEUI = []uint8{0x00, 0x04, 0xA3, 0x0B, 0x00, 0x1C, 0x05, 0x30}
+	testAppEUI = []uint8{0x70, 0xB3, 0xD5, 0x7E, 0xD0, 0x00, 0x00, 0x01}
+	testAppKey = bytes.Repeat([]uint8{0x2B}, 16)
+)
+
+// setup joins a device to a simulated network, and returns its radio, its
+// session and the network server's view of it.
+func setup(t *testing.T) (*Radio, *lorawan.Session, *NetworkServer, *Device) {
+	clock := NewClock(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+	ns := NewNetworkServer(region.EU868(), clock)
+	dev := ns.AddDevice(testDevEUI, testAppEUI, testAppKey)
+	radio := NewRadio(ns)
+
+	lorawan.UseClock(clock)
+	lorawan.ActiveRadio = radio
+	lorawan.UseRegionSettings(region.EU868())
+	t.Cleanup(func() {
+		lorawan.UseClock(nil)
+		lorawan.ActiveRadio = nil
+		lorawan.UseRegionSettings(nil)
+		lorawan.SetDownlinkHandler(nil)
+	})
+
+	otaa := &lorawan.Otaa{}
+	otaa.Set(testAppEUI, testDevEUI, testAppKey)
+	session := &lorawan.Session{}
+	if err := lorawan.Join(otaa, session); err != nil {
+		t.Fatal(err)
+	}
+	if !dev.Joined() || session.DevAddr != dev.DevAddr() {
+		t.Fatalf("unexpected DevAddr %x, expected %x", session.DevAddr, dev.DevAddr())
+	}
+	return radio, session, ns, dev
+}
+
+func TestUplinkDownlink(t *testing.T) {
+	_, session, ns, dev := setup(t)
+	var received []*lorawan.Downlink
+	lorawan.SetDownlinkHandler(func(dl *lorawan.Downlink) { received = append(received, dl) })
+
+	dev.QueueDownlink(5, []uint8("on"), false)
+	if err := lorawan.SendUplink([]uint8("hello"), session); err != nil {
+		t.Fatal(err)
+	}
+	up := dev.Uplinks()
+	if len(up) != 1 || up[0].FPort != 1 || string(up[0].Payload) != "hello" || up[0].FCnt != 0 {
+		t.Fatalf("unexpected uplinks %+v", up)
+	}
+	if len(received) != 1 || received[0].FPort != 5 || string(received[0].Payload) != "on" {
+		t.Fatalf("unexpected downlinks %+v", received)
+	}
+
+	// in RX2
+	ns.UseRX2 = true
+	dev.QueueDownlink(6, []uint8("off"), false)
+	if err := lorawan.SendUplink([]uint8("again"), session); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 || string(received[1].Payload) != "off" {
+		t.Fatalf("no downlink received in RX2: %+v", received)
+	}
+}
+
+func TestMACCommands(t *testing.T) {
+	_, session, _, dev := setup(t)
+
+	lorawan.RequestLinkCheck(session)
+	if err := dev.QueueMACCommand(0x04, 0x03); err != nil {
+		t.Fatal(err)
+	}
+	if err := lorawan.SendUplink([]uint8("x"), session); err != nil {
+		t.Fatal(err)
+	}
+	if session.GatewayCount != 1 || session.LinkMargin != 20 || session.MaxDutyCycle != 3 {
+		t.Errorf("unexpected LinkCheckAns %d %d, duty cycle %d", session.GatewayCount, session.LinkMargin, session.MaxDutyCycle)
+	}
+
+	// the DutyCycleAns comes with the next uplink
+	if err := lorawan.SendUplink([]uint8("x"), session); err != nil {
+		t.Fatal(err)
+	}
+	up := dev.Uplinks()
+	if !bytes.Equal(up[0].MACCommands, []uint8{0x02}) || !bytes.Equal(up[1].MACCommands, []uint8{0x04}) {
+		t.Errorf("unexpected MAC commands %x, %x", up[0].MACCommands, up[1].MACCommands)
+	}
+
+	if err := dev.QueueMACCommand(0x04); err != ErrInvalidMACCommand {
+		t.Errorf("expected ErrInvalidMACCommand, got %v", err)
+	}
+}
+
+func TestPacketLoss(t *testing.T) {
+	radio, session, _, dev := setup(t)
+	// the retransmissions down to SF12 wait for the duty cycle
+	lorawan.DutyCycleWait = time.Hour
+	defer func() { lorawan.DutyCycleWait = time.Minute }()
+
+	// the acknowledgements are all lost, the retransmissions are only
+	// received once
+	radio.DownlinkLoss = 1
+	if err := lorawan.SendConfirmedUplink([]uint8("x"), session); err != lorawan.ErrNoAck {
+		t.Fatalf("expected ErrNoAck, got %v", err)
+	}
+	if up := dev.Uplinks(); len(up) != 1 || !up[0].Confirmed {
+		t.Fatalf("unexpected uplinks %+v", up)
+	}
+
+	// acknowledged despite the losses, after retransmissions
+	radio.UplinkLoss = 0.3
+	radio.DownlinkLoss = 0.3
+	for i := 0; i < 10; i++ {
+		if err := lorawan.SendConfirmedUplink([]uint8("x"), session); err != nil {
+			t.Fatalf("uplink %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package lorawan
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// Number of uplinks reserved by each write of the session, the frame
+	// counter restarting after them on reboot.
+	DefaultFCntWriteAhead = 32
+
+	sessionMagic         = "LWS1"
+	sessionFlagSet       = 0x01
+	sessionFlagLoRaWAN11 = 0x02
+	sessionFlagJoinNonce = 0x04
+	sessionFlagRekey     = 0x08
+
+	// magic | seq | flags | DevNonce | JoinNonce | RJcount1 | DevAddr |
+	// NwkSKey | SNwkSIntKey | NwkSEncKey | AppSKey | FCntUp | FCntDown |
+	// AFCntDown | RXDelay | DLSettings | RX2Frequency | CRC
+	sessionRecordLen = 4 + 4 + 1 + 2 + 4 + 2 + 4 + 4*16 + 4 + 4 + 4 + 1 + 1 + 4 + 4
+)
+
+// Store is a non-volatile memory keeping the session across reboots, such as
+// an at24cx.Device EEPROM. A flash.Device can be used too, the blocks being
+// erased before each write.
+type Store interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// eraser is a Store which must be erased before being written.
+type eraser interface {
+	EraseBlockSize() int64
+	EraseBlocks(start, len int64) error
+}
+
+// SessionStore persists the session and the DevNonce counter in a Store,
+// with the JoinNonce and RJcount1 of LoRaWAN 1.1.
+//
+// The records are written in turn in two slots, so that a power loss while
+// writing one keeps the previous one. The uplink frame counter is written
+// ahead: each write reserves FCntWriteAhead uplinks, and the frame counter
+// restored on reboot is the first one after them, so that no frame counter
+// is ever used twice.
+type SessionStore struct {
+	// FCntWriteAhead is the number of uplinks reserved by each write.
+	FCntWriteAhead uint32
+
+	store    Store
+	offset   int64
+	slotSize int64
+
+	seq      uint32 // sequence number of the last record
+	devNonce [2]uint8
+	hasNonce bool
+
+	joinNonce    uint32
+	hasJoinNonce bool
+	rjCount1     uint16
+}
+
+// NewSessionStore returns a session store writing its two slots from offset
+// in the store, which must be aligned on an erase block for a flash memory.
+func NewSessionStore(store Store, offset int64) *SessionStore {
+	slotSize := int64(sessionRecordLen)
+	if e, ok := store.(eraser); ok {
+		// Each slot in its own erase block
+		bs := e.EraseBlockSize()
+		slotSize = (slotSize + bs - 1) / bs * bs
+	}
+	return &SessionStore{
+		FCntWriteAhead: DefaultFCntWriteAhead,
+		store:          store,
+		offset:         offset,
+		slotSize:       slotSize,
+	}
+}
+
+// Load restores the session of the last record, and the DevNonce counter
+// used by Join. It returns ErrNoSessionStored if there is no record, or if
+// the device has not been activated yet. The RX2 channel of the session is
+// applied to the region settings, which must be set before.
+func (ss *SessionStore) Load(s *Session) error {
+	var rec []uint8
+	var last uint32
+	for slot := int64(0); slot < 2; slot++ {
+		buf := make([]uint8, sessionRecordLen)
+		if _, err := ss.store.ReadAt(buf, ss.offset+slot*ss.slotSize); err != nil {
+			return err
+		}
+		if string(buf[:4]) != sessionMagic ||
+			crc32.ChecksumIEEE(buf[:len(buf)-4]) != binary.LittleEndian.Uint32(buf[len(buf)-4:]) {
+			continue
+		}
+		seq := binary.LittleEndian.Uint32(buf[4:])
+		if rec == nil || seq > last {
+			rec = buf
+			last = seq
+		}
+	}
+	if rec == nil {
+		return ErrNoSessionStored
+	}
+	ss.seq = last
+
+	flags := rec[8]
+	copy(ss.devNonce[:], rec[9:11])
+	ss.hasNonce = true
+	ss.joinNonce = binary.LittleEndian.Uint32(rec[11:])
+	ss.hasJoinNonce = flags&sessionFlagJoinNonce != 0
+	ss.rjCount1 = binary.LittleEndian.Uint16(rec[15:])
+	if flags&sessionFlagSet == 0 {
+		return ErrNoSessionStored
+	}
+
+	s.reset()
+	s.LoRaWAN11 = flags&sessionFlagLoRaWAN11 != 0
+	s.rekeyPending = flags&sessionFlagRekey != 0
+	copy(s.DevAddr[:], rec[17:21])
+	copy(s.NwkSKey[:], rec[21:37])
+	copy(s.SNwkSIntKey[:], rec[37:53])
+	copy(s.NwkSEncKey[:], rec[53:69])
+	copy(s.AppSKey[:], rec[69:85])
+	s.FCntUp = binary.LittleEndian.Uint32(rec[85:])
+	s.FCntDown = binary.LittleEndian.Uint32(rec[89:])
+	s.AFCntDown = binary.LittleEndian.Uint32(rec[93:])
+	s.RXDelay = rec[97]
+	s.DLSettings = rec[98]
+	s.RX2Frequency = binary.LittleEndian.Uint32(rec[99:])
+	if regionSettings != nil {
+		s.applyRX2()
+	}
+
+	// The reserved frame counters may have been used, the next uplink
+	// reserves new ones
+	s.fCntUpLimit = s.FCntUp
+	return nil
+}
+
+// Save writes the session in the slot of the oldest record, reserving the
+// next FCntWriteAhead uplinks. A nil session only saves the DevNonce counter.
+func (ss *SessionStore) Save(s *Session) error {
+	buf := make([]uint8, 0, sessionRecordLen)
+	buf = append(buf, sessionMagic...)
+	buf = binary.LittleEndian.AppendUint32(buf, ss.seq+1)
+
+	var flags uint8
+	if ss.hasJoinNonce {
+		flags |= sessionFlagJoinNonce
+	}
+	var limit uint32
+	if s != nil {
+		limit = s.FCntUp + ss.FCntWriteAhead
+		flags |= sessionFlagSet
+		if s.LoRaWAN11 {
+			flags |= sessionFlagLoRaWAN11
+		}
+		if s.rekeyPending {
+			flags |= sessionFlagRekey
+		}
+	}
+	buf = append(buf, flags)
+	buf = append(buf, ss.devNonce[:]...)
+	buf = binary.LittleEndian.AppendUint32(buf, ss.joinNonce)
+	buf = binary.LittleEndian.AppendUint16(buf, ss.rjCount1)
+	if s == nil {
+		buf = append(buf, make([]uint8, sessionRecordLen-4-len(buf))...)
+	} else {
+		buf = append(buf, s.DevAddr[:]...)
+		buf = append(buf, s.NwkSKey[:]...)
+		buf = append(buf, s.SNwkSIntKey[:]...)
+		buf = append(buf, s.NwkSEncKey[:]...)
+		buf = append(buf, s.AppSKey[:]...)
+		buf = binary.LittleEndian.AppendUint32(buf, limit)
+		buf = binary.LittleEndian.AppendUint32(buf, s.FCntDown)
+		buf = binary.LittleEndian.AppendUint32(buf, s.AFCntDown)
+		buf = append(buf, s.RXDelay, s.DLSettings)
+		buf = binary.LittleEndian.AppendUint32(buf, s.RX2Frequency)
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	// The slots are written in turn, by sequence number
+	addr := ss.offset + int64((ss.seq+1)%2)*ss.slotSize
+	if e, ok := ss.store.(eraser); ok {
+		bs := e.EraseBlockSize()
+		if err := e.EraseBlocks(addr/bs, ss.slotSize/bs); err != nil {
+			return err
+		}
+	}
+	if _, err := ss.store.WriteAt(buf, addr); err != nil {
+		return err
+	}
+	ss.seq++
+	if s != nil {
+		s.fCntUpLimit = limit
+	}
+	return nil
+}
+
+// Clear erases the records, the next Load returning ErrNoSessionStored. The
+// DevNonce counter is kept until the next Save.
+func (ss *SessionStore) Clear() error {
+	blank := make([]uint8, sessionRecordLen)
+	for slot := int64(0); slot < 2; slot++ {
+		addr := ss.offset + slot*ss.slotSize
+		if e, ok := ss.store.(eraser); ok {
+			bs := e.EraseBlockSize()
+			if err := e.EraseBlocks(addr/bs, ss.slotSize/bs); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := ss.store.WriteAt(blank, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reserveFCnt stores the session before its uplink frame counter reaches the
+// reserved ones.
+func (ss *SessionStore) reserveFCnt(s *Session) error {
+	if s.FCntUp < s.fCntUpLimit {
+		return nil
+	}
+	return ss.Save(s)
+}
+
+// useOtaa continues the stored DevNonce counter, if any, for the next join
+// request, and restores the JoinNonce and RJcount1 of LoRaWAN 1.1.
+func (ss *SessionStore) useOtaa(o *Otaa) {
+	if ss.hasNonce {
+		o.devNonce = ss.devNonce
+	}
+	o.joinNonce = ss.joinNonce
+	o.hasJoinNonce = ss.hasJoinNonce
+	o.rjCount1 = ss.rjCount1
+}
+
+// setOtaa keeps the DevNonce, JoinNonce and RJcount1 of o for the next Save.
+func (ss *SessionStore) setOtaa(o *Otaa) {
+	ss.devNonce = o.devNonce
+	ss.hasNonce = true
+	ss.joinNonce = o.joinNonce
+	ss.hasJoinNonce = o.hasJoinNonce
+	ss.rjCount1 = o.rjCount1
+}
+
+// saveDevNonce stores the DevNonce of the join request sent, before it is
+// accepted, so that it is not used again.
+func (ss *SessionStore) saveDevNonce(o *Otaa) error {
+	ss.setOtaa(o)
+	return ss.Save(nil)
+}
@@ -0,0 +1,169 @@
+package lorawan
+
+import (
+	"bytes"
+	"testing"
+
+	"tinygo.org/x/drivers/lora"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+// memStore is a Store in RAM, erased like a flash memory when eraseSize is
+// set.
+type memStore struct {
+	mem       []uint8
+	eraseSize int64
+	writes    int
+}
+
+func (m *memStore) ReadAt(p []uint8, off int64) (int, error) {
+	return copy(p, m.mem[off:]), nil
+}
+
+func (m *memStore) WriteAt(p []uint8, off int64) (int, error) {
+	m.writes++
+	return copy(m.mem[off:], p), nil
+}
+
+type flashStore struct {
+	memStore
+}
+
+func (m *flashStore) EraseBlockSize() int64 { return m.eraseSize }
+
+func (m *flashStore) EraseBlocks(start, n int64) error {
+	for i := start * m.eraseSize; i < (start+n)*m.eraseSize; i++ {
+		m.mem[i] = 0xFF
+	}
+	return nil
+}
+
+func TestSessionStore(t *testing.T) {
+	store := &memStore{mem: make([]uint8, 256)}
+	ss := NewSessionStore(store, 16)
+
+	s := &Session{}
+	if err := ss.Load(s); err != ErrNoSessionStored {
+		t.Errorf("expected no session stored, got %v", err)
+	}
+
+	err := ActivateABP(s, []uint8{1, 2, 3, 4}, bytes.Repeat([]uint8{0x11}, 16), bytes.Repeat([]uint8{0x22}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.FCntUp = 100
+	s.FCntDown = 7
+	s.DLSettings = 0x21
+	if err := ss.Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	// the frame counter restarts after the reserved ones
+	restored := &Session{}
+	if err := NewSessionStore(store, 16).Load(restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.DevAddr != s.DevAddr || restored.NwkSKey != s.NwkSKey || restored.AppSKey != s.AppSKey ||
+		restored.FCntUp != 100+DefaultFCntWriteAhead || restored.FCntDown != 7 || restored.DLSettings != 0x21 {
+		t.Errorf("unexpected session %+v", restored)
+	}
+
+	// a write interrupted by a power loss keeps the previous record
+	s.FCntUp = 200
+	ss.Save(s)
+	store.mem[16+20] ^= 0xFF
+	if err := NewSessionStore(store, 16).Load(restored); err != nil || restored.FCntUp != 100+DefaultFCntWriteAhead {
+		t.Errorf("unexpected FCntUp %d: %v", restored.FCntUp, err)
+	}
+
+	if err := ss.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Load(restored); err != ErrNoSessionStored {
+		t.Errorf("expected no session stored, got %v", err)
+	}
+}
+
+func TestSessionStoreRX2(t *testing.T) {
+	UseRegionSettings(region.EU868())
+	defer UseRegionSettings(nil)
+
+	store := &memStore{mem: make([]uint8, 256)}
+	ss := NewSessionStore(store, 0)
+	s := testSession()
+	// RX2 on 868.5 MHz at DR3
+	handleMACCommands(s, []uint8{cidRXParamSetup, 0x03, 0xC8, 0x85, 0x84})
+	if err := ss.Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	// restored in the settings of the region after a reboot
+	UseRegionSettings(region.EU868())
+	restored := &Session{}
+	if err := NewSessionStore(store, 0).Load(restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.RX2Frequency != 868500000 || restored.DLSettings != 0x03 {
+		t.Errorf("unexpected RX2 frequency %d and DLSettings %02x", restored.RX2Frequency, restored.DLSettings)
+	}
+	rx2 := regionSettings.RX2Channel()
+	if rx2.Frequency() != 868500000 || rx2.SpreadingFactor() != lora.SpreadingFactor9 {
+		t.Errorf("unexpected RX2 channel %d SF%d", rx2.Frequency(), rx2.SpreadingFactor())
+	}
+
+	// back to the default one with a new session
+	if err := ActivateABP(restored, []uint8{1, 2, 3, 4}, bytes.Repeat([]uint8{0x11}, 16), bytes.Repeat([]uint8{0x22}, 16)); err != nil {
+		t.Fatal(err)
+	}
+	rx2 = regionSettings.RX2Channel()
+	if rx2.Frequency() != 869525000 || rx2.SpreadingFactor() != lora.SpreadingFactor12 {
+		t.Errorf("unexpected RX2 channel %d SF%d", rx2.Frequency(), rx2.SpreadingFactor())
+	}
+}
+
+func TestSessionStoreWriteAhead(t *testing.T) {
+	store := &flashStore{memStore{mem: make([]uint8, 3*128), eraseSize: 128}}
+	ss := NewSessionStore(store, 128)
+	ss.FCntWriteAhead = 4
+	s := testSession()
+
+	for i := 0; i < 10; i++ {
+		if err := ss.reserveFCnt(s); err != nil {
+			t.Fatal(err)
+		}
+		s.GenMessage(0, []uint8("x"))
+	}
+	// written for the FCnt 0, 4 and 8
+	if store.writes != 3 {
+		t.Errorf("%d writes, expected 3", store.writes)
+	}
+	restored := &Session{}
+	if err := NewSessionStore(store, 128).Load(restored); err != nil || restored.FCntUp != 12 {
+		t.Errorf("unexpected FCntUp %d: %v", restored.FCntUp, err)
+	}
+}
+
+func TestSessionStoreDevNonce(t *testing.T) {
+	store := &memStore{mem: make([]uint8, 256)}
+	ss := NewSessionStore(store, 0)
+
+	o := &Otaa{}
+	o.Init()
+	o.GenerateJoinRequest()
+	if err := ss.saveDevNonce(o); err != nil {
+		t.Fatal(err)
+	}
+
+	// the counter continues after a reboot, without a session
+	ss = NewSessionStore(store, 0)
+	if err := ss.Load(&Session{}); err != ErrNoSessionStored {
+		t.Errorf("expected no session stored, got %v", err)
+	}
+	next := &Otaa{}
+	next.Init()
+	ss.useOtaa(next)
+	next.GenerateJoinRequest()
+	if uint16(next.devNonce[0])|uint16(next.devNonce[1])<<8 != uint16(o.devNonce[0])|uint16(o.devNonce[1])<<8+1 {
+		t.Errorf("DevNonce %x after %x", next.devNonce, o.devNonce)
+	}
+}
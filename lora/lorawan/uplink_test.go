@@ -0,0 +1,286 @@
+package lorawan
+
+import (
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/lora"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+// ackRadio is a radio acknowledging the confirmed uplinks in RX1 from the
+// given transmission.
+type ackRadio struct {
+	windowRadio
+	session *Session
+	ackFrom int
+	sent    [][]uint8
+	sfs     []uint8
+}
+
+func (r *ackRadio) Tx(pkt []uint8, timeoutMs uint32) error {
+	r.sent = append(r.sent, append([]uint8(nil), pkt...))
+	r.sfs = append(r.sfs, r.sf)
+	return nil
+}
+
+func (r *ackRadio) Rx(timeoutMs uint32) ([]uint8, error) {
+	if len(r.sent) < r.ackFrom {
+		return nil, nil
+	}
+	return encodeDownlink(r.session, mTypeUnconfirmedDataDown, fCtrlACK, r.session.FCntDown, nil, 0, nil), nil
+}
+
+func TestSendConfirmedUplink(t *testing.T) {
+	var clock time.Time
+	now = func() time.Time { return clock }
+	sleep = func(d time.Duration) { clock = clock.Add(d) }
+	// the retransmissions down to SF12 wait for the duty cycle
+	DutyCycleWait = time.Hour
+	defer func() {
+		now = time.Now
+		sleep = time.Sleep
+		DutyCycleWait = time.Minute
+		ActiveRadio = nil
+		UseRegionSettings(nil)
+	}()
+
+	s := testSession()
+	radio := &ackRadio{session: s, ackFrom: 3}
+	ActiveRadio = radio
+	UseRegionSettings(region.EU868())
+
+	if err := SendConfirmedUplink([]uint8("x"), s); err != nil {
+		t.Fatal(err)
+	}
+	if len(radio.sent) != 3 {
+		t.Fatalf("%d transmissions, expected 3", len(radio.sent))
+	}
+	for _, pkt := range radio.sent {
+		// the retransmissions are the same message
+		if pkt[0] != mTypeConfirmedDataUp || string(pkt) != string(radio.sent[0]) {
+			t.Errorf("unexpected retransmission % x", pkt)
+		}
+	}
+	// DR3, then DR2 from the third transmission
+	if radio.sfs[0] != 9 || radio.sfs[1] != 9 || radio.sfs[2] != 10 {
+		t.Errorf("unexpected spreading factors %v", radio.sfs)
+	}
+	// restored without ADR
+	if dr := regionSettings.DataRate(); dr != 3 {
+		t.Errorf("data rate DR%d after the exchange, expected DR3", dr)
+	}
+
+	// DR2 carries up to 51 bytes, the data rate isn't lowered
+	radio.sent, radio.sfs = nil, nil
+	if err := SendConfirmedUplink(make([]uint8, 100), s); err != nil {
+		t.Fatal(err)
+	}
+	if len(radio.sfs) != 3 || radio.sfs[2] != 9 {
+		t.Errorf("unexpected spreading factors %v", radio.sfs)
+	}
+
+	// kept with ADR
+	s.ADR = true
+	radio.sent, radio.sfs = nil, nil
+	if err := SendConfirmedUplink([]uint8("x"), s); err != nil {
+		t.Fatal(err)
+	}
+	if dr := regionSettings.DataRate(); dr != 2 {
+		t.Errorf("data rate DR%d after the exchange, expected DR2", dr)
+	}
+	s.ADR = false
+	regionSettings.SetDataRate(3)
+
+	// never acknowledged
+	radio.sent = nil
+	radio.ackFrom = 100
+	ConfirmedTransmissions = 4
+	defer func() { ConfirmedTransmissions = 8 }()
+	if err := SendConfirmedUplink([]uint8("x"), s); err != ErrNoAck {
+		t.Errorf("expected no ACK, got %v", err)
+	}
+	if len(radio.sent) != 4 {
+		t.Errorf("%d transmissions, expected 4", len(radio.sent))
+	}
+}
+
+func TestNbTrans(t *testing.T) {
+	var clock time.Time
+	now = func() time.Time { return clock }
+	sleep = func(d time.Duration) { clock = clock.Add(d) }
+	defer func() {
+		now = time.Now
+		sleep = time.Sleep
+		ActiveRadio = nil
+		UseRegionSettings(nil)
+	}()
+
+	s := testSession()
+	radio := &ackRadio{session: s, ackFrom: 100}
+	ActiveRadio = radio
+	UseRegionSettings(region.EU868())
+
+	for _, tc := range []struct {
+		nbTrans uint8
+		ackFrom int
+		want    int
+	}{
+		{0, 100, 1},
+		{1, 100, 1},
+		{3, 100, 3},
+		{3, 2, 2}, // stopped by the downlink
+	} {
+		s.NbTrans = tc.nbTrans
+		radio.ackFrom = tc.ackFrom
+		radio.sent = nil
+		if err := SendUplink([]uint8("x"), s); err != nil {
+			t.Fatal(err)
+		}
+		if len(radio.sent) != tc.want {
+			t.Errorf("NbTrans %d: %d transmissions, expected %d", tc.nbTrans, len(radio.sent), tc.want)
+		}
+		for _, pkt := range radio.sent {
+			// the repetitions are the same message
+			if pkt[0] != mTypeUnconfirmedDataUp || string(pkt) != string(radio.sent[0]) {
+				t.Errorf("unexpected repetition % x", pkt)
+			}
+		}
+	}
+}
+
+func TestADR(t *testing.T) {
+	var clock time.Time
+	now = func() time.Time { return clock }
+	sleep = func(d time.Duration) { clock = clock.Add(d) }
+	defer func() {
+		now = time.Now
+		sleep = time.Sleep
+		ActiveRadio = nil
+		UseRegionSettings(nil)
+	}()
+
+	s := testSession()
+	s.ADR = true
+	radio := &ackRadio{session: s, ackFrom: 1000}
+	ActiveRadio = radio
+	UseRegionSettings(region.EU868())
+
+	// DR3 carries up to 115 bytes
+	if err := SendUplink(make([]uint8, 116), s); err != ErrFrmPayloadTooLarge {
+		t.Errorf("expected payload too large, got %v", err)
+	}
+
+	for i := 0; i < ADR_ACK_LIMIT+ADR_ACK_DELAY+1; i++ {
+		if err := SendUplink([]uint8("x"), s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fCtrl := radio.sent[0][5]; fCtrl&fCtrlADR == 0 || fCtrl&fCtrlADRACKReq != 0 {
+		t.Errorf("unexpected first FCtrl %02x", fCtrl)
+	}
+	if fCtrl := radio.sent[ADR_ACK_LIMIT][5]; fCtrl&fCtrlADRACKReq == 0 {
+		t.Errorf("no ADR acknowledgement request, FCtrl %02x", fCtrl)
+	}
+	// stepped back to DR2
+	if sf := radio.sfs[len(radio.sfs)-2]; sf != 9 {
+		t.Errorf("unexpected spreading factor %d before the backoff", sf)
+	}
+	if sf := radio.sfs[len(radio.sfs)-1]; sf != 10 || regionSettings.DataRate() != 2 {
+		t.Errorf("unexpected spreading factor %d after the backoff", sf)
+	}
+
+	// a downlink stops the acknowledgement requests
+	radio.ackFrom = 0
+	SendUplink([]uint8("x"), s)
+	radio.ackFrom = 1000
+	SendUplink([]uint8("x"), s)
+	if fCtrl := radio.sent[len(radio.sent)-1][5]; fCtrl&fCtrlADRACKReq != 0 {
+		t.Errorf("unexpected FCtrl %02x", fCtrl)
+	}
+}
+
+func TestDutyCycle(t *testing.T) {
+	var clock time.Time
+	now = func() time.Time { return clock }
+	sleep = func(d time.Duration) { clock = clock.Add(d) }
+	defer func() {
+		now = time.Now
+		sleep = time.Sleep
+		ActiveRadio = nil
+		UseRegionSettings(nil)
+	}()
+
+	s := testSession()
+	radio := &ackRadio{session: s, ackFrom: 1000}
+	ActiveRadio = radio
+	UseRegionSettings(region.EU868())
+
+	// DR3 uplinks in the 1% sub-band of 868.0 MHz
+	start := clock
+	toa := airtime(regionSettings.UplinkChannel(), 14)
+	for i := 0; i < 3; i++ {
+		if err := SendUplink([]uint8("x"), s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if min := 2 * 100 * toa; clock.Sub(start) < min {
+		t.Errorf("3 uplinks sent in %s, expected %s at least", clock.Sub(start), min)
+	}
+
+	// too long to wait
+	DutyCycleWait = 0
+	defer func() { DutyCycleWait = time.Minute }()
+	if err := SendUplink([]uint8("x"), s); err != ErrDutyCycle {
+		t.Errorf("expected duty cycle limit, got %v", err)
+	}
+
+	// the sub-bands are independent
+	dc := region.NewDutyCycle(region.EU868().Bands())
+	dc.Transmitted(lora.MHz_868_1, clock, time.Second)
+	if dc.Wait(lora.MHz_868_3, clock) != 99*time.Second || dc.Wait(867100000, clock) != 0 {
+		t.Error("unexpected sub-band wait")
+	}
+	dc.SetAggregated(1 << 10)
+	dc.Transmitted(867100000, clock, time.Second)
+	if dc.Wait(869500000, clock) != 1023*time.Second {
+		t.Error("unexpected aggregated wait")
+	}
+}
+
+func TestDwellTime(t *testing.T) {
+	var clock time.Time
+	now = func() time.Time { return clock }
+	sleep = func(d time.Duration) { clock = clock.Add(d) }
+	defer func() {
+		now = time.Now
+		sleep = time.Sleep
+		ActiveRadio = nil
+		UseRegionSettings(nil)
+	}()
+
+	s := testSession()
+	ActiveRadio = &ackRadio{session: s, ackFrom: 1000}
+	UseRegionSettings(region.AS923_1())
+
+	// the largest payloads fit in 400 ms
+	if err := SendUplink(make([]uint8, 11), s); err != nil {
+		t.Error(err)
+	}
+	if !regionSettings.SetDataRate(4) || regionSettings.MaxPayload() != 125 {
+		t.Fatal("unexpected data rate")
+	}
+	if err := SendUplink(make([]uint8, 125), s); err != nil {
+		t.Error(err)
+	}
+
+	// not with MAC commands in the FOpts
+	regionSettings.SetDataRate(2)
+	s.macCommands = []uint8{
+		cidDevStatus, 1, 0, cidDevStatus, 1, 0, cidDevStatus, 1, 0,
+		cidDevStatus, 1, 0, cidDevStatus, 1, 0,
+	}
+	if err := SendUplink(make([]uint8, 11), s); err != ErrDwellTime {
+		t.Errorf("expected dwell time limit, got %v", err)
+	}
+}
@@ -17,3 +17,16 @@ type Radio interface {
 	SetHeaderType(headerType uint8)
 	LoraConfig(cnf Config)
 }
+
+// ContinuousReceiver is a Radio able to receive in the background, as the
+// sx126x and sx127x devices do: RxContinuous returns at once, and each packet
+// received is notified by a RadioEventRxDone event in the radio event channel,
+// to be read with ReadRxPacket. The reception goes on until SetStandby, Tx or
+// Rx.
+type ContinuousReceiver interface {
+	Radio
+	GetRadioEventChan() chan RadioEvent
+	RxContinuous() error
+	ReadRxPacket() []uint8
+	SetStandby()
+}
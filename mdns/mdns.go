@@ -0,0 +1,457 @@
+// Package mdns implements a multicast DNS responder and DNS-SD service
+// advertiser, so a device can be found by its hostname.local name and its
+// services browsed, over any netdev.Netdever supporting UDP multicast.
+//
+// The responder answers the A queries for the hostname, and the PTR, SRV and
+// TXT queries for the services. The names are not probed for conflicts, they
+// must be unique on the local network.
+//
+// RFC 6762: https://www.rfc-editor.org/rfc/rfc6762
+// RFC 6763: https://www.rfc-editor.org/rfc/rfc6763
+package mdns // import "tinygo.org/x/drivers/mdns"
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+)
+
+// DefaultTTL is the time to live of the records when the config has none,
+// in seconds.
+const DefaultTTL = 120
+
+const (
+	port = 5353
+
+	typeA   = 1
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+	typeANY = 255
+
+	classIN    = 1
+	cacheFlush = 0x8000
+
+	flagResponse      = 0x8000
+	flagAuthoritative = 0x0400
+
+	maxPacket = 1500
+
+	// index of the A record of the host in the records
+	hostRecord = 0
+
+	// Recv deadline, to check if the responder is stopped
+	pollInterval = 500 * time.Millisecond
+)
+
+var group = netip.AddrFrom4([4]byte{224, 0, 0, 251})
+
+var (
+	errNoHostname  = errors.New("mDNS hostname missing")
+	errRunning     = errors.New("mDNS responder already running")
+	errBadMessage  = errors.New("mDNS message malformed")
+	errNameTooLong = errors.New("mDNS name too long")
+)
+
+// Service is a DNS-SD service advertised by the responder.
+type Service struct {
+	// Instance is the user visible name of the service, such as
+	// "Living room thermostat".
+	Instance string
+
+	// Type is the service type and protocol, such as "_http._tcp".
+	Type string
+
+	// Port is the port of the service on the device.
+	Port uint16
+
+	// Text holds the key=value pairs of the TXT record.
+	Text []string
+}
+
+// Config is the configuration of the responder.
+type Config struct {
+	// Hostname is the name of the device, without the .local domain.
+	Hostname string
+
+	// Services are the services advertised.
+	Services []Service
+
+	// TTL is the time to live of the records, in seconds. The default is
+	// DefaultTTL.
+	TTL uint32
+}
+
+// record is a resource record, with an uncompressed rdata.
+type record struct {
+	name   []string
+	rtype  uint16
+	unique bool
+	rdata  []byte
+}
+
+// Responder answers the mDNS queries for the device.
+type Responder struct {
+	dev netdev.Netdever
+	cfg Config
+
+	mu      sync.Mutex
+	records []record
+	fd      int
+	running bool
+	done    chan struct{}
+}
+
+// New returns a responder sending and receiving through dev.
+func New(dev netdev.Netdever, cfg Config) *Responder {
+	if cfg.TTL == 0 {
+		cfg.TTL = DefaultTTL
+	}
+	return &Responder{
+		dev: dev,
+		cfg: cfg,
+		fd:  -1,
+	}
+}
+
+// Start joins the mDNS multicast group, announces the records and answers the
+// queries until Stop is called. The network link must be up.
+func (r *Responder) Start() error {
+	if r.cfg.Hostname == "" {
+		return errNoHostname
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running {
+		return errRunning
+	}
+
+	addr, err := r.dev.Addr()
+	if err != nil {
+		return err
+	}
+	r.records = r.buildRecords(addr)
+
+	fd, err := r.dev.Socket(netdev.AF_INET, netdev.SOCK_DGRAM, netdev.IPPROTO_UDP)
+	if err != nil {
+		return err
+	}
+	if err := r.dev.Bind(fd, netip.AddrPortFrom(netip.IPv4Unspecified(), port)); err != nil {
+		r.dev.Close(fd)
+		return err
+	}
+	if err := r.dev.SetSockOpt(fd, netdev.IPPROTO_IP, netdev.IP_ADD_MEMBERSHIP, group); err != nil {
+		r.dev.Close(fd)
+		return err
+	}
+	if err := r.dev.Connect(fd, "", netip.AddrPortFrom(group, port)); err != nil {
+		r.dev.Close(fd)
+		return err
+	}
+
+	r.fd = fd
+	r.running = true
+	r.done = make(chan struct{})
+	r.announce(r.cfg.TTL)
+	go r.serve(fd, r.done)
+	return nil
+}
+
+// Stop sends the goodbye records, so the caches forget the device, and leaves
+// the multicast group.
+func (r *Responder) Stop() {
+	r.mu.Lock()
+	if !r.running {
+		r.mu.Unlock()
+		return
+	}
+	r.running = false
+	done := r.done
+	r.mu.Unlock()
+
+	<-done
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.announce(0)
+	r.dev.SetSockOpt(r.fd, netdev.IPPROTO_IP, netdev.IP_DROP_MEMBERSHIP, group)
+	r.dev.Close(r.fd)
+	r.fd = -1
+}
+
+func (r *Responder) isRunning() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running
+}
+
+// serve answers the queries received on fd, and announces the records a
+// second time after a second, as recommended.
+func (r *Responder) serve(fd int, done chan struct{}) {
+	defer close(done)
+
+	buf := make([]byte, maxPacket)
+	announceAt := time.Now().Add(time.Second)
+
+	for r.isRunning() {
+		if !announceAt.IsZero() && time.Now().After(announceAt) {
+			r.mu.Lock()
+			r.announce(r.cfg.TTL)
+			r.mu.Unlock()
+			announceAt = time.Time{}
+		}
+
+		n, err := r.dev.Recv(fd, buf, 0, time.Now().Add(pollInterval))
+		if err != nil {
+			if e, ok := err.(interface{ Timeout() bool }); !ok || !e.Timeout() {
+				// Don't spin on a failing device
+				time.Sleep(pollInterval)
+			}
+			continue
+		}
+
+		r.mu.Lock()
+		if reply := r.respond(buf[:n]); reply != nil {
+			r.dev.Send(fd, reply, 0, time.Now().Add(pollInterval))
+		}
+		r.mu.Unlock()
+	}
+}
+
+// announce sends all the records with the given TTL, zero saying goodbye.
+// The responder must be locked.
+func (r *Responder) announce(ttl uint32) {
+	msg := header(len(r.records), 0)
+	for i := range r.records {
+		msg = appendRecord(msg, &r.records[i], ttl)
+	}
+	r.dev.Send(r.fd, msg, 0, time.Now().Add(pollInterval))
+}
+
+func (r *Responder) buildRecords(addr netip.Addr) []record {
+	host := []string{r.cfg.Hostname, "local"}
+	a := addr.As4()
+	records := []record{{name: host, rtype: typeA, unique: true, rdata: a[:]}}
+
+	for _, s := range r.cfg.Services {
+		stype := append(strings.Split(s.Type, "."), "local")
+		instance := append([]string{s.Instance}, stype...)
+
+		srv := binary.BigEndian.AppendUint16(make([]byte, 4), s.Port)
+		srv = appendName(srv, host)
+
+		var txt []byte
+		for _, kv := range s.Text {
+			txt = append(txt, byte(len(kv)))
+			txt = append(txt, kv...)
+		}
+		if len(txt) == 0 {
+			// A TXT record can't be empty
+			txt = []byte{0}
+		}
+
+		// The service types are enumerated once
+		meta := record{name: []string{"_services", "_dns-sd", "_udp", "local"}, rtype: typePTR, rdata: appendName(nil, stype)}
+		known := false
+		for _, rec := range records {
+			known = known || rec.rtype == typePTR && string(rec.rdata) == string(meta.rdata)
+		}
+		if !known {
+			records = append(records, meta)
+		}
+
+		records = append(records,
+			record{name: stype, rtype: typePTR, rdata: appendName(nil, instance)},
+			record{name: instance, rtype: typeSRV, unique: true, rdata: srv},
+			record{name: instance, rtype: typeTXT, unique: true, rdata: txt},
+		)
+	}
+	return records
+}
+
+// respond returns the reply to the query, or nil if there is nothing to
+// answer.
+func (r *Responder) respond(query []byte) []byte {
+	if len(query) < 12 || binary.BigEndian.Uint16(query[2:])&flagResponse != 0 {
+		return nil
+	}
+
+	var answers, additionals []int
+	has := func(list []int, i int) bool {
+		for _, j := range list {
+			if j == i {
+				return true
+			}
+		}
+		return false
+	}
+	addExtra := func(i int) {
+		if !has(additionals, i) {
+			additionals = append(additionals, i)
+		}
+	}
+	// add adds the record i, and the records needed to resolve it as
+	// additional records: the SRV and TXT records of a service instance,
+	// and the A record of the host.
+	add := func(i int) {
+		if has(answers, i) {
+			return
+		}
+		answers = append(answers, i)
+		rec := &r.records[i]
+		switch rec.rtype {
+		case typePTR:
+			target := rdataName(rec)
+			srv := false
+			for j := range r.records {
+				other := &r.records[j]
+				if other.rtype != typePTR && nameEqual(other.name, target) {
+					addExtra(j)
+					srv = srv || other.rtype == typeSRV
+				}
+			}
+			if srv {
+				addExtra(hostRecord)
+			}
+		case typeSRV:
+			addExtra(hostRecord)
+		}
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(query[4:]))
+	off := 12
+	for q := 0; q < qdcount; q++ {
+		name, n, err := readName(query, off)
+		if err != nil || n+4 > len(query) {
+			return nil
+		}
+		qtype := binary.BigEndian.Uint16(query[n:])
+		off = n + 4
+
+		for i := range r.records {
+			rec := &r.records[i]
+			if (qtype == rec.rtype || qtype == typeANY) && nameEqual(name, rec.name) {
+				add(i)
+			}
+		}
+	}
+	if len(answers) == 0 {
+		return nil
+	}
+
+	// Don't repeat the answers in the additional records
+	extra := additionals[:0]
+	for _, i := range additionals {
+		if !has(answers, i) {
+			extra = append(extra, i)
+		}
+	}
+
+	msg := header(len(answers), len(extra))
+	for _, i := range answers {
+		msg = appendRecord(msg, &r.records[i], r.cfg.TTL)
+	}
+	for _, i := range extra {
+		msg = appendRecord(msg, &r.records[i], r.cfg.TTL)
+	}
+	return msg
+}
+
+// header returns the header of a response.
+func header(ancount, arcount int) []byte {
+	msg := make([]byte, 12, maxPacket)
+	binary.BigEndian.PutUint16(msg[2:], flagResponse|flagAuthoritative)
+	binary.BigEndian.PutUint16(msg[6:], uint16(ancount))
+	binary.BigEndian.PutUint16(msg[10:], uint16(arcount))
+	return msg
+}
+
+func appendRecord(msg []byte, rec *record, ttl uint32) []byte {
+	class := uint16(classIN)
+	if rec.unique {
+		class |= cacheFlush
+	}
+	msg = appendName(msg, rec.name)
+	msg = binary.BigEndian.AppendUint16(msg, rec.rtype)
+	msg = binary.BigEndian.AppendUint16(msg, class)
+	msg = binary.BigEndian.AppendUint32(msg, ttl)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(rec.rdata)))
+	return append(msg, rec.rdata...)
+}
+
+// appendName appends the uncompressed name.
+func appendName(b []byte, labels []string) []byte {
+	for _, l := range labels {
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	return append(b, 0)
+}
+
+// rdataName returns the name pointed to by a PTR record.
+func rdataName(rec *record) []string {
+	name, _, _ := readName(rec.rdata, 0)
+	return name
+}
+
+// readName reads the possibly compressed name at off in msg, and returns its
+// labels and the offset following it.
+func readName(msg []byte, off int) ([]string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return nil, 0, errBadMessage
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return labels, end, nil
+		case l&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return nil, 0, errBadMessage
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			// Pointers only go backwards, bound them anyway
+			jumps++
+			if jumps > 16 {
+				return nil, 0, errNameTooLong
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		case l&0xC0 != 0:
+			return nil, 0, errBadMessage
+		default:
+			if off+1+l > len(msg) {
+				return nil, 0, errBadMessage
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+// nameEqual compares names, ignoring the ASCII case.
+func nameEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,290 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+)
+
+// fakeDev is a network device receiving the queued queries, and keeping what
+// is sent.
+type fakeDev struct {
+	mu      sync.Mutex
+	laddr   netip.AddrPort
+	raddr   netip.AddrPort
+	group   netip.Addr
+	closed  bool
+	sent    [][]byte
+	queries chan []byte
+}
+
+func newFakeDev() *fakeDev {
+	return &fakeDev{queries: make(chan []byte, 4)}
+}
+
+func (d *fakeDev) GetHostByName(name string) (netip.Addr, error) {
+	return netip.Addr{}, netdev.ErrHostUnknown
+}
+
+func (d *fakeDev) Addr() (netip.Addr, error) {
+	return netip.MustParseAddr("192.168.1.42"), nil
+}
+
+func (d *fakeDev) Socket(domain int, stype int, protocol int) (int, error) {
+	return 3, nil
+}
+
+func (d *fakeDev) Bind(sockfd int, ip netip.AddrPort) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.laddr = ip
+	return nil
+}
+
+func (d *fakeDev) Connect(sockfd int, host string, ip netip.AddrPort) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.raddr = ip
+	return nil
+}
+
+func (d *fakeDev) Listen(sockfd int, backlog int) error {
+	return netdev.ErrNotSupported
+}
+
+func (d *fakeDev) Accept(sockfd int) (int, netip.AddrPort, error) {
+	return -1, netip.AddrPort{}, netdev.ErrNotSupported
+}
+
+func (d *fakeDev) Send(sockfd int, buf []byte, flags int, deadline time.Time) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sent = append(d.sent, append([]byte(nil), buf...))
+	return len(buf), nil
+}
+
+func (d *fakeDev) Recv(sockfd int, buf []byte, flags int, deadline time.Time) (int, error) {
+	select {
+	case q := <-d.queries:
+		return copy(buf, q), nil
+	case <-time.After(time.Until(deadline)):
+		return -1, netdev.ErrTimeout
+	}
+}
+
+func (d *fakeDev) Close(sockfd int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	return nil
+}
+
+func (d *fakeDev) SetSockOpt(sockfd int, level int, opt int, value interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch opt {
+	case netdev.IP_ADD_MEMBERSHIP:
+		d.group = value.(netip.Addr)
+	case netdev.IP_DROP_MEMBERSHIP:
+		d.group = netip.Addr{}
+	}
+	return nil
+}
+
+// waitSent waits for n messages to be sent, and returns them.
+func (d *fakeDev) waitSent(t *testing.T, n int) [][]byte {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		d.mu.Lock()
+		sent := d.sent
+		d.mu.Unlock()
+		if len(sent) >= n {
+			return sent
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%d messages sent, expected %d", len(d.sent), n)
+	return nil
+}
+
+var testConfig = Config{
+	Hostname: "thermostat",
+	Services: []Service{
+		{Instance: "Living room", Type: "_http._tcp", Port: 80, Text: []string{"path=/"}},
+	},
+}
+
+// query returns a query for the name and type.
+func query(name string, qtype uint16) []byte {
+	msg := make([]byte, 12)
+	msg[5] = 1
+	msg = appendName(msg, strings.Split(name, "."))
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, classIN)
+}
+
+type testRecord struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+	rdata []byte
+}
+
+// parse returns the answers and additional records of a response.
+func parse(t *testing.T, msg []byte) (answers, additionals []testRecord) {
+	t.Helper()
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[2:]) != flagResponse|flagAuthoritative {
+		t.Fatalf("bad response header % x", msg)
+	}
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+	arcount := int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	var records []testRecord
+	for i := 0; i < ancount+arcount; i++ {
+		name, n, err := readName(msg, off)
+		if err != nil || n+10 > len(msg) {
+			t.Fatalf("bad record %d: %v", i, err)
+		}
+		rdlen := int(binary.BigEndian.Uint16(msg[n+8:]))
+		records = append(records, testRecord{
+			name:  strings.Join(name, "."),
+			rtype: binary.BigEndian.Uint16(msg[n:]),
+			class: binary.BigEndian.Uint16(msg[n+2:]),
+			ttl:   binary.BigEndian.Uint32(msg[n+4:]),
+			rdata: msg[n+10 : n+10+rdlen],
+		})
+		off = n + 10 + rdlen
+	}
+	return records[:ancount], records[ancount:]
+}
+
+func TestRespond(t *testing.T) {
+	r := New(newFakeDev(), testConfig)
+	r.records = r.buildRecords(netip.MustParseAddr("192.168.1.42"))
+
+	// the names are not case sensitive
+	answers, additionals := parse(t, r.respond(query("Thermostat.LOCAL", typeA)))
+	if len(answers) != 1 || len(additionals) != 0 {
+		t.Fatalf("unexpected records %v %v", answers, additionals)
+	}
+	a := answers[0]
+	if a.name != "thermostat.local" || a.class != classIN|cacheFlush || a.ttl != DefaultTTL ||
+		string(a.rdata) != "\xc0\xa8\x01\x2a" {
+		t.Errorf("unexpected A record %+v", a)
+	}
+
+	// browsing the service types
+	answers, _ = parse(t, r.respond(query("_services._dns-sd._udp.local", typePTR)))
+	if len(answers) != 1 || string(answers[0].rdata) != "\x05_http\x04_tcp\x05local\x00" {
+		t.Errorf("unexpected service types %+v", answers)
+	}
+
+	// browsing the service resolves it in the additional records
+	answers, additionals = parse(t, r.respond(query("_http._tcp.local", typePTR)))
+	if len(answers) != 1 || answers[0].class != classIN ||
+		string(answers[0].rdata) != "\x0bLiving room\x05_http\x04_tcp\x05local\x00" {
+		t.Errorf("unexpected PTR record %+v", answers)
+	}
+	if len(additionals) != 3 || additionals[0].rtype != typeSRV || additionals[1].rtype != typeTXT ||
+		additionals[2].rtype != typeA {
+		t.Fatalf("unexpected additional records %+v", additionals)
+	}
+	if srv := additionals[0].rdata; binary.BigEndian.Uint16(srv[4:]) != 80 ||
+		string(srv[6:]) != "\x0athermostat\x05local\x00" {
+		t.Errorf("unexpected SRV record % x", srv)
+	}
+	if txt := additionals[1].rdata; string(txt) != "\x06path=/" {
+		t.Errorf("unexpected TXT record %q", txt)
+	}
+
+	// ANY for the instance, with a compressed name
+	q := query("_http._tcp.local", typePTR)
+	binary.BigEndian.PutUint16(q[4:], 2)
+	q = append(q, 11)
+	q = append(q, "living room"...)
+	q = append(q, 0xc0, 12)
+	q = binary.BigEndian.AppendUint16(q, typeANY)
+	q = binary.BigEndian.AppendUint16(q, classIN)
+	answers, additionals = parse(t, r.respond(q))
+	if len(answers) != 3 || len(additionals) != 1 || additionals[0].rtype != typeA {
+		t.Errorf("unexpected records %+v %+v", answers, additionals)
+	}
+
+	// nothing to answer
+	if reply := r.respond(query("printer.local", typeA)); reply != nil {
+		t.Errorf("unexpected reply % x", reply)
+	}
+	if reply := r.respond(query("thermostat.local", typeTXT)); reply != nil {
+		t.Errorf("unexpected reply % x", reply)
+	}
+	response := query("thermostat.local", typeA)
+	binary.BigEndian.PutUint16(response[2:], flagResponse)
+	if reply := r.respond(response); reply != nil {
+		t.Errorf("response answered % x", reply)
+	}
+
+	// malformed names
+	loop := append(make([]byte, 12), 0xc0, 12, 0, 1, 0, 1)
+	loop[5] = 1
+	if reply := r.respond(loop); reply != nil {
+		t.Errorf("unexpected reply % x", reply)
+	}
+	if reply := r.respond(query("thermostat.local", typeA)[:20]); reply != nil {
+		t.Errorf("unexpected reply % x", reply)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	dev := newFakeDev()
+	r := New(dev, testConfig)
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Start(); err != errRunning {
+		t.Errorf("expected already running, got %v", err)
+	}
+
+	dev.mu.Lock()
+	if dev.laddr.Port() != port || dev.group != group || dev.raddr != netip.AddrPortFrom(group, port) {
+		t.Errorf("unexpected socket %s %s %s", dev.laddr, dev.group, dev.raddr)
+	}
+	dev.mu.Unlock()
+
+	// announced
+	sent := dev.waitSent(t, 1)
+	if answers, _ := parse(t, sent[0]); len(answers) != 5 || answers[0].ttl != DefaultTTL {
+		t.Errorf("unexpected announcement %+v", answers)
+	}
+
+	dev.queries <- query("thermostat.local", typeA)
+	sent = dev.waitSent(t, 2)
+	if answers, _ := parse(t, sent[len(sent)-1]); len(answers) != 1 || answers[0].rtype != typeA {
+		t.Errorf("unexpected reply %+v", answers)
+	}
+
+	r.Stop()
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	answers, _ := parse(t, dev.sent[len(dev.sent)-1])
+	for _, a := range answers {
+		if a.ttl != 0 {
+			t.Errorf("goodbye record with TTL %d", a.ttl)
+		}
+	}
+	if dev.group.IsValid() || !dev.closed {
+		t.Errorf("socket not released")
+	}
+}
+
+func TestNoHostname(t *testing.T) {
+	if err := New(newFakeDev(), Config{}).Start(); err != errNoHostname {
+		t.Errorf("expected no hostname, got %v", err)
+	}
+}
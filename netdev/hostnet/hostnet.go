@@ -0,0 +1,190 @@
+// Package hostnet implements the netdev.Netdever and netlink.Netlinker
+// interfaces with the sockets of the host running the program, so network
+// code written for TinyGo devices can be exercised with ordinary go test.
+//
+// Latency, UDP packet loss and TCP connection drops can be injected to test
+// how the code copes with a poor network.
+//
+// The netdev.UseNetdev binding only exists in TinyGo, so tests call the
+// Netdever methods directly, or through the code under test.
+package hostnet // import "tinygo.org/x/drivers/netdev/hostnet"
+
+import (
+	"crypto/tls"
+	"errors"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+	"tinygo.org/x/drivers/netlink"
+)
+
+var (
+	_ netdev.Netdever   = &Device{}
+	_ netlink.Netlinker = &Device{}
+)
+
+var errLinkDown = errors.New("Network link down")
+
+// DefaultMaxSockets is the number of sockets when Config.MaxSockets is zero,
+// in the range of what the network devices support.
+const DefaultMaxSockets = 8
+
+// Config is the configuration of the host network device.
+type Config struct {
+	// Addr is the address returned by Addr. The default is 127.0.0.1.
+	Addr netip.Addr
+
+	// MAC is the address returned by GetHardwareAddr. The default is a
+	// locally administered address.
+	MAC net.HardwareAddr
+
+	// MaxSockets is the number of sockets that can be open at once.
+	MaxSockets int
+
+	// Latency is added to every Send and Recv.
+	Latency time.Duration
+
+	// Loss is the probability, from 0 to 1, of losing a UDP datagram sent
+	// or received.
+	Loss float64
+
+	// Drop is the probability, from 0 to 1, of a TCP connection being
+	// dropped on each Send or Recv.
+	Drop float64
+
+	// Seed of the random generator deciding losses and drops, for
+	// reproducible runs. Zero seeds it from the time.
+	Seed int64
+
+	// TLSConfig is used by the IPPROTO_TLS sockets. The server name is set
+	// from the host passed to Connect.
+	TLSConfig *tls.Config
+}
+
+// Device is a network device using the host sockets.
+type Device struct {
+	cfg      Config
+	notifyCb func(netlink.Event)
+	mu       sync.Mutex
+
+	up      bool
+	rand    *rand.Rand
+	sockets map[int]*socket
+	nextFd  int
+
+	// bytes sent and received on sockets
+	txBytes uint64
+	rxBytes uint64
+}
+
+// New returns a host network device. The network link is down until
+// NetConnect is called.
+func New(cfg Config) *Device {
+	if !cfg.Addr.IsValid() {
+		cfg.Addr = netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	}
+	if cfg.MAC == nil {
+		cfg.MAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	}
+	if cfg.MaxSockets == 0 {
+		cfg.MaxSockets = DefaultMaxSockets
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Device{
+		cfg:     cfg,
+		rand:    rand.New(rand.NewSource(seed)),
+		sockets: make(map[int]*socket),
+	}
+}
+
+// NetConnect brings the network link up. The connect parameters are not
+// used, there is no Wifi network to join.
+func (d *Device) NetConnect(params *netlink.ConnectParams) error {
+	d.mu.Lock()
+	if d.up {
+		d.mu.Unlock()
+		return netlink.ErrConnected
+	}
+	d.up = true
+	cb := d.notifyCb
+	d.mu.Unlock()
+
+	if cb != nil {
+		cb(netlink.EventNetUp)
+	}
+	return nil
+}
+
+// NetDisconnect brings the network link down, dropping the open sockets.
+func (d *Device) NetDisconnect() {
+	d.mu.Lock()
+	if !d.up {
+		d.mu.Unlock()
+		return
+	}
+	d.up = false
+	for _, s := range d.sockets {
+		s.drop()
+	}
+	cb := d.notifyCb
+	d.mu.Unlock()
+
+	if cb != nil {
+		cb(netlink.EventNetDown)
+	}
+}
+
+func (d *Device) NetNotify(cb func(netlink.Event)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.notifyCb = cb
+}
+
+func (d *Device) NetScan() ([]netlink.AccessPoint, error) {
+	return nil, netlink.ErrNotSupported
+}
+
+func (d *Device) NetInfo() (netlink.LinkInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return netlink.LinkInfo{
+		Up:      d.up,
+		TxBytes: d.txBytes,
+		RxBytes: d.rxBytes,
+	}, nil
+}
+
+func (d *Device) GetHardwareAddr() (net.HardwareAddr, error) {
+	return d.cfg.MAC, nil
+}
+
+// DropConnections drops the open TCP connections, as if their peers went
+// away. Send then fails with net.ErrClosed, and Recv with io.EOF.
+func (d *Device) DropConnections() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, s := range d.sockets {
+		if s.protocol != netdev.IPPROTO_UDP && s.conn != nil {
+			s.drop()
+		}
+	}
+}
+
+// chance returns true with the given probability.
+func (d *Device) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rand.Float64() < p
+}
@@ -0,0 +1,218 @@
+package hostnet
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+	"tinygo.org/x/drivers/netdev/netdevtest"
+	"tinygo.org/x/drivers/netlink"
+)
+
+func newTestDevice(t *testing.T, cfg Config) *Device {
+	d := New(cfg)
+	if err := d.NetConnect(nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.NetDisconnect)
+	return d
+}
+
+// echoServer starts a TCP server on the host, echoing what it receives.
+func echoServer(t *testing.T) netip.AddrPort {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).AddrPort()
+}
+
+// freePort returns a port that is not in use on the host.
+func freePort(t *testing.T) uint16 {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).AddrPort().Port()
+}
+
+func TestTCPClient(t *testing.T) {
+	d := newTestDevice(t, Config{})
+	buf := make([]byte, 64)
+
+	fd, err := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Connect(fd, "", echoServer(t)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Send(fd, []byte("hello"), 0, time.Time{}); n != 5 || err != nil {
+		t.Fatalf("send failed: %d, %v", n, err)
+	}
+	if n, err := d.Recv(fd, buf, 0, time.Time{}); err != nil || string(buf[:n]) != "hello" {
+		t.Errorf("unexpected data %q: %v", buf[:n], err)
+	}
+
+	deadline := time.Now().Add(10 * time.Millisecond)
+	if _, err := d.Recv(fd, buf, 0, deadline); err != netdev.ErrTimeout {
+		t.Errorf("expected timeout, got %v", err)
+	}
+
+	if info, _ := d.NetInfo(); !info.Up || info.TxBytes != 5 || info.RxBytes != 5 {
+		t.Errorf("unexpected link info %+v", info)
+	}
+
+	// the connection is dropped
+	d.DropConnections()
+	if _, err := d.Send(fd, []byte("hello"), 0, time.Time{}); err != net.ErrClosed {
+		t.Errorf("expected closed connection, got %v", err)
+	}
+	if _, err := d.Recv(fd, buf, 0, time.Time{}); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	if err := d.Close(fd); err != nil {
+		t.Error(err)
+	}
+	if err := d.Close(fd); err != netdev.ErrInvalidSocketFd {
+		t.Errorf("expected invalid socket, got %v", err)
+	}
+}
+
+func TestTCPServer(t *testing.T) {
+	d := newTestDevice(t, Config{})
+	buf := make([]byte, 64)
+
+	fd, _ := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	laddr := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), freePort(t))
+	d.Bind(fd, laddr)
+	if err := d.Listen(fd, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := net.Dial("tcp4", laddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("ping"))
+
+	c, raddr, err := d.Accept(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raddr != client.LocalAddr().(*net.TCPAddr).AddrPort() {
+		t.Errorf("unexpected remote address %s", raddr)
+	}
+	if n, err := d.Recv(c, buf, 0, time.Time{}); err != nil || string(buf[:n]) != "ping" {
+		t.Errorf("unexpected data %q: %v", buf[:n], err)
+	}
+
+	// the client leaves
+	client.Close()
+	if _, err := d.Recv(c, buf, 0, time.Time{}); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	d.Close(c)
+	d.Close(fd)
+}
+
+func TestUDP(t *testing.T) {
+	d := newTestDevice(t, Config{Seed: 1})
+	buf := make([]byte, 64)
+
+	peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	fd, _ := d.Socket(netdev.AF_INET, netdev.SOCK_DGRAM, netdev.IPPROTO_UDP)
+	if err := d.Connect(fd, "", peer.LocalAddr().(*net.UDPAddr).AddrPort()); err != nil {
+		t.Fatal(err)
+	}
+	d.Send(fd, []byte("one"), 0, time.Time{})
+	d.Send(fd, []byte("two"), 0, time.Time{})
+
+	// datagram boundaries are kept
+	for _, want := range []string{"one", "two"} {
+		n, from, err := peer.ReadFromUDPAddrPort(buf)
+		if err != nil || string(buf[:n]) != want {
+			t.Fatalf("unexpected datagram %q: %v", buf[:n], err)
+		}
+		peer.WriteToUDPAddrPort([]byte("re:"+want), from)
+	}
+	for _, want := range []string{"re:one", "re:two"} {
+		if n, err := d.Recv(fd, buf, 0, time.Time{}); err != nil || string(buf[:n]) != want {
+			t.Errorf("unexpected datagram %q: %v", buf[:n], err)
+		}
+	}
+
+	// every datagram is lost
+	d.cfg.Loss = 1
+	if n, err := d.Send(fd, []byte("lost"), 0, time.Time{}); n != 4 || err != nil {
+		t.Errorf("send failed: %d, %v", n, err)
+	}
+	peer.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, _, err := peer.ReadFromUDPAddrPort(buf); err == nil {
+		t.Error("lost datagram received")
+	}
+}
+
+func TestLinkDown(t *testing.T) {
+	d := New(Config{MaxSockets: 1})
+	var events []netlink.Event
+	d.NetNotify(func(e netlink.Event) { events = append(events, e) })
+
+	if _, err := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP); err != errLinkDown {
+		t.Errorf("expected link down, got %v", err)
+	}
+	d.NetConnect(nil)
+	if err := d.NetConnect(nil); err != netlink.ErrConnected {
+		t.Errorf("expected already connected, got %v", err)
+	}
+
+	fd, _ := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	d.Connect(fd, "", echoServer(t))
+	if _, err := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP); err != netdev.ErrNoMoreSockets {
+		t.Errorf("expected no more sockets, got %v", err)
+	}
+	if _, err := d.Socket(netdev.AF_INET, netdev.SOCK_DGRAM, netdev.IPPROTO_TCP); err != netdev.ErrProtocolNotSupported {
+		t.Errorf("expected protocol not supported, got %v", err)
+	}
+
+	d.NetDisconnect()
+	if _, err := d.Send(fd, []byte("hello"), 0, time.Time{}); err != net.ErrClosed {
+		t.Errorf("expected closed connection, got %v", err)
+	}
+	if len(events) != 2 || events[0] != netlink.EventNetUp || events[1] != netlink.EventNetDown {
+		t.Errorf("unexpected events %v", events)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	netdevtest.Run(t, netdevtest.Config{
+		New: func(t *testing.T) netdev.Netdever {
+			return newTestDevice(t, Config{})
+		},
+		Servers:    netdevtest.HostServers(t),
+		MaxSockets: DefaultMaxSockets,
+	})
+}
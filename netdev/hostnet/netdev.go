@@ -0,0 +1,422 @@
+package hostnet
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+)
+
+type socket struct {
+	protocol int
+	laddr    netip.AddrPort
+	raddr    netip.AddrPort // destination of an UDP socket
+
+	conn     net.Conn         // TCP and TLS connections
+	udp      *net.UDPConn     // bound UDP socket
+	listener *net.TCPListener // TCP server
+
+	dropped bool
+}
+
+// drop closes the host socket, but keeps the socket open for the caller.
+func (s *socket) drop() {
+	s.dropped = true
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.listener != nil {
+		s.listener.Close()
+	}
+}
+
+func (d *Device) GetHostByName(name string) (netip.Addr, error) {
+	if ip, err := netip.ParseAddr(name); err == nil {
+		return ip, nil
+	}
+
+	d.mu.Lock()
+	up := d.up
+	d.mu.Unlock()
+	if !up {
+		return netip.Addr{}, errLinkDown
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(context.Background(), "ip4", name)
+	if err != nil || len(addrs) == 0 {
+		return netip.Addr{}, netdev.ErrHostUnknown
+	}
+	return addrs[0], nil
+}
+
+func (d *Device) Addr() (netip.Addr, error) {
+	return d.cfg.Addr, nil
+}
+
+func (d *Device) Socket(domain int, stype int, protocol int) (int, error) {
+	switch domain {
+	case netdev.AF_INET:
+	default:
+		return -1, netdev.ErrFamilyNotSupported
+	}
+
+	switch {
+	case protocol == netdev.IPPROTO_TCP && stype == netdev.SOCK_STREAM:
+	case protocol == netdev.IPPROTO_TLS && stype == netdev.SOCK_STREAM:
+	case protocol == netdev.IPPROTO_UDP && stype == netdev.SOCK_DGRAM:
+	default:
+		return -1, netdev.ErrProtocolNotSupported
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.up {
+		return -1, errLinkDown
+	}
+	if len(d.sockets) >= d.cfg.MaxSockets {
+		return -1, netdev.ErrNoMoreSockets
+	}
+
+	sockfd := d.nextFd
+	d.nextFd++
+	d.sockets[sockfd] = &socket{protocol: protocol}
+	return sockfd, nil
+}
+
+func (d *Device) socket(sockfd int) (*socket, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, ok := d.sockets[sockfd]
+	if !ok {
+		return nil, netdev.ErrInvalidSocketFd
+	}
+	return s, nil
+}
+
+// state returns a copy of the socket, to use its host sockets without holding
+// the lock.
+func (d *Device) state(sockfd int) (socket, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, ok := d.sockets[sockfd]
+	if !ok {
+		return socket{}, netdev.ErrInvalidSocketFd
+	}
+	return *s, nil
+}
+
+// dropped reports if the socket was dropped, or closed.
+func (d *Device) dropped(sockfd int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, ok := d.sockets[sockfd]
+	return !ok || s.dropped
+}
+
+// drop drops the connection of the socket.
+func (d *Device) drop(sockfd int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if s, ok := d.sockets[sockfd]; ok {
+		s.drop()
+	}
+}
+
+func (d *Device) Bind(sockfd int, ip netip.AddrPort) error {
+	s, err := d.socket(sockfd)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s.laddr = ip
+	if s.protocol == netdev.IPPROTO_UDP {
+		udp, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(ip))
+		if err != nil {
+			return err
+		}
+		s.udp = udp
+	}
+	return nil
+}
+
+func (d *Device) Connect(sockfd int, host string, ip netip.AddrPort) error {
+	s, err := d.socket(sockfd)
+	if err != nil {
+		return err
+	}
+
+	switch s.protocol {
+	case netdev.IPPROTO_UDP:
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if s.udp == nil {
+			udp, err := net.ListenUDP("udp4", nil)
+			if err != nil {
+				return err
+			}
+			s.udp = udp
+		}
+		s.raddr = ip
+		return nil
+
+	case netdev.IPPROTO_TCP:
+		dialer := net.Dialer{}
+		if s.laddr.IsValid() {
+			dialer.LocalAddr = net.TCPAddrFromAddrPort(s.laddr)
+		}
+		conn, err := dialer.Dial("tcp4", ip.String())
+		if err != nil {
+			return err
+		}
+		return d.connected(s, conn)
+
+	case netdev.IPPROTO_TLS:
+		addr := ip.String()
+		if host != "" {
+			addr = net.JoinHostPort(host, strconv.Itoa(int(ip.Port())))
+		}
+		cfg := &tls.Config{}
+		if d.cfg.TLSConfig != nil {
+			cfg = d.cfg.TLSConfig.Clone()
+		}
+		if host != "" {
+			cfg.ServerName = host
+		}
+		conn, err := tls.Dial("tcp4", addr, cfg)
+		if err != nil {
+			return err
+		}
+		return d.connected(s, conn)
+	}
+
+	return netdev.ErrProtocolNotSupported
+}
+
+// connected sets the connection of the socket, unless the link went down
+// meanwhile.
+func (d *Device) connected(s *socket, conn net.Conn) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.up {
+		conn.Close()
+		return errLinkDown
+	}
+	s.conn = conn
+	return nil
+}
+
+func (d *Device) Listen(sockfd int, backlog int) error {
+	s, err := d.socket(sockfd)
+	if err != nil {
+		return err
+	}
+
+	switch s.protocol {
+	case netdev.IPPROTO_TCP:
+	case netdev.IPPROTO_UDP:
+		// A bound UDP socket already receives
+		return nil
+	default:
+		return netdev.ErrProtocolNotSupported
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	listener, err := net.ListenTCP("tcp4", net.TCPAddrFromAddrPort(s.laddr))
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	return nil
+}
+
+func (d *Device) Accept(sockfd int) (int, netip.AddrPort, error) {
+	s, err := d.state(sockfd)
+	if err != nil {
+		return -1, netip.AddrPort{}, err
+	}
+	if s.listener == nil {
+		return -1, netip.AddrPort{}, errors.New("Socket not listening")
+	}
+
+	conn, err := s.listener.AcceptTCP()
+	if err != nil {
+		return -1, netip.AddrPort{}, net.ErrClosed
+	}
+	raddr := conn.RemoteAddr().(*net.TCPAddr).AddrPort()
+
+	fd, err := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	if err != nil {
+		conn.Close()
+		return -1, netip.AddrPort{}, err
+	}
+	c, _ := d.socket(fd)
+	if err := d.connected(c, conn); err != nil {
+		d.Close(fd)
+		return -1, netip.AddrPort{}, err
+	}
+	return fd, raddr, nil
+}
+
+// ioError converts the errors of the host sockets to the ones returned by the
+// network devices.
+func (d *Device) ioError(sockfd int, err error) error {
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return netdev.ErrTimeout
+	case d.dropped(sockfd), errors.Is(err, net.ErrClosed):
+		return net.ErrClosed
+	}
+	return err
+}
+
+func (d *Device) Send(sockfd int, buf []byte, flags int, deadline time.Time) (int, error) {
+	s, err := d.state(sockfd)
+	if err != nil {
+		return -1, err
+	}
+
+	time.Sleep(d.cfg.Latency)
+
+	var n int
+	switch {
+	case s.dropped:
+		return -1, net.ErrClosed
+	case s.udp != nil:
+		if !s.raddr.IsValid() {
+			return -1, errors.New("Socket not connected")
+		}
+		if d.chance(d.cfg.Loss) {
+			n = len(buf)
+			break
+		}
+		s.udp.SetWriteDeadline(deadline)
+		n, err = s.udp.WriteToUDPAddrPort(buf, s.raddr)
+	case s.conn != nil:
+		if d.chance(d.cfg.Drop) {
+			d.drop(sockfd)
+			return -1, net.ErrClosed
+		}
+		s.conn.SetWriteDeadline(deadline)
+		n, err = s.conn.Write(buf)
+	default:
+		return -1, errors.New("Socket not connected")
+	}
+	if err != nil {
+		return -1, d.ioError(sockfd, err)
+	}
+
+	d.mu.Lock()
+	d.txBytes += uint64(n)
+	d.mu.Unlock()
+	return n, nil
+}
+
+func (d *Device) Recv(sockfd int, buf []byte, flags int, deadline time.Time) (int, error) {
+	s, err := d.state(sockfd)
+	if err != nil {
+		return -1, err
+	}
+
+	var n int
+	switch {
+	case s.dropped && s.protocol == netdev.IPPROTO_UDP:
+		return -1, net.ErrClosed
+	case s.dropped:
+		return -1, io.EOF
+	case s.udp != nil:
+		s.udp.SetReadDeadline(deadline)
+		for {
+			n, _, err = s.udp.ReadFromUDPAddrPort(buf)
+			if err != nil || !d.chance(d.cfg.Loss) {
+				break
+			}
+		}
+	case s.conn != nil:
+		if d.chance(d.cfg.Drop) {
+			d.drop(sockfd)
+			return -1, io.EOF
+		}
+		s.conn.SetReadDeadline(deadline)
+		n, err = s.conn.Read(buf)
+		if err != nil && d.dropped(sockfd) {
+			return -1, io.EOF
+		}
+	default:
+		return -1, errors.New("Socket not connected")
+	}
+	if err == io.EOF {
+		return -1, io.EOF
+	}
+	if err != nil {
+		return -1, d.ioError(sockfd, err)
+	}
+
+	time.Sleep(d.cfg.Latency)
+
+	d.mu.Lock()
+	d.rxBytes += uint64(n)
+	d.mu.Unlock()
+	return n, nil
+}
+
+func (d *Device) Close(sockfd int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+	s.drop()
+	delete(d.sockets, sockfd)
+	return nil
+}
+
+func (d *Device) SetSockOpt(sockfd int, level int, opt int, value interface{}) error {
+	s, err := d.state(sockfd)
+	if err != nil {
+		return err
+	}
+
+	tcp, ok := s.conn.(*net.TCPConn)
+	if !ok {
+		return netdev.ErrNotSupported
+	}
+
+	switch {
+	case level == netdev.SOL_SOCKET && opt == netdev.SO_KEEPALIVE:
+		on, ok := value.(bool)
+		if !ok {
+			return netdev.ErrNotSupported
+		}
+		return tcp.SetKeepAlive(on)
+	case level == netdev.SOL_TCP && opt == netdev.TCP_KEEPINTVL:
+		secs, ok := value.(int)
+		if !ok {
+			return netdev.ErrNotSupported
+		}
+		return tcp.SetKeepAlivePeriod(time.Duration(secs) * time.Second)
+	}
+	return netdev.ErrNotSupported
+}
@@ -25,6 +25,15 @@ const (
 	F_SETFL     = 0x4
 )
 
+// Multicast socket options, set on a bound UDP socket with
+// SetSockOpt(sockfd, IPPROTO_IP, opt, group), the group being the netip.Addr
+// of the multicast group.
+const (
+	IPPROTO_IP         = 0x0
+	IP_ADD_MEMBERSHIP  = 0x23
+	IP_DROP_MEMBERSHIP = 0x24
+)
+
 // GethostByName() errors
 var (
 	ErrHostUnknown = errors.New("Host unknown")
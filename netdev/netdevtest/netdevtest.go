@@ -0,0 +1,350 @@
+// Package netdevtest is a conformance suite for netdev.Netdever
+// implementations. It checks the socket semantics the "net" package relies
+// on: socket exhaustion, deadlines, blocking receives, half-close, UDP
+// datagram boundaries and the error values.
+//
+// A driver, or a simulated device model, is tested by calling Run from a Go
+// test with the servers the device can reach. HostServers starts them on the
+// host, for devices using the host network such as hostnet.
+package netdevtest // import "tinygo.org/x/drivers/netdev/netdevtest"
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+)
+
+// Servers are the peers of the device under test.
+type Servers struct {
+	// TCPEcho sends back what it receives on each connection.
+	TCPEcho netip.AddrPort
+
+	// TCPHalfClose sends "bye" on each connection and closes its sending
+	// side, then reads until the device closes the connection.
+	TCPHalfClose netip.AddrPort
+
+	// UDPEcho sends back each datagram it receives.
+	UDPEcho netip.AddrPort
+}
+
+// Config is the configuration of the conformance suite.
+type Config struct {
+	// New returns the device under test, with its network link up and no
+	// open sockets.
+	New func(t *testing.T) netdev.Netdever
+
+	// Servers the device can reach.
+	Servers
+
+	// MaxSockets is the number of sockets the device can open at once.
+	// Zero skips the socket exhaustion test.
+	MaxSockets int
+
+	// Timeout bounds each receive expected to succeed.
+	// The default is 5 seconds.
+	Timeout time.Duration
+}
+
+// Run runs the conformance suite against the device returned by cfg.New.
+func Run(t *testing.T, cfg Config) {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	tests := []struct {
+		name string
+		fn   func(*testing.T, netdev.Netdever, Config)
+	}{
+		{"Socket", testSocket},
+		{"Exhaustion", testExhaustion},
+		{"InvalidFd", testInvalidFd},
+		{"TCPEcho", testTCPEcho},
+		{"RecvBlocks", testRecvBlocks},
+		{"RecvDeadline", testRecvDeadline},
+		{"SendDeadline", testSendDeadline},
+		{"HalfClose", testHalfClose},
+		{"UDPBoundaries", testUDPBoundaries},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, cfg.New(t), cfg)
+		})
+	}
+}
+
+// isTimeout reports if err is a timeout, such as netdev.ErrTimeout or
+// os.ErrDeadlineExceeded.
+func isTimeout(err error) bool {
+	e, ok := err.(interface{ Timeout() bool })
+	return ok && e.Timeout()
+}
+
+func deadline(cfg Config) time.Time {
+	return time.Now().Add(cfg.Timeout)
+}
+
+func open(t *testing.T, dev netdev.Netdever, stype, protocol int) int {
+	t.Helper()
+	fd, err := dev.Socket(netdev.AF_INET, stype, protocol)
+	if err != nil {
+		t.Fatalf("Socket: %v", err)
+	}
+	return fd
+}
+
+func dial(t *testing.T, dev netdev.Netdever, addr netip.AddrPort) int {
+	t.Helper()
+	fd := open(t, dev, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	if err := dev.Connect(fd, "", addr); err != nil {
+		dev.Close(fd)
+		t.Fatalf("Connect: %v", err)
+	}
+	return fd
+}
+
+// recvFull receives len(want) bytes and compares them with want.
+func recvFull(t *testing.T, dev netdev.Netdever, fd int, want []byte, cfg Config) {
+	t.Helper()
+	got := make([]byte, 0, len(want))
+	buf := make([]byte, len(want))
+	for len(got) < len(want) {
+		n, err := dev.Recv(fd, buf[:len(want)-len(got)], 0, deadline(cfg))
+		if err != nil {
+			t.Fatalf("Recv after %d bytes: %v", len(got), err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("received %q, expected %q", got, want)
+	}
+}
+
+func testSocket(t *testing.T, dev netdev.Netdever, cfg Config) {
+	if _, err := dev.Socket(netdev.AF_INET+1, netdev.SOCK_STREAM, netdev.IPPROTO_TCP); err != netdev.ErrFamilyNotSupported {
+		t.Errorf("unsupported family: expected %v, got %v", netdev.ErrFamilyNotSupported, err)
+	}
+	if _, err := dev.Socket(netdev.AF_INET, netdev.SOCK_DGRAM, netdev.IPPROTO_TCP); err != netdev.ErrProtocolNotSupported {
+		t.Errorf("TCP datagrams: expected %v, got %v", netdev.ErrProtocolNotSupported, err)
+	}
+	if _, err := dev.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_UDP); err != netdev.ErrProtocolNotSupported {
+		t.Errorf("UDP stream: expected %v, got %v", netdev.ErrProtocolNotSupported, err)
+	}
+
+	fd := open(t, dev, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	if err := dev.Close(fd); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func testExhaustion(t *testing.T, dev netdev.Netdever, cfg Config) {
+	if cfg.MaxSockets == 0 {
+		t.Skip("no socket limit")
+	}
+
+	var fds []int
+	for i := 0; i < cfg.MaxSockets; i++ {
+		fds = append(fds, open(t, dev, netdev.SOCK_STREAM, netdev.IPPROTO_TCP))
+	}
+	if _, err := dev.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP); err != netdev.ErrNoMoreSockets {
+		t.Errorf("socket %d: expected %v, got %v", cfg.MaxSockets+1, netdev.ErrNoMoreSockets, err)
+	}
+
+	// a closed socket can be opened again
+	dev.Close(fds[0])
+	fds[0] = open(t, dev, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	for _, fd := range fds {
+		dev.Close(fd)
+	}
+}
+
+func testInvalidFd(t *testing.T, dev netdev.Netdever, cfg Config) {
+	fd := open(t, dev, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	dev.Close(fd)
+	if err := dev.Close(fd); err != netdev.ErrInvalidSocketFd {
+		t.Errorf("Close of a closed socket: expected %v, got %v", netdev.ErrInvalidSocketFd, err)
+	}
+	if _, err := dev.Send(fd, []byte("x"), 0, time.Time{}); err == nil {
+		t.Error("Send on a closed socket succeeded")
+	}
+	if _, err := dev.Recv(fd, make([]byte, 1), 0, time.Now()); err == nil {
+		t.Error("Recv on a closed socket succeeded")
+	}
+}
+
+func testTCPEcho(t *testing.T, dev netdev.Netdever, cfg Config) {
+	fd := dial(t, dev, cfg.TCPEcho)
+	defer dev.Close(fd)
+
+	// larger than the chunks of most devices
+	msg := bytes.Repeat([]byte("0123456789abcdef"), 256)
+	n, err := dev.Send(fd, msg, 0, deadline(cfg))
+	if err != nil || n != len(msg) {
+		t.Fatalf("Send: %d, %v", n, err)
+	}
+	recvFull(t, dev, fd, msg, cfg)
+}
+
+func testRecvBlocks(t *testing.T, dev netdev.Netdever, cfg Config) {
+	fd := dial(t, dev, cfg.TCPEcho)
+	defer dev.Close(fd)
+
+	// a zero deadline waits for the data, however late it comes
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 4)
+		n, err := dev.Recv(fd, buf, 0, time.Time{})
+		if err == nil && string(buf[:n]) != "late"[:n] {
+			t.Errorf("received %q", buf[:n])
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Recv returned before any data: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := dev.Send(fd, []byte("late"), 0, deadline(cfg)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Recv: %v", err)
+		}
+	case <-time.After(cfg.Timeout):
+		t.Error("Recv still blocked after the data arrived")
+	}
+}
+
+func testRecvDeadline(t *testing.T, dev netdev.Netdever, cfg Config) {
+	fd := dial(t, dev, cfg.TCPEcho)
+	defer dev.Close(fd)
+
+	start := time.Now()
+	_, err := dev.Recv(fd, make([]byte, 4), 0, start.Add(50*time.Millisecond))
+	if !isTimeout(err) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > cfg.Timeout {
+		t.Errorf("Recv returned after %s", elapsed)
+	}
+
+	// the connection still works
+	dev.Send(fd, []byte("ok"), 0, deadline(cfg))
+	recvFull(t, dev, fd, []byte("ok"), cfg)
+}
+
+func testSendDeadline(t *testing.T, dev netdev.Netdever, cfg Config) {
+	fd := dial(t, dev, cfg.TCPEcho)
+	defer dev.Close(fd)
+
+	if _, err := dev.Send(fd, []byte("x"), 0, time.Now().Add(-time.Second)); !isTimeout(err) {
+		t.Errorf("expired deadline: expected a timeout, got %v", err)
+	}
+}
+
+func testHalfClose(t *testing.T, dev netdev.Netdever, cfg Config) {
+	fd := dial(t, dev, cfg.TCPHalfClose)
+	defer dev.Close(fd)
+
+	recvFull(t, dev, fd, []byte("bye"), cfg)
+	buf := make([]byte, 4)
+	n, err := dev.Recv(fd, buf, 0, deadline(cfg))
+	if err != io.EOF {
+		t.Errorf("expected %v, got %d bytes, %v", io.EOF, n, err)
+	}
+	if err := dev.Close(fd); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func testUDPBoundaries(t *testing.T, dev netdev.Netdever, cfg Config) {
+	fd := open(t, dev, netdev.SOCK_DGRAM, netdev.IPPROTO_UDP)
+	defer dev.Close(fd)
+
+	laddr := netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+	if err := dev.Bind(fd, laddr); err != nil {
+		t.Fatalf("Bind: %v", err)
+	}
+	if err := dev.Connect(fd, "", cfg.UDPEcho); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	msgs := []string{"one", "two", "three"}
+	for _, msg := range msgs {
+		if _, err := dev.Send(fd, []byte(msg), 0, deadline(cfg)); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	buf := make([]byte, 64)
+	for _, msg := range msgs {
+		n, err := dev.Recv(fd, buf, 0, deadline(cfg))
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if string(buf[:n]) != msg {
+			t.Errorf("received datagram %q, expected %q", buf[:n], msg)
+		}
+	}
+}
+
+// HostServers starts the servers on the host loopback interface, until the
+// end of the test.
+func HostServers(t *testing.T) Servers {
+	echo := listenTCP(t, func(c net.Conn) {
+		io.Copy(c, c)
+	})
+	halfClose := listenTCP(t, func(c net.Conn) {
+		c.Write([]byte("bye"))
+		c.(*net.TCPConn).CloseWrite()
+		io.Copy(io.Discard, c)
+	})
+
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udp.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := udp.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteToUDPAddrPort(buf[:n], from)
+		}
+	}()
+
+	return Servers{
+		TCPEcho:      echo,
+		TCPHalfClose: halfClose,
+		UDPEcho:      udp.LocalAddr().(*net.UDPAddr).AddrPort(),
+	}
+}
+
+func listenTCP(t *testing.T, serve func(net.Conn)) netip.AddrPort {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				serve(c)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).AddrPort()
+}
@@ -18,6 +18,7 @@ var (
 	ErrAuthTypeNoGood    = errors.New("Wifi authorization type not supported")
 	ErrConnectModeNoGood = errors.New("Connect mode not supported")
 	ErrNotSupported      = errors.New("Not supported")
+	ErrScanFailed        = errors.New("Wifi scan failed")
 )
 
 type Event int
@@ -28,6 +29,8 @@ const (
 	EventNetUp Event = iota
 	// The device's network connection is now DOWN
 	EventNetDown
+	// The Wifi signal strength is below ConnectParams.LowSignal
+	EventNetLowSignal
 )
 
 type ConnectMode int
@@ -47,6 +50,11 @@ const (
 	AuthTypeOpen             // No authorization required (open)
 	AuthTypeWPA              // WPA authorization
 	AuthTypeWPA2Mixed        // WPA2/WPA mixed authorization
+	AuthTypeWEP              // WEP authorization
+	AuthTypeWPA3             // WPA3 authorization
+	AuthTypeWPA3Mixed        // WPA3/WPA2 mixed authorization
+	AuthTypeEAP              // WPA/WPA2 enterprise (EAP) authorization
+	AuthTypeUnknown          // Authorization not known (scan results only)
 )
 
 const DefaultConnectTimeout = 10 * time.Second
@@ -81,6 +89,60 @@ type ConnectParams struct {
 	// downed connection or hardware fault and try to recover the
 	// connection.  Set to zero to disable watchodog.
 	WatchdogTimeout time.Duration
+
+	// Low signal strength threshold in dBm, e.g. -80.  On each watchdog
+	// tick, EventNetLowSignal is sent while the signal strength is below
+	// the threshold.  Set to zero to disable the check.
+	LowSignal int
+}
+
+// AccessPoint is a Wifi access point found by NetScan
+type AccessPoint struct {
+
+	// SSID of Wifi AP
+	Ssid string
+
+	// BSSID (MAC address) of Wifi AP
+	Bssid net.HardwareAddr
+
+	// Signal strength in dBm
+	Rssi int
+
+	// Wifi channel
+	Channel int
+
+	// Wifi authorization type
+	AuthType
+}
+
+// LinkInfo is the state of the network link returned by NetInfo.  Fields
+// not known by the device are left zero.
+type LinkInfo struct {
+
+	// Network connection is UP
+	Up bool
+
+	// SSID of Wifi AP
+	Ssid string
+
+	// BSSID (MAC address) of Wifi AP
+	Bssid net.HardwareAddr
+
+	// Signal strength in dBm
+	Rssi int
+
+	// Wifi channel
+	Channel int
+
+	// Link speed in Mbit/s
+	Speed int
+
+	// Full duplex link
+	FullDuplex bool
+
+	// Bytes sent and received on the device's sockets
+	TxBytes uint64
+	RxBytes uint64
 }
 
 // Netlinker is TinyGo's OSI L2 data link layer interface.  Network device
@@ -99,4 +161,11 @@ type Netlinker interface {
 
 	// GetHardwareAddr returns device MAC address
 	GetHardwareAddr() (net.HardwareAddr, error)
+
+	// NetScan returns the Wifi access points in range.  Wired devices
+	// return ErrNotSupported.
+	NetScan() ([]AccessPoint, error)
+
+	// NetInfo returns the state of the network link
+	NetInfo() (LinkInfo, error)
 }
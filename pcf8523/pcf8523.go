@@ -8,6 +8,8 @@ import (
 	"tinygo.org/x/drivers"
 )
 
+var _ drivers.RTC = &Device{}
+
 type Device struct {
 	bus     drivers.I2C
 	Address uint8
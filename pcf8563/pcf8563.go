@@ -11,6 +11,8 @@ import (
 	"tinygo.org/x/drivers"
 )
 
+var _ drivers.RTC = &Device{}
+
 // Device wraps an I2C connection to a PCF8563 device.
 type Device struct {
 	bus     drivers.I2C
@@ -0,0 +1,13 @@
+package drivers
+
+import "time"
+
+// RTC is a real-time clock keeping the date and time, such as the ds1307,
+// ds3231, pcf8523 and pcf8563.
+type RTC interface {
+	// ReadTime returns the date and time of the clock.
+	ReadTime() (time.Time, error)
+
+	// SetTime sets the date and time of the clock.
+	SetTime(t time.Time) error
+}
@@ -6,6 +6,8 @@
 package rtl8720dn // import "tinygo.org/x/drivers/rtl8720dn"
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -75,6 +77,10 @@ type rtl8720dn struct {
 
 	// keyed by sock as returned by rpc_lwip_socket()
 	sockets map[sock]*socket
+
+	// bytes sent and received on sockets
+	txBytes uint64
+	rxBytes uint64
 }
 
 func newSocket(protocol int) *socket {
@@ -262,6 +268,19 @@ func (r *rtl8720dn) networkDown() bool {
 	return result != 0
 }
 
+func (r *rtl8720dn) rssi() int {
+	var rssi int32
+	r.rpc_wifi_get_rssi(&rssi)
+	return int(rssi)
+}
+
+func (r *rtl8720dn) lowSignal() bool {
+	if r.params.LowSignal == 0 || r.params.ConnectMode == netlink.ConnectModeAP {
+		return false
+	}
+	return r.rssi() < r.params.LowSignal
+}
+
 func (r *rtl8720dn) watchdog() {
 	ticker := time.NewTicker(r.params.WatchdogTimeout)
 	for {
@@ -278,6 +297,10 @@ func (r *rtl8720dn) watchdog() {
 					r.notifyCb(netlink.EventNetDown)
 				}
 				r.netConnect(false)
+			} else if r.lowSignal() {
+				if r.notifyCb != nil {
+					r.notifyCb(netlink.EventNetLowSignal)
+				}
 			}
 			r.mu.Unlock()
 		}
@@ -383,6 +406,139 @@ func (r *rtl8720dn) NetNotify(cb func(netlink.Event)) {
 	r.notifyCb = cb
 }
 
+func (r *rtl8720dn) NetInfo() (netlink.LinkInfo, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[NetInfo]\r\n")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info := netlink.LinkInfo{
+		TxBytes: r.txBytes,
+		RxBytes: r.rxBytes,
+	}
+
+	if !r.netConnected {
+		return info, nil
+	}
+
+	// The AP mode has no connection to check
+	sta := r.params.ConnectMode != netlink.ConnectModeAP
+	if sta && r.networkDown() {
+		return info, nil
+	}
+
+	info.Up = true
+	info.Ssid = r.params.Ssid
+
+	var channel int32
+	if result := r.rpc_wifi_get_channel(&channel); result != -1 {
+		info.Channel = int(channel)
+	}
+
+	if sta {
+		bssid := make(net.HardwareAddr, 6)
+		if result := r.rpc_wifi_get_ap_bssid(bssid); result != -1 {
+			info.Bssid = bssid
+		}
+		info.Rssi = r.rssi()
+	}
+
+	return info, nil
+}
+
+// Scan results are wifi_ap_record_t structs, as in ESP-IDF
+const (
+	maxScanResults   = 20
+	apRecordSize     = 80
+	apRecordBSSID    = 0
+	apRecordSSID     = 6
+	apRecordChannel  = 39
+	apRecordRSSI     = 44
+	apRecordAuthMode = 48
+)
+
+func (r *rtl8720dn) NetScan() ([]netlink.AccessPoint, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[NetScan]\r\n")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Scanning doesn't need a connection, but a running device
+	if !r.netConnected {
+		r.showDriver()
+		if err := r.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	if result := r.rpc_wifi_scan_start(); result == -1 {
+		return nil, netlink.ErrScanFailed
+	}
+	for start := time.Now(); r.rpc_wifi_is_scaning(); {
+		if time.Since(start) > 10*time.Second {
+			return nil, netlink.ErrScanFailed
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	count := min(r.rpc_wifi_scan_get_ap_num(), maxScanResults)
+	if count == 0 {
+		return nil, nil
+	}
+	records := make([]byte, int(count)*apRecordSize)
+	if result := r.rpc_wifi_scan_get_ap_records(count, records); result == -1 {
+		return nil, netlink.ErrScanFailed
+	}
+
+	aps := make([]netlink.AccessPoint, count)
+	for i := range aps {
+		rec := records[i*apRecordSize : (i+1)*apRecordSize]
+		ssid := rec[apRecordSSID : apRecordSSID+33]
+		if n := bytes.IndexByte(ssid, 0); n >= 0 {
+			ssid = ssid[:n]
+		}
+		aps[i] = netlink.AccessPoint{
+			Ssid:     string(ssid),
+			Bssid:    net.HardwareAddr(append([]byte(nil), rec[apRecordBSSID:apRecordBSSID+6]...)),
+			Rssi:     int(int8(rec[apRecordRSSI])),
+			Channel:  int(rec[apRecordChannel]),
+			AuthType: authType(binary.LittleEndian.Uint32(rec[apRecordAuthMode:])),
+		}
+	}
+
+	return aps, nil
+}
+
+// authType converts a wifi_auth_mode_t
+func authType(mode uint32) netlink.AuthType {
+	switch mode {
+	case 0:
+		return netlink.AuthTypeOpen
+	case 1:
+		return netlink.AuthTypeWEP
+	case 2:
+		return netlink.AuthTypeWPA
+	case 3:
+		return netlink.AuthTypeWPA2
+	case 4:
+		return netlink.AuthTypeWPA2Mixed
+	case 5:
+		return netlink.AuthTypeEAP
+	case 6:
+		return netlink.AuthTypeWPA3
+	case 7:
+		return netlink.AuthTypeWPA3Mixed
+	default:
+		return netlink.AuthTypeUnknown
+	}
+}
+
 func (r *rtl8720dn) GetHostByName(name string) (netip.Addr, error) {
 
 	if debugging(debugNetdev) {
@@ -720,10 +876,11 @@ func (r *rtl8720dn) Send(sockfd int, buf []byte, flags int,
 		if end > len(buf) {
 			end = len(buf)
 		}
-		_, err := r.sendChunk(sockfd, buf[i:end], deadline)
+		n, err := r.sendChunk(sockfd, buf[i:end], deadline)
 		if err != nil {
 			return -1, err
 		}
+		r.txBytes += uint64(n)
 	}
 
 	return len(buf), nil
@@ -785,6 +942,7 @@ func (r *rtl8720dn) Recv(sockfd int, buf []byte, flags int,
 				sock, n)
 		}
 
+		r.rxBytes += uint64(n)
 		return int(n), nil
 	}
 }
@@ -0,0 +1,247 @@
+// Package sntp implements a Simple Network Time Protocol client, to get the
+// time from an NTP server over any netdev.Netdever supporting UDP.
+//
+// The result gives the offset of the local clock and the round-trip delay
+// to the server, and can be written to an RTC to correct its drift.
+//
+// RFC 4330: https://www.rfc-editor.org/rfc/rfc4330
+package sntp // import "tinygo.org/x/drivers/sntp"
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/netdev"
+)
+
+// DefaultServer is the server queried when the client has none.
+const DefaultServer = "pool.ntp.org"
+
+const (
+	port       = 123
+	packetSize = 48
+
+	modeClient = 3
+	modeServer = 4
+	version    = 4
+
+	// seconds from the NTP epoch (1900) to the Unix epoch (1970)
+	unixOffset = 2208988800
+)
+
+var (
+	errShortPacket   = errors.New("SNTP reply too short")
+	errBadReply      = errors.New("SNTP reply does not match the request")
+	errUnsynced      = errors.New("SNTP server not synchronized")
+	errKissOfDeath   = errors.New("SNTP server refused the request")
+	errNoReply       = errors.New("SNTP server not answering")
+	errInvalidServer = errors.New("invalid SNTP server address")
+)
+
+// Client queries an NTP server.
+type Client struct {
+	dev netdev.Netdever
+
+	// Server is the host name or IP address of the server, with an
+	// optional port. The default is DefaultServer.
+	Server string
+
+	// Timeout of each request. The default is 2 seconds.
+	Timeout time.Duration
+
+	// Attempts is the number of requests sent before giving up. The
+	// default is 3.
+	Attempts int
+
+	// now returns the local time, replaced in tests
+	now func() time.Time
+}
+
+// New returns a client sending its requests through dev.
+func New(dev netdev.Netdever) *Client {
+	return &Client{
+		dev: dev,
+		now: time.Now,
+	}
+}
+
+// Result is the outcome of a query.
+type Result struct {
+	// Time is the server time when the reply was received.
+	Time time.Time
+
+	// Offset is the difference between the server time and the local
+	// time: the local clock is late if positive.
+	Offset time.Duration
+
+	// Delay is the round-trip delay to the server.
+	Delay time.Duration
+
+	// Stratum of the server, 1 for a primary reference.
+	Stratum uint8
+}
+
+// Now returns the current time corrected with the offset of the result.
+func (r Result) Now() time.Time {
+	return time.Now().Add(r.Offset)
+}
+
+// SetRTC sets the time of the real-time clock to the current corrected time.
+func (r Result) SetRTC(rtc drivers.RTC) error {
+	return rtc.SetTime(r.Now().UTC())
+}
+
+// Query gets the time from the server.
+func (c *Client) Query() (Result, error) {
+	addr, err := c.resolve()
+	if err != nil {
+		return Result{}, err
+	}
+
+	fd, err := c.dev.Socket(netdev.AF_INET, netdev.SOCK_DGRAM, netdev.IPPROTO_UDP)
+	if err != nil {
+		return Result{}, err
+	}
+	defer c.dev.Close(fd)
+
+	// Some devices need a bound socket before sending
+	laddr := netip.AddrPortFrom(netip.IPv4Unspecified(), uint16(49152+rand.Intn(16384)))
+	if err := c.dev.Bind(fd, laddr); err != nil {
+		return Result{}, err
+	}
+	if err := c.dev.Connect(fd, "", addr); err != nil {
+		return Result{}, err
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+	attempts := c.Attempts
+	if attempts == 0 {
+		attempts = 3
+	}
+
+	var req, reply [packetSize]byte
+	err = errNoReply
+	for i := 0; i < attempts; i++ {
+		t1 := c.now()
+		req[0] = version<<3 | modeClient
+		putTimestamp(req[40:], t1)
+		if _, err := c.dev.Send(fd, req[:], 0, t1.Add(timeout)); err != nil {
+			return Result{}, err
+		}
+
+		var r Result
+		r, err = c.receive(fd, req[:], reply[:], t1, t1.Add(timeout))
+		if err == nil {
+			return r, nil
+		}
+		if err == errKissOfDeath || err == errUnsynced {
+			break
+		}
+	}
+	return Result{}, err
+}
+
+func (c *Client) resolve() (netip.AddrPort, error) {
+	server := c.Server
+	if server == "" {
+		server = DefaultServer
+	}
+	host, portStr, err := net.SplitHostPort(server)
+	if err != nil {
+		host, portStr = server, strconv.Itoa(port)
+	}
+	p, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, errInvalidServer
+	}
+	ip, err := c.dev.GetHostByName(host)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(ip, uint16(p)), nil
+}
+
+// receive waits for the reply to req until the deadline, ignoring the
+// packets that don't match it.
+func (c *Client) receive(fd int, req, reply []byte, t1, deadline time.Time) (Result, error) {
+	for {
+		n, err := c.dev.Recv(fd, reply, 0, deadline)
+		if err != nil {
+			if e, ok := err.(interface{ Timeout() bool }); ok && e.Timeout() {
+				return Result{}, errNoReply
+			}
+			return Result{}, err
+		}
+		t4 := c.now()
+
+		r, err := parse(reply[:n], req, t1, t4)
+		if err == errBadReply {
+			continue
+		}
+		return r, err
+	}
+}
+
+// parse parses the reply to req, sent at t1 and received at t4.
+func parse(reply, req []byte, t1, t4 time.Time) (Result, error) {
+	if len(reply) < packetSize {
+		return Result{}, errShortPacket
+	}
+
+	// The server copies the transmit timestamp of the request into the
+	// originate timestamp of its reply.
+	if reply[0]&0x7 != modeServer || string(reply[24:32]) != string(req[40:48]) {
+		return Result{}, errBadReply
+	}
+	stratum := reply[1]
+	if stratum == 0 {
+		return Result{}, errKissOfDeath
+	}
+	if reply[0]>>6 == 3 {
+		return Result{}, errUnsynced
+	}
+
+	t2 := timestamp(reply[32:])
+	t3 := timestamp(reply[40:])
+
+	// The round trip is measured with the monotonic clock of t1 and t4.
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	delay := t4.Sub(t1) - t3.Sub(t2)
+	if delay < 0 {
+		delay = 0
+	}
+	return Result{
+		Time:    t4.Add(offset),
+		Offset:  offset,
+		Delay:   delay,
+		Stratum: stratum,
+	}, nil
+}
+
+// timestamp decodes a 64 bits NTP timestamp. Timestamps with the high bit
+// clear are taken as after 2036, in the second NTP era.
+func timestamp(b []byte) time.Time {
+	secs := int64(binary.BigEndian.Uint32(b))
+	frac := int64(binary.BigEndian.Uint32(b[4:]))
+	if secs&0x80000000 == 0 {
+		secs += 1 << 32
+	}
+	return time.Unix(secs-unixOffset, (frac*1e9)>>32)
+}
+
+// putTimestamp encodes t as a 64 bits NTP timestamp.
+func putTimestamp(b []byte, t time.Time) {
+	secs := t.Unix() + unixOffset
+	frac := (int64(t.Nanosecond()) << 32) / 1e9
+	binary.BigEndian.PutUint32(b, uint32(secs))
+	binary.BigEndian.PutUint32(b[4:], uint32(frac))
+}
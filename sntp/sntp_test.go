@@ -0,0 +1,138 @@
+package sntp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/netdev/hostnet"
+)
+
+// server is an NTP server on the host, with a clock ahead of the local one.
+type server struct {
+	conn    *net.UDPConn
+	ahead   time.Duration
+	stratum uint8
+	bogus   bool // send a reply to another request first
+}
+
+func newServer(t *testing.T, s *server) *server {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	s.conn = conn
+	go s.serve()
+	return s
+}
+
+func (s *server) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := s.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		if n < packetSize {
+			continue
+		}
+		var reply [packetSize]byte
+		reply[0] = version<<3 | modeServer
+		reply[1] = s.stratum
+		copy(reply[24:32], buf[40:48])
+		putTimestamp(reply[32:], time.Now().Add(s.ahead))
+		time.Sleep(5 * time.Millisecond)
+		putTimestamp(reply[40:], time.Now().Add(s.ahead))
+		if s.bogus {
+			other := reply
+			other[31]++
+			s.conn.WriteToUDPAddrPort(other[:], from)
+		}
+		s.conn.WriteToUDPAddrPort(reply[:], from)
+	}
+}
+
+func newTestClient(t *testing.T, s *server) *Client {
+	dev := hostnet.New(hostnet.Config{Latency: 10 * time.Millisecond})
+	dev.NetConnect(nil)
+	t.Cleanup(dev.NetDisconnect)
+	c := New(dev)
+	c.Server = s.conn.LocalAddr().String()
+	c.Timeout = 200 * time.Millisecond
+	return c
+}
+
+// rtc is a real-time clock keeping the time it was set to.
+type rtc struct {
+	t time.Time
+}
+
+func (r *rtc) ReadTime() (time.Time, error) { return r.t, nil }
+func (r *rtc) SetTime(t time.Time) error    { r.t = t; return nil }
+
+func TestQuery(t *testing.T) {
+	s := newServer(t, &server{ahead: time.Hour, stratum: 2, bogus: true})
+	c := newTestClient(t, s)
+
+	r, err := c.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// receiving the bogus reply delays the right one by the latency
+	if d := r.Offset - time.Hour; d < -10*time.Millisecond || d > 10*time.Millisecond {
+		t.Errorf("unexpected offset %s", r.Offset)
+	}
+	// the latency is added to each of Send and Recv, the server takes 5ms
+	if r.Delay < 15*time.Millisecond || r.Delay > 100*time.Millisecond {
+		t.Errorf("unexpected delay %s", r.Delay)
+	}
+	if r.Stratum != 2 {
+		t.Errorf("unexpected stratum %d", r.Stratum)
+	}
+
+	var clock rtc
+	if err := r.SetRTC(&clock); err != nil {
+		t.Fatal(err)
+	}
+	if d := clock.t.Sub(time.Now().Add(time.Hour)); d < -20*time.Millisecond || d > 20*time.Millisecond {
+		t.Errorf("RTC set to %s", clock.t)
+	}
+}
+
+func TestKissOfDeath(t *testing.T) {
+	s := newServer(t, &server{})
+	c := newTestClient(t, s)
+
+	if _, err := c.Query(); err != errKissOfDeath {
+		t.Errorf("expected kiss-o'-death, got %v", err)
+	}
+}
+
+func TestNoReply(t *testing.T) {
+	s := newServer(t, &server{stratum: 2})
+	c := newTestClient(t, s)
+	s.conn.Close()
+	c.Attempts = 2
+
+	start := time.Now()
+	if _, err := c.Query(); err != errNoReply {
+		t.Errorf("expected no reply, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*c.Timeout {
+		t.Errorf("gave up after %s", elapsed)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	var b [8]byte
+	for _, want := range []time.Time{
+		time.Date(2024, 2, 29, 12, 30, 15, 250000000, time.UTC),
+		time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC), // second NTP era
+	} {
+		putTimestamp(b[:], want)
+		if got := timestamp(b[:]); got.Sub(want).Abs() > time.Microsecond {
+			t.Errorf("timestamp of %s decoded as %s", want, got)
+		}
+	}
+}
@@ -39,6 +39,8 @@ const (
 	RADIOEVENTCHAN_SIZE = 1
 )
 
+var _ lora.ContinuousReceiver = &Device{}
+
 // Device wraps an SPI connection to a SX126x device.
 type Device struct {
 	spi            drivers.SPI          // SPI bus for module communication
@@ -659,14 +661,52 @@ func (d *Device) Tx(pkt []uint8, timeoutMs uint32) error {
 
 // LoraRx tries to receive a Lora packet (with timeout in milliseconds)
 func (d *Device) Rx(timeoutMs uint32) ([]uint8, error) {
+	if err := d.prepareRx(); err != nil {
+		return nil, err
+	}
+	d.SetRx(timeoutMsToRtcSteps(timeoutMs))
+
+	msg := <-d.GetRadioEventChan()
+
+	if msg.EventType == lora.RadioEventTimeout {
+		return nil, nil
+	} else if msg.EventType != lora.RadioEventRxDone {
+		return nil, errUnexpectedRxRadioEvent
+	}
+
+	return d.ReadRxPacket(), nil
+}
+
+// RxContinuous starts receiving Lora packets until the next SetStandby, Tx
+// or Rx. Each packet received is notified by a RadioEventRxDone event, and
+// read with ReadRxPacket.
+func (d *Device) RxContinuous() error {
+	if err := d.prepareRx(); err != nil {
+		return err
+	}
+	d.SetRx(SX126X_RX_TIMEOUT_INF)
+	return nil
+}
+
+// ReadRxPacket returns the last packet received.
+func (d *Device) ReadRxPacket() []uint8 {
+	pLen, pStart := d.GetRxBufferStatus()
+	d.SetBufferBaseAddress(0, pStart+1)
+	pkt := d.ReadBuffer(pLen + 1)
+	return pkt[1:]
+}
+
+// prepareRx configures the device for the reception with the current Lora
+// configuration.
+func (d *Device) prepareRx() error {
 	if d.loraConf.Freq == 0 {
-		return nil, lora.ErrUndefinedLoraConf
+		return lora.ErrUndefinedLoraConf
 	}
 
 	if d.controller != nil {
 		err := d.controller.SetRfSwitchMode(RFSWITCH_RX)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
@@ -678,22 +718,7 @@ func (d *Device) Rx(timeoutMs uint32) ([]uint8, error) {
 	d.SetModulationParams(d.loraConf.Sf, bandwidth(d.loraConf.Bw), d.loraConf.Cr, d.loraConf.Ldr)
 	d.SetPacketParam(d.loraConf.Preamble, d.loraConf.HeaderType, d.loraConf.Crc, 0xFF, d.loraConf.Iq)
 	d.SetDioIrqParams(irqVal, irqVal, SX126X_IRQ_NONE, SX126X_IRQ_NONE)
-	d.SetRx(timeoutMsToRtcSteps(timeoutMs))
-
-	msg := <-d.GetRadioEventChan()
-
-	if msg.EventType == lora.RadioEventTimeout {
-		return nil, nil
-	} else if msg.EventType != lora.RadioEventRxDone {
-		return nil, errUnexpectedRxRadioEvent
-	}
-
-	pLen, pStart := d.GetRxBufferStatus()
-	d.SetBufferBaseAddress(0, pStart+1)
-	pkt := d.ReadBuffer(pLen + 1)
-	pkt = pkt[1:]
-
-	return pkt, nil
+	return nil
 }
 
 // HandleInterrupt must be called by main code on DIO state change.
@@ -19,6 +19,8 @@ const (
 	SPI_BUFFER_SIZE     = 5
 )
 
+var _ lora.ContinuousReceiver = &Device{}
+
 // Device wraps an SPI connection to a SX127x device.
 type Device struct {
 	spi            drivers.SPI          // SPI bus for module communication
@@ -446,8 +448,65 @@ func (d *Device) Tx(pkt []uint8, timeoutMs uint32) error {
 
 // Rx tries to receive a Lora packet (with timeout in milliseconds)
 func (d *Device) Rx(timeoutMs uint32) ([]uint8, error) {
+	if err := d.prepareRx(); err != nil {
+		return nil, err
+	}
+
+	// Single RX mode don't properly handle Timeouts on sx127x, so we use Continuous RX
+	// Go routine is a workaround to stop the Continuous RX and fire a timeout Event
+	d.SetOpMode(SX127X_OPMODE_RX)
+
+	var msg lora.RadioEvent
+	select {
+	case msg = <-d.radioEventChan:
+		if msg.EventType != lora.RadioEventRxDone {
+			return nil, errors.New("Unexpected Radio Event while RX " + string(0x30+msg.EventType))
+		}
+	case <-time.After(time.Millisecond * time.Duration(timeoutMs)):
+		d.SetOpMode(SX127X_OPMODE_STANDBY)
+		return nil, nil
+	}
+
+	return d.ReadRxPacket(), nil
+}
+
+// RxContinuous starts receiving Lora packets until the next SetStandby, Tx
+// or Rx. Each packet received is notified by a RadioEventRxDone event, and
+// read with ReadRxPacket.
+func (d *Device) RxContinuous() error {
+	if err := d.prepareRx(); err != nil {
+		return err
+	}
+	d.SetOpMode(SX127X_OPMODE_RX)
+	return nil
+}
+
+// ReadRxPacket returns the last packet received.
+func (d *Device) ReadRxPacket() []uint8 {
+	// Get the received payload
+	d.WriteRegister(SX127X_REG_FIFO_RX_BASE_ADDR, 0)
+	d.WriteRegister(SX127X_REG_FIFO_ADDR_PTR, 0)
+
+	pLen := d.ReadRegister(SX127X_REG_RX_NB_BYTES)
+	d.WriteRegister(SX127X_REG_FIFO_ADDR_PTR, d.ReadRegister(SX127X_REG_FIFO_RX_CURRENT_ADDR))
+
+	rxData := []uint8{}
+	for i := uint8(0); i < pLen; i++ {
+		rxData = append(rxData, d.ReadRegister(SX127X_REG_FIFO))
+	}
+	return rxData
+}
+
+// SetStandby stops the reception, the device staying in standby mode.
+func (d *Device) SetStandby() {
+	d.SetOpMode(SX127X_OPMODE_STANDBY)
+}
+
+// prepareRx configures the device for the reception with the current Lora
+// configuration.
+func (d *Device) prepareRx() error {
 	if d.loraConf.Freq == 0 {
-		return nil, lora.ErrUndefinedLoraConf
+		return lora.ErrUndefinedLoraConf
 	}
 
 	d.SetOpModeLora()
@@ -476,34 +535,7 @@ func (d *Device) Rx(timeoutMs uint32) ([]uint8, error) {
 	d.WriteRegister(SX127X_REG_IRQ_FLAGS, 0xFF)
 	// Mask all but RxDone
 	d.WriteRegister(SX127X_REG_IRQ_FLAGS_MASK, ^(SX127X_IRQ_LORA_RXDONE_MASK | SX127X_IRQ_LORA_RXTOUT_MASK))
-
-	// Single RX mode don't properly handle Timeouts on sx127x, so we use Continuous RX
-	// Go routine is a workaround to stop the Continuous RX and fire a timeout Event
-	d.SetOpMode(SX127X_OPMODE_RX)
-
-	var msg lora.RadioEvent
-	select {
-	case msg = <-d.radioEventChan:
-		if msg.EventType != lora.RadioEventRxDone {
-			return nil, errors.New("Unexpected Radio Event while RX " + string(0x30+msg.EventType))
-		}
-	case <-time.After(time.Millisecond * time.Duration(timeoutMs)):
-		d.SetOpMode(SX127X_OPMODE_STANDBY)
-		return nil, nil
-	}
-
-	// Get the received payload
-	d.WriteRegister(SX127X_REG_FIFO_RX_BASE_ADDR, 0)
-	d.WriteRegister(SX127X_REG_FIFO_ADDR_PTR, 0)
-
-	pLen := d.ReadRegister(SX127X_REG_RX_NB_BYTES)
-	d.WriteRegister(SX127X_REG_FIFO_ADDR_PTR, d.ReadRegister(SX127X_REG_FIFO_RX_CURRENT_ADDR))
-
-	rxData := []uint8{}
-	for i := uint8(0); i < pLen; i++ {
-		rxData = append(rxData, d.ReadRegister(SX127X_REG_FIFO))
-	}
-	return rxData, nil
+	return nil
 }
 
 // SetTxContinuousMode enable Continuous Tx mode
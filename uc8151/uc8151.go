@@ -20,6 +20,8 @@ var (
 	errOutOfRange = errors.New("out of screen range")
 )
 
+var _ drivers.EPaper = &Device{}
+
 type Config struct {
 	Width       int16
 	Height      int16
@@ -386,6 +388,21 @@ func (d *Device) xy(x, y int16) (int16, int16) {
 	return x, y
 }
 
+// SetRefreshMode changes how Display and DisplayRect refresh the screen.
+// RefreshPartial is the same as the flicker-free mode: a full refresh is
+// still done every UpdateAfter updates (see Config).
+func (d *Device) SetRefreshMode(mode drivers.RefreshMode) error {
+	switch mode {
+	case drivers.RefreshFull:
+		d.flickerFree = false
+	case drivers.RefreshPartial:
+		d.flickerFree = true
+	default:
+		return errors.New("unsupported refresh mode")
+	}
+	return d.SetLUT(d.speed, d.flickerFree)
+}
+
 // SetSpeed changes the refresh speed of the device (the display needs to re-configure)
 func (d *Device) SetSpeed(speed Speed) {
 	d.Configure(Config{
@@ -0,0 +1,126 @@
+package w5500
+
+import "encoding/binary"
+
+// fakeChip stands in for a W5500 on the SPI bus. It keeps the registers and
+// buffers in memory, and runs the socket commands as far as the tests need.
+type fakeChip struct {
+	mem  map[uint8][]byte // by block select bits
+	sent [][]byte         // packets sent
+
+	// current frame
+	header []byte
+	addr   uint16
+	bsb    uint8
+	write  bool
+}
+
+func newFakeChip() *fakeChip {
+	return &fakeChip{mem: make(map[uint8][]byte)}
+}
+
+// newTestDevice returns a device configured with the chip on its bus.
+func newTestDevice(c *fakeChip) *Device {
+	d := New(c, c)
+	if err := d.Configure(Config{MAC: []byte{0x02, 0, 0, 0, 0, 1}}); err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (c *fakeChip) block(bsb uint8) []byte {
+	if c.mem[bsb] == nil {
+		c.mem[bsb] = make([]byte, 0x10000)
+	}
+	return c.mem[bsb]
+}
+
+// Set is the chip select pin, starting a frame when low.
+func (c *fakeChip) Set(level bool) {
+	if !level {
+		c.header = c.header[:0]
+	}
+}
+
+func (c *fakeChip) Tx(w, r []byte) error {
+	for _, b := range w {
+		c.Transfer(b)
+	}
+	for i := range r {
+		r[i], _ = c.Transfer(0)
+	}
+	return nil
+}
+
+func (c *fakeChip) Transfer(b byte) (byte, error) {
+	if len(c.header) < 3 {
+		c.header = append(c.header, b)
+		if len(c.header) == 3 {
+			c.addr = binary.BigEndian.Uint16(c.header)
+			c.bsb = c.header[2] >> 3
+			c.write = c.header[2]&0b100 != 0
+		}
+		return 0, nil
+	}
+
+	mem := c.block(c.bsb)
+	addr := c.addr
+	c.addr++
+	if !c.write {
+		return mem[addr], nil
+	}
+	mem[addr] = b
+	if c.bsb&0b11 == 0b01 && addr == sockCmd {
+		c.command(c.bsb>>2, b)
+	}
+	return 0, nil
+}
+
+// command runs a socket command, written in the command register.
+func (c *fakeChip) command(sockn uint8, cmd byte) {
+	regs := c.block(sockAddr(sockn))
+	switch cmd {
+	case sockCmdOpen:
+		switch regs[sockMode] & 0x0F {
+		case 1:
+			regs[sockStatus] = sockStatusInit
+		case 2:
+			regs[sockStatus] = sockStatusUdp
+		case 4:
+			regs[sockStatus] = sockStatusMacRaw
+		}
+		binary.BigEndian.PutUint16(regs[sockTXFreeSize:], 2048)
+	case sockCmdClose:
+		regs[sockStatus] = sockStatusClosed
+	case sockCmdSend:
+		rd := binary.BigEndian.Uint16(regs[sockTXReadPtr:])
+		wr := binary.BigEndian.Uint16(regs[sockTXWritePtr:])
+		pkt := c.block(sockn<<2 | 0b00010)[rd:wr]
+		c.sent = append(c.sent, append([]byte(nil), pkt...))
+		binary.BigEndian.PutUint16(regs[sockTXReadPtr:], wr)
+		regs[sockInt] |= sockIntSendOK
+	case sockCmdRecv:
+		// The received size is what is left between the pointers
+		rd := binary.BigEndian.Uint16(regs[sockRXReadPtr:])
+		wr := binary.BigEndian.Uint16(regs[sockRXWritePtr:])
+		binary.BigEndian.PutUint16(regs[sockRXReceivedSize:], wr-rd)
+	}
+	regs[sockCmd] = 0
+}
+
+// receive appends data to the RX buffer of the socket, as the chip does when
+// a packet arrives.
+func (c *fakeChip) receive(sockn uint8, data []byte) {
+	regs := c.block(sockAddr(sockn))
+	wr := binary.BigEndian.Uint16(regs[sockRXWritePtr:])
+	copy(c.block(sockn<<2 | 0b00011)[wr:], data)
+	wr += uint16(len(data))
+	binary.BigEndian.PutUint16(regs[sockRXWritePtr:], wr)
+	rd := binary.BigEndian.Uint16(regs[sockRXReadPtr:])
+	binary.BigEndian.PutUint16(regs[sockRXReceivedSize:], wr-rd)
+}
+
+// received returns the number of bytes left in the RX buffer of the socket.
+func (c *fakeChip) received(sockn uint8) uint16 {
+	return binary.BigEndian.Uint16(c.block(sockAddr(sockn))[sockRXReceivedSize:])
+}
@@ -0,0 +1,538 @@
+package w5500
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net/netip"
+	"time"
+)
+
+// Lease is an IPv4 configuration obtained from a DHCP server.
+type Lease struct {
+	IP         netip.Addr
+	SubnetMask netip.Addr
+	Gateway    netip.Addr
+	DNS        []netip.Addr
+
+	// Server is the address of the DHCP server that granted the lease.
+	Server netip.Addr
+
+	// Duration is how long the lease is valid after Start. The lease is
+	// renewed with Server after Renew (T1), and with any server after
+	// Rebind (T2).
+	Duration time.Duration
+	Renew    time.Duration
+	Rebind   time.Duration
+	Start    time.Time
+}
+
+// DHCPConfig is the configuration for the DHCP client.
+type DHCPConfig struct {
+	// Hostname sent to the server, optional.
+	Hostname string
+
+	// Optional, default is 30 seconds.
+	Timeout time.Duration
+
+	// OnLease is called from the background goroutine when the lease
+	// changes: when the address, subnet mask, gateway or DNS servers are
+	// different after a renewal, or when the lease is lost (with a zero
+	// Lease), for instance after a NAK or if it expired.
+	OnLease func(Lease)
+}
+
+var (
+	errDHCPTimeout = errors.New("dhcp: no answer from server")
+	errDHCPNak     = errors.New("dhcp: request refused by server")
+	errDHCPRunning = errors.New("dhcp: client already running")
+	errDHCPStopped = errors.New("dhcp: client stopped")
+)
+
+// DHCP ports, message types and options (RFC 2131 and RFC 2132).
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpAck      = 5
+	dhcpNak      = 6
+	dhcpRelease  = 7
+
+	dhcpOptPad          = 0
+	dhcpOptSubnetMask   = 1
+	dhcpOptRouter       = 3
+	dhcpOptDNS          = 6
+	dhcpOptHostName     = 12
+	dhcpOptRequestedIP  = 50
+	dhcpOptLeaseTime    = 51
+	dhcpOptMessageType  = 53
+	dhcpOptServerID     = 54
+	dhcpOptParamRequest = 55
+	dhcpOptRenewalTime  = 58
+	dhcpOptRebindTime   = 59
+	dhcpOptClientID     = 61
+	dhcpOptEnd          = 255
+
+	dhcpMagic      = 0x63825363
+	dhcpHeaderSize = 240
+	dhcpMinSize    = 300 // minimal BOOTP message size, some servers require it
+)
+
+// DHCP client timings.
+const (
+	dhcpLinkPoll = time.Second      // how often the link status is checked
+	dhcpTimeout  = 2 * time.Second  // first retransmission timeout
+	dhcpAttempts = 3                // transmissions before giving up
+	dhcpMinRetry = 10 * time.Second // minimal wait before retrying
+	dhcpMaxRetry = 2 * time.Minute  // maximal wait between discoveries
+	dhcpDeadline = 30 * time.Second // default timeout of StartDHCP
+)
+
+var dhcpBroadcast = netip.AddrFrom4([4]byte{255, 255, 255, 255})
+
+// dhcpClient implements the DHCP client state machine.
+type dhcpClient struct {
+	tr       udpTransport
+	mac      [6]byte
+	hostname string
+	now      func() time.Time
+
+	timeout  time.Duration
+	attempts int
+
+	xid       uint32
+	lease     Lease
+	rebooting bool          // the link went down, the lease must be confirmed
+	next      time.Time     // time of the next action
+	retry     time.Duration // wait before the next discovery
+
+	sbuf [dhcpMinSize + 64]byte
+	rbuf [576]byte
+}
+
+// update advances the state machine. It is called periodically with the
+// status of the link and returns whether the lease changed.
+func (c *dhcpClient) update(linkUp bool) bool {
+	now := c.now()
+	if c.lease.IP.IsValid() && now.Sub(c.lease.Start) >= c.lease.Duration {
+		// The lease expired.
+		c.lease = Lease{}
+		c.rebooting = false
+		c.next = now
+		return true
+	}
+	if !linkUp {
+		// The device may be plugged into another network, so the lease is
+		// confirmed with the server once the link is back.
+		c.rebooting = c.lease.IP.IsValid()
+		return false
+	}
+	if now.Before(c.next) && !c.rebooting {
+		return false
+	}
+
+	if !c.lease.IP.IsValid() {
+		lease, err := c.acquire()
+		if err != nil {
+			c.retry = min(max(2*c.retry, dhcpMinRetry), dhcpMaxRetry)
+			c.next = now.Add(c.retry)
+			return false
+		}
+		c.retry = 0
+		return c.bind(lease)
+	}
+
+	elapsed := now.Sub(c.lease.Start)
+	var lease Lease
+	var err error
+	switch {
+	case c.rebooting:
+		c.rebooting = false
+		lease, err = c.reboot()
+	case elapsed >= c.lease.Rebind:
+		lease, err = c.renew(true)
+	case elapsed >= c.lease.Renew:
+		lease, err = c.renew(false)
+	default:
+		c.next = c.lease.Start.Add(c.lease.Renew)
+		return false
+	}
+
+	switch {
+	case err == errDHCPNak:
+		c.lease = Lease{}
+		c.next = now
+		return true
+	case err != nil:
+		// Keep using the lease, and retry after half of the time left
+		// until the next stage (RFC 2131 section 4.4.5).
+		stage := c.lease.Rebind
+		if elapsed >= c.lease.Rebind {
+			stage = c.lease.Duration
+		}
+		c.next = now.Add(max((stage-elapsed)/2, dhcpMinRetry))
+		return false
+	}
+	return c.bind(lease)
+}
+
+// bind makes lease the current one and returns whether the configuration
+// changed.
+func (c *dhcpClient) bind(lease Lease) bool {
+	changed := lease.IP != c.lease.IP ||
+		lease.SubnetMask != c.lease.SubnetMask ||
+		lease.Gateway != c.lease.Gateway ||
+		len(lease.DNS) != len(c.lease.DNS)
+	for i := 0; !changed && i < len(lease.DNS); i++ {
+		changed = lease.DNS[i] != c.lease.DNS[i]
+	}
+	c.lease = lease
+	c.next = lease.Start.Add(lease.Renew)
+	return changed
+}
+
+// acquire obtains a new lease: DISCOVER, OFFER, REQUEST and ACK.
+func (c *dhcpClient) acquire() (Lease, error) {
+	c.xid = rand.Uint32()
+	offer, err := c.exchange(c.finish(c.message(dhcpDiscover, netip.Addr{})), dhcpBroadcast, dhcpOffer)
+	if err != nil {
+		return Lease{}, err
+	}
+
+	msg := c.message(dhcpRequest, netip.Addr{})
+	msg = appendDHCPAddr(msg, dhcpOptRequestedIP, offer.IP)
+	msg = appendDHCPAddr(msg, dhcpOptServerID, offer.Server)
+	return c.exchange(c.finish(msg), dhcpBroadcast, dhcpAck)
+}
+
+// renew extends the current lease. The request is sent to the server that
+// granted it, or to any server when rebinding.
+func (c *dhcpClient) renew(rebind bool) (Lease, error) {
+	c.xid = rand.Uint32()
+	dst := c.lease.Server
+	if rebind || !dst.IsValid() {
+		dst = dhcpBroadcast
+	}
+	return c.exchange(c.finish(c.message(dhcpRequest, c.lease.IP)), dst, dhcpAck)
+}
+
+// reboot confirms the current lease after the link went down (INIT-REBOOT).
+func (c *dhcpClient) reboot() (Lease, error) {
+	c.xid = rand.Uint32()
+	msg := c.message(dhcpRequest, netip.Addr{})
+	msg = appendDHCPAddr(msg, dhcpOptRequestedIP, c.lease.IP)
+	return c.exchange(c.finish(msg), dhcpBroadcast, dhcpAck)
+}
+
+// release gives the current lease back to the server.
+func (c *dhcpClient) release() {
+	if !c.lease.IP.IsValid() {
+		return
+	}
+	c.xid = rand.Uint32()
+	msg := c.message(dhcpRelease, c.lease.IP)
+	msg = appendDHCPAddr(msg, dhcpOptServerID, c.lease.Server)
+	if c.tr.open() == nil {
+		c.tr.send(c.finish(msg), c.lease.Server)
+		c.tr.close()
+	}
+	c.lease = Lease{}
+}
+
+// exchange sends msg and waits for a reply of the given type, doubling the
+// timeout on every retransmission. A NAK ends the exchange.
+func (c *dhcpClient) exchange(msg []byte, dst netip.Addr, want uint8) (Lease, error) {
+	if err := c.tr.open(); err != nil {
+		return Lease{}, err
+	}
+	defer c.tr.close()
+
+	timeout := c.timeout
+	for i := 0; i < c.attempts; i++ {
+		if err := c.tr.send(msg, dst); err != nil {
+			return Lease{}, err
+		}
+		deadline := time.Now().Add(timeout)
+		for {
+			n, err := c.tr.recv(c.rbuf[:], deadline)
+			if err != nil {
+				break
+			}
+			typ, lease, ok := c.parse(c.rbuf[:n])
+			switch {
+			case !ok:
+			case typ == want:
+				return lease, nil
+			case typ == dhcpNak:
+				return Lease{}, errDHCPNak
+			}
+		}
+		timeout *= 2
+	}
+	return Lease{}, errDHCPTimeout
+}
+
+// message starts a DHCP message of the given type. ciaddr is only set when
+// the client already has an address.
+func (c *dhcpClient) message(typ uint8, ciaddr netip.Addr) []byte {
+	b := c.sbuf[:dhcpHeaderSize]
+	for i := range b {
+		b[i] = 0
+	}
+	b[0] = 1 // BOOTREQUEST
+	b[1] = 1 // Ethernet
+	b[2] = 6 // hardware address length
+	binary.BigEndian.PutUint32(b[4:], c.xid)
+	if ciaddr.Is4() {
+		copy(b[12:16], ciaddr.AsSlice())
+	} else {
+		// Ask for broadcast replies as we can't receive unicast yet.
+		b[10] = 0x80
+	}
+	copy(b[28:], c.mac[:])
+	binary.BigEndian.PutUint32(b[236:], dhcpMagic)
+
+	b = append(b, dhcpOptMessageType, 1, typ)
+	b = append(b, dhcpOptClientID, 7, 1)
+	b = append(b, c.mac[:]...)
+	if typ == dhcpRelease {
+		return b
+	}
+	if n := min(len(c.hostname), 63); n > 0 {
+		b = append(b, dhcpOptHostName, uint8(n))
+		b = append(b, c.hostname[:n]...)
+	}
+	b = append(b, dhcpOptParamRequest, 5,
+		dhcpOptSubnetMask, dhcpOptRouter, dhcpOptDNS, dhcpOptRenewalTime, dhcpOptRebindTime)
+	return b
+}
+
+// finish ends the options of a message and pads it to the minimal size.
+func (c *dhcpClient) finish(b []byte) []byte {
+	b = append(b, dhcpOptEnd)
+	for len(b) < dhcpMinSize {
+		b = append(b, dhcpOptPad)
+	}
+	return b
+}
+
+func appendDHCPAddr(b []byte, opt uint8, addr netip.Addr) []byte {
+	if !addr.Is4() {
+		return b
+	}
+	b = append(b, opt, 4)
+	return append(b, addr.AsSlice()...)
+}
+
+// parse decodes a reply to the current transaction.
+func (c *dhcpClient) parse(b []byte) (typ uint8, lease Lease, ok bool) {
+	if len(b) < dhcpHeaderSize || b[0] != 2 ||
+		binary.BigEndian.Uint32(b[4:]) != c.xid ||
+		[6]byte(b[28:34]) != c.mac ||
+		binary.BigEndian.Uint32(b[236:]) != dhcpMagic {
+		return 0, Lease{}, false
+	}
+	lease.IP = netip.AddrFrom4([4]byte(b[16:20]))
+
+	opts := b[dhcpHeaderSize:]
+	for len(opts) > 0 && opts[0] != dhcpOptEnd {
+		if opts[0] == dhcpOptPad {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			break
+		}
+		code, data := opts[0], opts[2:2+opts[1]]
+		opts = opts[2+len(data):]
+
+		switch code {
+		case dhcpOptMessageType:
+			if len(data) == 1 {
+				typ = data[0]
+			}
+		case dhcpOptSubnetMask:
+			lease.SubnetMask = dhcpAddr(data)
+		case dhcpOptRouter:
+			lease.Gateway = dhcpAddr(data)
+		case dhcpOptDNS:
+			for ; len(data) >= 4; data = data[4:] {
+				lease.DNS = append(lease.DNS, dhcpAddr(data))
+			}
+		case dhcpOptServerID:
+			lease.Server = dhcpAddr(data)
+		case dhcpOptLeaseTime:
+			lease.Duration = dhcpSeconds(data)
+		case dhcpOptRenewalTime:
+			lease.Renew = dhcpSeconds(data)
+		case dhcpOptRebindTime:
+			lease.Rebind = dhcpSeconds(data)
+		}
+	}
+	if typ == 0 {
+		return 0, Lease{}, false
+	}
+
+	if lease.Renew == 0 {
+		lease.Renew = lease.Duration / 2
+	}
+	if lease.Rebind == 0 {
+		lease.Rebind = lease.Duration / 8 * 7
+	}
+	lease.Start = c.now()
+	return typ, lease, true
+}
+
+func dhcpAddr(b []byte) netip.Addr {
+	if len(b) < 4 {
+		return netip.Addr{}
+	}
+	return netip.AddrFrom4([4]byte(b[:4]))
+}
+
+func dhcpSeconds(b []byte) time.Duration {
+	if len(b) != 4 {
+		return 0
+	}
+	return time.Duration(binary.BigEndian.Uint32(b)) * time.Second
+}
+
+// StartDHCP configures the device with an address obtained from a DHCP server.
+//
+// It blocks until a lease is acquired or the timeout expires. The lease is
+// then maintained in the background (renewed, confirmed when the link comes
+// back up, or acquired again once lost) until StopDHCP is called. Calling
+// StopDHCP while StartDHCP is blocked makes it return an error.
+// The device must be configured with its MAC address first.
+func (d *Device) StartDHCP(cfg DHCPConfig) (Lease, error) {
+	d.mu.Lock()
+	if d.dhcpStop != nil {
+		d.mu.Unlock()
+		return Lease{}, errDHCPRunning
+	}
+	stop := make(chan struct{})
+	d.dhcpStop = stop
+	d.mu.Unlock()
+
+	mac, _ := d.GetHardwareAddr()
+	c := &dhcpClient{
+		tr:       &udpSocket{d: d, lport: dhcpClientPort, rport: dhcpServerPort},
+		hostname: cfg.Hostname,
+		now:      time.Now,
+		timeout:  dhcpTimeout,
+		attempts: dhcpAttempts,
+	}
+	copy(c.mac[:], mac)
+	d.applyLease(Lease{})
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = dhcpDeadline
+	}
+	deadline := time.Now().Add(timeout)
+	for !c.update(d.LinkStatus() == LinkStatusUp) {
+		if time.Now().After(deadline) {
+			d.mu.Lock()
+			if d.dhcpStop == stop {
+				d.dhcpStop = nil
+			}
+			d.mu.Unlock()
+			return Lease{}, errDHCPTimeout
+		}
+		select {
+		case <-stop:
+			return Lease{}, errDHCPStopped
+		case <-time.After(dhcpLinkPoll):
+		}
+	}
+
+	// StopDHCP may have been called while the lease was acquired, the
+	// maintainer is only started if it can still be stopped.
+	done := make(chan struct{})
+	d.mu.Lock()
+	select {
+	case <-stop:
+		d.mu.Unlock()
+		c.release()
+		return Lease{}, errDHCPStopped
+	default:
+	}
+	d.dhcpDone = done
+	d.mu.Unlock()
+
+	d.applyLease(c.lease)
+	if cfg.OnLease != nil {
+		cfg.OnLease(c.lease)
+	}
+	go d.maintainDHCP(c, cfg.OnLease, stop, done)
+
+	return c.lease, nil
+}
+
+func (d *Device) maintainDHCP(c *dhcpClient, onLease func(Lease), stop, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			c.release()
+			return
+		case <-time.After(dhcpLinkPoll):
+		}
+
+		if c.update(d.LinkStatus() == LinkStatusUp) {
+			d.applyLease(c.lease)
+			if onLease != nil {
+				onLease(c.lease)
+			}
+		}
+	}
+}
+
+// StopDHCP stops maintaining the lease, releases it and clears the address of
+// the device.
+func (d *Device) StopDHCP() {
+	d.mu.Lock()
+	stop, done := d.dhcpStop, d.dhcpDone
+	d.dhcpStop, d.dhcpDone = nil, nil
+	d.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	if done != nil {
+		<-done
+	}
+	d.applyLease(Lease{})
+}
+
+// DHCPLease returns the current DHCP lease, or a zero Lease if the device has
+// no lease.
+func (d *Device) DHCPLease() Lease {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.lease
+}
+
+// applyLease configures the device with the addresses of the lease.
+func (d *Device) applyLease(l Lease) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.write(regIPAddr, 0, orUnspecified(l.IP).AsSlice())
+	d.write(regSubnetMask, 0, orUnspecified(l.SubnetMask).AsSlice())
+	d.write(regGatewayAddr, 0, orUnspecified(l.Gateway).AsSlice())
+	d.laddr = orUnspecified(l.IP)
+	d.lease = l
+}
+
+func orUnspecified(addr netip.Addr) netip.Addr {
+	if !addr.Is4() {
+		return netip.IPv4Unspecified()
+	}
+	return addr
+}
@@ -0,0 +1,295 @@
+package w5500
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+)
+
+// dhcpStandIn is a minimal DHCP server answering the client in memory.
+type dhcpStandIn struct {
+	t      *testing.T
+	server netip.Addr
+	offer  netip.Addr
+	lease  uint32 // seconds
+
+	nak    bool // refuse requests
+	silent bool // do not answer
+
+	requests []dhcpSeen
+	replies  [][]byte
+}
+
+// dhcpSeen is a message received by the stand-in.
+type dhcpSeen struct {
+	typ         uint8
+	dst         netip.Addr
+	ciaddr      netip.Addr
+	requestedIP netip.Addr
+	serverID    netip.Addr
+}
+
+func newDHCPStandIn(t *testing.T) *dhcpStandIn {
+	return &dhcpStandIn{
+		t:      t,
+		server: netip.MustParseAddr("10.0.0.1"),
+		offer:  netip.MustParseAddr("10.0.0.42"),
+		lease:  3600,
+	}
+}
+
+func (s *dhcpStandIn) open() error { return nil }
+func (s *dhcpStandIn) close()      {}
+
+func (s *dhcpStandIn) send(msg []byte, dst netip.Addr) error {
+	if len(msg) < dhcpMinSize || msg[0] != 1 || binary.BigEndian.Uint32(msg[236:]) != dhcpMagic {
+		s.t.Fatalf("malformed request: %x", msg)
+	}
+	seen := dhcpSeen{dst: dst, ciaddr: netip.AddrFrom4([4]byte(msg[12:16]))}
+	for opts := msg[dhcpHeaderSize:]; len(opts) > 1 && opts[0] != dhcpOptEnd; opts = opts[2+opts[1]:] {
+		data := opts[2 : 2+opts[1]]
+		switch opts[0] {
+		case dhcpOptMessageType:
+			seen.typ = data[0]
+		case dhcpOptRequestedIP:
+			seen.requestedIP = netip.AddrFrom4([4]byte(data))
+		case dhcpOptServerID:
+			seen.serverID = netip.AddrFrom4([4]byte(data))
+		}
+	}
+	s.requests = append(s.requests, seen)
+
+	if s.silent || seen.typ == dhcpRelease {
+		return nil
+	}
+	typ := uint8(dhcpOffer)
+	switch {
+	case seen.typ == dhcpRequest && s.nak:
+		typ = dhcpNak
+	case seen.typ == dhcpRequest:
+		typ = dhcpAck
+	}
+
+	reply := make([]byte, dhcpHeaderSize, 300)
+	reply[0] = 2
+	copy(reply[4:8], msg[4:8]) // xid
+	if typ != dhcpNak {
+		copy(reply[16:20], s.offer.AsSlice())
+	}
+	copy(reply[28:34], msg[28:34]) // chaddr
+	binary.BigEndian.PutUint32(reply[236:], dhcpMagic)
+	reply = append(reply, dhcpOptMessageType, 1, typ)
+	reply = append(reply, dhcpOptServerID, 4)
+	reply = append(reply, s.server.AsSlice()...)
+	if typ != dhcpNak {
+		reply = append(reply, dhcpOptLeaseTime, 4)
+		reply = binary.BigEndian.AppendUint32(reply, s.lease)
+		reply = append(reply, dhcpOptSubnetMask, 4, 255, 255, 255, 0)
+		reply = append(reply, dhcpOptRouter, 4, 10, 0, 0, 1)
+		reply = append(reply, dhcpOptDNS, 8, 10, 0, 0, 1, 9, 9, 9, 9)
+	}
+	reply = append(reply, dhcpOptEnd)
+
+	// a reply to another client must be ignored
+	other := append([]byte(nil), reply...)
+	other[4]++
+	s.replies = append(s.replies, other, reply)
+	return nil
+}
+
+func (s *dhcpStandIn) recv(buf []byte, deadline time.Time) (int, error) {
+	if len(s.replies) == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n := copy(buf, s.replies[0])
+	s.replies = s.replies[1:]
+	return n, nil
+}
+
+func (s *dhcpStandIn) last() dhcpSeen {
+	return s.requests[len(s.requests)-1]
+}
+
+// newTestDHCPClient returns a client talking to the stand-in, with a clock
+// that only moves when the test says so.
+func newTestDHCPClient(s *dhcpStandIn) (*dhcpClient, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &dhcpClient{
+		tr:       s,
+		mac:      [6]byte{0xee, 0xbe, 0xe9, 0xa9, 0xb6, 0x4f},
+		hostname: "plc-7",
+		now:      func() time.Time { return now },
+		timeout:  time.Millisecond,
+		attempts: 2,
+	}
+	return c, &now
+}
+
+func TestDHCPAcquire(t *testing.T) {
+	s := newDHCPStandIn(t)
+	c, _ := newTestDHCPClient(s)
+
+	if !c.update(true) {
+		t.Fatal("no lease acquired")
+	}
+	if len(s.requests) != 2 || s.requests[0].typ != dhcpDiscover || s.requests[1].typ != dhcpRequest {
+		t.Fatalf("unexpected exchange: %+v", s.requests)
+	}
+	if req := s.requests[1]; req.requestedIP != s.offer || req.serverID != s.server || req.dst != dhcpBroadcast {
+		t.Errorf("unexpected request: %+v", req)
+	}
+
+	l := c.lease
+	if l.IP != s.offer || l.SubnetMask != netip.MustParseAddr("255.255.255.0") ||
+		l.Gateway != s.server || l.Server != s.server {
+		t.Errorf("unexpected lease: %+v", l)
+	}
+	if len(l.DNS) != 2 || l.DNS[1] != netip.MustParseAddr("9.9.9.9") {
+		t.Errorf("unexpected DNS servers: %v", l.DNS)
+	}
+	if l.Duration != time.Hour || l.Renew != 30*time.Minute || l.Rebind != 52*time.Minute+30*time.Second {
+		t.Errorf("unexpected lease times: %v %v %v", l.Duration, l.Renew, l.Rebind)
+	}
+
+	// nothing to do before T1
+	if c.update(true) || len(s.requests) != 2 {
+		t.Error("unexpected activity before T1")
+	}
+}
+
+func TestDHCPRenewRebind(t *testing.T) {
+	s := newDHCPStandIn(t)
+	c, now := newTestDHCPClient(s)
+	c.update(true)
+
+	// renewal with the server that granted the lease
+	*now = now.Add(31 * time.Minute)
+	if c.update(true) {
+		t.Error("renewal with the same configuration reported as a change")
+	}
+	if req := s.last(); req.typ != dhcpRequest || req.dst != s.server || req.ciaddr != s.offer {
+		t.Errorf("unexpected renewal: %+v", req)
+	}
+	if !c.lease.Start.Equal(*now) {
+		t.Error("lease not renewed")
+	}
+
+	// the server is gone: the renewal fails, then rebinding is broadcast
+	s.silent = true
+	*now = now.Add(31 * time.Minute)
+	if c.update(true) || !c.lease.IP.IsValid() {
+		t.Fatal("lease lost before expiring")
+	}
+	*now = now.Add(22 * time.Minute)
+	c.update(true)
+	if req := s.last(); req.dst != dhcpBroadcast || req.ciaddr != s.offer {
+		t.Errorf("unexpected rebinding: %+v", req)
+	}
+
+	// another server answers with a new address
+	s.silent = false
+	s.offer = netip.MustParseAddr("10.0.0.43")
+	*now = now.Add(4 * time.Minute)
+	if !c.update(true) || c.lease.IP != s.offer {
+		t.Errorf("new address not reported: %+v", c.lease)
+	}
+
+	// expiry
+	s.silent = true
+	*now = now.Add(2 * time.Hour)
+	if !c.update(true) || c.lease.IP.IsValid() {
+		t.Error("expired lease not reported")
+	}
+}
+
+func TestDHCPLinkDown(t *testing.T) {
+	s := newDHCPStandIn(t)
+	c, now := newTestDHCPClient(s)
+	c.update(true)
+
+	*now = now.Add(time.Minute)
+	if c.update(false) {
+		t.Error("link down reported as a lease change")
+	}
+	n := len(s.requests)
+
+	// back on another network: the lease is refused and a new one acquired
+	s.nak = true
+	*now = now.Add(time.Minute)
+	if !c.update(true) || c.lease.IP.IsValid() {
+		t.Fatal("refused lease not reported")
+	}
+	if req := s.requests[n]; req.typ != dhcpRequest || req.requestedIP != s.offer || req.ciaddr.IsValid() && !req.ciaddr.IsUnspecified() {
+		t.Errorf("unexpected INIT-REBOOT request: %+v", req)
+	}
+
+	s.nak = false
+	if !c.update(true) || c.lease.IP != s.offer {
+		t.Error("no new lease acquired")
+	}
+
+	c.release()
+	if s.last().typ != dhcpRelease || s.last().dst != s.server || c.lease.IP.IsValid() {
+		t.Errorf("lease not released: %+v", s.last())
+	}
+}
+
+func TestDHCPNoServer(t *testing.T) {
+	s := newDHCPStandIn(t)
+	s.silent = true
+	c, now := newTestDHCPClient(s)
+
+	if c.update(true) {
+		t.Fatal("lease acquired without server")
+	}
+	if len(s.requests) != c.attempts {
+		t.Errorf("expected %d discover attempts, got %d", c.attempts, len(s.requests))
+	}
+
+	// back-off before the next discovery
+	*now = now.Add(time.Second)
+	c.update(true)
+	if len(s.requests) != c.attempts {
+		t.Error("discovery retried too early")
+	}
+	*now = now.Add(dhcpMinRetry)
+	c.update(true)
+	if len(s.requests) != 2*c.attempts {
+		t.Error("discovery not retried")
+	}
+}
+
+func TestDHCPStop(t *testing.T) {
+	d := newTestDevice(newFakeChip())
+
+	// The link of the chip is down, StartDHCP waits for it until stopped.
+	result := make(chan error, 1)
+	go func() {
+		_, err := d.StartDHCP(DHCPConfig{Timeout: time.Minute})
+		result <- err
+	}()
+	for running := false; !running; {
+		time.Sleep(time.Millisecond)
+		d.mu.Lock()
+		running = d.dhcpStop != nil
+		d.mu.Unlock()
+	}
+	d.StopDHCP()
+
+	select {
+	case err := <-result:
+		if err != errDHCPStopped {
+			t.Fatalf("expected %v, got %v", errDHCPStopped, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartDHCP not stopped")
+	}
+	d.mu.Lock()
+	stop, done := d.dhcpStop, d.dhcpDone
+	d.mu.Unlock()
+	if stop != nil || done != nil {
+		t.Error("client still running")
+	}
+}
@@ -0,0 +1,293 @@
+package w5500
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net/netip"
+	"sync"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+)
+
+// DNS record types.
+const (
+	DNSTypeA    uint16 = 1
+	DNSTypeAAAA uint16 = 28
+)
+
+var (
+	errDNSNoServer = errors.New("dns: no server configured")
+	errDNSName     = errors.New("dns: invalid host name")
+	errDNSTimeout  = errors.New("dns: no answer from servers")
+	errDNSNoRecord = errors.New("dns: no address for host")
+)
+
+// DNS protocol and resolver settings.
+const (
+	dnsPort       = 53
+	dnsHeaderSize = 12
+	dnsMaxSize    = 512 // without EDNS0
+	dnsMaxAddrs   = 4   // addresses kept per name
+	dnsCacheSize  = 8
+	dnsMaxTTL     = 24 * time.Hour
+	dnsTimeout    = 2 * time.Second // per server and attempt
+	dnsAttempts   = 2               // rounds over all servers
+)
+
+type dnsEntry struct {
+	name    string
+	qtype   uint16
+	addrs   []netip.Addr
+	expires time.Time
+}
+
+// dnsResolver is a stub resolver sending recursive queries to a list of
+// servers, with a small cache honoring the TTL of the records.
+type dnsResolver struct {
+	mu      sync.Mutex
+	tr      udpTransport
+	servers func() []netip.Addr
+	now     func() time.Time
+	timeout time.Duration
+
+	cache [dnsCacheSize]dnsEntry
+	id    uint16
+	buf   [dnsMaxSize]byte
+}
+
+// lookup returns the addresses of the given type for name.
+func (r *dnsResolver) lookup(name string, qtype uint16) ([]netip.Addr, error) {
+	if len(name) > 0 && name[len(name)-1] == '.' {
+		name = name[:len(name)-1]
+	}
+	if !validDNSName(name) {
+		return nil, errDNSName
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for i := range r.cache {
+		e := &r.cache[i]
+		if e.qtype == qtype && e.name == name && now.Before(e.expires) {
+			return e.addrs, nil
+		}
+	}
+
+	servers := r.servers()
+	if len(servers) == 0 {
+		return nil, errDNSNoServer
+	}
+
+	if err := r.tr.open(); err != nil {
+		return nil, err
+	}
+	defer r.tr.close()
+
+	for attempt := 0; attempt < dnsAttempts; attempt++ {
+		for _, server := range servers {
+			addrs, ttl, err := r.query(server, name, qtype)
+			switch {
+			case err == errDNSTimeout:
+				continue // try the next server
+			case err != nil:
+				return nil, err
+			}
+			r.store(name, qtype, addrs, ttl)
+			return addrs, nil
+		}
+	}
+	return nil, errDNSTimeout
+}
+
+// query sends a single query to server and waits for its answer.
+func (r *dnsResolver) query(server netip.Addr, name string, qtype uint16) ([]netip.Addr, time.Duration, error) {
+	r.id = uint16(rand.Uint32())
+	msg := r.buf[:dnsHeaderSize]
+	binary.BigEndian.PutUint16(msg[0:], r.id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // recursion desired
+	binary.BigEndian.PutUint16(msg[4:], 1)      // one question
+	for i := 6; i < dnsHeaderSize; i++ {
+		msg[i] = 0
+	}
+	msg = appendDNSName(msg, name)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // class IN
+
+	if err := r.tr.send(msg, server); err != nil {
+		return nil, 0, errDNSTimeout
+	}
+
+	deadline := time.Now().Add(r.timeout)
+	for {
+		n, err := r.tr.recv(r.buf[:], deadline)
+		if err != nil {
+			return nil, 0, errDNSTimeout
+		}
+		addrs, ttl, err := r.parse(r.buf[:n], qtype)
+		if err != errDNSNoAnswer {
+			return addrs, ttl, err
+		}
+	}
+}
+
+// errDNSNoAnswer is returned by parse for messages that are not an answer to
+// the pending query.
+var errDNSNoAnswer = errors.New("dns: not an answer")
+
+// parse decodes the answer to the pending query.
+func (r *dnsResolver) parse(b []byte, qtype uint16) (addrs []netip.Addr, ttl time.Duration, err error) {
+	if len(b) < dnsHeaderSize || binary.BigEndian.Uint16(b[0:]) != r.id || b[2]&0x80 == 0 {
+		return nil, 0, errDNSNoAnswer
+	}
+	switch b[3] & 0x0F { // response code
+	case 0:
+	case 3:
+		return nil, 0, netdev.ErrHostUnknown
+	default:
+		// The server failed, try the next one.
+		return nil, 0, errDNSTimeout
+	}
+
+	questions := binary.BigEndian.Uint16(b[4:])
+	answers := binary.BigEndian.Uint16(b[6:])
+	off := dnsHeaderSize
+	for ; questions > 0; questions-- {
+		if off = skipDNSName(b, off); off < 0 || off+4 > len(b) {
+			return nil, 0, netdev.ErrMalAddr
+		}
+		off += 4 // type and class
+	}
+
+	ttl = dnsMaxTTL
+	for ; answers > 0 && len(addrs) < dnsMaxAddrs; answers-- {
+		if off = skipDNSName(b, off); off < 0 || off+10 > len(b) {
+			break
+		}
+		typ := binary.BigEndian.Uint16(b[off:])
+		class := binary.BigEndian.Uint16(b[off+2:])
+		recTTL := time.Duration(binary.BigEndian.Uint32(b[off+4:])) * time.Second
+		size := int(binary.BigEndian.Uint16(b[off+8:]))
+		off += 10
+		if off+size > len(b) {
+			break
+		}
+		data := b[off : off+size]
+		off += size
+
+		// CNAME records are followed by the records of the canonical name,
+		// so all the addresses of the answer are used.
+		if class != 1 || typ != qtype {
+			continue
+		}
+		switch {
+		case typ == DNSTypeA && size == 4:
+			addrs = append(addrs, netip.AddrFrom4([4]byte(data)))
+		case typ == DNSTypeAAAA && size == 16:
+			addrs = append(addrs, netip.AddrFrom16([16]byte(data)))
+		default:
+			continue
+		}
+		ttl = min(ttl, recTTL)
+	}
+	if len(addrs) == 0 {
+		return nil, 0, errDNSNoRecord
+	}
+	return addrs, ttl, nil
+}
+
+// store adds an answer to the cache, replacing the entry expiring first.
+func (r *dnsResolver) store(name string, qtype uint16, addrs []netip.Addr, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	oldest := &r.cache[0]
+	for i := range r.cache {
+		e := &r.cache[i]
+		if e.name == name && e.qtype == qtype {
+			oldest = e
+			break
+		}
+		if e.expires.Before(oldest.expires) {
+			oldest = e
+		}
+	}
+	*oldest = dnsEntry{name: name, qtype: qtype, addrs: addrs, expires: r.now().Add(ttl)}
+}
+
+func validDNSName(name string) bool {
+	if len(name) == 0 || len(name) > 253 {
+		return false
+	}
+	label := 0
+	for i := 0; i < len(name); i++ {
+		if name[i] != '.' {
+			label++
+			continue
+		}
+		if label == 0 || label > 63 {
+			return false
+		}
+		label = 0
+	}
+	return label > 0 && label <= 63
+}
+
+func appendDNSName(b []byte, name string) []byte {
+	for len(name) > 0 {
+		end := 0
+		for end < len(name) && name[end] != '.' {
+			end++
+		}
+		b = append(b, uint8(end))
+		b = append(b, name[:end]...)
+		if end == len(name) {
+			break
+		}
+		name = name[end+1:]
+	}
+	return append(b, 0)
+}
+
+// skipDNSName returns the offset following the name at off, or -1.
+func skipDNSName(b []byte, off int) int {
+	for off < len(b) {
+		switch n := int(b[off]); {
+		case n == 0:
+			return off + 1
+		case n&0xC0 == 0xC0:
+			// compression pointer, the name ends here
+			return off + 2
+		default:
+			off += 1 + n
+		}
+	}
+	return -1
+}
+
+// LookupHost returns the addresses of host using the built-in DNS resolver,
+// with qtype DNSTypeA for IPv4 or DNSTypeAAAA for IPv6 addresses.
+//
+// The queries are sent to the DNS servers of the configuration, or else to
+// the ones of the DHCP lease, trying the next server when one doesn't answer.
+// Answers are cached for the duration of their TTL.
+func (d *Device) LookupHost(host string, qtype uint16) ([]netip.Addr, error) {
+	if qtype != DNSTypeA && qtype != DNSTypeAAAA {
+		return nil, netdev.ErrNotSupported
+	}
+	return d.resolver.lookup(host, qtype)
+}
+
+// dnsServers returns the servers used by the built-in resolver.
+func (d *Device) dnsServers() []netip.Addr {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.nameservers) > 0 {
+		return d.nameservers
+	}
+	return d.lease.DNS
+}
@@ -0,0 +1,203 @@
+package w5500
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+)
+
+// dnsStandIn is a set of DNS servers answering the resolver in memory.
+type dnsStandIn struct {
+	t       *testing.T
+	records map[string][]netip.Addr
+	ttl     uint32
+
+	silent map[netip.Addr]bool // servers not answering
+	rcode  uint8
+
+	queries []netip.Addr // servers queried
+	replies [][]byte
+}
+
+func newDNSStandIn(t *testing.T) *dnsStandIn {
+	return &dnsStandIn{
+		t: t,
+		records: map[string][]netip.Addr{
+			"example.com": {
+				netip.MustParseAddr("93.184.216.34"),
+				netip.MustParseAddr("2606:2800:220:1:248:1893:25c8:1946"),
+			},
+		},
+		ttl:    60,
+		silent: map[netip.Addr]bool{},
+	}
+}
+
+func (s *dnsStandIn) open() error { return nil }
+func (s *dnsStandIn) close()      {}
+
+func (s *dnsStandIn) send(msg []byte, dst netip.Addr) error {
+	s.queries = append(s.queries, dst)
+	if len(msg) < dnsHeaderSize || binary.BigEndian.Uint16(msg[4:]) != 1 {
+		s.t.Fatalf("malformed query: %x", msg)
+	}
+	if s.silent[dst] {
+		return nil
+	}
+
+	// decode the question
+	var name string
+	off := dnsHeaderSize
+	for msg[off] != 0 {
+		if name != "" {
+			name += "."
+		}
+		name += string(msg[off+1 : off+1+int(msg[off])])
+		off += 1 + int(msg[off])
+	}
+	question := msg[dnsHeaderSize : off+5]
+	qtype := binary.BigEndian.Uint16(msg[off+1:])
+
+	reply := append([]byte(nil), msg[:dnsHeaderSize]...)
+	reply[2] |= 0x80 // response
+	reply[3] = 0x80 | s.rcode
+	addrs, ok := s.records[name]
+	if !ok && s.rcode == 0 {
+		reply[3] |= 3
+	}
+	reply = append(reply, question...)
+
+	// a CNAME record precedes the addresses
+	reply = append(reply, 0xC0, dnsHeaderSize, 0, 5, 0, 1)
+	reply = binary.BigEndian.AppendUint32(reply, s.ttl)
+	reply = append(reply, 0, 2, 0xC0, dnsHeaderSize)
+	answers := uint16(1)
+	for _, addr := range addrs {
+		typ := DNSTypeA
+		if addr.Is6() {
+			typ = DNSTypeAAAA
+		}
+		if typ != qtype {
+			continue
+		}
+		reply = append(reply, 0xC0, dnsHeaderSize)
+		reply = binary.BigEndian.AppendUint16(reply, typ)
+		reply = append(reply, 0, 1)
+		reply = binary.BigEndian.AppendUint32(reply, s.ttl)
+		reply = binary.BigEndian.AppendUint16(reply, uint16(addr.BitLen()/8))
+		reply = append(reply, addr.AsSlice()...)
+		answers++
+	}
+	binary.BigEndian.PutUint16(reply[6:], answers)
+
+	// an answer to another query must be ignored
+	other := append([]byte(nil), reply...)
+	other[0]++
+	s.replies = append(s.replies, other, reply)
+	return nil
+}
+
+func (s *dnsStandIn) recv(buf []byte, deadline time.Time) (int, error) {
+	if len(s.replies) == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n := copy(buf, s.replies[0])
+	s.replies = s.replies[1:]
+	return n, nil
+}
+
+// newTestResolver returns a resolver querying the stand-in, with a clock that
+// only moves when the test says so.
+func newTestResolver(s *dnsStandIn, servers ...netip.Addr) (*dnsResolver, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := &dnsResolver{
+		tr:      s,
+		servers: func() []netip.Addr { return servers },
+		now:     func() time.Time { return now },
+		timeout: time.Millisecond,
+	}
+	return r, &now
+}
+
+var (
+	dnsServer1 = netip.MustParseAddr("10.0.0.1")
+	dnsServer2 = netip.MustParseAddr("9.9.9.9")
+)
+
+func TestDNSLookup(t *testing.T) {
+	s := newDNSStandIn(t)
+	r, _ := newTestResolver(s, dnsServer1)
+
+	addrs, err := r.lookup("example.com", DNSTypeA)
+	if err != nil || len(addrs) != 1 || addrs[0] != s.records["example.com"][0] {
+		t.Errorf("unexpected A answer: %v %v", addrs, err)
+	}
+	addrs, err = r.lookup("example.com.", DNSTypeAAAA)
+	if err != nil || len(addrs) != 1 || addrs[0] != s.records["example.com"][1] {
+		t.Errorf("unexpected AAAA answer: %v %v", addrs, err)
+	}
+
+	if _, err := r.lookup("nowhere.example.com", DNSTypeA); err != netdev.ErrHostUnknown {
+		t.Errorf("expected unknown host, got %v", err)
+	}
+	if _, err := r.lookup("bad..name", DNSTypeA); err != errDNSName {
+		t.Errorf("expected invalid name, got %v", err)
+	}
+}
+
+func TestDNSFallback(t *testing.T) {
+	s := newDNSStandIn(t)
+	r, _ := newTestResolver(s, dnsServer1, dnsServer2)
+
+	s.silent[dnsServer1] = true
+	if _, err := r.lookup("example.com", DNSTypeA); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.queries) != 2 || s.queries[1] != dnsServer2 {
+		t.Errorf("second server not queried: %v", s.queries)
+	}
+
+	// a failing server is treated as a silent one
+	s.silent[dnsServer1] = false
+	s.rcode = 2
+	s.queries = nil
+	if _, err := r.lookup("example.com", DNSTypeAAAA); err != errDNSTimeout {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if len(s.queries) != 2*dnsAttempts {
+		t.Errorf("expected %d queries, got %d", 2*dnsAttempts, len(s.queries))
+	}
+
+	r.servers = func() []netip.Addr { return nil }
+	if _, err := r.lookup("example.org", DNSTypeA); err != errDNSNoServer {
+		t.Errorf("expected no server, got %v", err)
+	}
+}
+
+func TestDNSCache(t *testing.T) {
+	s := newDNSStandIn(t)
+	r, now := newTestResolver(s, dnsServer1)
+
+	r.lookup("example.com", DNSTypeA)
+	*now = now.Add(59 * time.Second)
+	if _, err := r.lookup("example.com", DNSTypeA); err != nil || len(s.queries) != 1 {
+		t.Errorf("answer not cached: %d queries, %v", len(s.queries), err)
+	}
+	*now = now.Add(time.Second)
+	r.lookup("example.com", DNSTypeA)
+	if len(s.queries) != 2 {
+		t.Error("expired answer not queried again")
+	}
+
+	// answers with a zero TTL are not cached
+	s.ttl = 0
+	r.lookup("example.com", DNSTypeAAAA)
+	r.lookup("example.com", DNSTypeAAAA)
+	if len(s.queries) != 4 {
+		t.Error("answer with a zero TTL cached")
+	}
+}
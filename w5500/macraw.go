@@ -0,0 +1,164 @@
+package w5500
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// MaxFrameSize is the size of the largest Ethernet frame that can be sent or
+// received in MACRAW mode, without the frame check sequence.
+const MaxFrameSize = 1514
+
+// Socket n mode register bits in MACRAW mode.
+const (
+	macRawModeFilterMAC      = 1 << 7
+	macRawModeBlockBroadcast = 1 << 6
+	macRawModeBlockMulticast = 1 << 5
+	macRawModeBlockIPv6      = 1 << 4
+)
+
+// MACRawConfig is the configuration of the MACRAW socket.
+type MACRawConfig struct {
+	// Only receive the frames sent to the device MAC address, or to the
+	// broadcast and multicast addresses. All frames are received otherwise.
+	FilterMAC bool
+	// Block the broadcast frames.
+	BlockBroadcast bool
+	// Block the multicast frames.
+	BlockMulticast bool
+	// Block the IPv6 frames.
+	BlockIPv6 bool
+}
+
+// OpenMACRaw opens socket 0 in MACRAW mode, to send and receive whole
+// Ethernet frames with SendFrame and RecvFrame.
+//
+// Socket 0 must not be in use. The other sockets keep working: MACRAW only
+// receives the frames that are not handled by them.
+func (d *Device) OpenMACRaw(cfg MACRawConfig) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.sockets) == 0 {
+		return errors.New("device not configured")
+	}
+	sock := d.sockets[0]
+	if sock.inUse {
+		return errors.New("socket 0 is in use")
+	}
+
+	mode := byte(4) // MACRAW
+	if cfg.FilterMAC {
+		mode |= macRawModeFilterMAC
+	}
+	if cfg.BlockBroadcast {
+		mode |= macRawModeBlockBroadcast
+	}
+	if cfg.BlockMulticast {
+		mode |= macRawModeBlockMulticast
+	}
+	if cfg.BlockIPv6 {
+		mode |= macRawModeBlockIPv6
+	}
+	d.writeByte(sockMode, sockAddr(sock.sockn), mode)
+	d.socketSendCmd(sock.sockn, sockCmdOpen)
+	if d.sockStatus(sock.sockn) != sockStatusMacRaw {
+		d.socketSendCmd(sock.sockn, sockCmdClose)
+		return errors.New("could not open MACRAW socket")
+	}
+
+	sock.setProtocol(4).setInUse(true)
+	return nil
+}
+
+// CloseMACRaw closes the MACRAW socket.
+func (d *Device) CloseMACRaw() error {
+	if err := d.macRawSocket(); err != nil {
+		return err
+	}
+	return d.Close(0)
+}
+
+// SendFrame sends an Ethernet frame, starting with the destination MAC
+// address and without the frame check sequence, which is added by the device.
+// It blocks until the frame is sent or the deadline is reached.
+func (d *Device) SendFrame(frame []byte, deadline time.Time) error {
+	if len(frame) < 14 || len(frame) > MaxFrameSize {
+		return errors.New("invalid frame size")
+	}
+	if err := d.macRawSocket(); err != nil {
+		return err
+	}
+	_, err := d.sendChunk(0, frame, deadline)
+	return err
+}
+
+// RecvFrame receives a single Ethernet frame into buf, without its frame
+// check sequence. A buffer of MaxFrameSize bytes holds any frame, a frame
+// larger than buf is dropped and io.ErrShortBuffer returned.
+// It blocks until a frame is received or the deadline is reached.
+func (d *Device) RecvFrame(buf []byte, deadline time.Time) (int, error) {
+	if err := d.macRawSocket(); err != nil {
+		return 0, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sock := d.sockets[0]
+	recvd, err := d.waitForData(sock, deadline)
+	if err != nil {
+		return 0, err
+	}
+
+	// In MACRAW mode every frame in the RX buffer starts with a 2 bytes
+	// header holding its length, including the header.
+	var hdr [2]byte
+	recvPtr := d.readUint16(sockRXReadPtr, sockAddr(sock.sockn))
+	d.read(recvPtr, sock.sockn<<2|0b00011, hdr[:])
+	size, err := macRawFrameSize(hdr, recvd, len(buf))
+	next := recvPtr + 2 + uint16(size)
+	switch err {
+	case nil:
+		d.read(recvPtr+2, sock.sockn<<2|0b00011, buf[:size])
+		d.rxBytes += uint64(size)
+	case errInvalidFrameHeader:
+		// The next frames can't be found, drop all the data received
+		next = recvPtr + uint16(recvd)
+	}
+	d.writeUint16(sockRXReadPtr, sockAddr(sock.sockn), next)
+	d.socketSendCmd(sock.sockn, sockCmdRecv)
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+var errInvalidFrameHeader = errors.New("invalid MACRAW frame header")
+
+// macRawFrameSize returns the size of the frame following the header, with
+// recvd bytes in the RX buffer, header included. An error is returned if the
+// header is invalid, or the frame larger than bufLen.
+func macRawFrameSize(hdr [2]byte, recvd, bufLen int) (int, error) {
+	length := int(hdr[0])<<8 | int(hdr[1])
+	if length < 2 || length > recvd {
+		return 0, errInvalidFrameHeader
+	}
+	size := length - 2
+	if size > bufLen {
+		return size, io.ErrShortBuffer
+	}
+	return size, nil
+}
+
+func (d *Device) macRawSocket() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.sockets) == 0 || d.sockets[0].protocol != 4 {
+		return os.ErrClosed
+	}
+	return nil
+}
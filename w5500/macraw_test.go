@@ -0,0 +1,97 @@
+package w5500
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// macRawFrame returns a frame as stored in the RX buffer, after its header.
+func macRawFrame(frame string) []byte {
+	n := len(frame) + 2
+	return append([]byte{byte(n >> 8), byte(n)}, frame...)
+}
+
+func TestMACRawFrameSize(t *testing.T) {
+	for _, tc := range []struct {
+		hdr    [2]byte
+		recvd  int
+		bufLen int
+		size   int
+		err    error
+	}{
+		{[2]byte{0x00, 0x3E}, 62, MaxFrameSize, 60, nil},
+		{[2]byte{0x05, 0xEC}, 2000, MaxFrameSize, 1514, nil},
+		{[2]byte{0x00, 0x3E}, 62, 20, 60, io.ErrShortBuffer},
+		{[2]byte{0x00, 0x00}, 62, MaxFrameSize, 0, errInvalidFrameHeader},
+		{[2]byte{0x00, 0x01}, 62, MaxFrameSize, 0, errInvalidFrameHeader},
+		{[2]byte{0xFF, 0xFF}, 62, MaxFrameSize, 0, errInvalidFrameHeader},
+	} {
+		size, err := macRawFrameSize(tc.hdr, tc.recvd, tc.bufLen)
+		if size != tc.size || err != tc.err {
+			t.Errorf("header % x: size %d, %v, expected %d, %v", tc.hdr, size, err, tc.size, tc.err)
+		}
+	}
+}
+
+func TestMACRaw(t *testing.T) {
+	c := newFakeChip()
+	d := newTestDevice(c)
+
+	buf := make([]byte, 64)
+	if _, err := d.RecvFrame(buf, time.Time{}); err == nil {
+		t.Error("received a frame on a closed MACRAW socket")
+	}
+	if err := d.OpenMACRaw(MACRawConfig{FilterMAC: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	frame := make([]byte, 60)
+	copy(frame, "\xff\xff\xff\xff\xff\xff\x02\x00\x00\x00\x00\x01\x08\x06")
+	if err := d.SendFrame(frame[:13], deadline); err == nil {
+		t.Error("sent a frame shorter than its header")
+	}
+	if err := d.SendFrame(frame, deadline); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.sent) != 1 || string(c.sent[0]) != string(frame) {
+		t.Errorf("unexpected frames sent % x", c.sent)
+	}
+
+	c.receive(0, macRawFrame("first frame"))
+	c.receive(0, macRawFrame("second"))
+	for _, want := range []string{"first frame", "second"} {
+		n, err := d.RecvFrame(buf, deadline)
+		if err != nil || string(buf[:n]) != want {
+			t.Errorf("received %q: %v, expected %q", buf[:n], err, want)
+		}
+	}
+
+	// A frame larger than the buffer is dropped
+	c.receive(0, macRawFrame("too large"))
+	c.receive(0, macRawFrame("fits"))
+	if _, err := d.RecvFrame(buf[:4], deadline); err != io.ErrShortBuffer {
+		t.Errorf("expected io.ErrShortBuffer, got %v", err)
+	}
+	if n, err := d.RecvFrame(buf, deadline); err != nil || string(buf[:n]) != "fits" {
+		t.Errorf("received %q: %v", buf[:n], err)
+	}
+
+	// An invalid header drops everything received
+	c.receive(0, []byte{0x00, 0x01, 0xAA, 0xBB})
+	c.receive(0, macRawFrame("lost"))
+	if _, err := d.RecvFrame(buf, deadline); err != errInvalidFrameHeader {
+		t.Errorf("expected errInvalidFrameHeader, got %v", err)
+	}
+	if n := c.received(0); n != 0 {
+		t.Errorf("%d bytes left in the RX buffer", n)
+	}
+
+	if err := d.CloseMACRaw(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.RecvFrame(buf, deadline); err == nil {
+		t.Error("received a frame on a closed MACRAW socket")
+	}
+}
@@ -52,10 +52,17 @@ func (d *Device) GetHostByName(name string) (netip.Addr, error) {
 	dns := d.dns
 	d.mu.Unlock()
 
-	if dns == nil {
-		return netip.Addr{}, netdev.ErrNotSupported
+	if dns != nil {
+		return dns(name)
 	}
-	return dns(name)
+	if ip, err := netip.ParseAddr(name); err == nil {
+		return ip, nil
+	}
+	addrs, err := d.LookupHost(name, DNSTypeA)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addrs[0], nil
 }
 
 func (d *Device) Socket(domain int, stype int, protocol int) (int, error) {
@@ -126,10 +133,50 @@ func (d *Device) bindSocket(sockn uint8, port uint16) error {
 	return nil
 }
 
+// Socket n mode register bit in UDP mode.
+const sockModeMulticast = 1 << 7
+
 // SetSockOpt sets the socket option for the given socket file descriptor.
-// It is not supported by the W5500, so it always returns an error.
-func (d *Device) SetSockOpt(int, int, int, any) error {
-	return netdev.ErrNotSupported
+// Only the multicast group membership of bound UDP sockets is supported, with
+// one group per socket. The group is given as a netip.Addr.
+func (d *Device) SetSockOpt(sockfd int, level int, opt int, value any) error {
+	if level != netdev.IPPROTO_IP || (opt != netdev.IP_ADD_MEMBERSHIP && opt != netdev.IP_DROP_MEMBERSHIP) {
+		return netdev.ErrNotSupported
+	}
+	group, ok := value.(netip.Addr)
+	if !ok || !group.Is4() || !group.IsMulticast() {
+		return errors.New("invalid multicast group")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sock, err := d.socket(sockfd)
+	if err != nil {
+		return err
+	}
+	if sock.protocol != 2 {
+		return errors.New("not a UDP socket")
+	}
+
+	mode := byte(2) // UDP
+	if opt == netdev.IP_ADD_MEMBERSHIP {
+		// The group and its MAC address must be set before opening the
+		// socket in multicast mode.
+		g := group.As4()
+		mac := []byte{0x01, 0x00, 0x5e, g[1] & 0x7f, g[2], g[3]}
+		d.write(sockDestMAC, sockAddr(sock.sockn), mac)
+		d.write(sockDestIP, sockAddr(sock.sockn), g[:])
+		d.writeUint16(sockDestPort, sockAddr(sock.sockn), sock.port)
+		mode |= sockModeMulticast
+	}
+
+	d.socketSendCmd(sock.sockn, sockCmdClose)
+	d.writeByte(sockMode, sockAddr(sock.sockn), mode)
+	if err := d.bindSocket(sock.sockn, sock.port); err != nil {
+		return errors.New("could not reopen socket: " + err.Error())
+	}
+	return nil
 }
 
 // Connect establishes a connection to the specified host and port or ip and port.
@@ -320,6 +367,7 @@ func (d *Device) sendChunk(sockfd int, buf []byte, deadline time.Time) (int, err
 	case irq&sockIntTimeout != 0:
 		return 0, netdev.ErrTimeout
 	default:
+		d.txBytes += uint64(bufLen)
 		return int(bufLen), nil
 	}
 }
@@ -337,7 +385,7 @@ func (d *Device) waitForFreeBuffer(sockn uint8, len uint16, deadline time.Time)
 
 		status := d.sockStatus(sockn)
 		switch status {
-		case sockStatusEstablished, sockStatusCloseWait:
+		case sockStatusEstablished, sockStatusCloseWait, sockStatusUdp, sockStatusMacRaw:
 		default:
 			return errors.New("socket is not in a valid state for sending data")
 		}
@@ -364,6 +412,12 @@ func (d *Device) Recv(sockfd int, buf []byte, _ int, deadline time.Time) (int, e
 		return 0, os.ErrClosed
 	}
 
+	// Datagrams are received one at a time, without their header
+	if sock.protocol == 2 {
+		n, _, err := d.recvDatagram(sock, buf, deadline)
+		return n, err
+	}
+
 	size, err := d.waitForData(sock, deadline)
 	if err != nil {
 		return 0, err
@@ -376,6 +430,7 @@ func (d *Device) Recv(sockfd int, buf []byte, _ int, deadline time.Time) (int, e
 	d.writeUint16(sockRXReadPtr, sockAddr(sock.sockn), recvPtr+uint16(len(buf)))
 	d.socketSendCmd(sock.sockn, sockCmdRecv)
 
+	d.rxBytes += uint64(len(buf))
 	return len(buf), nil
 }
 
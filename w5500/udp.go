@@ -0,0 +1,133 @@
+package w5500
+
+import (
+	"errors"
+	"math/rand"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// udpSendTimeout is how long sending a datagram may take, including the ARP
+// resolution of the destination.
+const udpSendTimeout = 500 * time.Millisecond
+
+// openUDP opens a UDP socket bound to the given local port.
+func (d *Device) openUDP(port uint16) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sockfd, sock, err := d.nextSocket()
+	if err != nil {
+		return -1, err
+	}
+
+	d.openSocket(sock.sockn, 2) // UDP
+	if err = d.bindSocket(sock.sockn, port); err != nil {
+		d.socketSendCmd(sock.sockn, sockCmdClose)
+		return -1, errors.New("could not bind UDP socket: " + err.Error())
+	}
+	sock.setProtocol(2).setPort(port).setInUse(true)
+	return sockfd, nil
+}
+
+// sendTo sends a single UDP datagram to the given address.
+func (d *Device) sendTo(sockfd int, buf []byte, to netip.AddrPort, deadline time.Time) error {
+	if !to.Addr().Is4() {
+		return errors.New("invalid destination IP address: " + to.Addr().String())
+	}
+
+	d.mu.Lock()
+	sock, err := d.socket(sockfd)
+	if err == nil {
+		d.write(sockDestIP, sockAddr(sock.sockn), to.Addr().AsSlice())
+		d.writeUint16(sockDestPort, sockAddr(sock.sockn), to.Port())
+	}
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, err = d.sendChunk(sockfd, buf, deadline)
+	return err
+}
+
+// recvFrom receives a single UDP datagram into buf, the rest of the datagram
+// is discarded if buf is too small. It returns the number of bytes read and the
+// address of the sender.
+func (d *Device) recvFrom(sockfd int, buf []byte, deadline time.Time) (int, netip.AddrPort, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sock, err := d.socket(sockfd)
+	if err != nil {
+		return 0, netip.AddrPort{}, err
+	}
+	if sock.closed {
+		return 0, netip.AddrPort{}, os.ErrClosed
+	}
+	return d.recvDatagram(sock, buf, deadline)
+}
+
+// recvDatagram receives a single UDP datagram, the device must be locked.
+func (d *Device) recvDatagram(sock *socket, buf []byte, deadline time.Time) (int, netip.AddrPort, error) {
+	if _, err := d.waitForData(sock, deadline); err != nil {
+		return 0, netip.AddrPort{}, err
+	}
+
+	// In UDP mode every datagram in the RX buffer starts with an 8 bytes
+	// header: the source IP, the source port and the length of the data.
+	var hdr [8]byte
+	recvPtr := d.readUint16(sockRXReadPtr, sockAddr(sock.sockn))
+	d.read(recvPtr, sock.sockn<<2|0b00011, hdr[:])
+	size := uint16(hdr[6])<<8 | uint16(hdr[7])
+
+	n := min(int(size), len(buf))
+	d.read(recvPtr+8, sock.sockn<<2|0b00011, buf[:n])
+	d.writeUint16(sockRXReadPtr, sockAddr(sock.sockn), recvPtr+8+size)
+	d.socketSendCmd(sock.sockn, sockCmdRecv)
+	d.rxBytes += uint64(n)
+
+	from := netip.AddrPortFrom(netip.AddrFrom4([4]byte(hdr[:4])), uint16(hdr[4])<<8|uint16(hdr[5]))
+	return n, from, nil
+}
+
+// udpTransport carries the datagrams of a client (DHCP, DNS) to its servers.
+// It is implemented by udpSocket, and by stand-in servers in tests.
+type udpTransport interface {
+	open() error
+	close()
+	send(msg []byte, dst netip.Addr) error
+	recv(buf []byte, deadline time.Time) (int, error)
+}
+
+// udpSocket is a UDP socket between a local and a remote port that is only
+// open during exchanges. A random local port is used if lport is zero.
+type udpSocket struct {
+	d      *Device
+	sockfd int
+	lport  uint16
+	rport  uint16
+}
+
+func (s *udpSocket) open() (err error) {
+	port := s.lport
+	if port == 0 {
+		port = 49152 + uint16(rand.Intn(16384))
+	}
+	s.sockfd, err = s.d.openUDP(port)
+	return err
+}
+
+func (s *udpSocket) close() {
+	s.d.Close(s.sockfd)
+}
+
+func (s *udpSocket) send(msg []byte, dst netip.Addr) error {
+	return s.d.sendTo(s.sockfd, msg, netip.AddrPortFrom(dst, s.rport), time.Now().Add(udpSendTimeout))
+}
+
+func (s *udpSocket) recv(buf []byte, deadline time.Time) (int, error) {
+	n, _, err := s.d.recvFrom(s.sockfd, buf, deadline)
+	return n, err
+}
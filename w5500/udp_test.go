@@ -0,0 +1,61 @@
+package w5500
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+)
+
+// udpDatagram returns a datagram as stored in the RX buffer, after its header.
+func udpDatagram(from netip.AddrPort, data string) []byte {
+	ip := from.Addr().As4()
+	hdr := []byte{ip[0], ip[1], ip[2], ip[3], byte(from.Port() >> 8), byte(from.Port()), byte(len(data) >> 8), byte(len(data))}
+	return append(hdr, data...)
+}
+
+func TestUDPRecv(t *testing.T) {
+	c := newFakeChip()
+	d := newTestDevice(c)
+
+	sockfd, err := d.Socket(netdev.AF_INET, netdev.SOCK_DGRAM, netdev.IPPROTO_UDP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Bind(sockfd, netip.AddrPortFrom(netip.IPv4Unspecified(), 5353)); err != nil {
+		t.Fatal(err)
+	}
+
+	from := netip.MustParseAddrPort("192.168.1.20:4000")
+	c.receive(0, udpDatagram(from, "hello"))
+	c.receive(0, udpDatagram(from, "world!"))
+
+	// Each read returns a single datagram, without its header
+	deadline := time.Now().Add(time.Second)
+	buf := make([]byte, 64)
+	for _, want := range []string{"hello", "world!"} {
+		n, err := d.Recv(sockfd, buf, 0, deadline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Errorf("received %q, expected %q", got, want)
+		}
+	}
+	if n := c.received(0); n != 0 {
+		t.Errorf("%d bytes left in the RX buffer", n)
+	}
+
+	// The rest of a datagram larger than the buffer is discarded
+	c.receive(0, udpDatagram(from, "truncated"))
+	c.receive(0, udpDatagram(from, "next"))
+	n, addr, err := d.recvFrom(sockfd, buf[:5], deadline)
+	if err != nil || string(buf[:n]) != "trunc" || addr != from {
+		t.Errorf("received %q from %v: %v", buf[:n], addr, err)
+	}
+	n, err = d.Recv(sockfd, buf, 0, deadline)
+	if err != nil || string(buf[:n]) != "next" {
+		t.Errorf("received %q: %v", buf[:n], err)
+	}
+}
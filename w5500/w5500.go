@@ -1,6 +1,9 @@
 // Package w5500 implements a driver for the W5500 Ethernet controller.
 //
-// The driver supports basic network functionality including TCP and UDP sockets.
+// The driver supports basic network functionality including TCP and UDP sockets,
+// a DHCP client to configure the device automatically and a DNS resolver.
+// Socket 0 can also be opened in MACRAW mode to send and receive whole
+// Ethernet frames.
 // It currently does not use the IRQ or RST pins.
 //
 // Datasheet: https://docs.wiznet.io/img/products/w5500/W5500_ds_v110e.pdf
@@ -12,10 +15,12 @@ import (
 	"net"
 	"net/netip"
 	"sync"
+	"time"
 
 	"tinygo.org/x/drivers"
 	"tinygo.org/x/drivers/internal/pin"
 	"tinygo.org/x/drivers/netdev"
+	"tinygo.org/x/drivers/netlink"
 )
 
 var _ netdev.Netdever = &Device{}
@@ -36,6 +41,17 @@ type Device struct {
 	sockets []*socket
 	laddr   netip.Addr
 
+	lease    Lease
+	dhcpStop chan struct{}
+	dhcpDone chan struct{}
+
+	resolver    dnsResolver
+	nameservers []netip.Addr
+
+	// bytes sent and received on sockets
+	txBytes uint64
+	rxBytes uint64
+
 	cmdBuf [3]byte
 }
 
@@ -51,7 +67,11 @@ func New(bus drivers.SPI, csPin pin.Output) *Device {
 //
 // The SPI bus must be fully configured.
 type Config struct {
+	// Optional, resolves the host names instead of the built-in resolver.
 	DNS Resolver
+	// Optional, the servers of the built-in resolver. The ones provided by
+	// DHCP are used if empty.
+	DNSServers []netip.Addr
 
 	MAC        net.HardwareAddr
 	IP         netip.Addr
@@ -64,7 +84,8 @@ type Config struct {
 
 // Configure sets up the device.
 //
-// MAC address must be provided. The other fields are optional.
+// MAC address must be provided. The other fields are optional, the IP
+// configuration can be obtained later with StartDHCP.
 func (d *Device) Configure(cfg Config) error {
 	d.cs(true)
 
@@ -72,6 +93,13 @@ func (d *Device) Configure(cfg Config) error {
 	defer d.mu.Unlock()
 
 	d.dns = cfg.DNS
+	d.nameservers = cfg.DNSServers
+	d.resolver = dnsResolver{
+		tr:      &udpSocket{d: d, rport: dnsPort},
+		servers: d.dnsServers,
+		now:     time.Now,
+		timeout: dnsTimeout,
+	}
 
 	d.reset()
 
@@ -246,3 +274,32 @@ func (d *Device) LinkInfo() string {
 	}
 	return speed + " " + duplex
 }
+
+// NetScan is not supported, there are no access points on a wired network.
+func (d *Device) NetScan() ([]netlink.AccessPoint, error) {
+	return nil, netlink.ErrNotSupported
+}
+
+// NetInfo returns the state of the Ethernet link, and the number of bytes
+// sent and received on the sockets.
+func (d *Device) NetInfo() (netlink.LinkInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	info := netlink.LinkInfo{
+		TxBytes: d.txBytes,
+		RxBytes: d.rxBytes,
+	}
+
+	phy := d.readByte(regPHYCfg, 0)
+	if phy&0b00000001 == 0 {
+		return info, nil
+	}
+	info.Up = true
+	info.Speed = 10
+	if phy&0b00000010 != 0 {
+		info.Speed = 100
+	}
+	info.FullDuplex = phy&0b00000100 != 0
+	return info, nil
+}
@@ -9,16 +9,22 @@
 package epd1in54
 
 import (
+	"errors"
 	"image/color"
 	"machine"
 	"time"
+
+	"tinygo.org/x/drivers"
 )
 
+var _ drivers.EPaper = &Device{}
+
 type Config struct {
 	Width        int16
 	Height       int16
 	LogicalWidth int16
 	Rotation     Rotation
+	UpdateAfter  int // in partial refresh mode, how many updates are done before a full refresh (0 to disable)
 }
 
 type Device struct {
@@ -28,8 +34,12 @@ type Device struct {
 	rst  machine.Pin
 	busy machine.Pin
 
-	buffer   []uint8
-	rotation Rotation
+	buffer      []uint8
+	rotation    Rotation
+	yDecrement  bool
+	refreshMode drivers.RefreshMode
+	updateCount int
+	updateAfter int
 }
 
 type Rotation uint8
@@ -91,6 +101,11 @@ func New(bus *machine.SPI, csPin, dcPin, rstPin, busyPin machine.Pin) Device {
 }
 
 func (d *Device) LDirInit(cfg Config) {
+	d.yDecrement = false
+	d.refreshMode = drivers.RefreshFull
+	d.updateCount = 0
+	d.updateAfter = cfg.UpdateAfter
+
 	d.cs.Configure(machine.PinConfig{Mode: machine.PinOutput})
 	d.rst.Configure(machine.PinConfig{Mode: machine.PinOutput})
 	d.dc.Configure(machine.PinConfig{Mode: machine.PinOutput})
@@ -150,6 +165,11 @@ func (d *Device) LDirInit(cfg Config) {
 }
 
 func (d *Device) HDirInit(cfg Config) {
+	d.yDecrement = true
+	d.refreshMode = drivers.RefreshFull
+	d.updateCount = 0
+	d.updateAfter = cfg.UpdateAfter
+
 	d.cs.Configure(machine.PinConfig{Mode: machine.PinOutput})
 	d.rst.Configure(machine.PinConfig{Mode: machine.PinOutput})
 	d.dc.Configure(machine.PinConfig{Mode: machine.PinOutput})
@@ -287,6 +307,7 @@ func (d *Device) DisplayImage(image []uint8) {
 	}
 	h = int(Height)
 
+	d.setWindow(0, 0, Width, Height)
 	d.SendCommand(0x24)
 	for j := 0; j < h; j++ {
 		for i := 0; i < w; i++ {
@@ -294,6 +315,7 @@ func (d *Device) DisplayImage(image []uint8) {
 		}
 	}
 
+	d.setWindow(0, 0, Width, Height)
 	d.SendCommand(0x26)
 	for j := 0; j < h; j++ {
 		for i := 0; i < w; i++ {
@@ -304,34 +326,141 @@ func (d *Device) DisplayImage(image []uint8) {
 	d.displayFrame()
 }
 
+// Display sends the buffer to the screen.
 func (d *Device) Display() error {
-	var w, h int
-	if Width%8 == 0 {
-		w = int(Width / 8)
-	} else {
-		w = int(Width/8 + 1)
+	d.update(0, 0, Width, Height)
+	return nil
+}
+
+// DisplayRect sends only an area of the buffer to the screen.
+// The rectangle points need to be a multiple of 8 in the screen.
+// They might not work as expected if the screen is rotated.
+func (d *Device) DisplayRect(x int16, y int16, width int16, height int16) error {
+	x, y = d.xy(x, y)
+	if x < 0 || y < 0 || x >= Width || y >= Height || width < 0 || height < 0 {
+		return errors.New("wrong rectangle")
 	}
-	h = int(Height)
+	if d.rotation == ROTATION_90 {
+		width, height = height, width
+		x -= width
+	} else if d.rotation == ROTATION_180 {
+		x -= width - 1
+		y -= height - 1
+	} else if d.rotation == ROTATION_270 {
+		width, height = height, width
+		y -= height
+	}
+	x &^= 7
+	width &^= 7
+	width = x + width // reuse variables
+	if width >= Width {
+		width = Width
+	}
+	height = y + height
+	if height > Height {
+		height = Height
+	}
+	d.update(x, y, width, height)
+	return nil
+}
 
-	d.SendCommand(0x24)
-	for j := 0; j < h; j++ {
-		for i := 0; i < w; i++ {
-			x := i + j*w
-			d.SendData(d.buffer[x])
-		}
+// SetRefreshMode selects the LUT used by Display and DisplayRect.
+// In partial mode a full refresh is still done every UpdateAfter updates to
+// clear the ghosting (see Config).
+func (d *Device) SetRefreshMode(mode drivers.RefreshMode) error {
+	switch mode {
+	case drivers.RefreshFull:
+		d.setLUT(fullRefresh)
+
+		d.SendCommand(0x3C)
+		d.SendData(0x01)
+	case drivers.RefreshPartial:
+		d.setLUT(partialRefresh)
+
+		d.SendCommand(0x37)
+		d.SendData(0x00)
+		d.SendData(0x00)
+		d.SendData(0x00)
+		d.SendData(0x00)
+		d.SendData(0x00)
+		d.SendData(0x40) // ping-pong for display mode 2
+		d.SendData(0x00)
+		d.SendData(0x00)
+		d.SendData(0x00)
+		d.SendData(0x00)
+
+		d.SendCommand(0x3C)
+		d.SendData(0x80)
+
+		d.SendCommand(0x22)
+		d.SendData(0xC0)
+		d.SendCommand(0x20)
+		d.WaitUntilIdle()
+	default:
+		return errors.New("unsupported refresh mode")
 	}
+	d.refreshMode = mode
+	return nil
+}
 
-	d.SendCommand(0x26)
-	for j := 0; j < h; j++ {
-		for i := 0; i < w; i++ {
-			x := i + j*w
-			d.SendData(d.buffer[x])
+// update sends the area between (x0, y0) and (x1, y1) of the buffer to the
+// display and refreshes the screen. x0 and x1 must be multiples of 8.
+func (d *Device) update(x0, y0, x1, y1 int16) {
+	if d.refreshMode == drivers.RefreshPartial {
+		fullUpdate := d.updateAfter != 0 && d.updateCount%d.updateAfter == 0
+		d.updateCount++
+		if !fullUpdate {
+			// the previous image is kept in the 0x26 RAM by the controller
+			d.writeRAM(0x24, x0, y0, x1, y1)
+			d.displayPartFrame()
+			return
 		}
+		// we need full refresh here
+		d.setLUT(fullRefresh)
+		defer d.setLUT(partialRefresh)
 	}
 
+	d.writeRAM(0x24, 0, 0, Width, Height)
+	d.writeRAM(0x26, 0, 0, Width, Height)
 	d.displayFrame()
+}
 
-	return nil
+// writeRAM sends the area between (x0, y0) and (x1, y1) of the buffer to the
+// given RAM (0x24 for the new image, 0x26 for the previous one).
+func (d *Device) writeRAM(ram uint8, x0, y0, x1, y1 int16) {
+	d.setWindow(x0, y0, x1, y1)
+	d.SendCommand(ram)
+	for j := y0; j < y1; j++ {
+		for i := x0 / 8; i < x1/8; i++ {
+			d.SendData(d.buffer[i+j*(Width/8)])
+		}
+	}
+}
+
+// setWindow sets the RAM area between (x0, y0) and (x1, y1) that will be
+// written next and moves the address counter to its start.
+func (d *Device) setWindow(x0, y0, x1, y1 int16) {
+	y1--
+	if d.yDecrement {
+		y0, y1 = Height-1-y0, Height-1-y1
+	}
+
+	d.SendCommand(0x44)
+	d.SendData(uint8(x0 >> 3))
+	d.SendData(uint8((x1 - 1) >> 3))
+
+	d.SendCommand(0x45)
+	d.SendData(uint8(y0 & 0xFF))
+	d.SendData(uint8(y0 >> 8))
+	d.SendData(uint8(y1 & 0xFF))
+	d.SendData(uint8(y1 >> 8))
+
+	d.SendCommand(0x4E)
+	d.SendData(uint8(x0 >> 3))
+
+	d.SendCommand(0x4F)
+	d.SendData(uint8(y0 & 0xFF))
+	d.SendData(uint8(y0 >> 8))
 }
 
 func (d *Device) displayFrame() {
@@ -341,6 +470,23 @@ func (d *Device) displayFrame() {
 	d.WaitUntilIdle()
 }
 
+func (d *Device) displayPartFrame() {
+	d.SendCommand(0x22)
+	d.SendData(0xCF)
+	d.SendCommand(0x20)
+	d.WaitUntilIdle()
+}
+
+// ClearDisplay erases the display with a full refresh, the buffer is left
+// untouched.
+func (d *Device) ClearDisplay() {
+	if d.refreshMode == drivers.RefreshPartial {
+		d.setLUT(fullRefresh)
+		defer d.setLUT(partialRefresh)
+	}
+	d.Clear()
+}
+
 func (d *Device) Clear() {
 	var w, h int
 	if Width%8 == 0 {
@@ -350,6 +496,7 @@ func (d *Device) Clear() {
 	}
 	h = int(Height)
 
+	d.setWindow(0, 0, Width, Height)
 	d.SendCommand(0x24)
 	for j := 0; j < h; j++ {
 		for i := 0; i < w; i++ {
@@ -357,6 +504,7 @@ func (d *Device) Clear() {
 		}
 	}
 
+	d.setWindow(0, 0, Width, Height)
 	d.SendCommand(0x26)
 	for j := 0; j < h; j++ {
 		for i := 0; i < w; i++ {
@@ -12,6 +12,7 @@
 package epd2in9 // import "tinygo.org/x/drivers/waveshare-epd/epd2in9"
 
 import (
+	"errors"
 	"image/color"
 	"machine"
 	"time"
@@ -19,11 +20,14 @@ import (
 	"tinygo.org/x/drivers"
 )
 
+var _ drivers.EPaper = &Device{}
+
 type Config struct {
 	Width        int16 // Width is the display resolution
 	Height       int16
 	LogicalWidth int16    // LogicalWidth must be a multiple of 8 and same size or bigger than Width
 	Rotation     Rotation // Rotation is clock-wise
+	UpdateAfter  int      // in partial refresh mode, how many updates are done before a full refresh (0 to disable)
 }
 
 type Device struct {
@@ -38,6 +42,9 @@ type Device struct {
 	buffer       []uint8
 	bufferLength uint32
 	rotation     Rotation
+	refreshMode  drivers.RefreshMode
+	updateCount  int
+	updateAfter  int
 }
 
 type Rotation uint8
@@ -93,6 +100,9 @@ func (d *Device) Configure(cfg Config) {
 		d.height = 296
 	}
 	d.rotation = cfg.Rotation
+	d.refreshMode = drivers.RefreshFull
+	d.updateCount = 0
+	d.updateAfter = cfg.UpdateAfter
 	d.bufferLength = (uint32(d.logicalWidth) * uint32(d.height)) / 8
 	d.buffer = make([]uint8, d.bufferLength)
 	for i := uint32(0); i < d.bufferLength; i++ {
@@ -194,24 +204,104 @@ func (d *Device) SetPixel(x int16, y int16, c color.RGBA) {
 
 // Display sends the buffer to the screen.
 func (d *Device) Display() error {
-	d.setMemoryArea(0, 0, d.logicalWidth-1, d.height-1)
-	for j := int16(0); j < d.height; j++ {
-		d.setMemoryPointer(0, j)
-		d.SendCommand(WRITE_RAM)
-		for i := int16(0); i < d.logicalWidth/8; i++ {
-			d.SendData(d.buffer[i+j*(d.logicalWidth/8)])
-		}
+	d.update(0, 0, d.logicalWidth, d.height)
+	return nil
+}
+
+// DisplayRect sends only an area of the buffer to the screen.
+// The rectangle points need to be a multiple of 8 in the screen.
+// They might not work as expected if the screen is rotated.
+func (d *Device) DisplayRect(x int16, y int16, width int16, height int16) error {
+	x, y = d.xy(x, y)
+	if x < 0 || y < 0 || x >= d.logicalWidth || y >= d.height || width < 0 || height < 0 {
+		return errors.New("wrong rectangle")
 	}
+	if d.rotation == ROTATION_90 {
+		width, height = height, width
+		x -= width
+	} else if d.rotation == ROTATION_180 {
+		x -= width - 1
+		y -= height - 1
+	} else if d.rotation == ROTATION_270 {
+		width, height = height, width
+		y -= height
+	}
+	x &^= 7
+	width &^= 7
+	width = x + width // reuse variables
+	if width >= d.logicalWidth {
+		width = d.logicalWidth
+	}
+	height = y + height
+	if height > d.height {
+		height = d.height
+	}
+	d.update(x, y, width, height)
+	return nil
+}
 
+// SetRefreshMode selects the LUT used by Display and DisplayRect.
+// In partial mode a full refresh is still done every UpdateAfter updates to
+// clear the ghosting (see Config).
+func (d *Device) SetRefreshMode(mode drivers.RefreshMode) error {
+	if mode != drivers.RefreshFull && mode != drivers.RefreshPartial {
+		return errors.New("unsupported refresh mode")
+	}
+	d.WaitUntilIdle()
+	d.refreshMode = mode
+	d.SetLUT(mode == drivers.RefreshFull)
+	return nil
+}
+
+// update sends the area between (x0, y0) and (x1, y1) of the buffer to the
+// display RAM and refreshes the screen. x0 and x1 must be multiples of 8.
+func (d *Device) update(x0, y0, x1, y1 int16) {
+	partial := d.refreshMode == drivers.RefreshPartial
+	fullUpdate := partial && d.updateAfter != 0 && d.updateCount%d.updateAfter == 0
+	d.updateCount++
+	if fullUpdate {
+		// we need full refresh here
+		x0, y0, x1, y1 = 0, 0, d.logicalWidth, d.height
+		d.SetLUT(true)
+	}
+
+	d.writeRAM(x0, y0, x1, y1)
 	d.SendCommand(DISPLAY_UPDATE_CONTROL_2)
 	d.SendData(0xC4)
 	d.SendCommand(MASTER_ACTIVATION)
 	d.SendCommand(TERMINATE_FRAME_READ_WRITE)
-	return nil
+
+	if partial {
+		// The controller flips between its two RAM banks on every refresh and
+		// partial updates are computed from the differences between them, so
+		// the same image is written again once the refresh is done.
+		d.WaitUntilIdle()
+		d.writeRAM(x0, y0, x1, y1)
+		if fullUpdate {
+			d.SetLUT(false)
+		}
+	}
+}
+
+// writeRAM sends the area between (x0, y0) and (x1, y1) of the buffer to the
+// display RAM.
+func (d *Device) writeRAM(x0, y0, x1, y1 int16) {
+	d.setMemoryArea(x0, y0, x1-1, y1-1)
+	for j := y0; j < y1; j++ {
+		d.setMemoryPointer(x0, j)
+		d.SendCommand(WRITE_RAM)
+		for i := x0 / 8; i < x1/8; i++ {
+			d.SendData(d.buffer[i+j*(d.logicalWidth/8)])
+		}
+	}
 }
 
 // ClearDisplay erases the device SRAM
 func (d *Device) ClearDisplay() {
+	mode := d.refreshMode
+	d.SetRefreshMode(drivers.RefreshFull)
+	defer d.SetRefreshMode(mode)
+
 	d.setMemoryArea(0, 0, d.logicalWidth-1, d.height-1)
 	d.setMemoryPointer(0, 0)
 	d.SendCommand(WRITE_RAM)
@@ -11,6 +11,7 @@
 package epd2in9v2 // import "tinygo.org/x/drivers/waveshare-epd/epd2in9v2"
 
 import (
+	"errors"
 	"image/color"
 	"machine"
 	"time"
@@ -18,12 +19,15 @@ import (
 	"tinygo.org/x/drivers"
 )
 
+var _ drivers.EPaper = &Device{}
+
 type Config struct {
-	Width    int16
-	Height   int16
-	Rotation Rotation
-	Speed    Speed
-	Blocking bool
+	Width       int16
+	Height      int16
+	Rotation    Rotation
+	Speed       Speed
+	Blocking    bool
+	UpdateAfter int // in partial refresh mode, how many updates are done before a full refresh (0 to disable)
 }
 
 type Device struct {
@@ -39,6 +43,10 @@ type Device struct {
 	rotation     Rotation
 	speed        Speed
 	blocking     bool
+	partial      bool // the partial refresh LUT is loaded
+	refreshMode  drivers.RefreshMode
+	updateCount  int
+	updateAfter  int
 }
 
 type Rotation uint8
@@ -143,12 +151,21 @@ func (d *Device) Configure(cfg Config) {
 	d.rotation = cfg.Rotation
 	d.speed = cfg.Speed
 	d.blocking = cfg.Blocking
+	d.refreshMode = drivers.RefreshFull
+	d.updateCount = 0
+	d.updateAfter = cfg.UpdateAfter
 	d.bufferLength = (uint32(d.width) * uint32(d.height)) / 8
 	d.buffer = make([]uint8, d.bufferLength)
 	for i := uint32(0); i < d.bufferLength; i++ {
 		d.buffer[i] = 0xFF
 	}
 
+	d.init()
+}
+
+// init resets the controller and loads the full refresh LUT for the
+// configured speed.
+func (d *Device) init() {
 	d.Reset()
 	time.Sleep(100 * time.Millisecond)
 
@@ -166,7 +183,7 @@ func (d *Device) Configure(cfg Config) {
 
 	d.setWindow(0, 0, d.width-1, d.height-1)
 
-	if cfg.Speed == SPEED_FAST {
+	if d.speed == SPEED_FAST {
 		d.SendCommand(BORDER_WAVEFORM_CONTROL)
 		d.SendData(0x05)
 	}
@@ -178,12 +195,13 @@ func (d *Device) Configure(cfg Config) {
 	d.setCursor(0, 0)
 	d.WaitUntilIdle()
 
-	switch cfg.Speed {
+	switch d.speed {
 	case SPEED_FAST:
 		d.setLUTByHost(&lutFast)
 	default:
 		d.setLUTByHost(&lutDefault)
 	}
+	d.partial = false
 }
 
 // HardwareReset resets the device via the RST pin.
@@ -244,9 +262,69 @@ func (d *Device) SetPixel(x int16, y int16, c color.RGBA) {
 
 // Display sends the buffer to the screen.
 func (d *Device) Display() error {
+	return d.update(0, 0, d.width, d.height)
+}
+
+// DisplayRect sends only an area of the buffer to the screen.
+// The rectangle points need to be a multiple of 8 in the screen.
+// They might not work as expected if the screen is rotated.
+func (d *Device) DisplayRect(x int16, y int16, width int16, height int16) error {
+	x, y = d.xy(x, y)
+	if x < 0 || y < 0 || x >= d.width || y >= d.height || width < 0 || height < 0 {
+		return errors.New("wrong rectangle")
+	}
+	if d.rotation == ROTATION_90 {
+		width, height = height, width
+		x -= width
+	} else if d.rotation == ROTATION_180 {
+		x -= width - 1
+		y -= height - 1
+	} else if d.rotation == ROTATION_270 {
+		width, height = height, width
+		y -= height
+	}
+	x &^= 7
+	width &^= 7
+	width = x + width // reuse variables
+	if width >= d.width {
+		width = d.width
+	}
+	height = y + height
+	if height > d.height {
+		height = d.height
+	}
+	return d.update(x, y, width, height)
+}
+
+// SetRefreshMode changes how Display and DisplayRect refresh the screen.
+// In partial mode, the first update (and then one every UpdateAfter updates)
+// is a full refresh that also sets the base image used by the following
+// partial refreshes.
+func (d *Device) SetRefreshMode(mode drivers.RefreshMode) error {
+	if mode != drivers.RefreshFull && mode != drivers.RefreshPartial {
+		return errors.New("unsupported refresh mode")
+	}
+	d.refreshMode = mode
+	d.updateCount = 0
+	return nil
+}
+
+// update refreshes the area between (x0, y0) and (x1, y1) of the screen
+// according to the refresh mode. x0 and x1 must be multiples of 8.
+func (d *Device) update(x0, y0, x1, y1 int16) error {
+	if d.refreshMode == drivers.RefreshPartial {
+		fullUpdate := d.updateCount == 0 || (d.updateAfter != 0 && d.updateCount%d.updateAfter == 0)
+		d.updateCount++
+		if fullUpdate {
+			return d.DisplayWithBase()
+		}
+		return d.displayPartial(x0, y0, x1, y1)
+	}
+
 	if d.blocking {
 		d.WaitUntilIdle()
 	}
+	d.restoreLUT()
 
 	d.setCursor(0, 0)
 	d.SendCommand(WRITE_RAM_BW)
@@ -268,6 +346,7 @@ func (d *Device) DisplayWithBase() error {
 	if d.blocking {
 		d.WaitUntilIdle()
 	}
+	d.restoreLUT()
 
 	d.setCursor(0, 0)
 	d.SendCommand(WRITE_RAM_BW)
@@ -292,12 +371,19 @@ func (d *Device) DisplayWithBase() error {
 // DisplayPartial performs a partial refresh of the display.
 // Call DisplayWithBase first to set the base image before using partial updates.
 func (d *Device) DisplayPartial() error {
+	return d.displayPartial(0, 0, d.width, d.height)
+}
+
+// displayPartial performs a partial refresh of the area between (x0, y0) and
+// (x1, y1) of the display. x0 and x1 must be multiples of 8.
+func (d *Device) displayPartial(x0, y0, x1, y1 int16) error {
 	d.rst.Low()
 	time.Sleep(1 * time.Millisecond)
 	d.rst.High()
 	time.Sleep(2 * time.Millisecond)
 
 	d.setLUT(&lutPartial)
+	d.partial = true
 
 	d.SendCommand(OTP_SELECTION_CONTROL)
 	d.SendData(0x00)
@@ -319,21 +405,26 @@ func (d *Device) DisplayPartial() error {
 	d.SendCommand(MASTER_ACTIVATION)
 	d.WaitUntilIdle()
 
-	d.setWindow(0, 0, d.width-1, d.height-1)
-	d.setCursor(0, 0)
+	d.setWindow(x0, y0, x1-1, y1-1)
+	d.setCursor(x0, y0)
 
 	d.SendCommand(WRITE_RAM_BW)
-	for i := uint32(0); i < d.bufferLength; i++ {
-		d.SendData(d.buffer[i])
+	for j := y0; j < y1; j++ {
+		for i := x0 / 8; i < x1/8; i++ {
+			d.SendData(d.buffer[j*(d.width/8)+i])
+		}
 	}
 
 	d.turnOnDisplayPartial()
 	d.WaitUntilIdle()
+
+	d.setWindow(0, 0, d.width-1, d.height-1)
 	return nil
 }
 
 // ClearDisplay erases the display.
 func (d *Device) ClearDisplay() {
+	d.updateCount = 0 // full refresh in partial mode too
 	d.ClearBuffer()
 	d.Display()
 }
@@ -417,13 +508,21 @@ func (d *Device) setWindow(xStart, yStart, xEnd, yEnd int16) {
 
 func (d *Device) setCursor(x, y int16) {
 	d.SendCommand(SET_RAM_X_COUNTER)
-	d.SendData(uint8(x & 0xFF))
+	d.SendData(uint8((x >> 3) & 0xFF))
 
 	d.SendCommand(SET_RAM_Y_COUNTER)
 	d.SendData(uint8(y & 0xFF))
 	d.SendData(uint8((y >> 8) & 0xFF))
 }
 
+// restoreLUT sets the full refresh LUT back after a partial refresh.
+func (d *Device) restoreLUT() {
+	if d.partial {
+		// the partial refresh sequence resets the controller
+		d.init()
+	}
+}
+
 func (d *Device) turnOnDisplay() {
 	d.SendCommand(DISPLAY_UPDATE_CONTROL_2)
 	d.SendData(0xC7)
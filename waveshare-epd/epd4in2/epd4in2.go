@@ -9,6 +9,7 @@
 package epd4in2
 
 import (
+	"errors"
 	"image/color"
 	"machine"
 	"time"
@@ -16,11 +17,14 @@ import (
 	"tinygo.org/x/drivers"
 )
 
+var _ drivers.EPaper = &Device{}
+
 type Config struct {
 	Width        int16 // Width is the display resolution
 	Height       int16
 	LogicalWidth int16    // LogicalWidth must be a multiple of 8 and same size or bigger than Width
 	Rotation     Rotation // Rotation is clock-wise
+	UpdateAfter  int      // in partial refresh mode, how many updates are done before a full refresh (0 to disable)
 }
 
 type Device struct {
@@ -35,6 +39,9 @@ type Device struct {
 	buffer       []uint8
 	bufferLength uint32
 	rotation     Rotation
+	refreshMode  drivers.RefreshMode
+	updateCount  int
+	updateAfter  int
 }
 
 type Rotation uint8
@@ -72,6 +79,9 @@ func (d *Device) Configure(cfg Config) {
 		d.height = EPD_HEIGHT
 	}
 	d.rotation = cfg.Rotation
+	d.refreshMode = drivers.RefreshFull
+	d.updateCount = 0
+	d.updateAfter = cfg.UpdateAfter
 	d.bufferLength = (uint32(d.logicalWidth) * uint32(d.height)) / 8
 	d.buffer = make([]uint8, d.bufferLength)
 	for i := uint32(0); i < d.bufferLength; i++ {
@@ -229,6 +239,35 @@ func (d *Device) SetLUT() {
 	}
 }
 
+// setPartialLUT sets the look up tables for partial updates, every pixel of
+// the window is driven to its new color in a single short phase.
+func (d *Device) setPartialLUT() {
+	d.SendCommand(LUT_FOR_VCOM)
+	d.SendData(0x00)
+	d.SendData(0x19)
+	d.SendData(0x01)
+	d.SendData(0x00)
+	d.SendData(0x00)
+	d.SendData(0x01)
+	for count := 6; count < 44; count++ {
+		d.SendData(0x00)
+	}
+
+	// white to white, black to white, white to black, black to black
+	for i, level := range [4]uint8{0x00, 0x80, 0x40, 0x00} {
+		d.SendCommand(LUT_WHITE_TO_WHITE + uint8(i))
+		d.SendData(level)
+		d.SendData(0x19)
+		d.SendData(0x01)
+		d.SendData(0x00)
+		d.SendData(0x00)
+		d.SendData(0x01)
+		for count := 6; count < 42; count++ {
+			d.SendData(0x00)
+		}
+	}
+}
+
 // SetPixel modifies the internal buffer in a single pixel.
 // The display have 2 colors: black and white
 // We use RGBA(0,0,0, 255) as white (transparent)
@@ -248,6 +287,15 @@ func (d *Device) SetPixel(x int16, y int16, c color.RGBA) {
 
 // Display sends the buffer to the screen.
 func (d *Device) Display() error {
+	if d.refreshMode == drivers.RefreshPartial {
+		fullUpdate := d.updateAfter != 0 && d.updateCount%d.updateAfter == 0
+		d.updateCount++
+		if !fullUpdate {
+			d.displayPartial(0, 0, d.logicalWidth, d.height)
+			return nil
+		}
+	}
+
 	d.SendCommand(RESOLUTION_SETTING)
 	d.SendData(uint8(d.height >> 8))
 	d.SendData(uint8(d.logicalWidth & 0xff))
@@ -281,6 +329,98 @@ func (d *Device) Display() error {
 	return nil
 }
 
+// DisplayRect sends only an area of the buffer to the screen.
+// The rectangle points need to be a multiple of 8 in the screen.
+// They might not work as expected if the screen is rotated.
+// In full refresh mode the whole screen is updated.
+func (d *Device) DisplayRect(x int16, y int16, width int16, height int16) error {
+	x, y = d.xy(x, y)
+	if x < 0 || y < 0 || x >= d.logicalWidth || y >= d.height || width < 0 || height < 0 {
+		return errors.New("wrong rectangle")
+	}
+	if d.refreshMode != drivers.RefreshPartial ||
+		(d.updateAfter != 0 && d.updateCount%d.updateAfter == 0) {
+		return d.Display()
+	}
+	d.updateCount++
+
+	if d.rotation == ROTATION_90 {
+		width, height = height, width
+		x -= width
+	} else if d.rotation == ROTATION_180 {
+		x -= width - 1
+		y -= height - 1
+	} else if d.rotation == ROTATION_270 {
+		width, height = height, width
+		y -= height
+	}
+	x &^= 7
+	width &^= 7
+	width = x + width // reuse variables
+	if width >= d.logicalWidth {
+		width = d.logicalWidth
+	}
+	height = y + height
+	if height > d.height {
+		height = d.height
+	}
+	d.displayPartial(x, y, width, height)
+	return nil
+}
+
+// SetRefreshMode changes how Display and DisplayRect refresh the screen.
+// In partial mode a full refresh is still done every UpdateAfter updates to
+// clear the ghosting (see Config).
+func (d *Device) SetRefreshMode(mode drivers.RefreshMode) error {
+	if mode != drivers.RefreshFull && mode != drivers.RefreshPartial {
+		return errors.New("unsupported refresh mode")
+	}
+	d.refreshMode = mode
+	return nil
+}
+
+// displayPartial refreshes the area between (x0, y0) and (x1, y1) of the
+// screen using the partial window. x0 and x1 must be multiples of 8.
+func (d *Device) displayPartial(x0, y0, x1, y1 int16) {
+	d.setPartialLUT()
+
+	d.SendCommand(PARTIAL_IN)
+	d.SendCommand(PARTIAL_WINDOW)
+	d.SendData(uint8(x0 >> 8))
+	d.SendData(uint8(x0) & 0xF8)
+	d.SendData(uint8((x1 - 1) >> 8))
+	d.SendData(uint8(x1-1) | 0x07)
+	d.SendData(uint8(y0 >> 8))
+	d.SendData(uint8(y0 & 0xff))
+	d.SendData(uint8((y1 - 1) >> 8))
+	d.SendData(uint8((y1 - 1) & 0xff))
+	d.SendData(0x01) // gates scan both inside and outside of the window
+
+	// The previous image is not known, so the old data is the inverse of the
+	// new one and every pixel of the window gets driven.
+	w := d.logicalWidth / 8
+	d.SendCommand(DATA_START_TRANSMISSION_1)
+	for j := y0; j < y1; j++ {
+		for i := x0 / 8; i < x1/8; i++ {
+			d.SendData(^d.buffer[int32(i)+int32(j)*int32(w)])
+		}
+	}
+	time.Sleep(2 * time.Millisecond)
+	d.SendCommand(DATA_START_TRANSMISSION_2)
+	for j := y0; j < y1; j++ {
+		for i := x0 / 8; i < x1/8; i++ {
+			d.SendData(d.buffer[int32(i)+int32(j)*int32(w)])
+		}
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	d.SendCommand(DISPLAY_REFRESH)
+	time.Sleep(100 * time.Millisecond)
+	d.WaitUntilIdle()
+
+	d.SendCommand(PARTIAL_OUT)
+}
+
 // ClearDisplay erases the device SRAM
 func (d *Device) ClearDisplay() {
 	d.SendCommand(RESOLUTION_SETTING)
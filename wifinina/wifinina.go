@@ -218,6 +218,10 @@ type wifinina struct {
 	fault        error
 
 	sockets map[int]*Socket // keyed by sockfd
+
+	// bytes sent and received on sockets
+	txBytes uint64
+	rxBytes uint64
 }
 
 func New(cfg *Config) *wifinina {
@@ -446,6 +450,13 @@ func (w *wifinina) networkDown() bool {
 	}
 }
 
+func (w *wifinina) lowSignal() bool {
+	if w.params.LowSignal == 0 || w.params.ConnectMode == netlink.ConnectModeAP {
+		return false
+	}
+	return int(w.getCurrentRSSI()) < w.params.LowSignal
+}
+
 func (w *wifinina) watchdog() {
 	ticker := time.NewTicker(w.params.WatchdogTimeout)
 	for {
@@ -469,6 +480,10 @@ func (w *wifinina) watchdog() {
 					w.notifyCb(netlink.EventNetDown)
 				}
 				w.netConnect(false)
+			} else if w.lowSignal() {
+				if w.notifyCb != nil {
+					w.notifyCb(netlink.EventNetLowSignal)
+				}
 			}
 			w.mu.Unlock()
 		}
@@ -571,6 +586,77 @@ func (w *wifinina) NetNotify(cb func(netlink.Event)) {
 	w.notifyCb = cb
 }
 
+func (w *wifinina) NetInfo() (netlink.LinkInfo, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[NetInfo]\r\n")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info := netlink.LinkInfo{
+		TxBytes: w.txBytes,
+		RxBytes: w.rxBytes,
+	}
+
+	if !w.netConnected || w.networkDown() {
+		return info, nil
+	}
+
+	info.Up = true
+	info.Ssid = w.getCurrentSSID()
+	if w.params.ConnectMode != netlink.ConnectModeAP {
+		info.Bssid = w.getCurrentBSSID()
+		info.Rssi = int(w.getCurrentRSSI())
+	}
+
+	return info, nil
+}
+
+func (w *wifinina) NetScan() ([]netlink.AccessPoint, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[NetScan]\r\n")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Scanning doesn't need a connection, but a running device
+	if !w.netConnected {
+		w.showDriver()
+		w.setupSPI()
+		w.start()
+	}
+
+	if w.startScanNetworks() == 0xFF {
+		return nil, netlink.ErrScanFailed
+	}
+
+	// Results come after a couple of seconds, retry a few times as
+	// there might be no network in range
+	var count uint8
+	for i := 0; i < 5 && count == 0; i++ {
+		time.Sleep(2 * time.Second)
+		count = w.scanNetworks()
+	}
+	count = min(count, maxNetworks)
+
+	aps := make([]netlink.AccessPoint, count)
+	for i := range aps {
+		aps[i] = netlink.AccessPoint{
+			Ssid:     w.getNetworkSSID(i),
+			Bssid:    w.getNetworkBSSID(i),
+			Rssi:     int(w.getNetworkRSSI(i)),
+			Channel:  int(w.getNetworkChannel(i)),
+			AuthType: w.getNetworkEncrType(i).authType(),
+		}
+	}
+
+	return aps, nil
+}
+
 func (w *wifinina) GetHostByName(name string) (netip.Addr, error) {
 
 	if debugging(debugNetdev) {
@@ -983,10 +1069,11 @@ func (w *wifinina) Send(sockfd int, buf []byte, flags int,
 		if end > len(buf) {
 			end = len(buf)
 		}
-		_, err := w.sendChunk(sockfd, buf[i:end], deadline)
+		n, err := w.sendChunk(sockfd, buf[i:end], deadline)
 		if err != nil {
 			return -1, err
 		}
+		w.txBytes += uint64(n)
 	}
 
 	return len(buf), nil
@@ -1033,6 +1120,7 @@ func (w *wifinina) Recv(sockfd int, buf []byte, flags int,
 				fmt.Printf("[<--Recv] sockfd: %d, n: %d\r\n",
 					sockfd, n)
 			}
+			w.rxBytes += uint64(n)
 			return n, nil
 		}
 
@@ -1045,6 +1133,7 @@ func (w *wifinina) Recv(sockfd int, buf []byte, flags int,
 					sockfd, n)
 			}
 			if n > 0 {
+				w.rxBytes += uint64(n)
 				return n, io.EOF
 			}
 			return -1, io.EOF
@@ -1085,13 +1174,43 @@ func (w *wifinina) Close(sockfd int) error {
 	return nil
 }
 
+// SetSockOpt only supports joining and leaving a multicast group on a bound
+// UDP socket, the group being the netip.Addr value.
 func (w *wifinina) SetSockOpt(sockfd int, level int, opt int, value interface{}) error {
 
 	if debugging(debugNetdev) {
 		fmt.Printf("[SetSockOpt] sockfd: %d\r\n", sockfd)
 	}
 
-	return netdev.ErrNotSupported
+	if level != netdev.IPPROTO_IP || (opt != netdev.IP_ADD_MEMBERSHIP && opt != netdev.IP_DROP_MEMBERSHIP) {
+		return netdev.ErrNotSupported
+	}
+	group, ok := value.(netip.Addr)
+	if !ok || !group.Is4() || !group.IsMulticast() {
+		return fmt.Errorf("Invalid multicast group %s", group)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	socket, ok := w.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+	if socket.protocol != netdev.IPPROTO_UDP || socket.sock == noSocketAvail {
+		return fmt.Errorf("Must Bind before joining a multicast group")
+	}
+
+	// The firmware joins the group when starting the UDP server, so
+	// restart it on the same device socket.
+	w.stopClient(socket.sock)
+	if opt == netdev.IP_ADD_MEMBERSHIP {
+		w.startServerMulticast(socket.sock, toUint32(group.As4()), socket.laddr.Port())
+	} else {
+		w.startServer(socket.sock, socket.laddr.Port(), protoModeUDP)
+	}
+
+	return nil
 }
 
 func (w *wifinina) startClient(sock sock, hostname string, addr uint32, port uint16, mode uint8) {
@@ -1218,6 +1337,25 @@ func (w *wifinina) startServer(sock sock, port uint16, mode uint8) {
 	w.waitRspCmd1(cmdStartServerTCP)
 }
 
+func (w *wifinina) startServerMulticast(sock sock, group uint32, port uint16) {
+	if debugging(debugCmd) {
+		fmt.Printf("    [cmdStartServerTCP] sock: %d, group: % 02X, port: %d, mode: %d\r\n",
+			sock, group, port, protoModeMul)
+	}
+
+	w.waitForChipReady()
+	w.spiChipSelect()
+	l := w.sendCmd(cmdStartServerTCP, 4)
+	l += w.sendParam32(group, false)
+	l += w.sendParam16(port, false)
+	l += w.sendParam8(uint8(sock), false)
+	l += w.sendParam8(protoModeMul, true)
+	w.addPadding(l)
+	w.spiChipDeselect()
+
+	w.waitRspCmd1(cmdStartServerTCP)
+}
+
 func (w *wifinina) accept(s sock) sock {
 
 	if debugging(debugCmd) {
@@ -1393,6 +1531,23 @@ func (w *wifinina) getNetworkChannel(idx int) uint8 {
 	return w.getUint8(w.reqUint8(cmdGetIdxChannel, uint8(idx)))
 }
 
+func (e encryptionType) authType() netlink.AuthType {
+	switch e {
+	case encTypeTKIP:
+		return netlink.AuthTypeWPA
+	case encTypeCCMP:
+		return netlink.AuthTypeWPA2
+	case encTypeWEP:
+		return netlink.AuthTypeWEP
+	case encTypeNone:
+		return netlink.AuthTypeOpen
+	case encTypeAuto:
+		return netlink.AuthTypeWPA2Mixed
+	default:
+		return netlink.AuthTypeUnknown
+	}
+}
+
 func (w *wifinina) getNetworkEncrType(idx int) encryptionType {
 	if idx < 0 || idx >= maxNetworks {
 		return 0